@@ -0,0 +1,115 @@
+// Package apperr defines kinds of domain errors, which let the layers of the application
+// tell failures apart without knowing the concrete errors of each other
+package apperr
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// Kind is a class of failures that callers handle the same way
+type Kind string
+
+const (
+	// Unknown is the kind of errors that don't declare one, which are unexpected failures
+	Unknown Kind = ""
+	// NotFound means the requested object doesn't exist
+	NotFound Kind = "not_found"
+	// Conflict means the operation clashes with the current state of the object, e.g. it has been changed concurrently
+	Conflict Kind = "conflict"
+	// Validation means the input is invalid
+	Validation Kind = "validation"
+	// PermissionDenied means the actor isn't allowed to perform the operation
+	PermissionDenied Kind = "permission_denied"
+	// Unavailable means the operation can't be performed right now, but may succeed later
+	Unavailable Kind = "unavailable"
+	// RateLimited means the actor has made too many requests and should slow down
+	RateLimited Kind = "rate_limited"
+)
+
+// Error is an error of a certain kind
+type Error struct {
+	Kind    Kind
+	Message string
+	// Err is an optional cause of the error
+	Err error
+}
+
+// New returns an error of the given kind with a formatted message
+func New(kind Kind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns an error of the given kind caused by err, nil if err is nil
+func Wrap(kind Kind, err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	if e.Message == "" {
+		return e.Err.Error()
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+// Cause returns the cause of the error, which makes it work with github.com/pkg/errors
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind implements Kinded
+func (e *Error) ErrorKind() Kind {
+	return e.Kind
+}
+
+// Kinded is implemented by errors that belong to a kind, so that packages may declare kinds of their own error types
+type Kinded interface {
+	ErrorKind() Kind
+}
+
+// KindOf returns the kind of the first error in the chain that declares one.
+// Errors are unwrapped both the github.com/pkg/errors and the standard way.
+// Timeouts and broken connections are Unavailable unless they declare another kind
+func KindOf(err error) Kind {
+	unavailable := false
+	for cause := err; cause != nil; cause = unwrap(cause) {
+		if kinded, ok := cause.(Kinded); ok {
+			if kind := kinded.ErrorKind(); kind != Unknown {
+				return kind
+			}
+		}
+		if timeout, ok := cause.(interface{ Timeout() bool }); ok && timeout.Timeout() {
+			unavailable = true
+		}
+		if cause == driver.ErrBadConn {
+			unavailable = true
+		}
+	}
+	if unavailable {
+		return Unavailable
+	}
+	return Unknown
+}
+
+// Is tells whether err is of the given kind
+func Is(err error, kind Kind) bool {
+	return err != nil && KindOf(err) == kind
+}
+
+func unwrap(err error) error {
+	if causer, ok := err.(interface{ Cause() error }); ok {
+		return causer.Cause()
+	}
+	return errors.Unwrap(err)
+}
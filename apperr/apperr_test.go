@@ -0,0 +1,53 @@
+package apperr_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/apperr"
+)
+
+type kindedError struct{}
+
+func (kindedError) Error() string { return "kinded" }
+
+func (kindedError) ErrorKind() apperr.Kind { return apperr.Conflict }
+
+func TestKindOf(t *testing.T) {
+	notFound := apperr.New(apperr.NotFound, "customer %d not found", 1)
+	require.Equal(t, "customer 1 not found", notFound.Error())
+
+	for _, test := range []struct {
+		name string
+		err  error
+		kind apperr.Kind
+	}{
+		{"nil", nil, apperr.Unknown},
+		{"plain", fmt.Errorf("failed"), apperr.Unknown},
+		{"direct", notFound, apperr.NotFound},
+		{"pkg wrapped", errors.Wrapf(errors.Wrapf(notFound, "inner"), "outer"), apperr.NotFound},
+		{"std wrapped", fmt.Errorf("outer: %w", notFound), apperr.NotFound},
+		{"own type", errors.Wrapf(kindedError{}, "update"), apperr.Conflict},
+		{"outermost wins", apperr.Wrap(apperr.Validation, notFound, "bad input"), apperr.Validation},
+		{"timeout", errors.Wrapf(context.DeadlineExceeded, "query"), apperr.Unavailable},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.kind, apperr.KindOf(test.err))
+		})
+	}
+	require.True(t, apperr.Is(notFound, apperr.NotFound))
+	require.False(t, apperr.Is(nil, apperr.Unknown))
+}
+
+func TestWrap(t *testing.T) {
+	require.Nil(t, apperr.Wrap(apperr.Validation, nil, "ignored"))
+
+	cause := fmt.Errorf("bad digit")
+	err := apperr.Wrap(apperr.Validation, cause, "parse page")
+	require.Equal(t, "parse page: bad digit", err.Error())
+	require.Equal(t, cause, errors.Cause(err))
+}
@@ -0,0 +1,261 @@
+// Package audit verifies the hash-chained audit trail of customer changes and signs checkpoints of it.
+// The chain shows that no entry has been changed or removed from the middle of the trail,
+// and checkpoints signed with a key kept apart from the database show that the trail hasn't been cut short or rebuilt
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+const (
+	// DefaultCheckpointInterval is the default period between signed checkpoints
+	DefaultCheckpointInterval = time.Hour
+	verifyBatchSize           = 1000
+)
+
+// Signer signs checkpoints with an Ed25519 key
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner creates a signer with the given base64 encoded 32 byte Ed25519 seed
+func NewSigner(seed string) (*Signer, error) {
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil || len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d base64 encoded bytes", ed25519.SeedSize)
+	}
+	return &Signer{key: ed25519.NewKeyFromSeed(raw)}, nil
+}
+
+// PublicKey returns the base64 encoded public key, which verifies signed checkpoints
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Sign returns a signed checkpoint of the given entry
+func (s *Signer) Sign(entry models.AuditEntry, now time.Time) models.AuditCheckpoint {
+	checkpoint := models.AuditCheckpoint{
+		EntryID:   entry.ID,
+		Hash:      entry.Hash,
+		CreatedAt: now.UTC().Truncate(time.Microsecond),
+		PublicKey: s.PublicKey(),
+	}
+	checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, checkpoint.SignedContent()))
+	return checkpoint
+}
+
+// VerifySignature tells whether the checkpoint has been signed with the key it names
+func VerifySignature(checkpoint models.AuditCheckpoint) bool {
+	key, err := base64.StdEncoding.DecodeString(checkpoint.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, checkpoint.SignedContent(), signature)
+}
+
+// BrokenLink describes where the audit trail has been tampered with
+type BrokenLink struct {
+	// EntryID is the first entry that doesn't belong to the chain, or the entry a bad checkpoint refers to
+	EntryID int64
+	Reason  string
+}
+
+func (b BrokenLink) Error() string {
+	return fmt.Sprintf("audit trail is broken at entry %v: %s", b.EntryID, b.Reason)
+}
+
+// ErrNoPublicKey occurs when the trail is verified without a public key, which would accept a trail rebuilt and signed anew
+var ErrNoPublicKey = errors.New("a public key to verify checkpoints with is required")
+
+// Result is the outcome of a verification
+type Result struct {
+	Entries     int
+	Checkpoints int
+	// Exported is the number of verified checkpoints of those exported earlier
+	Exported int
+	// Broken is the first broken link, nil if the trail is intact
+	Broken *BrokenLink
+}
+
+// checkpointRef is a checkpoint to verify along with where it's been read from
+type checkpointRef struct {
+	models.AuditCheckpoint
+	exported bool
+}
+
+func (c checkpointRef) String() string {
+	if c.exported {
+		return fmt.Sprintf("exported checkpoint %v", c.ID)
+	}
+	return fmt.Sprintf("checkpoint %v", c.ID)
+}
+
+// Verify walks the audit trail from the first entry and checks that every entry carries its own hash
+// and the hash of the previous one, and that checkpoints, both stored and exported, match the entries they refer to.
+// Checkpoints must be signed with publicKey. Exported checkpoints are kept apart from the database,
+// so they tell when the trail has been cut short along with its stored checkpoints
+func Verify(ctx context.Context, store stores.AuditStore, publicKey string, exported []models.AuditCheckpoint) (Result, error) {
+	var result Result
+	if publicKey == "" {
+		return result, ErrNoPublicKey
+	}
+	stored, err := store.ListCheckpoints(ctx)
+	if err != nil {
+		return result, err
+	}
+	var checkpoints []checkpointRef
+	for _, checkpoint := range stored {
+		checkpoints = append(checkpoints, checkpointRef{AuditCheckpoint: checkpoint})
+	}
+	for _, checkpoint := range exported {
+		checkpoints = append(checkpoints, checkpointRef{AuditCheckpoint: checkpoint, exported: true})
+	}
+	byEntry := make(map[int64][]checkpointRef)
+	for _, checkpoint := range checkpoints {
+		if !VerifySignature(checkpoint.AuditCheckpoint) || checkpoint.PublicKey != publicKey {
+			result.Broken = &BrokenLink{EntryID: checkpoint.EntryID, Reason: fmt.Sprintf("%s has an invalid signature", checkpoint)}
+			return result, nil
+		}
+		byEntry[checkpoint.EntryID] = append(byEntry[checkpoint.EntryID], checkpoint)
+	}
+
+	var last models.AuditEntry
+	for {
+		entries, err := store.ListAudit(ctx, last.ID, verifyBatchSize)
+		if err != nil {
+			return result, errors.Wrapf(err, "verify audit trail")
+		}
+		for _, entry := range entries {
+			if broken := checkLink(last, entry); broken != nil {
+				result.Broken = broken
+				return result, nil
+			}
+			for _, checkpoint := range byEntry[entry.ID] {
+				if checkpoint.Hash != entry.Hash {
+					result.Broken = &BrokenLink{EntryID: entry.ID, Reason: fmt.Sprintf("entry doesn't match %s", checkpoint)}
+					return result, nil
+				}
+				if checkpoint.exported {
+					result.Exported++
+				} else {
+					result.Checkpoints++
+				}
+			}
+			delete(byEntry, entry.ID)
+			last = entry
+			result.Entries++
+		}
+		if len(entries) < verifyBatchSize {
+			break
+		}
+	}
+	for entryID, checkpoints := range byEntry {
+		result.Broken = &BrokenLink{EntryID: entryID, Reason: fmt.Sprintf("entry of %s is missing", checkpoints[0])}
+		return result, nil
+	}
+	return result, nil
+}
+
+func checkLink(previous, entry models.AuditEntry) *BrokenLink {
+	switch {
+	case entry.PrevHash != previous.Hash:
+		return &BrokenLink{EntryID: entry.ID, Reason: fmt.Sprintf("previous hash doesn't match entry %v", previous.ID)}
+	case entry.Hash != entry.ComputeHash():
+		return &BrokenLink{EntryID: entry.ID, Reason: "entry has been changed"}
+	}
+	return nil
+}
+
+// Checkpointer signs checkpoints of the audit trail periodically
+type Checkpointer struct {
+	store    stores.AuditStore
+	signer   *Signer
+	Interval time.Duration
+	Now      func() time.Time
+}
+
+// NewCheckpointer creates a checkpointer that signs checkpoints of the given trail
+func NewCheckpointer(store stores.AuditStore, signer *Signer) *Checkpointer {
+	return &Checkpointer{
+		store:    store,
+		signer:   signer,
+		Interval: DefaultCheckpointInterval,
+		Now:      time.Now,
+	}
+}
+
+// Run signs checkpoints until the context is done
+func (c *Checkpointer) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.Checkpoint(ctx); err != nil && ctx.Err() == nil {
+			fmt.Println("sign audit checkpoint:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Checkpoint signs a checkpoint of the last entry unless there is one already.
+// It returns false if there has been nothing to sign
+func (c *Checkpointer) Checkpoint(ctx context.Context) (bool, error) {
+	last, err := c.store.LastAudit(ctx)
+	if err == stores.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	checkpoints, err := c.store.ListCheckpoints(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(checkpoints) > 0 && checkpoints[len(checkpoints)-1].EntryID == last.ID {
+		return false, nil
+	}
+	_, err = c.store.SaveCheckpoint(ctx, c.signer.Sign(last, c.Now()))
+	return err == nil, err
+}
+
+// ReadCheckpoints reads checkpoints written by WriteCheckpoints
+func ReadCheckpoints(r io.Reader) ([]models.AuditCheckpoint, error) {
+	var checkpoints []models.AuditCheckpoint
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		var checkpoint models.AuditCheckpoint
+		if err := decoder.Decode(&checkpoint); err != nil {
+			return nil, errors.Wrapf(err, "read checkpoint %v", len(checkpoints)+1)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, nil
+}
+
+// WriteCheckpoints writes checkpoints as newline delimited JSON, which auditors keep apart from the database
+func WriteCheckpoints(w io.Writer, checkpoints []models.AuditCheckpoint) error {
+	encoder := json.NewEncoder(w)
+	for _, checkpoint := range checkpoints {
+		if err := encoder.Encode(checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/audit"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+var seed = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+func newTrail(t *testing.T, n int) *memoryAuditStore {
+	store := &memoryAuditStore{}
+	for i := 0; i < n; i++ {
+		_, err := store.AppendAudit(context.Background(), models.AuditEntry{
+			Action:     models.CustomerUpdated,
+			CustomerID: i + 1,
+			Actor:      "admin",
+			Fields:     []string{"email"},
+		})
+		require.NoError(t, err)
+	}
+	return store
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	signer, err := audit.NewSigner(seed)
+	require.NoError(t, err)
+	store := newTrail(t, 5)
+	checkpointer := audit.NewCheckpointer(store, signer)
+	signed, err := checkpointer.Checkpoint(ctx)
+	require.NoError(t, err)
+	require.True(t, signed)
+	signed, err = checkpointer.Checkpoint(ctx)
+	require.NoError(t, err)
+	require.False(t, signed, "the last entry has a checkpoint already")
+
+	result, err := audit.Verify(ctx, store, signer.PublicKey(), nil)
+	require.NoError(t, err)
+	require.Nil(t, result.Broken)
+	require.Equal(t, 5, result.Entries)
+	require.Equal(t, 1, result.Checkpoints)
+
+	for name, test := range map[string]struct {
+		tamper func(store *memoryAuditStore)
+		broken int64
+	}{
+		"changed": {
+			tamper: func(store *memoryAuditStore) { store.entries[2].Actor = "somebody else" },
+			broken: 3,
+		},
+		"noted": {
+			tamper: func(store *memoryAuditStore) { store.entries[1].Note = "added later" },
+			broken: 2,
+		},
+		"rehashed": {
+			tamper: func(store *memoryAuditStore) {
+				store.entries[2].Actor = "somebody else"
+				store.entries[2].Hash = store.entries[2].ComputeHash()
+			},
+			broken: 4,
+		},
+		"removed": {
+			tamper: func(store *memoryAuditStore) { store.entries = append(store.entries[:1], store.entries[2:]...) },
+			broken: 3,
+		},
+		"cut short": {
+			tamper: func(store *memoryAuditStore) { store.entries = store.entries[:4] },
+			broken: 5,
+		},
+		"forged checkpoint": {
+			tamper: func(store *memoryAuditStore) { store.checkpoints[0].EntryID = 4 },
+			broken: 4,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := newTrail(t, 5)
+			_, err := audit.NewCheckpointer(store, signer).Checkpoint(ctx)
+			require.NoError(t, err)
+			test.tamper(store)
+			result, err := audit.Verify(ctx, store, signer.PublicKey(), nil)
+			require.NoError(t, err)
+			require.NotNil(t, result.Broken)
+			require.Equal(t, test.broken, result.Broken.EntryID, result.Broken.Reason)
+		})
+	}
+
+	other, err := audit.NewSigner(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32)))
+	require.NoError(t, err)
+	result, err = audit.Verify(ctx, store, other.PublicKey(), nil)
+	require.NoError(t, err)
+	require.NotNil(t, result.Broken, "checkpoints must be signed with the expected key")
+	_, err = audit.Verify(ctx, store, "", nil)
+	require.Equal(t, audit.ErrNoPublicKey, err, "checkpoints can't be trusted without a key")
+}
+
+func TestVerifyExportedCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	signer, err := audit.NewSigner(seed)
+	require.NoError(t, err)
+	store := newTrail(t, 5)
+	_, err = audit.NewCheckpointer(store, signer).Checkpoint(ctx)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, audit.WriteCheckpoints(&out, store.checkpoints))
+	exported, err := audit.ReadCheckpoints(&out)
+	require.NoError(t, err)
+	require.Equal(t, store.checkpoints, exported)
+
+	result, err := audit.Verify(ctx, store, signer.PublicKey(), exported)
+	require.NoError(t, err)
+	require.Nil(t, result.Broken)
+	require.Equal(t, 1, result.Exported)
+
+	// the tail is cut along with its checkpoints, which only the exported copy tells
+	store.entries, store.checkpoints = store.entries[:3], nil
+	result, err = audit.Verify(ctx, store, signer.PublicKey(), nil)
+	require.NoError(t, err)
+	require.Nil(t, result.Broken)
+	result, err = audit.Verify(ctx, store, signer.PublicKey(), exported)
+	require.NoError(t, err)
+	require.NotNil(t, result.Broken)
+	require.Equal(t, int64(5), result.Broken.EntryID)
+
+	// a rebuilt trail signed with another key doesn't pass with the exported checkpoints
+	other, err := audit.NewSigner(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32)))
+	require.NoError(t, err)
+	rebuilt := newTrail(t, 5)
+	_, err = audit.NewCheckpointer(rebuilt, other).Checkpoint(ctx)
+	require.NoError(t, err)
+	result, err = audit.Verify(ctx, rebuilt, signer.PublicKey(), exported)
+	require.NoError(t, err)
+	require.NotNil(t, result.Broken)
+}
+
+func TestWriteCheckpoints(t *testing.T) {
+	signer, err := audit.NewSigner(seed)
+	require.NoError(t, err)
+	checkpoint := signer.Sign(models.AuditEntry{ID: 42, Hash: "abc"}, time.Now())
+	var out bytes.Buffer
+	require.NoError(t, audit.WriteCheckpoints(&out, []models.AuditCheckpoint{checkpoint}))
+
+	var read models.AuditCheckpoint
+	require.NoError(t, json.Unmarshal(out.Bytes(), &read))
+	require.True(t, audit.VerifySignature(read))
+	read.Hash = "abd"
+	require.False(t, audit.VerifySignature(read))
+}
+
+type memoryAuditStore struct {
+	entries     []models.AuditEntry
+	checkpoints []models.AuditCheckpoint
+}
+
+func (s *memoryAuditStore) AppendAudit(ctx context.Context, entry models.AuditEntry) (models.AuditEntry, error) {
+	entry.ID = int64(len(s.entries) + 1)
+	entry.CreatedAt = time.Now().UTC()
+	if len(s.entries) > 0 {
+		entry.PrevHash = s.entries[len(s.entries)-1].Hash
+	}
+	entry.Hash = entry.ComputeHash()
+	s.entries = append(s.entries, entry)
+	return entry, nil
+}
+
+func (s *memoryAuditStore) ListAudit(ctx context.Context, afterID int64, limit int) ([]models.AuditEntry, error) {
+	var result []models.AuditEntry
+	for _, entry := range s.entries {
+		if entry.ID > afterID && len(result) < limit {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+func (s *memoryAuditStore) LastAudit(ctx context.Context) (models.AuditEntry, error) {
+	if len(s.entries) == 0 {
+		return models.AuditEntry{}, stores.ErrNotFound
+	}
+	return s.entries[len(s.entries)-1], nil
+}
+
+func (s *memoryAuditStore) SaveCheckpoint(ctx context.Context, checkpoint models.AuditCheckpoint) (models.AuditCheckpoint, error) {
+	checkpoint.ID = int64(len(s.checkpoints) + 1)
+	s.checkpoints = append(s.checkpoints, checkpoint)
+	return checkpoint, nil
+}
+
+func (s *memoryAuditStore) ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error) {
+	return s.checkpoints, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+// createAdmin creates the initial administrator account
+func createAdmin(ctx context.Context, db *sql.DB, args []string) error {
+	return createUser(ctx, db, append([]string{"-username", "admin", "-role", string(models.RoleAdmin)}, args...))
+}
+
+// createUser creates a user account.
+// The password is taken from CUSTOMERS_ADMIN_PASSWORD or read from the standard input
+func createUser(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("create-user", flag.ExitOnError)
+	username := flags.String("username", "", "name of the user")
+	role := flags.String("role", string(models.RoleIntern), fmt.Sprintf("role of the user: one of %v", models.Roles))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	password := os.Getenv("CUSTOMERS_ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read password: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	user, err := newUserManager(db).CreateUser(ctx, *username, password, models.Role(*role))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s %q with ID %v\n", user.Role, user.Username, user.ID)
+	return nil
+}
+
+// setRole changes the role of an existing user
+func setRole(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("set-role", flag.ExitOnError)
+	username := flags.String("username", "", "name of the user")
+	role := flags.String("role", "", fmt.Sprintf("new role of the user: one of %v", models.Roles))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := newUserManager(db).SetRole(ctx, *username, models.Role(*role)); err != nil {
+		return err
+	}
+	fmt.Printf("User %q is %s now\n", *username, *role)
+	return nil
+}
+
+// resetTwoFactor disables two-factor authentication of a user who has lost the authenticator and the recovery codes
+func resetTwoFactor(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("reset-2fa", flag.ExitOnError)
+	username := flags.String("username", "", "name of the user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := newUserManager(db).ResetTwoFactor(ctx, *username); err != nil {
+		return err
+	}
+	fmt.Printf("Two-factor authentication of %q has been reset\n", *username)
+	return nil
+}
+
+// requireTwoFactor makes two-factor authentication mandatory or optional for users who can change or delete customers
+func requireTwoFactor(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("require-2fa", flag.ExitOnError)
+	required := flags.Bool("required", true, "whether two-factor authentication is mandatory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return newUserManager(db).SetTwoFactorRequired(managers.AsSystem(ctx), *required)
+}
+
+func newUserManager(db *sql.DB) *managers.UserManager {
+	userManager := managers.NewUserManager(stores.NewUserStore(db), stores.NewSessionStore(db), stores.NewSettingStore(db), stores.NewTransactor(db))
+	userManager.IdleTimeout = *fSessionIdleTimeout
+	userManager.AbsoluteTimeout = *fSessionAbsoluteTimeout
+	return userManager
+}
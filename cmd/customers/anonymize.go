@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+	"github.com/havr/customers/util/customeru"
+)
+
+// anonymizeExport copies customers with pseudonymized personal data to newline delimited JSON or another database,
+// e.g. to have realistic data in test environments
+func anonymizeExport(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("anonymize-export", flag.ExitOnError)
+	out := flags.String("out", "", "file to write customers to as newline delimited JSON; standard output if empty and there is no target database")
+	targetDB := flags.String("target-db", "", "url of a database to copy customers to; it's created if it doesn't exist")
+	key := flags.String("key", os.Getenv("PSEUDONYM_KEY"), "secret that pseudonyms are derived from, the same key gives the same pseudonyms; a random one is used if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out != "" && *targetDB != "" {
+		return fmt.Errorf("expected either a file or a target database")
+	}
+	secret := []byte(*key)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Warning: no key is given, so pseudonyms differ from those of other exports")
+	}
+	pseudonymizer := customeru.NewPseudonymizer(secret)
+
+	accessLog := managers.NewAccessLog(stores.NewAccessStore(db))
+	customerStore, _, err := newCustomerStore(db)
+	if err != nil {
+		return err
+	}
+	manager := managers.NewCustomerManager(customerStore, managers.WithAccessLog(accessLog))
+	export := func(write func(models.Customer) error) error {
+		err := manager.ExportCustomers(managers.AsSystem(ctx), stores.CustomerListFilter{}, func(customer models.Customer) error {
+			return write(pseudonymizer.Customer(customer))
+		})
+		// reads are accounted for even if the export fails halfway
+		if flushErr := accessLog.Flush(ctx); err == nil {
+			err = flushErr
+		}
+		return err
+	}
+
+	if *targetDB != "" {
+		target, err := stores.PrepareDB(ctx, *targetDB)
+		if err != nil {
+			return err
+		}
+		defer target.Close()
+		copied, err := copyCustomers(ctx, target, export)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Copied %v pseudonymized customers\n", copied)
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	encoder := json.NewEncoder(w)
+	written := 0
+	if err := export(func(customer models.Customer) error {
+		written++
+		return encoder.Encode(customer)
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %v pseudonymized customers\n", written)
+	return nil
+}
+
+// copyCustomers creates exported customers in the target database in a single transaction,
+// so that a failed copy leaves nothing behind
+func copyCustomers(ctx context.Context, target *sql.DB, export func(write func(models.Customer) error) error) (int, error) {
+	store := stores.NewCustomerStore(target)
+	copied := 0
+	err := stores.NewTransactor(target).InTx(ctx, func(ctx context.Context) error {
+		return export(func(customer models.Customer) error {
+			if _, err := store.CreateCustomer(ctx, customer); err != nil {
+				return err
+			}
+			copied++
+			return nil
+		})
+	})
+	return copied, err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+// createAPIKey creates an API key and prints its value
+func createAPIKey(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("apikey-create", flag.ExitOnError)
+	name := flags.String("name", "", "name of the key, e.g. the client it's issued to")
+	scopes := flags.String("scopes", string(models.ReadCustomers), fmt.Sprintf("comma separated scopes: any of %v", models.APIKeyScopes))
+	allowedIPs := flags.String("allow-ip", "", "comma separated IP addresses or CIDR networks the key may be used from; any if empty")
+	expiresIn := flags.Duration("expires-in", 0, "time after which the key expires; never if zero")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	key := models.APIKey{Name: *name}
+	for _, scope := range splitList(*scopes) {
+		key.Scopes = append(key.Scopes, models.Permission(scope))
+	}
+	key.AllowedIPs = splitList(*allowedIPs)
+	if *expiresIn > 0 {
+		expiresAt := time.Now().Add(*expiresIn)
+		key.ExpiresAt = &expiresAt
+	}
+	created, token, err := newAPIKeyManager(db).CreateAPIKey(managers.AsSystem(ctx), key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created API key %q with ID %v. Keep it safe, it won't be shown again:\n%s\n", created.Name, created.ID, token)
+	return nil
+}
+
+// listAPIKeys prints all API keys
+func listAPIKeys(ctx context.Context, db *sql.DB, args []string) error {
+	keys, err := newAPIKeyManager(db).ListAPIKeys(managers.AsSystem(ctx))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		status := "active"
+		if key.RevokedAt != nil {
+			status = "revoked"
+		} else if !key.Active(time.Now()) {
+			status = "expired"
+		}
+		fmt.Printf("%v\t%s\t%s...\t%s\t%v\n", key.ID, key.Name, key.Prefix, status, key.Scopes)
+	}
+	return nil
+}
+
+// revokeAPIKey revokes an API key by its ID
+func revokeAPIKey(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("apikey-revoke", flag.ExitOnError)
+	id := flags.Int("id", 0, "ID of the key to revoke")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := newAPIKeyManager(db).RevokeAPIKey(managers.AsSystem(ctx), *id); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked API key %v\n", *id)
+	return nil
+}
+
+func newAPIKeyManager(db *sql.DB) *managers.APIKeyManager {
+	return managers.NewAPIKeyManager(stores.NewAPIKeyStore(db))
+}
+
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/havr/customers/audit"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+var (
+	fAuditSigningKey         = flag.String("audit-signing-key", os.Getenv("AUDIT_SIGNING_KEY"), "base64 encoded 32 byte Ed25519 seed to sign audit trail checkpoints with; checkpoints aren't signed if empty")
+	fAuditCheckpointInterval = flag.Duration("audit-checkpoint-interval", audit.DefaultCheckpointInterval, "time between signed checkpoints of the audit trail")
+)
+
+// auditCommands are subcommands of the audit command
+var auditCommands = map[string]func(ctx context.Context, db *sql.DB, args []string) error{
+	"verify":             verifyAudit,
+	"checkpoint":         checkpointAudit,
+	"export-checkpoints": exportCheckpoints,
+}
+
+// auditCommand runs a subcommand that deals with the audit trail
+func auditCommand(ctx context.Context, db *sql.DB, args []string) error {
+	var names []string
+	for name := range auditCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) == 0 {
+		return fmt.Errorf("expected a subcommand of audit: %s", strings.Join(names, ", "))
+	}
+	command, ok := auditCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown subcommand of audit %q: expected %s", args[0], strings.Join(names, ", "))
+	}
+	return command(ctx, db, args[1:])
+}
+
+// verifyAudit walks the audit trail and reports the first broken link
+func verifyAudit(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	publicKey := flags.String("public-key", "", "base64 encoded public key checkpoints must be signed with; the key of --audit-signing-key by default")
+	checkpointsFile := flags.String("checkpoints", "", "file of checkpoints exported earlier, every one of which must match the trail")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *publicKey == "" && *fAuditSigningKey != "" {
+		signer, err := audit.NewSigner(*fAuditSigningKey)
+		if err != nil {
+			return err
+		}
+		*publicKey = signer.PublicKey()
+	}
+	if *publicKey == "" {
+		return fmt.Errorf("%v: pass --public-key or configure --audit-signing-key", audit.ErrNoPublicKey)
+	}
+	var exported []models.AuditCheckpoint
+	if *checkpointsFile != "" {
+		file, err := os.Open(*checkpointsFile)
+		if err != nil {
+			return err
+		}
+		exported, err = audit.ReadCheckpoints(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	result, err := audit.Verify(ctx, stores.NewAuditStore(db), *publicKey, exported)
+	if err != nil {
+		return err
+	}
+	if result.Broken != nil {
+		return result.Broken
+	}
+	fmt.Printf("Audit trail is intact: %v entries, %v checkpoints and %v exported checkpoints verified\n", result.Entries, result.Checkpoints, result.Exported)
+	if *checkpointsFile == "" {
+		fmt.Println("Warning: no exported checkpoints are given, so a trail cut short along with its checkpoints isn't noticed")
+	}
+	return nil
+}
+
+// checkpointAudit signs a checkpoint of the audit trail right away
+func checkpointAudit(ctx context.Context, db *sql.DB, args []string) error {
+	checkpointer, err := newCheckpointer(db)
+	if err != nil {
+		return err
+	}
+	signed, err := checkpointer.Checkpoint(ctx)
+	if err != nil {
+		return err
+	}
+	if !signed {
+		fmt.Println("The last entry of the audit trail already has a checkpoint")
+		return nil
+	}
+	fmt.Println("Signed a checkpoint of the audit trail")
+	return nil
+}
+
+// exportCheckpoints writes all signed checkpoints to a file
+func exportCheckpoints(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("audit export-checkpoints", flag.ExitOnError)
+	out := flags.String("out", "", "file to write checkpoints to as newline delimited JSON; standard output if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	checkpoints, err := stores.NewAuditStore(db).ListCheckpoints(ctx)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return audit.WriteCheckpoints(os.Stdout, checkpoints)
+	}
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := audit.WriteCheckpoints(file, checkpoints); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %v checkpoints to %s\n", len(checkpoints), *out)
+	return nil
+}
+
+// newCheckpointer creates a checkpointer with the key of --audit-signing-key
+func newCheckpointer(db *sql.DB) (*audit.Checkpointer, error) {
+	if *fAuditSigningKey == "" {
+		return nil, fmt.Errorf("no audit signing key is configured, see --audit-signing-key")
+	}
+	signer, err := audit.NewSigner(*fAuditSigningKey)
+	if err != nil {
+		return nil, err
+	}
+	checkpointer := audit.NewCheckpointer(stores.NewAuditStore(db), signer)
+	checkpointer.Interval = *fAuditCheckpointInterval
+	return checkpointer, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/havr/customers/encryption"
+	"github.com/havr/customers/stores"
+)
+
+var (
+	fEncryptionKeyfile = flag.String("encryption-keyfile", "", "path to a JSON file with keys to encrypt customer fields with; keys are read from ENCRYPTION_KEYS if empty")
+	fEncryptFields     = flag.String("encrypt-fields", "email,address", fmt.Sprintf("comma separated customer fields to encrypt if keys are configured: any of %v", stores.EncryptableCustomerFields))
+)
+
+// newCipher creates a cipher with the keys from the keyfile or the environment, it returns nil if there are none
+func newCipher() (*encryption.Cipher, error) {
+	var keys encryption.Keys
+	var err error
+	switch {
+	case *fEncryptionKeyfile != "":
+		keys, err = encryption.LoadKeyfile(*fEncryptionKeyfile)
+	case os.Getenv("ENCRYPTION_KEYS") != "":
+		keys, err = encryption.KeysFromEnv()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys.Cipher()
+}
+
+// encryptedFields returns the fields to encrypt as configured by the flags
+func encryptedFields() ([]string, error) {
+	fields := splitList(*fEncryptFields)
+	for _, field := range fields {
+		var ok bool
+		for _, encryptable := range stores.EncryptableCustomerFields {
+			ok = ok || field == encryptable
+		}
+		if !ok {
+			return nil, fmt.Errorf("field %q can't be encrypted: expected any of %v", field, stores.EncryptableCustomerFields)
+		}
+	}
+	return fields, nil
+}
+
+// newCustomerStore creates a customer store that encrypts fields if keys are configured.
+// It returns the encrypted fields along with the store
+func newCustomerStore(db *sql.DB) (stores.CustomerStore, []string, error) {
+	cipher, err := newCipher()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cipher == nil {
+		return stores.NewCustomerStore(db), nil, nil
+	}
+	fields, err := encryptedFields()
+	if err != nil {
+		return nil, nil, err
+	}
+	return stores.NewCustomerStore(db, stores.WithFieldEncryption(cipher, fields...)), fields, nil
+}
+
+// payloadEncryption returns options that encrypt copies of encrypted customer fields in outbox events
+// and webhook deliveries, it returns none if no keys are configured
+func payloadEncryption() ([]stores.PayloadOption, error) {
+	cipher, err := newCipher()
+	if err != nil || cipher == nil {
+		return nil, err
+	}
+	fields, err := encryptedFields()
+	if err != nil {
+		return nil, err
+	}
+	return []stores.PayloadOption{stores.WithPayloadEncryption(cipher, fields...)}, nil
+}
+
+// rotateKeys re-encrypts customers with the current key in batches, so that old keys may be dropped afterwards.
+// Every batch is a transaction of its own, so that the application may keep running
+func rotateKeys(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	batch := flags.Int("batch", 500, "number of customers rotated in a transaction")
+	after := flags.Int("after", 0, "ID of the customer to continue after, e.g. if a previous run has been interrupted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *batch <= 0 {
+		return fmt.Errorf("batch must be positive")
+	}
+	store, _, err := newCustomerStore(db)
+	if err != nil {
+		return err
+	}
+	rotator, ok := store.(stores.CustomerKeyRotator)
+	if !ok {
+		return fmt.Errorf("customer store can't rotate keys")
+	}
+	lastID, total := *after, 0
+	for {
+		next, rotated, err := rotator.RotateCustomerKeys(ctx, lastID, *batch)
+		if err != nil {
+			return fmt.Errorf("%v; rerun with --after %v to continue", err, lastID)
+		}
+		if next == 0 {
+			break
+		}
+		lastID, total = next, total+rotated
+		fmt.Printf("Rotated %v customers up to ID %v\n", total, lastID)
+	}
+	fmt.Printf("Done, %v customers rotated\n", total)
+	return nil
+}
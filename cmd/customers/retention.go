@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+var (
+	fRetentionPurgeDeleted      = flag.String("retention-purge-deleted-after", "off", "time after which deleted customers are erased for good, e.g. 30d; off keeps them")
+	fRetentionAnonymizeInactive = flag.String("retention-anonymize-inactive-after", "off", "time without changes after which customers are anonymized, e.g. 5y; off keeps them")
+	fRetentionInterval          = flag.Duration("retention-interval", managers.DefaultRetentionInterval, "time between runs of the retention policy")
+	fRetentionBatch             = flag.Int("retention-batch", managers.DefaultRetentionBatchSize, "number of customers the retention policy reads at once")
+	fRetentionDryRun            = flag.Bool("retention-dry-run", false, "only count customers the retention policy applies to instead of erasing them")
+)
+
+// newRetention creates a retention job configured by the retention flags
+func newRetention(customers *managers.CustomerManager, store stores.RetentionStore) (*managers.Retention, error) {
+	var policy models.RetentionPolicy
+	var err error
+	if policy.PurgeDeletedAfter, err = models.ParseRetentionPeriod(*fRetentionPurgeDeleted); err != nil {
+		return nil, err
+	}
+	if policy.AnonymizeInactiveAfter, err = models.ParseRetentionPeriod(*fRetentionAnonymizeInactive); err != nil {
+		return nil, err
+	}
+	retention := managers.NewRetention(customers, store, policy)
+	retention.Interval = *fRetentionInterval
+	retention.BatchSize = *fRetentionBatch
+	retention.DryRun = *fRetentionDryRun
+	return retention, nil
+}
+
+// applyRetention applies the retention policy once and prints what it has done
+func applyRetention(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("retention", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", *fRetentionDryRun, "only count customers the policy applies to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	customerStore, _, err := newCustomerStore(db)
+	if err != nil {
+		return err
+	}
+	manager, err := newSubjectManager(db)
+	if err != nil {
+		return err
+	}
+	retention, err := newRetention(manager, customerStore.(stores.RetentionStore))
+	if err != nil {
+		return err
+	}
+	run, err := retention.Apply(managers.AsSystem(ctx), *dryRun)
+	fmt.Println("Retention policy:", run)
+	for _, reason := range run.Errors {
+		fmt.Println(" ", reason)
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/stores"
+	"github.com/havr/customers/util/customeru"
+)
+
+// seedCustomers generates customers and creates them, or writes them as newline delimited JSON
+func seedCustomers(ctx context.Context, db *sql.DB, args []string) error {
+	defaults := customeru.DefaultGeneratorOptions(time.Now().UnixNano(), 100)
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	seed := flags.Int64("seed", defaults.Seed, "seed of the generator, the same seed and options give the same customers; random by default")
+	count := flags.Int("count", defaults.Count, "number of customers to generate, duplicates and invalid ones included")
+	locale := flags.String("locale", defaults.Locale, fmt.Sprintf("language of names and addresses: any of %v", customeru.Locales()))
+	minAge := flags.Int("min-age", defaults.MinAge, "minimal age of customers")
+	maxAge := flags.Int("max-age", defaults.MaxAge, "maximal age of customers")
+	ageDistribution := flags.String("age-distribution", defaults.AgeDistribution, fmt.Sprintf("how ages spread between the minimum and the maximum: any of %v", customeru.AgeDistributions))
+	femaleRatio := flags.Float64("female-ratio", defaults.FemaleRatio, "share of female customers, from 0 to 1")
+	duplicateRatio := flags.Float64("duplicate-ratio", 0, "share of customers that repeat one generated earlier, from 0 to 1")
+	invalidRatio := flags.Float64("invalid-ratio", 0, "share of customers with a deliberately invalid field, from 0 to 1; they are stored without validation")
+	out := flags.String("out", "", "file to write customers to as newline delimited JSON instead of creating them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	options := defaults
+	options.Seed, options.Count, options.Locale = *seed, *count, *locale
+	options.MinAge, options.MaxAge, options.AgeDistribution = *minAge, *maxAge, *ageDistribution
+	options.FemaleRatio, options.DuplicateRatio, options.InvalidRatio = *femaleRatio, *duplicateRatio, *invalidRatio
+	customers, err := customeru.Generate(options)
+	if err != nil {
+		return err
+	}
+
+	if *out != "" {
+		file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		for _, customer := range customers {
+			if err := encoder.Encode(customer); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %v customers generated with seed %v to %s\n", len(customers), options.Seed, *out)
+		return nil
+	}
+
+	customerStore, _, err := newCustomerStore(db)
+	if err != nil {
+		return err
+	}
+	payloadOptions, err := payloadEncryption()
+	if err != nil {
+		return err
+	}
+	transactor := stores.NewTransactor(db)
+	manager := managers.NewCustomerManager(customerStore, managers.WithOutbox(transactor, stores.NewOutboxStore(db, payloadOptions...)),
+		managers.WithAuditTrail(transactor, stores.NewAuditStore(db)))
+	if err := manager.SeedCustomers(managers.AsSystem(ctx), customers); err != nil {
+		return err
+	}
+	fmt.Printf("Created %v customers generated with seed %v\n", len(customers), options.Seed)
+	return nil
+}
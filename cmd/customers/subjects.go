@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+	"github.com/havr/customers/views"
+)
+
+// newSubjectManager creates a customer manager that handles requests of data subjects, which are recorded in the audit trail
+func newSubjectManager(db *sql.DB, options ...managers.Option) (*managers.CustomerManager, error) {
+	customerStore, _, err := newCustomerStore(db)
+	if err != nil {
+		return nil, err
+	}
+	payloadOptions, err := payloadEncryption()
+	if err != nil {
+		return nil, err
+	}
+	transactor := stores.NewTransactor(db)
+	options = append(options, managers.WithOutbox(transactor, stores.NewOutboxStore(db, payloadOptions...)),
+		managers.WithAuditTrail(transactor, stores.NewAuditStore(db)))
+	return managers.NewCustomerManager(customerStore, options...), nil
+}
+
+// exportSubject writes everything stored about a customer into a zip archive of JSON and HTML
+func exportSubject(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("dsar-export", flag.ExitOnError)
+	id := flags.Int("id", 0, "ID of the customer")
+	note := flags.String("note", "", "justification recorded in the audit trail, e.g. who has asked and how the identity has been verified")
+	out := flags.String("out", "", "file to write the archive to; customer-<id>-data.zip if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("customer-%d-data.zip", *id)
+	}
+	accessLog := managers.NewAccessLog(stores.NewAccessStore(db))
+	manager, err := newSubjectManager(db, managers.WithAccessLog(accessLog))
+	if err != nil {
+		return err
+	}
+	data, err := manager.ExportSubjectData(managers.AsSystem(ctx), *id, *note)
+	if err != nil {
+		return err
+	}
+	if err := accessLog.Flush(ctx); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := views.WriteSubjectBundle(file, filepath.Join(*fResources, "web"), data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported data of customer %v to %s\n", *id, *out)
+	return nil
+}
+
+// eraseSubject erases personal data of a customer
+func eraseSubject(ctx context.Context, db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("dsar-erase", flag.ExitOnError)
+	id := flags.Int("id", 0, "ID of the customer")
+	mode := flags.String("mode", string(models.ErasureAnonymize), fmt.Sprintf("how to erase the customer: any of %v", models.ErasureModes))
+	note := flags.String("note", "", "justification recorded in the audit trail, e.g. who has asked and how the identity has been verified")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	manager, err := newSubjectManager(db)
+	if err != nil {
+		return err
+	}
+	if err := manager.EraseCustomer(managers.AsSystem(ctx), *id, models.ErasureMode(*mode), *note); err != nil {
+		return err
+	}
+	fmt.Printf("Erased personal data of customer %v (%s)\n", *id, *mode)
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package encryption encrypts fields of records at rest with envelope encryption.
+// Values are encrypted with AES-GCM data keys, which are in turn encrypted ("wrapped") with key encryption keys
+// held by a KMS. Encrypted values carry the ID of the key encryption key and the wrapped data key,
+// so that keys may be rotated while values encrypted with older ones are still readable
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// prefix marks encrypted values, values without it are plaintext that hasn't been encrypted yet
+	prefix = "enc:v1:"
+	// dataKeySize is the size of AES-256 keys
+	dataKeySize = 32
+	// maxDataKeyUses is the number of values encrypted with a data key before a new one is generated,
+	// which keeps the chance of random GCM nonces colliding negligible
+	maxDataKeyUses = 1 << 24
+)
+
+// KMS holds key encryption keys and wraps data keys with them, so that the keys themselves never leave it
+type KMS interface {
+	// CurrentKeyID returns the ID of the key new data keys are wrapped with
+	CurrentKeyID() string
+	// WrapKey encrypts a data key with the key with the given ID
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key that has been wrapped with the key with the given ID
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Cipher encrypts and decrypts field values and computes their blind indexes
+type Cipher struct {
+	kms      KMS
+	indexKey []byte
+
+	mu      sync.Mutex
+	current *dataKey
+	// unwrapped caches data keys by key ID and wrapped key, so that the KMS isn't asked for every value
+	unwrapped map[string]cipher.AEAD
+}
+
+type dataKey struct {
+	keyID   string
+	wrapped string
+	aead    cipher.AEAD
+	uses    int
+}
+
+// NewCipher creates a cipher that wraps data keys with the given KMS.
+// The index key is a secret of blind indexes, which must stay the same as long as indexes are used
+func NewCipher(kms KMS, indexKey []byte) (*Cipher, error) {
+	if len(indexKey) < 32 {
+		return nil, fmt.Errorf("index key must be at least 32 bytes long")
+	}
+	return &Cipher{
+		kms:       kms,
+		indexKey:  indexKey,
+		unwrapped: make(map[string]cipher.AEAD),
+	}, nil
+}
+
+// Encrypt encrypts the value with a data key wrapped with the current key of the KMS.
+// The label, e.g. the name of the field, must be the same for decryption, which keeps values from being swapped
+func (c *Cipher) Encrypt(ctx context.Context, value, label string) (string, error) {
+	key, err := c.dataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(value), []byte(label))
+	return prefix + key.keyID + ":" + key.wrapped + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value encrypted with the given label. Values that aren't encrypted are returned as they are,
+// so that existing records may be encrypted gradually
+func (c *Cipher) Decrypt(ctx context.Context, value, label string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 3)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	aead, err := c.unwrap(ctx, parts[0], parts[1])
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(label))
+	if err != nil {
+		return "", errors.Wrapf(err, "decrypt %s", label)
+	}
+	return string(plain), nil
+}
+
+// IsEncrypted tells whether the value has been encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyID returns the ID of the key the value has been encrypted with, empty if it isn't encrypted
+func KeyID(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	rest := strings.TrimPrefix(value, prefix)
+	return rest[:strings.IndexByte(rest+":", ':')]
+}
+
+// IsCurrent tells whether the value has been encrypted with the current key of the KMS
+func (c *Cipher) IsCurrent(value string) bool {
+	return IsEncrypted(value) && KeyID(value) == c.kms.CurrentKeyID()
+}
+
+// BlindIndex returns a keyed hash of the value, which finds equal values without decrypting them.
+// Values are compared case-insensitively, since the index is meant for emails and the like
+func (c *Cipher) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, c.indexKey)
+	_, _ = mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// dataKey returns the data key to encrypt with, a new one is generated when the current key of the KMS changes
+// or the data key has been used too many times
+func (c *Cipher) dataKey(ctx context.Context) (*dataKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keyID := c.kms.CurrentKeyID()
+	if c.current != nil && c.current.keyID == keyID && c.current.uses < maxDataKeyUses {
+		c.current.uses++
+		return c.current, nil
+	}
+	raw := make([]byte, dataKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	wrapped, err := c.kms.WrapKey(ctx, keyID, raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "wrap data key with %s", keyID)
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	c.current = &dataKey{keyID: keyID, wrapped: base64.RawStdEncoding.EncodeToString(wrapped), aead: aead, uses: 1}
+	c.unwrapped[keyID+":"+c.current.wrapped] = aead
+	return c.current, nil
+}
+
+func (c *Cipher) unwrap(ctx context.Context, keyID, wrapped string) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if aead, ok := c.unwrapped[keyID+":"+wrapped]; ok {
+		return aead, nil
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("malformed wrapped data key")
+	}
+	key, err := c.kms.UnwrapKey(ctx, keyID, raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unwrap data key with %s", keyID)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	c.unwrapped[keyID+":"+wrapped] = aead
+	return aead, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,132 @@
+package encryption_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/encryption"
+)
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newCipher(t *testing.T, current string) *encryption.Cipher {
+	kms, err := encryption.NewLocalKMS(current, map[string][]byte{"k1": key(1), "k2": key(2)})
+	require.NoError(t, err)
+	cipher, err := encryption.NewCipher(kms, key(9))
+	require.NoError(t, err)
+	return cipher
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	cipher := newCipher(t, "k1")
+
+	encrypted, err := cipher.Encrypt(ctx, "john@example.com", "customers.email")
+	require.NoError(t, err)
+	require.True(t, encryption.IsEncrypted(encrypted))
+	require.Equal(t, "k1", encryption.KeyID(encrypted))
+	require.NotContains(t, encrypted, "john")
+
+	decrypted, err := cipher.Decrypt(ctx, encrypted, "customers.email")
+	require.NoError(t, err)
+	require.Equal(t, "john@example.com", decrypted)
+
+	// values can't be moved to another field
+	_, err = cipher.Decrypt(ctx, encrypted, "customers.address")
+	require.Error(t, err)
+
+	// values that haven't been encrypted yet are read as they are
+	plain, err := cipher.Decrypt(ctx, "john@example.com", "customers.email")
+	require.NoError(t, err)
+	require.Equal(t, "john@example.com", plain)
+	require.Empty(t, encryption.KeyID(plain))
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	old := newCipher(t, "k1")
+	encrypted, err := old.Encrypt(ctx, "Main St. 1", "customers.address")
+	require.NoError(t, err)
+
+	rotated := newCipher(t, "k2")
+	require.False(t, rotated.IsCurrent(encrypted))
+	require.False(t, rotated.IsCurrent("Main St. 1"))
+	decrypted, err := rotated.Decrypt(ctx, encrypted, "customers.address")
+	require.NoError(t, err)
+	require.Equal(t, "Main St. 1", decrypted)
+
+	reencrypted, err := rotated.Encrypt(ctx, decrypted, "customers.address")
+	require.NoError(t, err)
+	require.True(t, rotated.IsCurrent(reencrypted))
+
+	// values can't be decrypted once their key is gone
+	kms, err := encryption.NewLocalKMS("k2", map[string][]byte{"k2": key(2)})
+	require.NoError(t, err)
+	withoutOld, err := encryption.NewCipher(kms, key(9))
+	require.NoError(t, err)
+	_, err = withoutOld.Decrypt(ctx, encrypted, "customers.address")
+	require.Error(t, err)
+}
+
+func TestBlindIndex(t *testing.T) {
+	cipher := newCipher(t, "k1")
+	index := cipher.BlindIndex("John@Example.com")
+	require.Equal(t, index, cipher.BlindIndex(" john@example.com"))
+	require.Equal(t, index, newCipher(t, "k2").BlindIndex("john@example.com"), "index doesn't depend on keys")
+	require.NotEqual(t, index, cipher.BlindIndex("jane@example.com"))
+
+	kms, err := encryption.NewLocalKMS("k1", map[string][]byte{"k1": key(1)})
+	require.NoError(t, err)
+	other, err := encryption.NewCipher(kms, key(8))
+	require.NoError(t, err)
+	require.NotEqual(t, index, other.BlindIndex("john@example.com"))
+
+	_, err = encryption.NewCipher(kms, []byte("short"))
+	require.Error(t, err)
+}
+
+func TestKeys(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString
+	os.Setenv("ENCRYPTION_KEYS", "k1:"+encoded(key(1))+", k2:"+encoded(key(2)))
+	os.Setenv("ENCRYPTION_INDEX_KEY", encoded(key(9)))
+	defer os.Unsetenv("ENCRYPTION_KEYS")
+	defer os.Unsetenv("ENCRYPTION_INDEX_KEY")
+
+	keys, err := encryption.KeysFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, "k2", keys.Current)
+	cipher, err := keys.Cipher()
+	require.NoError(t, err)
+	encrypted, err := cipher.Encrypt(context.Background(), "value", "label")
+	require.NoError(t, err)
+	require.Equal(t, "k2", encryption.KeyID(encrypted))
+
+	dir, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	content := `{"current": "k1", "keys": {"k1": "` + encoded(key(1)) + `"}, "indexKey": "` + encoded(key(9)) + `"}`
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	_, err = encryption.LoadKeyfile(path)
+	require.Error(t, err, "keyfile readable by others")
+
+	require.NoError(t, os.Chmod(path, 0600))
+	keys, err = encryption.LoadKeyfile(path)
+	require.NoError(t, err)
+	_, err = keys.Cipher()
+	require.NoError(t, err)
+
+	keys.Keys["k1"] = encoded([]byte(strings.Repeat("x", 16)))
+	_, err = keys.Cipher()
+	require.Error(t, err, "key is too short")
+}
@@ -0,0 +1,153 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LocalKMS keeps key encryption keys in memory, e.g. loaded from a keyfile or the environment
+type LocalKMS struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewLocalKMS creates a KMS with the given AES-256 keys by their IDs, new data keys are wrapped with the current one
+func NewLocalKMS(current string, keys map[string][]byte) (*LocalKMS, error) {
+	for id, key := range keys {
+		if id == "" || strings.ContainsAny(id, ": ") {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("key %s must be %d bytes long", id, dataKeySize)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is unknown", current)
+	}
+	return &LocalKMS{current: current, keys: keys}, nil
+}
+
+// CurrentKeyID implements KMS
+func (k *LocalKMS) CurrentKeyID() string {
+	return k.current
+}
+
+// WrapKey implements KMS
+func (k *LocalKMS) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// UnwrapKey implements KMS
+func (k *LocalKMS) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("malformed wrapped key")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyID))
+}
+
+func (k *LocalKMS) aead(keyID string) (cipher.AEAD, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return newAEAD(key)
+}
+
+// Keys configure a local KMS and the blind index. Keys are base64 encoded
+type Keys struct {
+	// Current is the ID of the key new values are encrypted with
+	Current string `json:"current"`
+	// Keys are AES-256 key encryption keys by their IDs. Old keys must be kept until values are rotated
+	Keys map[string]string `json:"keys"`
+	// IndexKey is the secret of blind indexes
+	IndexKey string `json:"indexKey"`
+}
+
+// LoadKeyfile reads keys from a JSON file, which must not be readable by others
+func LoadKeyfile(path string) (Keys, error) {
+	var keys Keys
+	info, err := os.Stat(path)
+	if err != nil {
+		return keys, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return keys, fmt.Errorf("keyfile %s must not be accessible by group or others", path)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return keys, err
+	}
+	return keys, errors.Wrapf(json.Unmarshal(content, &keys), "parse keyfile %s", path)
+}
+
+// KeysFromEnv reads keys from ENCRYPTION_KEYS, a comma separated list of id:key pairs,
+// ENCRYPTION_CURRENT_KEY, which defaults to the last key of the list, and ENCRYPTION_INDEX_KEY
+func KeysFromEnv() (Keys, error) {
+	keys := Keys{
+		Current:  os.Getenv("ENCRYPTION_CURRENT_KEY"),
+		Keys:     make(map[string]string),
+		IndexKey: os.Getenv("ENCRYPTION_INDEX_KEY"),
+	}
+	var last string
+	for _, pair := range strings.Split(os.Getenv("ENCRYPTION_KEYS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		separator := strings.Index(pair, ":")
+		if separator <= 0 {
+			return keys, fmt.Errorf("invalid key in ENCRYPTION_KEYS, expected id:key")
+		}
+		last = pair[:separator]
+		keys.Keys[last] = pair[separator+1:]
+	}
+	if len(keys.Keys) == 0 {
+		return keys, fmt.Errorf("ENCRYPTION_KEYS is empty")
+	}
+	if keys.Current == "" {
+		keys.Current = last
+	}
+	return keys, nil
+}
+
+// Cipher creates a cipher backed by a local KMS with the keys
+func (k Keys) Cipher() (*Cipher, error) {
+	decoded := make(map[string][]byte)
+	for id, key := range k.Keys {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s isn't valid base64", id)
+		}
+		decoded[id] = raw
+	}
+	kms, err := NewLocalKMS(k.Current, decoded)
+	if err != nil {
+		return nil, err
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(k.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("index key isn't valid base64")
+	}
+	return NewCipher(kms, indexKey)
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/havr/customers/stores"
+)
+
+const (
+	// DefaultRelayInterval is the default period between outbox polls
+	DefaultRelayInterval = time.Second
+	relayBatchSize       = 100
+)
+
+// Relay delivers events from the outbox to sinks.
+// Every sink receives events at least once, in the order ListEvents returns them: by transaction ID, which may differ
+// from the commit order, without skipping events of transactions that commit out of order
+type Relay struct {
+	outbox   stores.OutboxStore
+	sinks    []Sink
+	Interval time.Duration
+}
+
+// NewRelay creates a relay that delivers events of the given outbox to the given sinks
+func NewRelay(outbox stores.OutboxStore, sinks ...Sink) *Relay {
+	return &Relay{
+		outbox:   outbox,
+		sinks:    sinks,
+		Interval: DefaultRelayInterval,
+	}
+}
+
+// Run delivers events until the context is done. Each sink is served by its own goroutine,
+// so a failing sink doesn't hold back the others
+func (r *Relay) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, sink := range r.sinks {
+		wg.Add(1)
+		go func(sink Sink) {
+			defer wg.Done()
+			r.runSink(ctx, sink)
+		}(sink)
+	}
+	wg.Wait()
+}
+
+func (r *Relay) runSink(ctx context.Context, sink Sink) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Deliver(ctx, sink); err != nil && ctx.Err() == nil {
+			fmt.Println("relay events to", sink.Name(), ":", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver delivers all events the sink hasn't received yet.
+// It stops on the first failed event, so it can be delivered again on the next try
+func (r *Relay) Deliver(ctx context.Context, sink Sink) error {
+	offset, err := r.outbox.GetOffset(ctx, sink.Name())
+	if err != nil {
+		return err
+	}
+	for {
+		events, err := r.outbox.ListEvents(ctx, offset, relayBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := sink.Deliver(ctx, event); err != nil {
+				return err
+			}
+			offset = event.Position()
+			if err := r.outbox.SetOffset(ctx, sink.Name(), offset); err != nil {
+				return err
+			}
+		}
+		if len(events) < relayBatchSize {
+			return nil
+		}
+	}
+}
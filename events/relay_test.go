@@ -0,0 +1,104 @@
+package events_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/events"
+	"github.com/havr/customers/models"
+)
+
+func TestRelayDeliversInOrder(t *testing.T) {
+	outbox := newFakeOutbox(250)
+	sink := &fakeSink{}
+	relay := events.NewRelay(outbox)
+	require.NoError(t, relay.Deliver(context.Background(), sink))
+	require.Equal(t, outbox.events, sink.delivered)
+	require.Equal(t, outbox.events[len(outbox.events)-1].Position(), outbox.offsets[sink.Name()])
+}
+
+func TestRelayRedeliversFailed(t *testing.T) {
+	ctx := context.Background()
+	outbox := newFakeOutbox(10)
+	sink := &fakeSink{failAt: 5}
+	relay := events.NewRelay(outbox)
+	require.Error(t, relay.Deliver(ctx, sink))
+	require.Equal(t, outbox.events[:5], sink.delivered)
+
+	sink.failAt = -1
+	require.NoError(t, relay.Deliver(ctx, sink))
+	require.Equal(t, outbox.events, sink.delivered)
+}
+
+func TestRelayResumesFromOffset(t *testing.T) {
+	ctx := context.Background()
+	outbox := newFakeOutbox(10)
+	relay := events.NewRelay(outbox)
+	require.NoError(t, relay.Deliver(ctx, &fakeSink{}))
+
+	outbox.append(5)
+	sink := &fakeSink{}
+	require.NoError(t, relay.Deliver(ctx, sink))
+	require.Equal(t, outbox.events[10:], sink.delivered)
+}
+
+type fakeSink struct {
+	delivered []models.Event
+	failAt    int
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Deliver(ctx context.Context, event models.Event) error {
+	if s.failAt > 0 && len(s.delivered) == s.failAt {
+		return fmt.Errorf("failed to deliver %v", event.ID)
+	}
+	s.delivered = append(s.delivered, event)
+	return nil
+}
+
+type fakeOutbox struct {
+	events  []models.Event
+	offsets map[string]models.EventPosition
+}
+
+func newFakeOutbox(n int) *fakeOutbox {
+	outbox := &fakeOutbox{offsets: make(map[string]models.EventPosition)}
+	outbox.append(n)
+	return outbox
+}
+
+func (o *fakeOutbox) append(n int) {
+	for i := 0; i < n; i++ {
+		id := int64(len(o.events) + 1)
+		o.events = append(o.events, models.Event{ID: id, TxID: id, Type: models.CustomerCreated, CustomerID: int(id)})
+	}
+}
+
+func (o *fakeOutbox) AppendEvent(ctx context.Context, event models.Event) (models.Event, error) {
+	return event, nil
+}
+
+func (o *fakeOutbox) ListEvents(ctx context.Context, after models.EventPosition, limit int) ([]models.Event, error) {
+	var result []models.Event
+	for _, event := range o.events {
+		if event.Position().After(after) && len(result) < limit {
+			result = append(result, event)
+		}
+	}
+	return result, nil
+}
+
+func (o *fakeOutbox) GetOffset(ctx context.Context, consumer string) (models.EventPosition, error) {
+	return o.offsets[consumer], nil
+}
+
+func (o *fakeOutbox) SetOffset(ctx context.Context, consumer string, position models.EventPosition) error {
+	o.offsets[consumer] = position
+	return nil
+}
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+// Sink delivers customer domain events to an external system.
+// Its name identifies the delivery offset, so it must stay the same between restarts
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, event models.Event) error
+}
+
+// ParseSink creates a sink from its specification, which is one of:
+// "stdout", "file:<path>" or an http(s) URL
+func ParseSink(spec string) (Sink, error) {
+	switch {
+	case spec == "stdout":
+		return NewWriterSink(spec, os.Stdout), nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileSink(strings.TrimPrefix(spec, "file:")), nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return NewHTTPSink(spec, http.DefaultClient), nil
+	}
+	return nil, fmt.Errorf("unknown event sink: %q", spec)
+}
+
+// NewWriterSink creates a sink that writes events to the given writer as JSON lines
+func NewWriterSink(name string, w io.Writer) Sink {
+	return &writerSink{
+		name: name,
+		w:    w,
+	}
+}
+
+type writerSink struct {
+	name string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+func (s *writerSink) Name() string {
+	return s.name
+}
+
+func (s *writerSink) Deliver(ctx context.Context, event models.Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// NewFileSink creates a sink that appends events to the given file as JSON lines
+func NewFileSink(path string) Sink {
+	return &fileSink{
+		path: path,
+	}
+}
+
+type fileSink struct {
+	path string
+}
+
+func (s *fileSink) Name() string {
+	return "file:" + s.path
+}
+
+func (s *fileSink) Deliver(ctx context.Context, event models.Event) (gerr error) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "open event file")
+	}
+	defer func() {
+		if err := file.Close(); err != nil && gerr == nil {
+			gerr = err
+		}
+	}()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// NewHTTPSink creates a sink that POSTs events to the given URL as JSON
+func NewHTTPSink(url string, client *http.Client) Sink {
+	return &httpSink{
+		url:    url,
+		client: client,
+	}
+}
+
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Name() string {
+	return s.url
+}
+
+func (s *httpSink) Deliver(ctx context.Context, event models.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "post event %v", event.ID)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post event %v: unexpected status %v", event.ID, resp.Status)
+	}
+	return nil
+}
@@ -1,15 +1,20 @@
 module github.com/havr/customers
 
+go 1.27.1
+
 require (
 	github.com/badoux/checkmail v0.0.0-20180430153108-0755fe2dc241
 	github.com/bearbin/go-age v0.0.0-20140407072555-316d0c1e7cd1
-	github.com/corpix/uarand v0.0.0-20170903190822-2b8494104d86 // indirect
-	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/mux v1.6.2
 	github.com/icrowley/fake v0.0.0-20180203215853-4178557ae428
 	github.com/lib/pq v1.0.0
 	github.com/pkg/errors v0.8.0
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2
 )
+
+require (
+	github.com/corpix/uarand v0.0.0-20170903190822-2b8494104d86 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/gorilla/context v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
@@ -0,0 +1,48 @@
+package live
+
+import (
+	"sync"
+
+	"github.com/havr/customers/models"
+)
+
+const subscriberBuffer = 64
+
+// Hub fans out customer changes to subscribers, e.g. browsers connected to the change stream.
+// A subscriber that doesn't keep up misses changes rather than holding back the others
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[chan models.CustomerChange]struct{}
+}
+
+// NewHub creates a hub without subscribers
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[chan models.CustomerChange]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives changes published after the call and a function that cancels the subscription
+func (h *Hub) Subscribe() (<-chan models.CustomerChange, func()) {
+	ch := make(chan models.CustomerChange, subscriberBuffer)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Publish sends the change to all subscribers
+func (h *Hub) Publish(change models.CustomerChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package live_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/live"
+	"github.com/havr/customers/models"
+)
+
+func TestHubFansOut(t *testing.T) {
+	hub := live.NewHub()
+	first, cancelFirst := hub.Subscribe()
+	second, cancelSecond := hub.Subscribe()
+	defer cancelSecond()
+
+	change := models.CustomerChange{Type: models.ChangeUpdate, ID: 1, Revision: 2}
+	hub.Publish(change)
+	require.Equal(t, change, <-first)
+	require.Equal(t, change, <-second)
+
+	cancelFirst()
+	hub.Publish(change)
+	require.Equal(t, change, <-second)
+	require.Len(t, first, 0)
+}
+
+func TestHubDoesNotBlockOnSlowSubscribers(t *testing.T) {
+	hub := live.NewHub()
+	_, cancel := hub.Subscribe()
+	defer cancel()
+	for i := 0; i < 1000; i++ {
+		hub.Publish(models.CustomerChange{Type: models.ChangeCreate, ID: i})
+	}
+}
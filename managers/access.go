@@ -0,0 +1,80 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/models"
+)
+
+// SystemUser acts on behalf of the application itself, e.g. when applying updates from upstream systems
+var SystemUser = models.User{Username: "system", Role: models.RoleAdmin}
+
+// PermissionError occurs when the current user lacks a permission an operation requires
+type PermissionError struct {
+	Permission models.Permission
+}
+
+func (e PermissionError) Error() string {
+	return fmt.Sprintf("permission denied: %s is required", e.Permission)
+}
+
+// ErrorKind implements apperr.Kinded
+func (e PermissionError) ErrorKind() apperr.Kind {
+	return apperr.PermissionDenied
+}
+
+type apiKeyKey struct{}
+
+// WithAPIKey returns a context that carries the given API key
+func WithAPIKey(ctx context.Context, key models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, key)
+}
+
+// APIKeyFromContext returns the API key the context carries, if any
+func APIKeyFromContext(ctx context.Context) (models.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyKey{}).(models.APIKey)
+	return key, ok
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context that carries the ID of the request being served, which ties logs and records to it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the ID of the request the context belongs to, empty if it doesn't belong to one
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// AsSystem returns a context that acts on behalf of SystemUser
+func AsSystem(ctx context.Context) context.Context {
+	return WithUser(ctx, SystemUser)
+}
+
+// Authorize returns PermissionError unless the user or the API key the context carries has the given permission.
+// A context without either has no permissions at all
+func Authorize(ctx context.Context, permission models.Permission) error {
+	if user, ok := UserFromContext(ctx); ok && user.Can(permission) {
+		return nil
+	}
+	if key, ok := APIKeyFromContext(ctx); ok && key.Can(permission) {
+		return nil
+	}
+	return PermissionError{Permission: permission}
+}
+
+// Actor returns the name of whoever acts in the given context
+func Actor(ctx context.Context) string {
+	if user, ok := UserFromContext(ctx); ok {
+		return user.Username
+	}
+	if key, ok := APIKeyFromContext(ctx); ok {
+		return "apikey:" + key.Name
+	}
+	return ""
+}
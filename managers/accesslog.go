@@ -0,0 +1,187 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+const (
+	// DefaultAccessLogInterval is the default period between writes of recorded accesses
+	DefaultAccessLogInterval = 5 * time.Second
+	// DefaultAccessLogBatchSize is the default number of accesses that are written at once
+	DefaultAccessLogBatchSize = 500
+	// maxPendingAccesses bounds accesses kept while the store fails, older ones are dropped beyond it
+	maxPendingAccesses = 100000
+)
+
+// AccessRecorder records reads of customer data
+type AccessRecorder interface {
+	RecordAccess(ctx context.Context, purpose models.AccessPurpose, customerIDs ...int)
+}
+
+type accessPurposeKey struct{}
+
+// WithAccessPurpose returns a context whose reads of customers are recorded with the given purpose
+// rather than the one the operation implies, e.g. a customer read by the edit page
+func WithAccessPurpose(ctx context.Context, purpose models.AccessPurpose) context.Context {
+	return context.WithValue(ctx, accessPurposeKey{}, purpose)
+}
+
+// accessPurpose returns the purpose the context carries, or the given one
+func accessPurpose(ctx context.Context, purpose models.AccessPurpose) models.AccessPurpose {
+	if carried, ok := ctx.Value(accessPurposeKey{}).(models.AccessPurpose); ok {
+		return carried
+	}
+	return purpose
+}
+
+// AccessLog records who has read which customers. Accesses are collected in memory and written in batches,
+// so that a page of customers doesn't cost a write per row. Accesses recorded since the last write
+// are lost if the application crashes
+type AccessLog struct {
+	store     stores.AccessStore
+	Interval  time.Duration
+	BatchSize int
+	Now       func() time.Time
+
+	mu      sync.Mutex
+	pending []models.Access
+	full    chan struct{}
+}
+
+// NewAccessLog creates an access log that writes accesses to the given store
+func NewAccessLog(store stores.AccessStore) *AccessLog {
+	return &AccessLog{
+		store:     store,
+		Interval:  DefaultAccessLogInterval,
+		BatchSize: DefaultAccessLogBatchSize,
+		Now:       time.Now,
+		full:      make(chan struct{}, 1),
+	}
+}
+
+// RecordAccess implements AccessRecorder. The purpose the context carries takes precedence over the given one
+func (l *AccessLog) RecordAccess(ctx context.Context, purpose models.AccessPurpose, customerIDs ...int) {
+	if len(customerIDs) == 0 {
+		return
+	}
+	now := l.Now().UTC()
+	access := models.Access{
+		Actor:      Actor(ctx),
+		Purpose:    accessPurpose(ctx, purpose),
+		RequestID:  RequestIDFromContext(ctx),
+		AccessedAt: now,
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range customerIDs {
+		access.CustomerID = id
+		l.pending = append(l.pending, access)
+	}
+	l.dropOverflow()
+	if len(l.pending) >= l.BatchSize {
+		select {
+		case l.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run writes recorded accesses periodically or whenever a batch fills up until the context is done,
+// and then writes the rest
+func (l *AccessLog) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := l.Flush(context.Background()); err != nil {
+				fmt.Println("write access log:", err)
+			}
+			return
+		case <-ticker.C:
+		case <-l.full:
+		}
+		if err := l.Flush(ctx); err != nil && ctx.Err() == nil {
+			fmt.Println("write access log:", err)
+		}
+	}
+}
+
+// Flush writes all recorded accesses in batches. Accesses that haven't been written are kept for the next try
+func (l *AccessLog) Flush(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		batch := l.pending
+		if len(batch) > l.BatchSize {
+			batch = batch[:l.BatchSize]
+		}
+		l.pending = l.pending[len(batch):]
+		l.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := l.store.RecordAccesses(ctx, batch); err != nil {
+			l.mu.Lock()
+			l.pending = append(batch[:len(batch):len(batch)], l.pending...)
+			l.dropOverflow()
+			l.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// dropOverflow drops the oldest accesses beyond maxPendingAccesses, so that a failing store doesn't exhaust memory
+func (l *AccessLog) dropOverflow() {
+	if dropped := len(l.pending) - maxPendingAccesses; dropped > 0 {
+		fmt.Printf("access log is full, %v accesses dropped\n", dropped)
+		l.pending = l.pending[dropped:]
+	}
+}
+
+// ListAccesses returns recorded accesses that match the filter, the latest first
+func (l *AccessLog) ListAccesses(ctx context.Context, filter models.AccessFilter, offset, limit int) ([]models.Access, error) {
+	if err := Authorize(ctx, models.ReadAccessLog); err != nil {
+		return nil, err
+	}
+	// accesses that are still pending are written first, so that the log is up to date
+	if err := l.Flush(ctx); err != nil {
+		return nil, err
+	}
+	return l.store.ListAccesses(ctx, filter, offset, limit)
+}
+
+// ExportAccesses passes every recorded access that matches the filter to fn, reading them in batches in the order of IDs
+func (l *AccessLog) ExportAccesses(ctx context.Context, filter models.AccessFilter, fn func(models.Access) error) error {
+	if err := Authorize(ctx, models.ReadAccessLog); err != nil {
+		return err
+	}
+	if err := l.Flush(ctx); err != nil {
+		return err
+	}
+	// the export is bounded by the time it starts, so that it doesn't chase accesses recorded meanwhile
+	if filter.Until.IsZero() {
+		filter.Until = l.Now().UTC().Add(time.Second)
+	}
+	var lastID int64
+	for {
+		accesses, err := l.store.ListAccessesAfter(ctx, filter, lastID, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, access := range accesses {
+			if err := fn(access); err != nil {
+				return err
+			}
+		}
+		if len(accesses) < exportBatchSize {
+			return nil
+		}
+		lastID = accesses[len(accesses)-1].ID
+	}
+}
@@ -0,0 +1,143 @@
+package managers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+func TestAccessLogWritesBatches(t *testing.T) {
+	store := &fakeAccessStore{}
+	log := managers.NewAccessLog(store)
+	log.BatchSize = 10
+	ctx := managers.WithRequestID(managers.WithUser(context.Background(), admin), "req-1")
+
+	ids := make([]int, 25)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	log.RecordAccess(ctx, models.AccessList, ids...)
+	require.Empty(t, store.batches, "accesses are written in the background")
+
+	store.err = errors.New("database is down")
+	require.Error(t, log.Flush(ctx))
+	store.err = nil
+	require.NoError(t, log.Flush(ctx))
+	require.Len(t, store.batches, 3)
+	require.Len(t, store.batches[0], 10)
+	require.Len(t, store.batches[2], 5)
+	var written []int
+	for _, batch := range store.batches {
+		for _, access := range batch {
+			require.Equal(t, "admin", access.Actor)
+			require.Equal(t, models.AccessList, access.Purpose)
+			require.Equal(t, "req-1", access.RequestID)
+			written = append(written, access.CustomerID)
+		}
+	}
+	require.Equal(t, ids, written, "failed batches are retried")
+
+	require.NoError(t, log.Flush(ctx))
+	require.Len(t, store.batches, 3, "nothing is written twice")
+}
+
+func TestManagerRecordsAccesses(t *testing.T) {
+	store := &memoryCustomerStore{customers: make(map[int]models.Customer)}
+	accesses := &fakeAccessStore{}
+	log := managers.NewAccessLog(accesses)
+	mgr := managers.NewCustomerManager(store, managers.WithAccessLog(log))
+	ctx := managers.WithUser(context.Background(), admin)
+	first, err := mgr.CreateCustomer(ctx, validCustomer)
+	require.NoError(t, err)
+	second, err := mgr.CreateCustomer(ctx, validCustomer)
+	require.NoError(t, err)
+
+	_, err = mgr.GetCustomer(ctx, first.ID)
+	require.NoError(t, err)
+	_, err = mgr.GetCustomer(managers.WithAccessPurpose(ctx, models.AccessEdit), second.ID)
+	require.NoError(t, err)
+	_, err = mgr.ListCustomers(ctx, stores.CustomerListFilter{}, stores.CustomerViewOptions{})
+	require.NoError(t, err)
+	require.NoError(t, mgr.ExportCustomers(ctx, stores.CustomerListFilter{}, func(models.Customer) error { return nil }))
+
+	list, err := log.ListAccesses(ctx, models.AccessFilter{}, 0, 100)
+	require.NoError(t, err)
+	var got []models.Access
+	for _, access := range list {
+		got = append(got, models.Access{CustomerID: access.CustomerID, Purpose: access.Purpose})
+	}
+	require.Equal(t, []models.Access{
+		{CustomerID: first.ID, Purpose: models.AccessView},
+		{CustomerID: second.ID, Purpose: models.AccessEdit},
+		{CustomerID: first.ID, Purpose: models.AccessList},
+		{CustomerID: second.ID, Purpose: models.AccessList},
+		{CustomerID: first.ID, Purpose: models.AccessExport},
+		{CustomerID: second.ID, Purpose: models.AccessExport},
+	}, got)
+
+	intern := managers.WithUser(context.Background(), models.User{Username: "intern", Role: models.RoleIntern})
+	_, err = log.ListAccesses(intern, models.AccessFilter{}, 0, 100)
+	require.Equal(t, managers.PermissionError{Permission: models.ReadAccessLog}, err)
+}
+
+func TestAccessLogExportsInBatches(t *testing.T) {
+	store := &fakeAccessStore{}
+	log := managers.NewAccessLog(store)
+	ctx := managers.WithUser(context.Background(), admin)
+	ids := make([]int, 1200)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	log.RecordAccess(ctx, models.AccessList, ids...)
+
+	var exported []int
+	require.NoError(t, log.ExportAccesses(ctx, models.AccessFilter{}, func(access models.Access) error {
+		exported = append(exported, access.CustomerID)
+		return nil
+	}))
+	require.Equal(t, ids, exported)
+}
+
+type fakeAccessStore struct {
+	batches [][]models.Access
+	err     error
+}
+
+func (s *fakeAccessStore) RecordAccesses(ctx context.Context, accesses []models.Access) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, append([]models.Access(nil), accesses...))
+	return nil
+}
+
+// ListAccesses returns accesses in the order they have been written rather than the latest first
+func (s *fakeAccessStore) ListAccesses(ctx context.Context, filter models.AccessFilter, offset, limit int) ([]models.Access, error) {
+	var result []models.Access
+	for _, batch := range s.batches {
+		result = append(result, batch...)
+	}
+	return result, nil
+}
+
+// ListAccessesAfter takes the position of an access in the order it has been written for its ID
+func (s *fakeAccessStore) ListAccessesAfter(ctx context.Context, filter models.AccessFilter, afterID int64, limit int) ([]models.Access, error) {
+	var result []models.Access
+	var id int64
+	for _, batch := range s.batches {
+		for _, access := range batch {
+			id++
+			if id > afterID && len(result) < limit {
+				access.ID = id
+				result = append(result, access)
+			}
+		}
+	}
+	return result, nil
+}
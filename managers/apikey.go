@@ -0,0 +1,147 @@
+package managers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+const (
+	// apiKeyTokenPrefix makes API keys easy to recognize, e.g. by secret scanners
+	apiKeyTokenPrefix = "ck_"
+	// apiKeyPrefixLength is the number of leading characters of a key that are stored in plain text
+	apiKeyPrefixLength = len(apiKeyTokenPrefix) + 8
+)
+
+// ErrInvalidAPIKey occurs when an API key doesn't exist, is revoked, expired or is used from a disallowed address
+var ErrInvalidAPIKey = fmt.Errorf("invalid api key")
+
+// APIKeyManager represents business logic related to API keys of machine clients
+type APIKeyManager struct {
+	store stores.APIKeyStore
+	Now   func() time.Time
+}
+
+// NewAPIKeyManager creates an API key manager that uses the given store
+func NewAPIKeyManager(store stores.APIKeyStore) *APIKeyManager {
+	return &APIKeyManager{
+		store: store,
+		Now:   time.Now,
+	}
+}
+
+// CreateAPIKey validates and creates the given key and returns it along with its value.
+// The value is never stored, so it can't be shown again
+func (a *APIKeyManager) CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, string, error) {
+	if err := Authorize(ctx, models.ManageAPIKeys); err != nil {
+		return models.APIKey{}, "", err
+	}
+	if err := a.ValidateAPIKey(key); err != nil {
+		return models.APIKey{}, "", err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return models.APIKey{}, "", err
+	}
+	token := apiKeyTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	key.Prefix = token[:apiKeyPrefixLength]
+	key.Hash = apiKeyHash(token)
+	key.CreatedBy = Actor(ctx)
+	key.LastUsedAt, key.RevokedAt = nil, nil
+	created, err := a.store.CreateAPIKey(ctx, key)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	return created, token, nil
+}
+
+// ListAPIKeys returns all API keys including revoked ones
+func (a *APIKeyManager) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	if err := Authorize(ctx, models.ManageAPIKeys); err != nil {
+		return nil, err
+	}
+	return a.store.ListAPIKeys(ctx)
+}
+
+// RevokeAPIKey makes the key with the given ID unusable
+func (a *APIKeyManager) RevokeAPIKey(ctx context.Context, id int) error {
+	if err := Authorize(ctx, models.ManageAPIKeys); err != nil {
+		return err
+	}
+	return a.store.RevokeAPIKey(ctx, id, a.Now())
+}
+
+// Authenticate returns the key with the given value if it may be used from the given address, or ErrInvalidAPIKey.
+// It records the time the key has been used
+func (a *APIKeyManager) Authenticate(ctx context.Context, token string, ip net.IP) (models.APIKey, error) {
+	key, err := a.store.GetAPIKeyByHash(ctx, apiKeyHash(token))
+	if err != nil {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	now := a.Now()
+	if !key.Active(now) || !key.AllowsIP(ip) {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > touchInterval {
+		if err := a.store.TouchAPIKey(ctx, key.ID, now); err != nil {
+			return models.APIKey{}, err
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
+
+// ValidateAPIKey validates the given key and returns all errors it encountered, if any
+func (a *APIKeyManager) ValidateAPIKey(key models.APIKey) error {
+	var errs MultipleErrors
+	if err := validateString("name", key.Name, true, 100); err != nil {
+		errs = append(errs, err)
+	}
+	if len(key.Scopes) == 0 {
+		errs = append(errs, &FieldError{Field: "scopes", Code: CodeRequired})
+	}
+	for _, scope := range key.Scopes {
+		if !knownScope(scope) {
+			errs = append(errs, &FieldError{Field: "scopes", Code: CodeInvalidChoice, Params: map[string]interface{}{
+				"value":   scope,
+				"allowed": models.APIKeyScopes,
+			}})
+		}
+	}
+	for _, allowed := range key.AllowedIPs {
+		if _, _, err := net.ParseCIDR(allowed); err != nil && net.ParseIP(allowed) == nil {
+			errs = append(errs, &FieldError{Field: "allowedIps", Code: CodeInvalidFormat, Params: map[string]interface{}{"value": allowed}})
+		}
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(a.Now()) {
+		errs = append(errs, &FieldError{Field: "expiresAt", Code: CodeInPast})
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func knownScope(scope models.Permission) bool {
+	for _, known := range models.APIKeyScopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyHash derives the stored hash from the key value.
+// Keys are long random strings, so unlike passwords they don't need a slow salted hash
+func apiKeyHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
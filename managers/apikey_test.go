@@ -0,0 +1,121 @@
+package managers_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memoryAPIKeyStore{}
+	mgr := managers.NewAPIKeyManager(store)
+	mgr.Now = func() time.Time { return now }
+	ctx := managers.WithUser(context.Background(), admin)
+
+	expiresAt := now.Add(time.Hour)
+	key, token, err := mgr.CreateAPIKey(ctx, models.APIKey{
+		Name:       "billing",
+		Scopes:     []models.Permission{models.ReadCustomers, models.ExportCustomers},
+		AllowedIPs: []string{"10.0.0.0/8", "192.168.1.1"},
+		ExpiresAt:  &expiresAt,
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, key.Prefix))
+	require.NotContains(t, key.Hash, token)
+	require.Equal(t, "admin", key.CreatedBy)
+
+	authenticated, err := mgr.Authenticate(ctx, token, net.ParseIP("10.1.2.3"))
+	require.NoError(t, err)
+	require.Equal(t, key.ID, authenticated.ID)
+	require.Equal(t, now, *store.keys[0].LastUsedAt)
+
+	_, err = mgr.Authenticate(ctx, token, net.ParseIP("192.168.1.1"))
+	require.NoError(t, err)
+	_, err = mgr.Authenticate(ctx, token, net.ParseIP("172.16.0.1"))
+	require.Equal(t, managers.ErrInvalidAPIKey, err)
+	_, err = mgr.Authenticate(ctx, token+"x", net.ParseIP("10.1.2.3"))
+	require.Equal(t, managers.ErrInvalidAPIKey, err)
+
+	now = now.Add(2 * time.Hour)
+	_, err = mgr.Authenticate(ctx, token, net.ParseIP("10.1.2.3"))
+	require.Equal(t, managers.ErrInvalidAPIKey, err, "expired key")
+
+	_, token, err = mgr.CreateAPIKey(ctx, models.APIKey{Name: "crm", Scopes: []models.Permission{models.WriteCustomers}})
+	require.NoError(t, err)
+	_, err = mgr.Authenticate(ctx, token, nil)
+	require.NoError(t, err)
+	require.NoError(t, mgr.RevokeAPIKey(ctx, 2))
+	_, err = mgr.Authenticate(ctx, token, nil)
+	require.Equal(t, managers.ErrInvalidAPIKey, err, "revoked key")
+}
+
+func TestAPIKeyValidation(t *testing.T) {
+	mgr := managers.NewAPIKeyManager(&memoryAPIKeyStore{})
+	ctx := managers.WithUser(context.Background(), admin)
+
+	past := time.Now().Add(-time.Hour)
+	_, _, err := mgr.CreateAPIKey(ctx, models.APIKey{
+		Scopes:     []models.Permission{models.ManageAPIKeys},
+		AllowedIPs: []string{"not an ip"},
+		ExpiresAt:  &past,
+	})
+	require.Len(t, err.(managers.MultipleErrors), 4)
+
+	support := managers.WithUser(context.Background(), models.User{Username: "support", Role: models.RoleSupport})
+	_, _, err = mgr.CreateAPIKey(support, models.APIKey{Name: "key", Scopes: []models.Permission{models.ReadCustomers}})
+	require.Equal(t, managers.PermissionError{Permission: models.ManageAPIKeys}, err)
+}
+
+func TestAPIKeyPermissions(t *testing.T) {
+	mgr := managers.NewCustomerManager(fakeCustomerStore{})
+	ctx := managers.WithAPIKey(context.Background(), models.APIKey{Name: "reader", Scopes: []models.Permission{models.ReadCustomers}})
+
+	_, err := mgr.GetCustomer(ctx, 1)
+	require.NoError(t, err)
+	_, err = mgr.CreateCustomer(ctx, validCustomer)
+	require.Equal(t, managers.PermissionError{Permission: models.WriteCustomers}, err)
+	err = mgr.ExportCustomers(ctx, models.CustomerListFilter{}, func(models.Customer) error { return nil })
+	require.Equal(t, managers.PermissionError{Permission: models.ExportCustomers}, err)
+	require.Equal(t, "apikey:reader", managers.Actor(ctx))
+}
+
+type memoryAPIKeyStore struct {
+	keys []models.APIKey
+}
+
+func (s *memoryAPIKeyStore) CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	key.ID = len(s.keys) + 1
+	s.keys = append(s.keys, key)
+	return key, nil
+}
+
+func (s *memoryAPIKeyStore) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return s.keys, nil
+}
+
+func (s *memoryAPIKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	for _, key := range s.keys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return models.APIKey{}, stores.ErrNotFound
+}
+
+func (s *memoryAPIKeyStore) RevokeAPIKey(ctx context.Context, id int, at time.Time) error {
+	s.keys[id-1].RevokedAt = &at
+	return nil
+}
+
+func (s *memoryAPIKeyStore) TouchAPIKey(ctx context.Context, id int, at time.Time) error {
+	s.keys[id-1].LastUsedAt = &at
+	return nil
+}
@@ -2,12 +2,11 @@ package managers
 
 import (
 	"context"
-	"fmt"
-	"strings"
 	"time"
 
 	"github.com/badoux/checkmail"
 	"github.com/bearbin/go-age"
+	"github.com/havr/customers/apperr"
 	"github.com/havr/customers/models"
 	"github.com/havr/customers/stores"
 )
@@ -21,50 +20,457 @@ const (
 
 var (
 	// ErrCustomerTooOld occurs when customer age exceeds allowed MaxCustomerAge
-	ErrCustomerTooOld = fmt.Errorf("customer is too old")
+	ErrCustomerTooOld = &FieldError{Field: "birthDate", Code: CodeTooOld, Params: map[string]interface{}{"max": MaxCustomerAge}}
 	// ErrCustomerTooYoung occurs when customer age is lesser then allowed MaxCustomerAge
-	ErrCustomerTooYoung = fmt.Errorf("customer is too young")
+	ErrCustomerTooYoung = &FieldError{Field: "birthDate", Code: CodeTooYoung, Params: map[string]interface{}{"min": MinCustomerAge}}
 	// ErrInvalidEmail occurs when email field is invalid
-	ErrInvalidEmail = fmt.Errorf("email has invalid format")
+	ErrInvalidEmail = &FieldError{Field: "email", Code: CodeInvalidFormat}
 )
 
-// CustomerManager represents business logic related to customer management, such as validation
+// CustomerManager represents business logic related to customer management, such as validation and access control.
+// Every operation requires the user of the context to have the corresponding permission
 type CustomerManager struct {
 	stores.CustomerStore
+	transactor stores.Transactor
+	outbox     stores.OutboxStore
+	reveals    stores.RevealStore
+	accesses   AccessRecorder
+	audit      stores.AuditStore
+}
+
+// Option configures optional dependencies of a customer manager
+type Option func(c *CustomerManager)
+
+// WithOutbox makes the manager record domain events into the given outbox
+// within the same transaction as the corresponding customer change
+func WithOutbox(transactor stores.Transactor, outbox stores.OutboxStore) Option {
+	return func(c *CustomerManager) {
+		c.transactor = transactor
+		c.outbox = outbox
+	}
+}
+
+// WithRevealLog makes the manager record reveals of personal data into the given store, which enables RevealCustomer
+func WithRevealLog(reveals stores.RevealStore) Option {
+	return func(c *CustomerManager) {
+		c.reveals = reveals
+	}
+}
+
+// WithAccessLog makes the manager record every read of customer data with the given recorder
+func WithAccessLog(accesses AccessRecorder) Option {
+	return func(c *CustomerManager) {
+		c.accesses = accesses
+	}
+}
+
+// WithAuditTrail makes the manager append every change of customers to the given audit trail
+// within the same transaction as the change
+func WithAuditTrail(transactor stores.Transactor, audit stores.AuditStore) Option {
+	return func(c *CustomerManager) {
+		c.transactor = transactor
+		c.audit = audit
+	}
 }
 
 // NewCustomerManager creates a customer manager that uses the given store
-func NewCustomerManager(store stores.CustomerStore) *CustomerManager {
-	return &CustomerManager{
+func NewCustomerManager(store stores.CustomerStore, options ...Option) *CustomerManager {
+	manager := &CustomerManager{
 		CustomerStore: store,
+		transactor:    noTransactor{},
+	}
+	for _, option := range options {
+		option(manager)
 	}
+	return manager
 }
 
-// UpdateCustomer updates the given customer model
+// GetCustomer returns a customer by its ID. Personal data is masked unless the user may view it
+func (c *CustomerManager) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
+	if err := Authorize(ctx, models.ReadCustomers); err != nil {
+		return models.Customer{}, err
+	}
+	customer, err := c.CustomerStore.GetCustomer(ctx, id)
+	if err != nil {
+		return models.Customer{}, err
+	}
+	c.recordAccess(ctx, models.AccessView, customer.ID)
+	return maskCustomer(ctx, customer), nil
+}
+
+// ErrNoRevealLog occurs when personal data is revealed through a manager that can't record it
+var ErrNoRevealLog = apperr.New(apperr.Unavailable, "personal data can't be revealed, since reveals aren't recorded")
+
+// RevealCustomer returns a customer with personal data unmasked and records who has seen it.
+// It requires the user to be allowed to reveal personal data, unless the user may view it anyway
+func (c *CustomerManager) RevealCustomer(ctx context.Context, id int) (models.Customer, error) {
+	if err := Authorize(ctx, models.ReadCustomers); err != nil {
+		return models.Customer{}, err
+	}
+	if Authorize(ctx, models.ViewPII) == nil {
+		customer, err := c.CustomerStore.GetCustomer(ctx, id)
+		if err != nil {
+			return models.Customer{}, err
+		}
+		c.recordAccess(ctx, models.AccessView, customer.ID)
+		return customer, nil
+	}
+	if err := Authorize(ctx, models.RevealPII); err != nil {
+		return models.Customer{}, err
+	}
+	if c.reveals == nil {
+		return models.Customer{}, ErrNoRevealLog
+	}
+	customer, err := c.CustomerStore.GetCustomer(ctx, id)
+	if err != nil {
+		return models.Customer{}, err
+	}
+	_, err = c.reveals.RecordReveal(ctx, models.Reveal{
+		CustomerID: id,
+		Actor:      Actor(ctx),
+		Fields:     models.MaskedFields,
+		RequestID:  RequestIDFromContext(ctx),
+	})
+	if err != nil {
+		return models.Customer{}, err
+	}
+	c.recordAccess(ctx, models.AccessReveal, customer.ID)
+	return customer, nil
+}
+
+// recordAccess records reads of the given customers, if the manager has an access log
+func (c *CustomerManager) recordAccess(ctx context.Context, purpose models.AccessPurpose, customerIDs ...int) {
+	if c.accesses != nil {
+		c.accesses.RecordAccess(ctx, purpose, customerIDs...)
+	}
+}
+
+// customerIDs returns IDs of the given customers
+func customerIDs(customers []models.Customer) []int {
+	ids := make([]int, len(customers))
+	for i, customer := range customers {
+		ids[i] = customer.ID
+	}
+	return ids
+}
+
+// maskCustomer masks personal data of the customer unless the user of the context may view it
+func maskCustomer(ctx context.Context, customer models.Customer) models.Customer {
+	if Authorize(ctx, models.ViewPII) == nil {
+		return customer
+	}
+	return customer.Masked()
+}
+
+// authorizeFilter checks that the user may filter customers by the given filter.
+// Filtering by email tells whether a customer has it, so it requires the permission to view personal data
+func authorizeFilter(ctx context.Context, filter stores.CustomerListFilter) error {
+	if filter.Email == "" {
+		return nil
+	}
+	return Authorize(ctx, models.ViewPII)
+}
+
+// CountCustomers returns the number of customers that match the given filter
+func (c *CustomerManager) CountCustomers(ctx context.Context, filter stores.CustomerListFilter) (int, error) {
+	if err := Authorize(ctx, models.ReadCustomers); err != nil {
+		return 0, err
+	}
+	if err := authorizeFilter(ctx, filter); err != nil {
+		return 0, err
+	}
+	return c.CustomerStore.CountCustomers(ctx, filter)
+}
+
+// ListCustomers returns customers that match the given filter. Personal data is masked unless the user may view it
+func (c *CustomerManager) ListCustomers(ctx context.Context, filter stores.CustomerListFilter, options stores.CustomerViewOptions) ([]models.Customer, error) {
+	if err := Authorize(ctx, models.ReadCustomers); err != nil {
+		return nil, err
+	}
+	if err := authorizeFilter(ctx, filter); err != nil {
+		return nil, err
+	}
+	customers, err := c.CustomerStore.ListCustomers(ctx, filter, options)
+	if err != nil {
+		return nil, err
+	}
+	c.recordAccess(ctx, models.AccessList, customerIDs(customers)...)
+	for i := range customers {
+		customers[i] = maskCustomer(ctx, customers[i])
+	}
+	return customers, nil
+}
+
+// UpdateCustomer updates the given customer model.
+// Masked fields that are left as they have been read keep their values, so users who may not view personal data
+// may still edit other fields
 func (c *CustomerManager) UpdateCustomer(ctx context.Context, customer models.Customer) error {
-	if err := c.ValidateCustomer(customer); err != nil {
+	if err := Authorize(ctx, models.WriteCustomers); err != nil {
 		return err
 	}
-	return c.CustomerStore.UpdateCustomer(ctx, customer)
+	masked := Authorize(ctx, models.ViewPII) != nil
+	if !masked {
+		if err := c.ValidateCustomer(customer); err != nil {
+			return err
+		}
+	}
+	return c.transactor.InTx(ctx, func(ctx context.Context) error {
+		if !c.recording() && !masked {
+			return c.CustomerStore.UpdateCustomer(ctx, customer)
+		}
+		old, err := c.CustomerStore.GetCustomer(ctx, customer.ID)
+		if err != nil {
+			return err
+		}
+		if masked {
+			customer = customer.Unmask(old)
+			if err := c.ValidateCustomer(customer); err != nil {
+				return err
+			}
+		}
+		if err := c.CustomerStore.UpdateCustomer(ctx, customer); err != nil {
+			return err
+		}
+		if !c.recording() {
+			return nil
+		}
+		updated, err := c.CustomerStore.GetCustomer(ctx, customer.ID)
+		if err != nil {
+			return err
+		}
+		return c.record(ctx, models.CustomerUpdated, updated, old.Diff(updated))
+	})
 }
 
 // CreateCustomer creates the given customer model
-func (c *CustomerManager) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+func (c *CustomerManager) CreateCustomer(ctx context.Context, customer models.Customer) (result models.Customer, _ error) {
+	if err := Authorize(ctx, models.WriteCustomers); err != nil {
+		return models.Customer{}, err
+	}
 	if err := c.ValidateCustomer(customer); err != nil {
 		return models.Customer{}, err
 	}
-	return c.CustomerStore.CreateCustomer(ctx, customer)
+	err := c.transactor.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		if result, err = c.CustomerStore.CreateCustomer(ctx, customer); err != nil {
+			return err
+		}
+		return c.record(ctx, models.CustomerCreated, result, nil)
+	})
+	if err != nil {
+		return models.Customer{}, err
+	}
+	return result, nil
+}
+
+// DeleteCustomer deletes a customer by its ID
+func (c *CustomerManager) DeleteCustomer(ctx context.Context, id int) error {
+	if err := Authorize(ctx, models.DeleteCustomers); err != nil {
+		return err
+	}
+	return c.transactor.InTx(ctx, func(ctx context.Context) error {
+		if !c.recording() {
+			return c.CustomerStore.DeleteCustomer(ctx, id)
+		}
+		old, err := c.CustomerStore.GetCustomer(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := c.CustomerStore.DeleteCustomer(ctx, id); err != nil {
+			return err
+		}
+		return c.record(ctx, models.CustomerDeleted, old, nil)
+	})
+}
+
+// RestoreCustomer brings back a deleted customer by its ID, which requires the same permission as deleting
+func (c *CustomerManager) RestoreCustomer(ctx context.Context, id int) error {
+	if err := Authorize(ctx, models.DeleteCustomers); err != nil {
+		return err
+	}
+	return c.transactor.InTx(ctx, func(ctx context.Context) error {
+		if err := c.CustomerStore.RestoreCustomer(ctx, id); err != nil {
+			return err
+		}
+		if !c.recording() {
+			return nil
+		}
+		restored, err := c.CustomerStore.GetCustomer(ctx, id)
+		if err != nil {
+			return err
+		}
+		return c.record(ctx, models.CustomerRestored, restored, nil)
+	})
+}
+
+// seedBatchSize is the number of generated customers created in a transaction
+const seedBatchSize = 100
+
+// SeedCustomers creates generated customers. Customers the generator has deliberately made invalid are stored
+// without validation, so that tests may see how the application copes with bad data that is already there.
+// Customers are committed in batches, so that the audit trail, which a transaction locks until it ends, isn't held
+// for the whole run; if a batch fails, the batches before it stay created
+func (c *CustomerManager) SeedCustomers(ctx context.Context, customers []models.GeneratedCustomer) error {
+	if err := Authorize(ctx, models.GenerateCustomers); err != nil {
+		return err
+	}
+	for start := 0; start < len(customers); start += seedBatchSize {
+		end := start + seedBatchSize
+		if end > len(customers) {
+			end = len(customers)
+		}
+		if err := c.transactor.InTx(ctx, func(ctx context.Context) error {
+			return c.seedBatch(ctx, customers[start:end])
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CustomerManager) seedBatch(ctx context.Context, customers []models.GeneratedCustomer) error {
+	for _, customer := range customers {
+		if customer.Invalid == "" {
+			if _, err := c.CreateCustomer(ctx, customer.Customer); err != nil {
+				return err
+			}
+			continue
+		}
+		created, err := c.CustomerStore.CreateCustomer(ctx, customer.Customer)
+		if err != nil {
+			return err
+		}
+		if err := c.record(ctx, models.CustomerCreated, created, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportBatchSize is the number of customers an export reads from the store at once
+const exportBatchSize = 500
+
+// ExportCustomers passes every customer that matches the given filter to fn, reading them in batches in the order of IDs.
+// Personal data is masked unless the user may view it
+func (c *CustomerManager) ExportCustomers(ctx context.Context, filter stores.CustomerListFilter, fn func(models.Customer) error) error {
+	if err := Authorize(ctx, models.ExportCustomers); err != nil {
+		return err
+	}
+	if err := authorizeFilter(ctx, filter); err != nil {
+		return err
+	}
+	// customers are read in the order of IDs after the last one read, so that customers created or deleted
+	// meanwhile don't shift the batches
+	options := stores.CustomerViewOptions{Limit: exportBatchSize}
+	for {
+		customers, err := c.CustomerStore.ListCustomers(ctx, filter, options)
+		if err != nil {
+			return err
+		}
+		c.recordAccess(ctx, models.AccessExport, customerIDs(customers)...)
+		for _, customer := range customers {
+			if err := fn(maskCustomer(ctx, customer)); err != nil {
+				return err
+			}
+		}
+		if len(customers) < exportBatchSize {
+			return nil
+		}
+		options.AfterID = customers[len(customers)-1].ID
+	}
+}
+
+// ErrNoChangeFeed occurs when changes are requested from a manager that doesn't record them
+var ErrNoChangeFeed = apperr.New(apperr.Unavailable, "change feed is not available")
+
+// ListChanges returns customer events that go after the given position in the outbox.
+// Events are ordered by transaction ID rather than by commit, and events of a transaction are returned
+// only after all the transactions with lower IDs have finished,
+// so that a reader following the positions never misses a change that commits out of order
+func (c *CustomerManager) ListChanges(ctx context.Context, after models.EventPosition, limit int) ([]models.Event, error) {
+	if err := Authorize(ctx, models.ReadCustomers); err != nil {
+		return nil, err
+	}
+	if c.outbox == nil {
+		return nil, ErrNoChangeFeed
+	}
+	events, err := c.outbox.ListEvents(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	var read []int
+	for _, event := range events {
+		if event.Customer != nil {
+			read = append(read, event.CustomerID)
+		}
+	}
+	c.recordAccess(ctx, models.AccessChanges, read...)
+	if Authorize(ctx, models.ViewPII) == nil {
+		return events, nil
+	}
+	for i, event := range events {
+		if event.Customer != nil {
+			masked := event.Customer.Masked()
+			events[i].Customer = &masked
+		}
+		if len(event.Changes) == 0 {
+			continue
+		}
+		changes := make([]models.FieldChange, len(event.Changes))
+		for j, change := range event.Changes {
+			changes[j] = change.Masked()
+		}
+		events[i].Changes = changes
+	}
+	return events, nil
 }
 
-//MultipleErrors aggregates multiple errors into one
-type MultipleErrors []error
+// recording tells whether changes are recorded anywhere, which requires customers to be read around them
+func (c *CustomerManager) recording() bool {
+	return c.outbox != nil || c.audit != nil
+}
+
+// record records a change of the given customer into the outbox and the audit trail, if the manager has them
+func (c *CustomerManager) record(ctx context.Context, eventType models.EventType, customer models.Customer, changes []models.FieldChange) error {
+	if err := c.emit(ctx, eventType, customer, changes); err != nil {
+		return err
+	}
+	if c.audit == nil {
+		return nil
+	}
+	var fields []string
+	for _, change := range changes {
+		fields = append(fields, change.Field)
+	}
+	_, err := c.audit.AppendAudit(ctx, models.AuditEntry{
+		Action:     eventType,
+		CustomerID: customer.ID,
+		Actor:      Actor(ctx),
+		RequestID:  RequestIDFromContext(ctx),
+		Fields:     fields,
+	})
+	return err
+}
 
-func (e MultipleErrors) Error() string {
-	var strs []string
-	for _, err := range e {
-		strs = append(strs, err.Error())
+// emit records a domain event about the given customer into the outbox, if the manager has one
+func (c *CustomerManager) emit(ctx context.Context, eventType models.EventType, customer models.Customer, changes []models.FieldChange) error {
+	if c.outbox == nil {
+		return nil
 	}
-	return strings.Join(strs, "\n")
+	_, err := c.outbox.AppendEvent(ctx, models.Event{
+		Type:       eventType,
+		CustomerID: customer.ID,
+		Customer:   &customer,
+		Changes:    changes,
+	})
+	return err
+}
+
+// noTransactor runs functions without a transaction, which is used when the manager has no outbox to keep consistent
+type noTransactor struct{}
+
+func (noTransactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
 }
 
 // ValidateCustomer validates the given model and returns all errors it encountered, if any
@@ -83,11 +489,11 @@ func (c CustomerManager) ValidateCustomer(customer models.Customer) error {
 }
 
 func (c CustomerManager) validateFirstName(errs MultipleErrors, value string) MultipleErrors {
-	return c.appendValidationError(errs, "first name", value, 100)
+	return c.appendValidationError(errs, "firstName", value, 100)
 }
 
 func (c CustomerManager) validateLastName(errs MultipleErrors, value string) MultipleErrors {
-	return c.appendValidationError(errs, "last name", value, 100)
+	return c.appendValidationError(errs, "lastName", value, 100)
 }
 
 func (c CustomerManager) validateAddress(errs MultipleErrors, value string) MultipleErrors {
@@ -95,7 +501,7 @@ func (c CustomerManager) validateAddress(errs MultipleErrors, value string) Mult
 }
 
 func (c CustomerManager) validateEmail(errs MultipleErrors, value string) MultipleErrors {
-	if err := c.validateString("email", value, true, 254); err != nil {
+	if err := validateString("email", value, true, 254); err != nil {
 		return append(errs, err)
 	}
 	if err := checkmail.ValidateFormat(value); err != nil {
@@ -112,13 +518,27 @@ func (c CustomerManager) validateBirthDate(errs MultipleErrors, birthDate time.T
 }
 
 func (c CustomerManager) validateGender(errs MultipleErrors, value models.Gender) MultipleErrors {
-	return c.appendValidationError(errs, "gender", string(value), 0)
+	if err := validateString("gender", string(value), true, 0); err != nil {
+		return append(errs, err)
+	}
+	if !models.IsValidGender(string(value)) {
+		return append(errs, InvalidGender(string(value)))
+	}
+	return errs
+}
+
+// InvalidGender returns the error of a gender that is neither male nor female
+func InvalidGender(value string) *FieldError {
+	return &FieldError{Field: "gender", Code: CodeInvalidChoice, Params: map[string]interface{}{
+		"value":   value,
+		"allowed": []models.Gender{models.Male, models.Female},
+	}}
 }
 
 func (c CustomerManager) validateAgeError(date time.Time) error {
 	goDate := time.Time(date)
 	if goDate.IsZero() {
-		return fmt.Errorf("age is undefined")
+		return &FieldError{Field: "birthDate", Code: CodeRequired}
 	}
 	customerAge := age.Age(goDate)
 	if customerAge < MinCustomerAge {
@@ -130,18 +550,8 @@ func (c CustomerManager) validateAgeError(date time.Time) error {
 }
 
 func (c CustomerManager) appendValidationError(errs MultipleErrors, fieldName string, value string, maxLength int) MultipleErrors {
-	if err := c.validateString(fieldName, value, true, maxLength); err != nil {
+	if err := validateString(fieldName, value, true, maxLength); err != nil {
 		return append(errs, err)
 	}
 	return errs
 }
-
-func (c CustomerManager) validateString(fieldName, str string, nonEmpty bool, maxLength int) error {
-	if nonEmpty && str == "" {
-		return fmt.Errorf("%s is empty", fieldName)
-	}
-	if maxLength > 0 && len(str) > maxLength {
-		return fmt.Errorf("%s is too long: maximum allowed length is %d", fieldName, maxLength)
-	}
-	return nil
-}
@@ -2,10 +2,12 @@ package managers_test
 
 import (
 	"context"
+	"encoding/json"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/havr/customers/apperr"
 	"github.com/havr/customers/managers"
 	"github.com/havr/customers/models"
 	"github.com/havr/customers/stores"
@@ -13,6 +15,8 @@ import (
 )
 
 var (
+	admin = models.User{Username: "admin", Role: models.RoleAdmin}
+
 	validCustomer = models.Customer{
 		FirstName: "First Name",
 		LastName:  "Last Name",
@@ -25,20 +29,60 @@ var (
 
 func TestManagerCreate(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	_, err := mgr.CreateCustomer(ctx, validCustomer)
 	require.NoError(t, err)
 }
 
 func TestManagerUpdate(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	require.NoError(t, mgr.UpdateCustomer(ctx, validCustomer))
 }
 
+func TestManagerPermissions(t *testing.T) {
+	mgr := managers.NewCustomerManager(fakeCustomerStore{})
+	for _, test := range []struct {
+		role                   models.Role
+		view, edit, del, spawn bool
+	}{
+		{role: models.RoleIntern, view: true},
+		{role: models.RoleSupport, view: true, edit: true},
+		{role: models.RoleAdmin, view: true, edit: true, del: true, spawn: true},
+		{role: ""},
+	} {
+		ctx := managers.WithUser(context.Background(), models.User{Username: "user", Role: test.role})
+		_, err := mgr.GetCustomer(ctx, 1)
+		requirePermission(t, test.view, models.ReadCustomers, err)
+		_, err = mgr.ListCustomers(ctx, stores.CustomerListFilter{}, stores.CustomerViewOptions{})
+		requirePermission(t, test.view, models.ReadCustomers, err)
+		_, err = mgr.CreateCustomer(ctx, validCustomer)
+		requirePermission(t, test.edit, models.WriteCustomers, err)
+		err = mgr.UpdateCustomer(ctx, validCustomer)
+		requirePermission(t, test.edit, models.WriteCustomers, err)
+		err = mgr.DeleteCustomer(ctx, 1)
+		requirePermission(t, test.del, models.DeleteCustomers, err)
+		err = mgr.RestoreCustomer(ctx, 1)
+		requirePermission(t, test.del, models.DeleteCustomers, err)
+		err = mgr.SeedCustomers(ctx, []models.GeneratedCustomer{{Customer: validCustomer, DuplicateOf: -1}})
+		requirePermission(t, test.spawn, models.GenerateCustomers, err)
+	}
+
+	_, err := mgr.GetCustomer(context.Background(), 1)
+	require.Equal(t, managers.PermissionError{Permission: models.ReadCustomers}, err, "context without a user has no permissions")
+}
+
+func requirePermission(t *testing.T, allowed bool, permission models.Permission, err error) {
+	if allowed {
+		require.NoError(t, err)
+	} else {
+		require.Equal(t, managers.PermissionError{Permission: permission}, err)
+	}
+}
+
 func TestManagerCreateEmpty(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	_, err := mgr.CreateCustomer(ctx, models.Customer{})
 	errs := err.(managers.MultipleErrors)
 	require.Len(t, errs, 6)
@@ -46,15 +90,37 @@ func TestManagerCreateEmpty(t *testing.T) {
 
 func TestManagerUpdateEmpty(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	_, err := mgr.CreateCustomer(ctx, models.Customer{})
 	errs := err.(managers.MultipleErrors)
 	require.Len(t, errs, 6)
 }
 
+func TestManagerFieldErrors(t *testing.T) {
+	mgr := managers.NewCustomerManager(fakeCustomerStore{})
+	ctx := managers.WithUser(context.Background(), admin)
+	invalid := customerWithTooLongFields()
+	invalid.Gender = models.Gender("<script>")
+	_, err := mgr.CreateCustomer(ctx, invalid)
+
+	fields, other := managers.FieldErrors(err)
+	require.Empty(t, other)
+	require.Len(t, fields, 5)
+	require.Equal(t, &managers.FieldError{Field: "firstName", Code: managers.CodeTooLong, Params: map[string]interface{}{"max": 100}}, fields[0])
+	require.Equal(t, "first name is too long: maximum allowed length is 100", fields[0].Error())
+	gender := fields[4]
+	require.Equal(t, "gender", gender.Field)
+	require.Equal(t, managers.CodeInvalidChoice, gender.Code)
+	require.Equal(t, "<script>", gender.Params["value"])
+
+	encoded, err := json.Marshal(fields[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"field": "firstName", "code": "too_long", "params": {"max": 100}}`, string(encoded))
+}
+
 func TestManagerCreateInvalidEmail(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	withInvalidEmail := validCustomer
 	withInvalidEmail.Email = "invalid"
 	_, err := mgr.CreateCustomer(ctx, withInvalidEmail)
@@ -63,7 +129,7 @@ func TestManagerCreateInvalidEmail(t *testing.T) {
 
 func TestManagerUpdateInvalidEmail(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	withInvalidEmail := validCustomer
 	withInvalidEmail.Email = "invalid"
 	err := mgr.UpdateCustomer(ctx, withInvalidEmail)
@@ -72,7 +138,7 @@ func TestManagerUpdateInvalidEmail(t *testing.T) {
 
 func TestManagerCreateInvalidAge(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	_, err := mgr.CreateCustomer(ctx, tooYoungCustomer())
 	requireOneError(t, managers.ErrCustomerTooYoung, err)
 
@@ -94,7 +160,7 @@ func tooOldCustomer() models.Customer {
 
 func TestManagerUpdateInvalidAge(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	err := mgr.UpdateCustomer(ctx, tooYoungCustomer())
 	requireOneError(t, managers.ErrCustomerTooYoung, err)
 
@@ -104,7 +170,7 @@ func TestManagerUpdateInvalidAge(t *testing.T) {
 
 func TestManagerCreateTooLong(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	_, err := mgr.CreateCustomer(ctx, customerWithTooLongFields())
 	require.Error(t, err)
 	merr, ok := err.(managers.MultipleErrors)
@@ -114,7 +180,7 @@ func TestManagerCreateTooLong(t *testing.T) {
 
 func TestManagerUpdateTooLong(t *testing.T) {
 	mgr := managers.NewCustomerManager(fakeCustomerStore{})
-	ctx := context.Background()
+	ctx := managers.WithUser(context.Background(), admin)
 	_, err := mgr.CreateCustomer(ctx, customerWithTooLongFields())
 	require.Error(t, err)
 	merr, ok := err.(managers.MultipleErrors)
@@ -171,6 +237,245 @@ func (fakeCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
 	return nil
 }
 
+func (fakeCustomerStore) RestoreCustomer(ctx context.Context, id int) error {
+	return nil
+}
+
 func (fakeCustomerStore) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
 	return models.Customer{}, nil
 }
+
+func TestManagerEmitsEvents(t *testing.T) {
+	store := &memoryCustomerStore{customers: make(map[int]models.Customer)}
+	outbox := &fakeOutbox{}
+	mgr := managers.NewCustomerManager(store, managers.WithOutbox(fakeTransactor{}, outbox))
+	ctx := managers.WithUser(context.Background(), admin)
+
+	created, err := mgr.CreateCustomer(ctx, validCustomer)
+	require.NoError(t, err)
+	updated := created
+	updated.Email = "another@email.com"
+	require.NoError(t, mgr.UpdateCustomer(ctx, updated))
+	require.NoError(t, mgr.DeleteCustomer(ctx, created.ID))
+	require.NoError(t, mgr.RestoreCustomer(ctx, created.ID))
+
+	require.Len(t, outbox.events, 4)
+	require.Equal(t, models.CustomerCreated, outbox.events[0].Type)
+	require.Equal(t, models.CustomerUpdated, outbox.events[1].Type)
+	require.Equal(t, []models.FieldChange{{Field: "email", Old: validCustomer.Email, New: updated.Email}}, outbox.events[1].Changes)
+	require.Equal(t, models.CustomerDeleted, outbox.events[2].Type)
+	require.Equal(t, models.CustomerRestored, outbox.events[3].Type)
+	require.Equal(t, updated.Email, outbox.events[3].Customer.Email)
+	for _, event := range outbox.events {
+		require.Equal(t, created.ID, event.CustomerID)
+	}
+}
+
+func TestManagerAppendsAuditTrail(t *testing.T) {
+	store := &memoryCustomerStore{customers: make(map[int]models.Customer)}
+	trail := &fakeAuditStore{}
+	mgr := managers.NewCustomerManager(store, managers.WithOutbox(fakeTransactor{}, &fakeOutbox{}), managers.WithAuditTrail(fakeTransactor{}, trail))
+	ctx := managers.WithUser(context.Background(), admin)
+
+	created, err := mgr.CreateCustomer(ctx, validCustomer)
+	require.NoError(t, err)
+	updated := created
+	updated.Email = "another@email.com"
+	require.NoError(t, mgr.UpdateCustomer(ctx, updated))
+	require.NoError(t, mgr.DeleteCustomer(ctx, created.ID))
+
+	require.Len(t, trail.entries, 3)
+	require.Equal(t, models.CustomerUpdated, trail.entries[1].Action)
+	require.Equal(t, []string{"email"}, trail.entries[1].Fields, "values must not get into the audit trail")
+	require.Equal(t, models.CustomerDeleted, trail.entries[2].Action)
+	for _, entry := range trail.entries {
+		require.Equal(t, created.ID, entry.CustomerID)
+		require.Equal(t, managers.Actor(ctx), entry.Actor)
+	}
+
+	transactor := &countingTransactor{}
+	mgr = managers.NewCustomerManager(store, managers.WithAuditTrail(transactor, trail))
+	_, err = mgr.CreateCustomer(ctx, validCustomer)
+	require.NoError(t, err)
+	require.Equal(t, 1, transactor.transactions, "changes are appended in their transaction without an outbox too")
+}
+
+func TestManagerSeedsInvalidCustomers(t *testing.T) {
+	store := &memoryCustomerStore{customers: make(map[int]models.Customer)}
+	outbox := &fakeOutbox{}
+	mgr := managers.NewCustomerManager(store, managers.WithOutbox(fakeTransactor{}, outbox))
+	ctx := managers.WithUser(context.Background(), admin)
+	invalid := validCustomer
+	invalid.Email = "fake at email.com"
+
+	err := mgr.SeedCustomers(ctx, []models.GeneratedCustomer{{Customer: invalid, DuplicateOf: -1}})
+	require.True(t, apperr.Is(err, apperr.Validation), "customers that aren't meant to be invalid are validated")
+	require.NoError(t, mgr.SeedCustomers(ctx, []models.GeneratedCustomer{
+		{Customer: validCustomer, DuplicateOf: -1},
+		{Customer: invalid, Invalid: "email", DuplicateOf: -1},
+	}))
+	require.Len(t, store.customers, 2)
+	require.Len(t, outbox.events, 2, "invalid customers are announced as any other")
+
+	transactor := &countingTransactor{}
+	mgr = managers.NewCustomerManager(store, managers.WithOutbox(transactor, outbox))
+	seeded := make([]models.GeneratedCustomer, 250)
+	for i := range seeded {
+		seeded[i] = models.GeneratedCustomer{Customer: validCustomer, DuplicateOf: -1}
+	}
+	require.NoError(t, mgr.SeedCustomers(ctx, seeded))
+	require.Len(t, store.customers, 252)
+	require.Equal(t, 3, transactor.transactions, "customers are committed in batches")
+
+	support := managers.WithUser(context.Background(), models.User{Username: "support", Role: models.RoleSupport})
+	err = mgr.SeedCustomers(support, nil)
+	require.Equal(t, managers.PermissionError{Permission: models.GenerateCustomers}, err)
+}
+
+func TestManagerExportsInBatches(t *testing.T) {
+	store := &memoryCustomerStore{customers: make(map[int]models.Customer)}
+	mgr := managers.NewCustomerManager(store)
+	ctx := managers.WithUser(context.Background(), admin)
+	for i := 0; i < 1200; i++ {
+		_, err := store.CreateCustomer(ctx, validCustomer)
+		require.NoError(t, err)
+	}
+
+	var exported []int
+	err := mgr.ExportCustomers(ctx, stores.CustomerListFilter{}, func(customer models.Customer) error {
+		if customer.ID == 1 {
+			require.NoError(t, store.DeleteCustomer(ctx, customer.ID))
+		}
+		exported = append(exported, customer.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, exported, 1200, "customers deleted during an export don't shift the batches")
+	for i, id := range exported {
+		require.Equal(t, i+1, id)
+	}
+}
+
+type fakeTransactor struct{}
+
+func (fakeTransactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// countingTransactor counts outermost transactions, since inner ones join them as they do in stores
+type countingTransactor struct {
+	transactions int
+}
+
+type inTxKey struct{}
+
+func (t *countingTransactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(inTxKey{}) != nil {
+		return fn(ctx)
+	}
+	t.transactions++
+	return fn(context.WithValue(ctx, inTxKey{}, true))
+}
+
+type fakeOutbox struct {
+	events []models.Event
+}
+
+func (o *fakeOutbox) AppendEvent(ctx context.Context, event models.Event) (models.Event, error) {
+	o.events = append(o.events, event)
+	return event, nil
+}
+
+func (o *fakeOutbox) ListEvents(ctx context.Context, after models.EventPosition, limit int) ([]models.Event, error) {
+	return nil, nil
+}
+
+func (o *fakeOutbox) GetOffset(ctx context.Context, consumer string) (models.EventPosition, error) {
+	return models.EventPosition{}, nil
+}
+
+func (o *fakeOutbox) SetOffset(ctx context.Context, consumer string, position models.EventPosition) error {
+	return nil
+}
+
+type fakeAuditStore struct {
+	entries []models.AuditEntry
+}
+
+func (a *fakeAuditStore) AppendAudit(ctx context.Context, entry models.AuditEntry) (models.AuditEntry, error) {
+	a.entries = append(a.entries, entry)
+	return entry, nil
+}
+
+func (a *fakeAuditStore) ListAudit(ctx context.Context, afterID int64, limit int) ([]models.AuditEntry, error) {
+	return nil, nil
+}
+
+func (a *fakeAuditStore) LastAudit(ctx context.Context) (models.AuditEntry, error) {
+	return models.AuditEntry{}, nil
+}
+
+func (a *fakeAuditStore) SaveCheckpoint(ctx context.Context, checkpoint models.AuditCheckpoint) (models.AuditCheckpoint, error) {
+	return checkpoint, nil
+}
+
+func (a *fakeAuditStore) ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error) {
+	return nil, nil
+}
+
+type memoryCustomerStore struct {
+	fakeCustomerStore
+	customers map[int]models.Customer
+	deleted   map[int]models.Customer
+}
+
+func (s *memoryCustomerStore) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+	customer.ID = len(s.customers) + len(s.deleted) + 1
+	s.customers[customer.ID] = customer
+	return customer, nil
+}
+
+func (s *memoryCustomerStore) UpdateCustomer(ctx context.Context, customer models.Customer) error {
+	s.customers[customer.ID] = customer
+	return nil
+}
+
+func (s *memoryCustomerStore) DeleteCustomer(ctx context.Context, id int) error {
+	if s.deleted == nil {
+		s.deleted = make(map[int]models.Customer)
+	}
+	s.deleted[id] = s.customers[id]
+	delete(s.customers, id)
+	return nil
+}
+
+func (s *memoryCustomerStore) RestoreCustomer(ctx context.Context, id int) error {
+	customer, ok := s.deleted[id]
+	if !ok {
+		return stores.ErrNotFound
+	}
+	s.customers[id] = customer
+	delete(s.deleted, id)
+	return nil
+}
+
+func (s *memoryCustomerStore) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
+	customer, ok := s.customers[id]
+	if !ok {
+		return models.Customer{}, stores.ErrNotFound
+	}
+	return customer, nil
+}
+
+func (s *memoryCustomerStore) ListCustomers(ctx context.Context, filter stores.CustomerListFilter, options stores.CustomerViewOptions) ([]models.Customer, error) {
+	var result []models.Customer
+	for id := options.AfterID + 1; id <= len(s.customers)+len(s.deleted); id++ {
+		if customer, ok := s.customers[id]; ok && filter.Matches(customer) {
+			result = append(result, customer)
+		}
+		if options.Limit != 0 && len(result) == options.Limit {
+			break
+		}
+	}
+	return result, nil
+}
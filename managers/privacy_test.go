@@ -0,0 +1,77 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+func TestMasking(t *testing.T) {
+	require.Equal(t, "j***@example.com", models.MaskEmail("john.doe@example.com"))
+	require.Equal(t, "***", models.MaskEmail("invalid"))
+	require.Equal(t, "Main St. ***, *** Springfield", models.MaskAddress("Main St. 12a, 40500 Springfield"))
+
+	customer := models.Customer{FirstName: "John", Email: "john@example.com", Address: "Main St. 12"}
+	masked := customer.Masked()
+	require.Equal(t, models.Customer{FirstName: "John", Email: "j***@example.com", Address: "Main St. ***"}, masked)
+	require.Equal(t, customer, masked.Unmask(customer), "masked values are restored")
+	masked.Email = "jane@example.com"
+	require.Equal(t, "jane@example.com", masked.Unmask(customer).Email, "replaced values are kept")
+}
+
+func TestManagerMasksPersonalData(t *testing.T) {
+	store := &memoryCustomerStore{customers: make(map[int]models.Customer)}
+	reveals := &fakeRevealStore{}
+	mgr := managers.NewCustomerManager(store, managers.WithRevealLog(reveals))
+	created, err := mgr.CreateCustomer(managers.WithUser(context.Background(), admin), validCustomer)
+	require.NoError(t, err)
+
+	intern := managers.WithUser(context.Background(), models.User{Username: "intern", Role: models.RoleIntern})
+	stored, err := mgr.GetCustomer(intern, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, created.Masked(), stored)
+	_, err = mgr.RevealCustomer(intern, created.ID)
+	require.Equal(t, managers.PermissionError{Permission: models.RevealPII}, err)
+	byEmail := stores.CustomerListFilter{Email: validCustomer.Email}
+	_, err = mgr.ListCustomers(intern, byEmail, stores.CustomerViewOptions{})
+	require.Equal(t, managers.PermissionError{Permission: models.ViewPII}, err, "filtering by email would tell the email")
+	_, err = mgr.CountCustomers(intern, byEmail)
+	require.Equal(t, managers.PermissionError{Permission: models.ViewPII}, err)
+	_, err = mgr.CountCustomers(intern, stores.CustomerListFilter{FirstName: "J"})
+	require.NoError(t, err)
+
+	support := managers.WithRequestID(managers.WithUser(context.Background(), models.User{Username: "support", Role: models.RoleSupport}), "req-1")
+	stored, err = mgr.GetCustomer(support, created.ID)
+	require.NoError(t, err)
+	stored.FirstName = "Changed"
+	require.NoError(t, mgr.UpdateCustomer(support, stored))
+	require.Equal(t, validCustomer.Email, store.customers[created.ID].Email, "masked email is kept")
+	require.Equal(t, "Changed", store.customers[created.ID].FirstName)
+
+	revealed, err := mgr.RevealCustomer(support, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, validCustomer.Email, revealed.Email)
+	require.Len(t, reveals.reveals, 1)
+	require.Equal(t, models.Reveal{CustomerID: created.ID, Actor: "support", Fields: models.MaskedFields, RequestID: "req-1"}, reveals.reveals[0])
+
+	stored, err = mgr.GetCustomer(managers.WithUser(context.Background(), admin), created.ID)
+	require.NoError(t, err)
+	require.Equal(t, validCustomer.Email, stored.Email)
+	_, err = mgr.RevealCustomer(managers.WithUser(context.Background(), admin), created.ID)
+	require.NoError(t, err)
+	require.Len(t, reveals.reveals, 1, "users who may view personal data don't reveal it")
+}
+
+type fakeRevealStore struct {
+	reveals []models.Reveal
+}
+
+func (s *fakeRevealStore) RecordReveal(ctx context.Context, reveal models.Reveal) (models.Reveal, error) {
+	s.reveals = append(s.reveals, reveal)
+	return reveal, nil
+}
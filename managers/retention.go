@@ -0,0 +1,177 @@
+package managers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+const (
+	// DefaultRetentionInterval is the default time between runs of retention rules
+	DefaultRetentionInterval = 24 * time.Hour
+	// DefaultRetentionBatchSize is the default number of customers read from the store at once
+	DefaultRetentionBatchSize = 500
+	// retentionSample is the number of affected customers and errors a run summary lists
+	retentionSample = 20
+)
+
+// RecommendedRetentionPolicy purges deleted customers after 30 days and anonymizes customers after 5 years without changes.
+// Erasure can't be undone, so no policy applies unless it's configured
+var RecommendedRetentionPolicy = models.RetentionPolicy{
+	PurgeDeletedAfter:      30 * 24 * time.Hour,
+	AnonymizeInactiveAfter: 5 * 365 * 24 * time.Hour,
+}
+
+// Retention applies the retention policy to customers periodically. Customers are erased through the customer manager,
+// so every purge and anonymization is recorded in the audit trail with the rule that has caused it
+type Retention struct {
+	customers *CustomerManager
+	store     stores.RetentionStore
+	Policy    models.RetentionPolicy
+	Interval  time.Duration
+	BatchSize int
+	// DryRun makes periodic runs only count the customers rules apply to
+	DryRun bool
+	Now    func() time.Time
+
+	// applying keeps runs from overlapping
+	applying sync.Mutex
+	mu       sync.Mutex
+	nextRun  time.Time
+	lastRun  *models.RetentionRun
+}
+
+// NewRetention creates a job that applies the policy to customers found in the given store
+func NewRetention(customers *CustomerManager, store stores.RetentionStore, policy models.RetentionPolicy) *Retention {
+	return &Retention{
+		customers: customers,
+		store:     store,
+		Policy:    policy,
+		Interval:  DefaultRetentionInterval,
+		BatchSize: DefaultRetentionBatchSize,
+		Now:       time.Now,
+	}
+}
+
+// Run applies the policy on behalf of SystemUser right away and then periodically until the context is done
+func (r *Retention) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		r.mu.Lock()
+		r.nextRun = r.Now().Add(r.Interval)
+		r.mu.Unlock()
+		if run, err := r.Apply(AsSystem(ctx), r.DryRun); err != nil && ctx.Err() == nil {
+			fmt.Println("apply retention policy:", err, "after", run)
+		} else if err == nil {
+			fmt.Println("retention policy applied:", run)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// NextRun returns the time of the next periodic run, which is zero if Run hasn't been started
+func (r *Retention) NextRun() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.nextRun
+}
+
+// LastRun returns the summary of the last run, if there has been one
+func (r *Retention) LastRun() (models.RetentionRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastRun == nil {
+		return models.RetentionRun{}, false
+	}
+	return *r.lastRun, true
+}
+
+// Apply purges customers deleted longer ago than the policy allows and anonymizes customers that haven't changed
+// for longer than it allows, in batches. A dry run only counts them. It requires the user to be allowed to erase customers.
+// Customers that can't be erased are counted as failed without stopping the run
+func (r *Retention) Apply(ctx context.Context, dryRun bool) (models.RetentionRun, error) {
+	if err := Authorize(ctx, models.HandleSubjectRequests); err != nil {
+		return models.RetentionRun{}, err
+	}
+	if _, err := r.customers.subjects(); err != nil && !dryRun {
+		return models.RetentionRun{}, err
+	}
+	r.applying.Lock()
+	defer r.applying.Unlock()
+
+	now := r.Now().UTC()
+	run := models.RetentionRun{StartedAt: now, DryRun: dryRun}
+	err := r.applyRule(ctx, &run, r.Policy.PurgeDeletedAfter, models.ErasureDelete, r.store.ListDeletedBefore)
+	if err == nil {
+		err = r.applyRule(ctx, &run, r.Policy.AnonymizeInactiveAfter, models.ErasureAnonymize, r.store.ListInactiveBefore)
+	}
+	run.FinishedAt = r.Now().UTC()
+	r.mu.Lock()
+	r.lastRun = &run
+	r.mu.Unlock()
+	return run, err
+}
+
+// applyRule erases customers the list function returns for the time the period goes back to
+func (r *Retention) applyRule(ctx context.Context, run *models.RetentionRun, period time.Duration, mode models.ErasureMode,
+	list func(ctx context.Context, before time.Time, afterID, limit int) ([]int, error)) error {
+	if period <= 0 {
+		return nil
+	}
+	before := run.StartedAt.Add(-period)
+	note := fmt.Sprintf("retention policy: deleted more than %s ago", models.FormatRetentionPeriod(period))
+	count, ids := &run.Purged, &run.PurgedIDs
+	if mode == models.ErasureAnonymize {
+		note = fmt.Sprintf("retention policy: unchanged for more than %s", models.FormatRetentionPeriod(period))
+		count, ids = &run.Anonymized, &run.AnonymizedIDs
+	}
+	afterID := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch, err := list(ctx, before, afterID, r.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, id := range batch {
+			if !run.DryRun {
+				err := r.customers.eraseCustomer(ctx, id, mode, note, before)
+				if apperr.Is(err, apperr.NotFound) {
+					// another instance has got there first
+					continue
+				}
+				if apperr.Is(err, apperr.Conflict) {
+					// the customer has been restored or changed since it has been found
+					run.Skipped++
+					continue
+				}
+				if err != nil {
+					run.Failed++
+					if len(run.Errors) < retentionSample {
+						run.Errors = append(run.Errors, fmt.Sprintf("customer %v: %v", id, err))
+					}
+					continue
+				}
+			}
+			*count++
+			if len(*ids) < retentionSample {
+				*ids = append(*ids, id)
+			}
+		}
+		if len(batch) < r.BatchSize {
+			return nil
+		}
+		afterID = batch[len(batch)-1]
+	}
+}
@@ -0,0 +1,112 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+func TestRetentionPurgesAndAnonymizesCustomers(t *testing.T) {
+	store := &memorySubjectStore{memoryCustomerStore{customers: make(map[int]models.Customer)}}
+	trail := &fakeAuditStore{}
+	mgr := managers.NewCustomerManager(store, managers.WithAuditTrail(fakeTransactor{}, trail))
+	ctx := managers.WithUser(context.Background(), admin)
+	var ids []int
+	for i := 0; i < 4; i++ {
+		created, err := mgr.CreateCustomer(ctx, validCustomer)
+		require.NoError(t, err)
+		ids = append(ids, created.ID)
+	}
+	require.NoError(t, mgr.DeleteCustomer(ctx, ids[0]))
+	require.NoError(t, mgr.DeleteCustomer(ctx, ids[3]))
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	// the last customer is restored after it has been found
+	expired := &fakeRetentionStore{deleted: []int{ids[0], ids[3]}, inactive: ids[1:3]}
+	retention := managers.NewRetention(mgr, expired, managers.RecommendedRetentionPolicy)
+	retention.BatchSize = 1
+	retention.Now = func() time.Time { return now }
+
+	support := managers.WithUser(context.Background(), models.User{Username: "support", Role: models.RoleSupport})
+	_, err := retention.Apply(support, true)
+	require.Equal(t, managers.PermissionError{Permission: models.HandleSubjectRequests}, err)
+
+	run, err := retention.Apply(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, run.Purged)
+	require.Equal(t, ids[1:3], run.AnonymizedIDs)
+	require.Len(t, store.customers, 2, "a dry run changes nothing")
+	require.Equal(t, now.AddDate(0, 0, -30), expired.deletedBefore)
+	require.Equal(t, now.Add(-5*365*24*time.Hour), expired.inactiveBefore)
+
+	require.NoError(t, mgr.RestoreCustomer(ctx, ids[3]))
+	run, err = retention.Apply(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, "purged 1, anonymized 2, 1 skipped in 0s", run.String())
+	_, err = store.GetCustomer(ctx, ids[0])
+	require.Equal(t, stores.ErrNotFound, err)
+	_, err = store.GetCustomer(ctx, ids[3])
+	require.NoError(t, err, "restored customers aren't purged")
+	anonymized, err := store.GetCustomer(ctx, ids[1])
+	require.NoError(t, err)
+	require.Equal(t, validCustomer.Anonymized().FirstName, anonymized.FirstName)
+	last, ok := retention.LastRun()
+	require.True(t, ok)
+	require.Equal(t, run, last)
+
+	entry := trail.entries[len(trail.entries)-1]
+	require.Equal(t, models.CustomerErased, entry.Action)
+	require.Equal(t, "retention policy: unchanged for more than 5y", entry.Note)
+
+	_, err = managers.NewRetention(managers.NewCustomerManager(store), expired, managers.RecommendedRetentionPolicy).Apply(ctx, false)
+	require.Equal(t, managers.ErrNoSubjectRequests, err, "erasures must be accounted for in the audit trail")
+}
+
+func TestParseRetentionPeriod(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"30d":     30 * 24 * time.Hour,
+		"5y":      5 * 365 * 24 * time.Hour,
+		"12h0m0s": 12 * time.Hour,
+		"off":     0,
+	} {
+		period, err := models.ParseRetentionPeriod(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, period, value)
+		require.Equal(t, value, models.FormatRetentionPeriod(period))
+	}
+	for _, value := range []string{"", "-1d", "5 years", "-1h"} {
+		_, err := models.ParseRetentionPeriod(value)
+		require.Error(t, err, value)
+	}
+}
+
+// fakeRetentionStore returns fixed customers for retention rules, in pages
+type fakeRetentionStore struct {
+	deleted, inactive             []int
+	deletedBefore, inactiveBefore time.Time
+}
+
+func (s *fakeRetentionStore) ListDeletedBefore(ctx context.Context, before time.Time, afterID, limit int) ([]int, error) {
+	s.deletedBefore = before
+	return page(s.deleted, afterID, limit), nil
+}
+
+func (s *fakeRetentionStore) ListInactiveBefore(ctx context.Context, before time.Time, afterID, limit int) ([]int, error) {
+	s.inactiveBefore = before
+	return page(s.inactive, afterID, limit), nil
+}
+
+func page(ids []int, afterID, limit int) []int {
+	var result []int
+	for _, id := range ids {
+		if id > afterID && len(result) < limit {
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,123 @@
+package managers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+// ErrNoSubjectRequests occurs when requests of data subjects are handled by a manager whose store can't collect
+// or erase data of customers, or that has no audit trail to account for them in
+var ErrNoSubjectRequests = apperr.New(apperr.Unavailable, "requests of data subjects can't be handled, since customers can't be erased or the audit trail is disabled")
+
+// maxNoteLength is the maximum length of a note that justifies a request
+const maxNoteLength = 2000
+
+// subjects returns the store that handles requests of data subjects, if the manager may handle them
+func (c *CustomerManager) subjects() (stores.SubjectStore, error) {
+	subjects, ok := c.CustomerStore.(stores.SubjectStore)
+	if !ok || c.audit == nil {
+		return nil, ErrNoSubjectRequests
+	}
+	return subjects, nil
+}
+
+// ExportSubjectData returns everything stored about a customer to be handed over on the customer's request,
+// with personal data unmasked. The note justifies the export, which is recorded in the audit trail along with it
+func (c *CustomerManager) ExportSubjectData(ctx context.Context, id int, note string) (models.SubjectData, error) {
+	if err := Authorize(ctx, models.HandleSubjectRequests); err != nil {
+		return models.SubjectData{}, err
+	}
+	note = strings.TrimSpace(note)
+	if err := validateString("note", note, true, maxNoteLength); err != nil {
+		return models.SubjectData{}, MultipleErrors{err}
+	}
+	subjects, err := c.subjects()
+	if err != nil {
+		return models.SubjectData{}, err
+	}
+	var data models.SubjectData
+	err = c.transactor.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		if data, err = subjects.CollectSubjectData(ctx, id); err != nil {
+			return err
+		}
+		_, err = c.audit.AppendAudit(ctx, models.AuditEntry{
+			Action:     models.SubjectDataExported,
+			CustomerID: id,
+			Actor:      Actor(ctx),
+			RequestID:  RequestIDFromContext(ctx),
+			Note:       note,
+		})
+		return err
+	})
+	if err != nil {
+		return models.SubjectData{}, err
+	}
+	c.recordAccess(ctx, models.AccessExport, id)
+	data.GeneratedAt = time.Now().UTC()
+	data.GeneratedBy = Actor(ctx)
+	data.Note = note
+	return data, nil
+}
+
+// EraseCustomer erases personal data of a customer on the customer's request, even if the customer has been deleted.
+// Records the law requires to keep, such as the audit trail, are kept. The note justifies the erasure,
+// which is recorded in the audit trail and emitted as CustomerErased, so that other systems may erase the customer too
+func (c *CustomerManager) EraseCustomer(ctx context.Context, id int, mode models.ErasureMode, note string) error {
+	if err := Authorize(ctx, models.HandleSubjectRequests); err != nil {
+		return err
+	}
+	var errs MultipleErrors
+	if !mode.Valid() {
+		errs = append(errs, &FieldError{Field: "mode", Code: CodeInvalidChoice, Params: map[string]interface{}{
+			"value":   string(mode),
+			"allowed": models.ErasureModes,
+		}})
+	}
+	note = strings.TrimSpace(note)
+	if err := validateString("note", note, true, maxNoteLength); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return c.eraseCustomer(ctx, id, mode, note, time.Time{})
+}
+
+// eraseCustomer erases the customer and accounts for it. Unless before is zero, the customer is erased only
+// if the retention rule of the mode still applies to it, see stores.SubjectStore
+func (c *CustomerManager) eraseCustomer(ctx context.Context, id int, mode models.ErasureMode, note string, before time.Time) error {
+	subjects, err := c.subjects()
+	if err != nil {
+		return err
+	}
+	return c.transactor.InTx(ctx, func(ctx context.Context) error {
+		erased, err := subjects.EraseSubject(ctx, id, mode, before)
+		if err != nil {
+			return err
+		}
+		if c.outbox != nil {
+			event := models.Event{Type: models.CustomerErased, CustomerID: id}
+			if mode == models.ErasureAnonymize {
+				event.Customer = &erased
+			}
+			if _, err := c.outbox.AppendEvent(ctx, event); err != nil {
+				return err
+			}
+		}
+		_, err = c.audit.AppendAudit(ctx, models.AuditEntry{
+			Action:     models.CustomerErased,
+			CustomerID: id,
+			Actor:      Actor(ctx),
+			RequestID:  RequestIDFromContext(ctx),
+			Fields:     models.ErasedFields,
+			Note:       note,
+		})
+		return err
+	})
+}
@@ -0,0 +1,107 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+func TestManagerExportsSubjectData(t *testing.T) {
+	store := &memorySubjectStore{memoryCustomerStore{customers: make(map[int]models.Customer)}}
+	trail := &fakeAuditStore{}
+	mgr := managers.NewCustomerManager(store, managers.WithAuditTrail(fakeTransactor{}, trail))
+	ctx := managers.WithUser(context.Background(), admin)
+	created, err := mgr.CreateCustomer(ctx, validCustomer)
+	require.NoError(t, err)
+
+	support := managers.WithUser(context.Background(), models.User{Username: "support", Role: models.RoleSupport})
+	_, err = mgr.ExportSubjectData(support, created.ID, "asked by email")
+	require.Equal(t, managers.PermissionError{Permission: models.HandleSubjectRequests}, err)
+	_, err = mgr.ExportSubjectData(ctx, created.ID, "  ")
+	require.True(t, apperr.Is(err, apperr.Validation), "a note is required")
+
+	data, err := mgr.ExportSubjectData(ctx, created.ID, "asked by email")
+	require.NoError(t, err)
+	require.Equal(t, &created, data.Customer, "personal data is handed over unmasked")
+	require.Equal(t, "admin", data.GeneratedBy)
+	require.Equal(t, "asked by email", data.Note)
+	last := trail.entries[len(trail.entries)-1]
+	require.Equal(t, models.SubjectDataExported, last.Action)
+	require.Equal(t, "asked by email", last.Note)
+
+	_, err = managers.NewCustomerManager(store).ExportSubjectData(ctx, created.ID, "asked by email")
+	require.Equal(t, managers.ErrNoSubjectRequests, err, "exports must be accounted for in the audit trail")
+}
+
+func TestManagerErasesCustomer(t *testing.T) {
+	store := &memorySubjectStore{memoryCustomerStore{customers: make(map[int]models.Customer)}}
+	trail := &fakeAuditStore{}
+	outbox := &fakeOutbox{}
+	mgr := managers.NewCustomerManager(store, managers.WithOutbox(fakeTransactor{}, outbox), managers.WithAuditTrail(fakeTransactor{}, trail))
+	ctx := managers.WithUser(context.Background(), admin)
+	created, err := mgr.CreateCustomer(ctx, validCustomer)
+	require.NoError(t, err)
+
+	err = mgr.EraseCustomer(ctx, created.ID, "shred", "")
+	fields, _ := managers.FieldErrors(err)
+	require.Len(t, fields, 2, "both the mode and the note are invalid")
+
+	require.NoError(t, mgr.EraseCustomer(ctx, created.ID, models.ErasureAnonymize, "asked by phone"))
+	erased, err := store.GetCustomer(ctx, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, created.Anonymized(), erased)
+	require.Equal(t, created.Gender, erased.Gender)
+	require.Equal(t, created.BirthDate.Year(), erased.BirthDate.Year())
+
+	event := outbox.events[len(outbox.events)-1]
+	require.Equal(t, models.CustomerErased, event.Type)
+	require.Equal(t, &erased, event.Customer)
+	entry := trail.entries[len(trail.entries)-1]
+	require.Equal(t, models.CustomerErased, entry.Action)
+	require.Equal(t, models.ErasedFields, entry.Fields)
+	require.Equal(t, "asked by phone", entry.Note)
+
+	require.NoError(t, mgr.EraseCustomer(ctx, created.ID, models.ErasureDelete, "asked by phone"))
+	_, err = store.GetCustomer(ctx, created.ID)
+	require.Equal(t, stores.ErrNotFound, err)
+	require.Nil(t, outbox.events[len(outbox.events)-1].Customer, "deleted customers leave nothing behind")
+}
+
+// memorySubjectStore handles requests of data subjects for customers of the memory store
+type memorySubjectStore struct {
+	memoryCustomerStore
+}
+
+func (s *memorySubjectStore) CollectSubjectData(ctx context.Context, id int) (models.SubjectData, error) {
+	customer, ok := s.customers[id]
+	if !ok {
+		return models.SubjectData{}, stores.ErrNotFound
+	}
+	return models.SubjectData{CustomerID: id, Customer: &customer}, nil
+}
+
+func (s *memorySubjectStore) EraseSubject(ctx context.Context, id int, mode models.ErasureMode, before time.Time) (models.Customer, error) {
+	customer, ok := s.customers[id]
+	_, deleted := s.deleted[id]
+	if !ok && !deleted {
+		return models.Customer{}, stores.ErrNotFound
+	}
+	// the memory store doesn't keep times, so only whether the customer is deleted is checked again
+	if !before.IsZero() && deleted != (mode == models.ErasureDelete) {
+		return models.Customer{}, stores.ErrChanged
+	}
+	if mode == models.ErasureDelete {
+		delete(s.customers, id)
+		delete(s.deleted, id)
+		return models.Customer{}, nil
+	}
+	s.customers[id] = customer.Anonymized()
+	return s.customers[id], nil
+}
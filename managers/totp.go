@@ -0,0 +1,93 @@
+package managers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPPeriod is the length of a time step of TOTP codes
+	TOTPPeriod = 30 * time.Second
+	// TOTPDigits is the number of digits in a TOTP code
+	TOTPDigits = 6
+	// totpSkew is the number of time steps a code may be late or early to tolerate clock drift
+	totpSkew = 1
+	// totpSecretSize is the number of random bytes in a TOTP secret
+	totpSecretSize = 20
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret generates a random base32 encoded TOTP secret
+func NewTOTPSecret() (string, error) {
+	raw := make([]byte, totpSecretSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(raw), nil
+}
+
+// HOTP computes a code for the given counter according to RFC 4226
+func HOTP(secret []byte, counter uint64, digits int) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+// TOTPCounter returns the time step the given time belongs to according to RFC 6238
+func TOTPCounter(at time.Time) int64 {
+	return at.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// VerifyTOTP checks the code against the base32 encoded secret at the given time, tolerating a small clock drift.
+// It returns the time step the code belongs to, which lets callers reject reused codes
+func VerifyTOTP(secret, code string, at time.Time) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := TOTPCounter(at)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		expected := HOTP(key, uint64(counter), TOTPDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI returns an otpauth URI authenticator apps use to enroll the given secret
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// validTOTPSecret tells whether the secret is a base32 encoded secret as NewTOTPSecret generates
+func validTOTPSecret(secret string) bool {
+	raw, err := base32NoPadding.DecodeString(secret)
+	return err == nil && len(raw) == totpSecretSize
+}
@@ -0,0 +1,145 @@
+package managers_test
+
+import (
+	"context"
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPVectors(t *testing.T) {
+	// test vectors of RFC 6238 for HMAC-SHA1
+	secret := []byte("12345678901234567890")
+	for unix, expected := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		counter := managers.TOTPCounter(time.Unix(unix, 0))
+		require.Equal(t, expected, managers.HOTP(secret, uint64(counter), 8), "time %v", unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := strings.TrimRight(base32.StdEncoding.EncodeToString([]byte("12345678901234567890")), "=")
+	at := time.Unix(1111111109, 0)
+	counter, ok := managers.VerifyTOTP(secret, "081804", at)
+	require.True(t, ok)
+	require.Equal(t, managers.TOTPCounter(at), counter)
+
+	_, ok = managers.VerifyTOTP(secret, "081804", at.Add(managers.TOTPPeriod))
+	require.True(t, ok, "a code of the previous step is tolerated")
+	_, ok = managers.VerifyTOTP(secret, "081804", at.Add(3*managers.TOTPPeriod))
+	require.False(t, ok)
+	_, ok = managers.VerifyTOTP(secret, "000000", at)
+	require.False(t, ok)
+
+	uri := managers.TOTPURI("Customers", "jane doe", secret)
+	require.True(t, strings.HasPrefix(uri, "otpauth://totp/Customers:jane%20doe?"))
+	require.Contains(t, uri, "secret="+secret)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	settings := memorySettingStore{}
+	mgr := managers.NewUserManager(newMemoryUserStore(), newMemorySessionStore(), settings, fakeTransactor{})
+	mgr.Now = func() time.Time { return now }
+
+	user, err := mgr.CreateUser(ctx, "support", "long enough", models.RoleSupport)
+	require.NoError(t, err)
+	token, err := mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	_, err = mgr.ResolveSession(ctx, token)
+	require.NoError(t, err)
+
+	require.Equal(t, managers.PermissionError{Permission: models.ManageSettings}, mgr.SetTwoFactorRequired(ctx, true))
+	require.NoError(t, mgr.SetTwoFactorRequired(managers.AsSystem(ctx), true))
+	resolved, err := mgr.ResolveSession(ctx, token)
+	require.Equal(t, managers.ErrTwoFactorEnrollmentRequired, err)
+	require.Equal(t, user.ID, resolved.ID)
+
+	secret, _, err := mgr.TOTPEnrollment(ctx, token, user)
+	require.NoError(t, err)
+	again, _, err := mgr.TOTPEnrollment(ctx, token, user)
+	require.NoError(t, err)
+	require.Equal(t, secret, again, "the secret is kept in the session until it's enrolled")
+	_, err = mgr.EnrollTOTP(ctx, token, user, "000000")
+	require.Equal(t, managers.ErrInvalidCode, err)
+	other, err := mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	_, err = mgr.EnrollTOTP(ctx, other, user, currentCode(t, secret, now))
+	require.Equal(t, managers.ErrNoEnrollment, err, "the secret belongs to the session it's been generated for")
+	codes, err := mgr.EnrollTOTP(ctx, token, user, currentCode(t, secret, now))
+	require.NoError(t, err)
+	require.Len(t, codes, managers.RecoveryCodeCount)
+	_, err = mgr.EnrollTOTP(ctx, token, user, currentCode(t, secret, now))
+	require.Equal(t, managers.ErrNoEnrollment, err, "the secret is dropped from the session once enrolled")
+
+	user, err = mgr.Authenticate(ctx, "support", "long enough")
+	require.NoError(t, err)
+	token, err = mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	_, err = mgr.ResolveSession(ctx, token)
+	require.Equal(t, managers.ErrSecondFactorRequired, err)
+
+	_, err = mgr.VerifySecondFactor(ctx, token, currentCode(t, secret, now))
+	require.Equal(t, managers.ErrInvalidCode, err, "the code used for enrollment can't be reused")
+	now = now.Add(managers.TOTPPeriod)
+	verified, err := mgr.VerifySecondFactor(ctx, token, currentCode(t, secret, now))
+	require.NoError(t, err)
+	require.NotEqual(t, token, verified)
+	_, err = mgr.ResolveSession(ctx, verified)
+	require.NoError(t, err)
+	_, err = mgr.ResolveSession(ctx, token)
+	require.Equal(t, managers.ErrSessionExpired, err, "the pending session is replaced")
+
+	token, err = mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	_, err = mgr.VerifySecondFactor(ctx, token, strings.ToLower(codes[0]))
+	require.NoError(t, err)
+	token, err = mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	_, err = mgr.VerifySecondFactor(ctx, token, codes[0])
+	require.Equal(t, managers.ErrInvalidCode, err, "a recovery code can be used once")
+	token, err = mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	for i := 1; i < managers.MaxSecondFactorAttempts; i++ {
+		_, err = mgr.VerifySecondFactor(ctx, token, "000000")
+		require.Equal(t, managers.ErrInvalidCode, err)
+	}
+	_, err = mgr.VerifySecondFactor(ctx, token, "000000")
+	require.Equal(t, managers.ErrTooManyAttempts, err)
+	_, err = mgr.VerifySecondFactor(ctx, token, codes[1])
+	require.Equal(t, managers.ErrSessionExpired, err, "the password has to be entered again after too many wrong codes")
+
+	left, err := mgr.RecoveryCodesLeft(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, managers.RecoveryCodeCount-1, left)
+
+	_, err = mgr.RegenerateRecoveryCodes(ctx, user, codes[1])
+	require.Equal(t, managers.ErrInvalidCode, err, "recovery codes are regenerated with an authenticator code only")
+	_, err = mgr.RegenerateRecoveryCodes(ctx, user, currentCode(t, secret, now))
+	require.Equal(t, managers.ErrInvalidCode, err, "a used authenticator code can't be reused")
+	now = now.Add(managers.TOTPPeriod)
+	regenerated, err := mgr.RegenerateRecoveryCodes(ctx, user, currentCode(t, secret, now))
+	require.NoError(t, err)
+	require.Len(t, regenerated, managers.RecoveryCodeCount)
+	left, err = mgr.RecoveryCodesLeft(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, managers.RecoveryCodeCount, left)
+}
+
+func currentCode(t *testing.T, secret string, at time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	return managers.HOTP(key, uint64(managers.TOTPCounter(at)), managers.TOTPDigits)
+}
@@ -0,0 +1,217 @@
+package managers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/havr/customers/models"
+)
+
+// VerifySecondFactor completes the pending session with the given token using either an authenticator code
+// or an unused recovery code. The pending session is replaced with a full one, whose token is returned.
+// After MaxSecondFactorAttempts wrong codes the pending session is ended and ErrTooManyAttempts is returned
+func (u *UserManager) VerifySecondFactor(ctx context.Context, token, code string) (string, error) {
+	session, user, err := u.resolve(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if !session.Pending {
+		return token, nil
+	}
+	ok, err := u.checkCode(ctx, user, code)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		attempts, err := u.sessions.AddFailedAttempt(ctx, session.ID)
+		if err != nil {
+			return "", err
+		}
+		if attempts < MaxSecondFactorAttempts {
+			return "", ErrInvalidCode
+		}
+		if err := u.sessions.DeleteSession(ctx, session.ID); err != nil {
+			return "", err
+		}
+		return "", ErrTooManyAttempts
+	}
+	if err := u.sessions.DeleteSession(ctx, session.ID); err != nil {
+		return "", err
+	}
+	return u.createSession(ctx, user, false)
+}
+
+// checkCode accepts an authenticator code that hasn't been used yet or an unused recovery code
+func (u *UserManager) checkCode(ctx context.Context, user models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if counter, ok := VerifyTOTP(user.TOTPSecret, code, u.Now()); ok {
+		return u.users.AdvanceTOTPCounter(ctx, user.ID, counter)
+	}
+	return u.users.UseRecoveryCode(ctx, user.ID, recoveryCodeHash(code), u.Now())
+}
+
+// TOTPEnrollment returns the secret the user of the session with the given token is enrolling along with its otpauth URI.
+// The secret is generated on the first call and kept in the session, so that it never comes from the client
+func (u *UserManager) TOTPEnrollment(ctx context.Context, token string, user models.User) (secret, uri string, _ error) {
+	session, err := u.enrollingSession(ctx, token, user)
+	if err != nil {
+		return "", "", err
+	}
+	secret = session.TOTPSecret
+	if !validTOTPSecret(secret) {
+		if secret, err = NewTOTPSecret(); err != nil {
+			return "", "", err
+		}
+		if err := u.sessions.SetSessionTOTPSecret(ctx, session.ID, secret); err != nil {
+			return "", "", err
+		}
+	}
+	return secret, TOTPURI(u.Issuer, user.Username, secret), nil
+}
+
+// EnrollTOTP enables two-factor authentication for the given user once the code proves the secret
+// kept in the session with the given token has been enrolled. It returns new recovery codes, which replace the existing ones
+func (u *UserManager) EnrollTOTP(ctx context.Context, token string, user models.User, code string) ([]string, error) {
+	session, err := u.enrollingSession(ctx, token, user)
+	if err != nil {
+		return nil, err
+	}
+	secret := session.TOTPSecret
+	if !validTOTPSecret(secret) {
+		return nil, ErrNoEnrollment
+	}
+	counter, ok := VerifyTOTP(secret, strings.TrimSpace(code), u.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	var codes []string
+	err = u.transactor.InTx(ctx, func(ctx context.Context) error {
+		if err := u.users.SetTOTPSecret(ctx, user.ID, secret); err != nil {
+			return err
+		}
+		if _, err := u.users.AdvanceTOTPCounter(ctx, user.ID, counter); err != nil {
+			return err
+		}
+		if codes, err = u.replaceRecoveryCodes(ctx, user); err != nil {
+			return err
+		}
+		return u.sessions.SetSessionTOTPSecret(ctx, session.ID, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// enrollingSession returns the session with the given token, which must belong to the given user
+func (u *UserManager) enrollingSession(ctx context.Context, token string, user models.User) (models.Session, error) {
+	session, owner, err := u.resolve(ctx, token)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if session.Pending || owner.ID != user.ID {
+		return models.Session{}, ErrSessionExpired
+	}
+	return session, nil
+}
+
+// RegenerateRecoveryCodes replaces recovery codes of the given user with new ones and returns them
+// once a current authenticator code proves the user still has the authenticator.
+// Only hashes of the codes are stored, so they can't be shown again
+func (u *UserManager) RegenerateRecoveryCodes(ctx context.Context, user models.User, code string) ([]string, error) {
+	var codes []string
+	err := u.transactor.InTx(ctx, func(ctx context.Context) error {
+		counter, ok := VerifyTOTP(user.TOTPSecret, strings.TrimSpace(code), u.Now())
+		if !ok {
+			return ErrInvalidCode
+		}
+		if ok, err := u.users.AdvanceTOTPCounter(ctx, user.ID, counter); err != nil {
+			return err
+		} else if !ok {
+			return ErrInvalidCode
+		}
+		var err error
+		codes, err = u.replaceRecoveryCodes(ctx, user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes replaces recovery codes of the given user with new ones and returns them
+func (u *UserManager) replaceRecoveryCodes(ctx context.Context, user models.User) ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := base32NoPadding.EncodeToString(raw)
+		code := encoded[:8] + "-" + encoded[8:]
+		codes = append(codes, code)
+		hashes = append(hashes, recoveryCodeHash(code))
+	}
+	if err := u.users.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the given user
+func (u *UserManager) RecoveryCodesLeft(ctx context.Context, user models.User) (int, error) {
+	return u.users.CountRecoveryCodes(ctx, user.ID)
+}
+
+// ResetTwoFactor disables two-factor authentication of the user with the given name, e.g. when the user has lost the authenticator
+func (u *UserManager) ResetTwoFactor(ctx context.Context, username string) error {
+	user, err := u.users.GetUserByName(ctx, username)
+	if err != nil {
+		return err
+	}
+	return u.transactor.InTx(ctx, func(ctx context.Context) error {
+		if err := u.users.SetTOTPSecret(ctx, user.ID, ""); err != nil {
+			return err
+		}
+		return u.users.ReplaceRecoveryCodes(ctx, user.ID, nil)
+	})
+}
+
+// TwoFactorRequired tells whether two-factor authentication is mandatory for users who can change or delete customers
+func (u *UserManager) TwoFactorRequired(ctx context.Context) (bool, error) {
+	value, err := u.settings.GetSetting(ctx, SettingRequireTwoFactor)
+	return value == "true", err
+}
+
+// SetTwoFactorRequired makes two-factor authentication mandatory or optional for users who can change or delete customers
+func (u *UserManager) SetTwoFactorRequired(ctx context.Context, required bool) error {
+	if err := Authorize(ctx, models.ManageSettings); err != nil {
+		return err
+	}
+	value := "false"
+	if required {
+		value = "true"
+	}
+	return u.settings.SetSetting(ctx, SettingRequireTwoFactor, value)
+}
+
+// TwoFactorRequiredFor tells whether the given user must use two-factor authentication.
+// External users are no exception: whether their identity provider has asked for a second factor can't be told
+func (u *UserManager) TwoFactorRequiredFor(ctx context.Context, user models.User) (bool, error) {
+	if !user.Can(models.WriteCustomers) && !user.Can(models.DeleteCustomers) {
+		return false, nil
+	}
+	return u.TwoFactorRequired(ctx)
+}
+
+// recoveryCodeHash derives the stored hash of a recovery code, ignoring its case and separators
+func recoveryCodeHash(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,311 @@
+package managers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+const (
+	// DefaultIdleTimeout is the default time after which an unused session expires
+	DefaultIdleTimeout = 30 * time.Minute
+	// DefaultAbsoluteTimeout is the default time after which a session expires regardless of its use
+	DefaultAbsoluteTimeout = 12 * time.Hour
+	// MinPasswordLength is a minimal allowed password length
+	MinPasswordLength = 8
+
+	// DefaultIssuer is the default name authenticator apps show next to the codes of the application
+	DefaultIssuer = "Customers"
+	// RecoveryCodeCount is the number of recovery codes generated at once
+	RecoveryCodeCount = 10
+	// SettingRequireTwoFactor is the name of the setting that makes two-factor authentication
+	// mandatory for users who can change or delete customers
+	SettingRequireTwoFactor = "require_two_factor"
+
+	// sessions aren't touched more often than this to save on database writes
+	touchInterval = time.Minute
+	// pendingTimeout is the time a user has to enter the second factor after the password
+	pendingTimeout = 5 * time.Minute
+	// MaxSecondFactorAttempts is the number of wrong codes after which a pending session is ended,
+	// so that the password has to be entered again
+	MaxSecondFactorAttempts = 5
+)
+
+var (
+	// ErrInvalidCredentials occurs when a username or a password is wrong
+	ErrInvalidCredentials = fmt.Errorf("invalid username or password")
+	// ErrSessionExpired occurs when a session doesn't exist or has timed out
+	ErrSessionExpired = fmt.Errorf("session has expired")
+	// ErrSecondFactorRequired occurs when a session still waits for the second factor
+	ErrSecondFactorRequired = fmt.Errorf("second factor is required")
+	// ErrTwoFactorEnrollmentRequired occurs when a user must enroll an authenticator before going on
+	ErrTwoFactorEnrollmentRequired = fmt.Errorf("two-factor authentication must be set up")
+	// ErrInvalidCode occurs when a two-factor code or a recovery code is wrong or has been already used
+	ErrInvalidCode = fmt.Errorf("invalid or already used code")
+	// ErrNoEnrollment occurs when an authenticator is enrolled without a secret generated for the session
+	ErrNoEnrollment = fmt.Errorf("no authenticator is being set up, start over")
+	// ErrTooManyAttempts occurs when a pending session has been ended after too many wrong codes
+	ErrTooManyAttempts = fmt.Errorf("too many invalid codes, log in again")
+)
+
+type userKey struct{}
+
+// WithUser returns a context that carries the given user
+func WithUser(ctx context.Context, user models.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user the context carries, if any
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	user, ok := ctx.Value(userKey{}).(models.User)
+	return user, ok
+}
+
+// UserManager represents business logic related to users, their sessions and second factors
+type UserManager struct {
+	transactor      stores.Transactor
+	users           stores.UserStore
+	sessions        stores.SessionStore
+	settings        stores.SettingStore
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	Issuer          string
+	Now             func() time.Time
+}
+
+// NewUserManager creates a user manager that uses the given stores.
+// Second factors of a user are changed within a transaction of the transactor
+func NewUserManager(users stores.UserStore, sessions stores.SessionStore, settings stores.SettingStore, transactor stores.Transactor) *UserManager {
+	return &UserManager{
+		transactor:      transactor,
+		users:           users,
+		sessions:        sessions,
+		settings:        settings,
+		IdleTimeout:     DefaultIdleTimeout,
+		AbsoluteTimeout: DefaultAbsoluteTimeout,
+		Issuer:          DefaultIssuer,
+		Now:             time.Now,
+	}
+}
+
+// CreateUser validates the username, the password and the role and creates a user with them
+func (u *UserManager) CreateUser(ctx context.Context, username, password string, role models.Role) (models.User, error) {
+	var errs MultipleErrors
+	errs = u.appendError(errs, validateUsername(username))
+	errs = u.appendError(errs, validateRole(role))
+	if len(password) < MinPasswordLength {
+		errs = append(errs, fmt.Errorf("password is too short: minimum allowed length is %d", MinPasswordLength))
+	}
+	if len(errs) != 0 {
+		return models.User{}, errs
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return models.User{}, err
+	}
+	user, err := u.users.CreateUser(ctx, models.User{Username: username, Role: role, PasswordHash: hash})
+	if err == stores.ErrDuplicate {
+		return models.User{}, MultipleErrors{fmt.Errorf("username %q is already taken", username)}
+	}
+	return user, err
+}
+
+// GetUser returns a user by its ID
+func (u *UserManager) GetUser(ctx context.Context, id int) (models.User, error) {
+	return u.users.GetUser(ctx, id)
+}
+
+// SetRole changes the role of the user with the given username
+func (u *UserManager) SetRole(ctx context.Context, username string, role models.Role) error {
+	if err := validateRole(role); err != nil {
+		return err
+	}
+	user, err := u.users.GetUserByName(ctx, username)
+	if err != nil {
+		return err
+	}
+	return u.users.SetUserRole(ctx, user.ID, role)
+}
+
+// ProvisionExternalUser returns the user linked to the given identity at an identity provider, creating it on the first login.
+// The role is updated on every login, so changes of the user's groups at the provider take effect.
+// External users have no password and can't log in with one
+func (u *UserManager) ProvisionExternalUser(ctx context.Context, externalID, username string, role models.Role) (models.User, error) {
+	if err := validateRole(role); err != nil {
+		return models.User{}, err
+	}
+	user, found, err := u.users.GetUserByExternalID(ctx, externalID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if found {
+		if user.Role != role {
+			if err := u.users.SetUserRole(ctx, user.ID, role); err != nil {
+				return models.User{}, err
+			}
+			user.Role = role
+		}
+		return user, nil
+	}
+	if err := validateUsername(username); err != nil {
+		return models.User{}, err
+	}
+	user, err = u.users.CreateUser(ctx, models.User{Username: username, Role: role, ExternalID: externalID})
+	if err == stores.ErrDuplicate {
+		return models.User{}, apperr.New(apperr.Conflict, "username %q is already taken by another user", username)
+	}
+	return user, err
+}
+
+// Authenticate returns the user with the given credentials or ErrInvalidCredentials
+func (u *UserManager) Authenticate(ctx context.Context, username, password string) (models.User, error) {
+	user, err := u.users.GetUserByName(ctx, username)
+	if err != nil {
+		CheckPassword(dummyPasswordHash, password)
+		return models.User{}, ErrInvalidCredentials
+	}
+	if !CheckPassword(user.PasswordHash, password) {
+		return models.User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// StartSession creates a session for the given user and returns its token.
+// The session of a user who has enrolled an authenticator stays pending until VerifySecondFactor
+func (u *UserManager) StartSession(ctx context.Context, user models.User) (string, error) {
+	return u.createSession(ctx, user, user.TwoFactorEnabled())
+}
+
+func (u *UserManager) createSession(ctx context.Context, user models.User, pending bool) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := u.Now()
+	err := u.sessions.CreateSession(ctx, models.Session{
+		ID:         sessionID(token),
+		UserID:     user.ID,
+		Pending:    pending,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResolveSession returns the user of the session with the given token and prolongs the session.
+// It returns ErrSessionExpired if the session doesn't exist or has exceeded its idle or absolute timeout,
+// and ErrSecondFactorRequired if the session is pending. It returns the user along with ErrTwoFactorEnrollmentRequired
+// if the user must enroll an authenticator before doing anything else
+func (u *UserManager) ResolveSession(ctx context.Context, token string) (models.User, error) {
+	session, user, err := u.resolve(ctx, token)
+	if err != nil {
+		return models.User{}, err
+	}
+	if session.Pending {
+		return models.User{}, ErrSecondFactorRequired
+	}
+	now := u.Now()
+	if now.Sub(session.LastSeenAt) > touchInterval {
+		if err := u.sessions.TouchSession(ctx, session.ID, now); err != nil {
+			return models.User{}, err
+		}
+	}
+	if !user.TwoFactorEnabled() {
+		required, err := u.TwoFactorRequiredFor(ctx, user)
+		if err != nil {
+			return models.User{}, err
+		}
+		if required {
+			return user, ErrTwoFactorEnrollmentRequired
+		}
+	}
+	return user, nil
+}
+
+// resolve returns a live session with the given token along with its user
+func (u *UserManager) resolve(ctx context.Context, token string) (models.Session, models.User, error) {
+	if token == "" {
+		return models.Session{}, models.User{}, ErrSessionExpired
+	}
+	id := sessionID(token)
+	session, err := u.sessions.GetSession(ctx, id)
+	if err != nil {
+		return models.Session{}, models.User{}, ErrSessionExpired
+	}
+	now := u.Now()
+	if now.Sub(session.LastSeenAt) > u.IdleTimeout || now.Sub(session.CreatedAt) > u.AbsoluteTimeout ||
+		(session.Pending && now.Sub(session.CreatedAt) > pendingTimeout) {
+		if err := u.sessions.DeleteSession(ctx, id); err != nil {
+			return models.Session{}, models.User{}, err
+		}
+		return models.Session{}, models.User{}, ErrSessionExpired
+	}
+	user, err := u.users.GetUser(ctx, session.UserID)
+	if err != nil {
+		return models.Session{}, models.User{}, ErrSessionExpired
+	}
+	return session, user, nil
+}
+
+// EndSession deletes the session with the given token
+func (u *UserManager) EndSession(ctx context.Context, token string) error {
+	return u.sessions.DeleteSession(ctx, sessionID(token))
+}
+
+// AddFlash saves a flash message to be shown on the next page of the session with the given token
+func (u *UserManager) AddFlash(ctx context.Context, token string, flash models.Flash) error {
+	return u.sessions.AddFlash(ctx, sessionID(token), flash)
+}
+
+// TakeFlashes returns flash messages of the session with the given token, so that they are shown only once
+func (u *UserManager) TakeFlashes(ctx context.Context, token string) ([]models.Flash, error) {
+	return u.sessions.TakeFlashes(ctx, sessionID(token))
+}
+
+// PurgeSessions deletes all expired sessions
+func (u *UserManager) PurgeSessions(ctx context.Context) error {
+	now := u.Now()
+	return u.sessions.DeleteStaleSessions(ctx, now.Add(-u.IdleTimeout), now.Add(-u.AbsoluteTimeout))
+}
+
+func (u *UserManager) appendError(errs MultipleErrors, err error) MultipleErrors {
+	if err != nil {
+		return append(errs, err)
+	}
+	return errs
+}
+
+func validateUsername(username string) error {
+	if username == "" {
+		return apperr.New(apperr.Validation, "username is empty")
+	}
+	if len(username) > 100 {
+		return apperr.New(apperr.Validation, "username is too long: maximum allowed length is %d", 100)
+	}
+	return nil
+}
+
+func validateRole(role models.Role) error {
+	if !role.Valid() {
+		return apperr.New(apperr.Validation, "unknown role %q: expected one of %v", role, models.Roles)
+	}
+	return nil
+}
+
+// sessionID derives the stored session ID from its token
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
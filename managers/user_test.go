@@ -0,0 +1,331 @@
+package managers_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := managers.HashPassword("correct horse")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, "pbkdf2-sha256$"))
+	require.True(t, managers.CheckPassword(hash, "correct horse"))
+	require.False(t, managers.CheckPassword(hash, "wrong horse"))
+	require.False(t, managers.CheckPassword("garbage", "correct horse"))
+
+	another, err := managers.HashPassword("correct horse")
+	require.NoError(t, err)
+	require.NotEqual(t, hash, another, "hashes must be salted")
+}
+
+func TestUserAuthentication(t *testing.T) {
+	ctx := context.Background()
+	mgr := managers.NewUserManager(newMemoryUserStore(), newMemorySessionStore(), memorySettingStore{}, fakeTransactor{})
+
+	_, err := mgr.CreateUser(ctx, "admin", "short", models.RoleAdmin)
+	require.Error(t, err)
+	user, err := mgr.CreateUser(ctx, "admin", "long enough", models.RoleAdmin)
+	require.NoError(t, err)
+	_, err = mgr.CreateUser(ctx, "admin", "long enough", models.RoleAdmin)
+	require.Error(t, err)
+	_, err = mgr.CreateUser(ctx, "root", "long enough", models.Role("root"))
+	require.Error(t, err)
+
+	authenticated, err := mgr.Authenticate(ctx, "admin", "long enough")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, authenticated.ID)
+
+	_, err = mgr.Authenticate(ctx, "admin", "wrong password")
+	require.Equal(t, managers.ErrInvalidCredentials, err)
+	_, err = mgr.Authenticate(ctx, "nobody", "long enough")
+	require.Equal(t, managers.ErrInvalidCredentials, err)
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sessions := newMemorySessionStore()
+	mgr := managers.NewUserManager(newMemoryUserStore(), sessions, memorySettingStore{}, fakeTransactor{})
+	mgr.IdleTimeout, mgr.AbsoluteTimeout = 10*time.Minute, time.Hour
+	mgr.Now = func() time.Time { return now }
+
+	user, err := mgr.CreateUser(ctx, "admin", "long enough", models.RoleAdmin)
+	require.NoError(t, err)
+	token, err := mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+
+	_, err = mgr.ResolveSession(ctx, "unknown")
+	require.Equal(t, managers.ErrSessionExpired, err)
+
+	// activity keeps the session alive up to the absolute timeout
+	for i := 0; i < 5; i++ {
+		now = now.Add(9 * time.Minute)
+		resolved, err := mgr.ResolveSession(ctx, token)
+		require.NoError(t, err)
+		require.Equal(t, user.ID, resolved.ID)
+	}
+	now = now.Add(16 * time.Minute)
+	_, err = mgr.ResolveSession(ctx, token)
+	require.Equal(t, managers.ErrSessionExpired, err)
+	require.Empty(t, sessions.sessions)
+
+	// a session expires after being idle
+	token, err = mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	now = now.Add(11 * time.Minute)
+	_, err = mgr.ResolveSession(ctx, token)
+	require.Equal(t, managers.ErrSessionExpired, err)
+
+	// a session ends on logout
+	token, err = mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	require.NoError(t, mgr.EndSession(ctx, token))
+	_, err = mgr.ResolveSession(ctx, token)
+	require.Equal(t, managers.ErrSessionExpired, err)
+}
+
+func TestProvisionExternalUser(t *testing.T) {
+	ctx := context.Background()
+	settings := memorySettingStore{managers.SettingRequireTwoFactor: "true"}
+	mgr := managers.NewUserManager(newMemoryUserStore(), newMemorySessionStore(), settings, fakeTransactor{})
+	_, err := mgr.CreateUser(ctx, "local", "long enough", models.RoleAdmin)
+	require.NoError(t, err)
+
+	user, err := mgr.ProvisionExternalUser(ctx, "https://idp|1", "jane", models.RoleSupport)
+	require.NoError(t, err)
+	require.True(t, user.External())
+	require.Equal(t, models.RoleSupport, user.Role)
+	_, err = mgr.Authenticate(ctx, "jane", "")
+	require.Equal(t, managers.ErrInvalidCredentials, err, "external users have no password")
+
+	// the role follows the groups at the provider
+	again, err := mgr.ProvisionExternalUser(ctx, "https://idp|1", "jane", models.RoleIntern)
+	require.NoError(t, err)
+	require.Equal(t, user.ID, again.ID)
+	stored, err := mgr.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.RoleIntern, stored.Role)
+
+	// an external user never takes over a local account
+	_, err = mgr.ProvisionExternalUser(ctx, "https://idp|2", "local", models.RoleIntern)
+	require.Error(t, err)
+
+	required, err := mgr.TwoFactorRequiredFor(ctx, models.User{Role: models.RoleAdmin})
+	require.NoError(t, err)
+	require.True(t, required)
+	required, err = mgr.TwoFactorRequiredFor(ctx, models.User{Role: models.RoleAdmin, ExternalID: "https://idp|1"})
+	require.NoError(t, err)
+	require.True(t, required, "external users enroll an authenticator as local ones do")
+	required, err = mgr.TwoFactorRequiredFor(ctx, models.User{Role: models.RoleIntern, ExternalID: "https://idp|1"})
+	require.NoError(t, err)
+	require.False(t, required)
+}
+
+func TestFlashes(t *testing.T) {
+	ctx := context.Background()
+	mgr := managers.NewUserManager(newMemoryUserStore(), newMemorySessionStore(), memorySettingStore{}, fakeTransactor{})
+	user, err := mgr.CreateUser(ctx, "admin", "long enough", models.RoleAdmin)
+	require.NoError(t, err)
+	token, err := mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+	another, err := mgr.StartSession(ctx, user)
+	require.NoError(t, err)
+
+	deleted := models.Flash{Kind: models.FlashSuccess, Message: "Customer #42 deleted", Action: "/ui/customer/restore/42", ActionLabel: "Undo"}
+	require.NoError(t, mgr.AddFlash(ctx, token, models.Flash{Kind: models.FlashSuccess, Message: "Customer #42 updated"}))
+	require.NoError(t, mgr.AddFlash(ctx, token, deleted))
+
+	flashes, err := mgr.TakeFlashes(ctx, another)
+	require.NoError(t, err)
+	require.Empty(t, flashes, "flashes belong to their session")
+	flashes, err = mgr.TakeFlashes(ctx, token)
+	require.NoError(t, err)
+	require.Len(t, flashes, 2)
+	require.Equal(t, deleted, flashes[1])
+	flashes, err = mgr.TakeFlashes(ctx, token)
+	require.NoError(t, err)
+	require.Empty(t, flashes, "a flash is shown once")
+}
+
+type memoryUserStore struct {
+	users         []models.User
+	recoveryCodes map[int]map[string]bool
+}
+
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{recoveryCodes: map[int]map[string]bool{}}
+}
+
+func (s *memoryUserStore) CreateUser(ctx context.Context, user models.User) (models.User, error) {
+	for _, existing := range s.users {
+		if existing.Username == user.Username {
+			return models.User{}, stores.ErrDuplicate
+		}
+	}
+	user.ID = len(s.users) + 1
+	s.users = append(s.users, user)
+	return user, nil
+}
+
+func (s *memoryUserStore) GetUser(ctx context.Context, id int) (models.User, error) {
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return models.User{}, stores.ErrNotFound
+}
+
+func (s *memoryUserStore) GetUserByName(ctx context.Context, username string) (models.User, error) {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return models.User{}, stores.ErrNotFound
+}
+
+func (s *memoryUserStore) GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool, error) {
+	for _, user := range s.users {
+		if user.ExternalID == externalID {
+			return user, true, nil
+		}
+	}
+	return models.User{}, false, nil
+}
+
+func (s *memoryUserStore) SetUserRole(ctx context.Context, id int, role models.Role) error {
+	for i := range s.users {
+		if s.users[i].ID == id {
+			s.users[i].Role = role
+			return nil
+		}
+	}
+	return stores.ErrNotFound
+}
+
+func (s *memoryUserStore) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	s.users[id-1].TOTPSecret, s.users[id-1].TOTPCounter = secret, 0
+	return nil
+}
+
+func (s *memoryUserStore) AdvanceTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	if s.users[id-1].TOTPCounter >= counter {
+		return false, nil
+	}
+	s.users[id-1].TOTPCounter = counter
+	return true, nil
+}
+
+func (s *memoryUserStore) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	s.recoveryCodes[userID] = map[string]bool{}
+	for _, hash := range hashes {
+		s.recoveryCodes[userID][hash] = false
+	}
+	return nil
+}
+
+func (s *memoryUserStore) UseRecoveryCode(ctx context.Context, userID int, hash string, at time.Time) (bool, error) {
+	used, ok := s.recoveryCodes[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	s.recoveryCodes[userID][hash] = true
+	return true, nil
+}
+
+func (s *memoryUserStore) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var count int
+	for _, used := range s.recoveryCodes[userID] {
+		if !used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type memorySettingStore map[string]string
+
+func (s memorySettingStore) GetSetting(ctx context.Context, name string) (string, error) {
+	return s[name], nil
+}
+
+func (s memorySettingStore) SetSetting(ctx context.Context, name, value string) error {
+	s[name] = value
+	return nil
+}
+
+type memorySessionStore struct {
+	sessions map[string]models.Session
+	flashes  map[string][]models.Flash
+	attempts map[string]int
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: map[string]models.Session{}, flashes: map[string][]models.Flash{}, attempts: map[string]int{}}
+}
+
+func (s *memorySessionStore) CreateSession(ctx context.Context, session models.Session) error {
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *memorySessionStore) GetSession(ctx context.Context, id string) (models.Session, error) {
+	session, ok := s.sessions[id]
+	if !ok {
+		return models.Session{}, stores.ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *memorySessionStore) TouchSession(ctx context.Context, id string, at time.Time) error {
+	session := s.sessions[id]
+	session.LastSeenAt = at
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *memorySessionStore) SetSessionTOTPSecret(ctx context.Context, id, secret string) error {
+	session := s.sessions[id]
+	session.TOTPSecret = secret
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *memorySessionStore) AddFailedAttempt(ctx context.Context, id string) (int, error) {
+	s.attempts[id]++
+	return s.attempts[id], nil
+}
+
+func (s *memorySessionStore) DeleteSession(ctx context.Context, id string) error {
+	delete(s.sessions, id)
+	delete(s.flashes, id)
+	return nil
+}
+
+func (s *memorySessionStore) AddFlash(ctx context.Context, sessionID string, flash models.Flash) error {
+	s.flashes[sessionID] = append(s.flashes[sessionID], flash)
+	return nil
+}
+
+func (s *memorySessionStore) TakeFlashes(ctx context.Context, sessionID string) ([]models.Flash, error) {
+	flashes := s.flashes[sessionID]
+	delete(s.flashes, sessionID)
+	return flashes, nil
+}
+
+func (s *memorySessionStore) DeleteStaleSessions(ctx context.Context, lastSeenBefore, createdBefore time.Time) error {
+	for id, session := range s.sessions {
+		if session.LastSeenAt.Before(lastSeenBefore) || session.CreatedAt.Before(createdBefore) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
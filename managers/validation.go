@@ -0,0 +1,129 @@
+package managers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/havr/customers/apperr"
+)
+
+// Codes of field errors, which let clients tell problems apart without parsing messages
+const (
+	// CodeRequired means the field is empty
+	CodeRequired = "required"
+	// CodeTooLong means the field exceeds the "max" length
+	CodeTooLong = "too_long"
+	// CodeInvalidFormat means the field, or its "value" if the field is a list, can't be parsed or has an invalid format
+	CodeInvalidFormat = "invalid_format"
+	// CodeInvalidChoice means the field has a "value" that isn't one of the "allowed" ones
+	CodeInvalidChoice = "invalid_choice"
+	// CodeTooYoung means the customer is younger than the "min" age
+	CodeTooYoung = "too_young"
+	// CodeTooOld means the customer is older than the "max" age
+	CodeTooOld = "too_old"
+	// CodeInPast means the time has already passed
+	CodeInPast = "in_past"
+	// CodeOutOfRange means the field has a "value" that isn't between the "min" and the "max" ones inclusive
+	CodeOutOfRange = "out_of_range"
+	// CodeInternalAddress means the URL points to the "value" address, which is loopback, private or otherwise internal
+	CodeInternalAddress = "internal_address"
+)
+
+// fieldLabels are human readable names of fields that differ from their JSON names
+var fieldLabels = map[string]string{
+	"firstName":  "first name",
+	"lastName":   "last name",
+	"birthDate":  "birth date",
+	"eventTypes": "event types",
+	"allowedIps": "allowed IPs",
+	"expiresAt":  "expiry time",
+}
+
+// FieldError is a validation error of a single field of a model. Field is the JSON name of the field,
+// Code tells what is wrong and Params carry the details, e.g. the maximum allowed length
+type FieldError struct {
+	Field  string                 `json:"field"`
+	Code   string                 `json:"code"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Error returns a human readable message. It may include a parameter value the user has entered,
+// so it must be escaped when rendered
+func (e *FieldError) Error() string {
+	label, ok := fieldLabels[e.Field]
+	if !ok {
+		label = e.Field
+	}
+	switch e.Code {
+	case CodeRequired:
+		return fmt.Sprintf("%s is empty", label)
+	case CodeTooLong:
+		return fmt.Sprintf("%s is too long: maximum allowed length is %v", label, e.Params["max"])
+	case CodeInvalidFormat:
+		if value, ok := e.Params["value"]; ok {
+			return fmt.Sprintf("%s has invalid format: %q", label, value)
+		}
+		return fmt.Sprintf("%s has invalid format", label)
+	case CodeInvalidChoice:
+		return fmt.Sprintf("unknown %s %q: expected one of %v", label, e.Params["value"], e.Params["allowed"])
+	case CodeTooYoung:
+		return "customer is too young"
+	case CodeTooOld:
+		return "customer is too old"
+	case CodeInPast:
+		return fmt.Sprintf("%s is in the past", label)
+	case CodeOutOfRange:
+		return fmt.Sprintf("%s must be between %v and %v", label, e.Params["min"], e.Params["max"])
+	case CodeInternalAddress:
+		return fmt.Sprintf("%s points to %v, which isn't a public address", label, e.Params["value"])
+	}
+	return fmt.Sprintf("%s is invalid", label)
+}
+
+// ErrorKind implements apperr.Kinded
+func (e *FieldError) ErrorKind() apperr.Kind {
+	return apperr.Validation
+}
+
+// FieldErrors returns the field errors the given error consists of, along with the errors that aren't bound to a field
+func FieldErrors(err error) (fields []*FieldError, other []error) {
+	errs, ok := err.(MultipleErrors)
+	if !ok {
+		errs = MultipleErrors{err}
+	}
+	for _, err := range errs {
+		if fieldErr, ok := err.(*FieldError); ok {
+			fields = append(fields, fieldErr)
+		} else if err != nil {
+			other = append(other, err)
+		}
+	}
+	return
+}
+
+// MultipleErrors aggregates multiple errors into one
+type MultipleErrors []error
+
+func (e MultipleErrors) Error() string {
+	var strs []string
+	for _, err := range e {
+		strs = append(strs, err.Error())
+	}
+	return strings.Join(strs, "\n")
+}
+
+// ErrorKind implements apperr.Kinded
+func (e MultipleErrors) ErrorKind() apperr.Kind {
+	return apperr.Validation
+}
+
+// validateString checks that a required string field is not empty and that a string doesn't exceed the maximum length, if it's given
+func validateString(field, str string, nonEmpty bool, maxLength int) error {
+	if nonEmpty && str == "" {
+		return &FieldError{Field: field, Code: CodeRequired}
+	}
+	if maxLength > 0 && len(str) > maxLength {
+		return &FieldError{Field: field, Code: CodeTooLong, Params: map[string]interface{}{"max": maxLength}}
+	}
+	return nil
+}
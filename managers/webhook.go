@@ -0,0 +1,125 @@
+package managers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+	"github.com/havr/customers/webhooks"
+)
+
+// KnownEventTypes lists event types webhooks may subscribe to
+var KnownEventTypes = []models.EventType{models.CustomerCreated, models.CustomerUpdated, models.CustomerDeleted, models.CustomerRestored, models.CustomerErased}
+
+// WebhookManager represents business logic related to webhook subscriptions
+type WebhookManager struct {
+	stores.WebhookStore
+	// LookupIP resolves host names of webhook URLs, which must not point to internal addresses
+	LookupIP func(host string) ([]net.IP, error)
+}
+
+// NewWebhookManager creates a webhook manager that uses the given store
+func NewWebhookManager(store stores.WebhookStore) *WebhookManager {
+	return &WebhookManager{
+		WebhookStore: store,
+		LookupIP:     net.LookupIP,
+	}
+}
+
+// CreateWebhook validates and creates the given webhook subscription.
+// If the webhook has no secret, a random one is generated
+func (w *WebhookManager) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	if webhook.Secret == "" {
+		secret, err := randomSecret()
+		if err != nil {
+			return models.Webhook{}, err
+		}
+		webhook.Secret = secret
+	}
+	if err := w.ValidateWebhook(webhook); err != nil {
+		return models.Webhook{}, err
+	}
+	return w.WebhookStore.CreateWebhook(ctx, webhook)
+}
+
+// Redeliver schedules the given delivery to be attempted again right away, regardless of its status
+func (w *WebhookManager) Redeliver(ctx context.Context, deliveryID int64) error {
+	delivery, err := w.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return err
+	}
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	return w.UpdateDelivery(ctx, delivery)
+}
+
+// ValidateWebhook validates the given webhook and returns all errors it encountered, if any
+func (w *WebhookManager) ValidateWebhook(webhook models.Webhook) error {
+	var errs MultipleErrors
+	if parsed, err := url.Parse(webhook.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs = append(errs, &FieldError{Field: "url", Code: CodeInvalidFormat})
+	} else if err := validateString("url", webhook.URL, true, 2000); err != nil {
+		errs = append(errs, err)
+	} else if ip := w.internalAddress(parsed.Hostname()); ip != nil {
+		errs = append(errs, &FieldError{Field: "url", Code: CodeInternalAddress, Params: map[string]interface{}{"value": ip.String()}})
+	}
+	if err := validateString("secret", webhook.Secret, false, 200); err != nil {
+		errs = append(errs, err)
+	}
+	if len(webhook.EventTypes) == 0 {
+		errs = append(errs, &FieldError{Field: "eventTypes", Code: CodeRequired})
+	}
+	for _, eventType := range webhook.EventTypes {
+		if !isKnownEventType(eventType) {
+			errs = append(errs, &FieldError{Field: "eventTypes", Code: CodeInvalidChoice, Params: map[string]interface{}{
+				"value":   eventType,
+				"allowed": KnownEventTypes,
+			}})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// internalAddress returns an internal address the host is or resolves to, if any. Names that can't be resolved
+// are let through, since deliveries check addresses again as they connect
+func (w *WebhookManager) internalAddress(host string) net.IP {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = w.LookupIP(host); err != nil {
+			return nil
+		}
+	}
+	for _, ip := range ips {
+		if !webhooks.PublicIP(ip) {
+			return ip
+		}
+	}
+	return nil
+}
+
+func isKnownEventType(eventType models.EventType) bool {
+	for _, known := range KnownEventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func randomSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
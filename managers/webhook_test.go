@@ -0,0 +1,43 @@
+package managers_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+)
+
+func TestValidateWebhookRefusesInternalAddresses(t *testing.T) {
+	mgr := managers.NewWebhookManager(nil)
+	mgr.LookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "hooks.example.com":
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		case "internal.example.com":
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.5")}, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	webhook := models.Webhook{Secret: "secret", EventTypes: []models.EventType{models.CustomerCreated}}
+	for url, internal := range map[string]string{
+		"https://hooks.example.com/events":       "",
+		"https://unknown.example.com/events":     "",
+		"https://internal.example.com/events":    "10.0.0.5",
+		"http://169.254.169.254/latest/metadata": "169.254.169.254",
+		"http://127.0.0.1:8080/ui":               "127.0.0.1",
+		"http://[::1]/":                          "::1",
+	} {
+		webhook.URL = url
+		err := mgr.ValidateWebhook(webhook)
+		if internal == "" {
+			require.NoError(t, err, url)
+			continue
+		}
+		require.Equal(t, managers.MultipleErrors{&managers.FieldError{Field: "url", Code: managers.CodeInternalAddress,
+			Params: map[string]interface{}{"value": internal}}}, err, url)
+	}
+}
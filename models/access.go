@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// AccessPurpose tells why customer data has been read
+type AccessPurpose string
+
+const (
+	// AccessView is a customer shown on its own, e.g. on the view page or through the API
+	AccessView AccessPurpose = "view"
+	// AccessEdit is a customer read to be edited
+	AccessEdit AccessPurpose = "edit"
+	// AccessList is a customer shown among others, e.g. a row of the list page
+	AccessList AccessPurpose = "list"
+	// AccessExport is a customer exported in bulk
+	AccessExport AccessPurpose = "export"
+	// AccessReveal is a customer whose personal data has been revealed
+	AccessReveal AccessPurpose = "reveal"
+	// AccessChanges is a customer read through the change feed
+	AccessChanges AccessPurpose = "changes"
+)
+
+// AccessPurposes lists all known purposes
+var AccessPurposes = []AccessPurpose{AccessView, AccessEdit, AccessList, AccessExport, AccessReveal, AccessChanges}
+
+// Access records that a user or an API key has read data of a customer
+type Access struct {
+	ID         int64         `json:"id"`
+	CustomerID int           `json:"customerId"`
+	Actor      string        `json:"actor"`
+	Purpose    AccessPurpose `json:"purpose"`
+	RequestID  string        `json:"requestId,omitempty"`
+	AccessedAt time.Time     `json:"accessedAt"`
+}
+
+// AccessFilter selects accesses of the access log. Zero fields match any access
+type AccessFilter struct {
+	CustomerID int
+	Actor      string
+	Purpose    AccessPurpose
+	// Since and Until bound the time of accesses, Until is exclusive
+	Since time.Time
+	Until time.Time
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"net"
+	"time"
+)
+
+// APIKeyScopes lists permissions an API key may be granted
+var APIKeyScopes = []Permission{ReadCustomers, ViewPII, RevealPII, WriteCustomers, DeleteCustomers, ExportCustomers}
+
+// APIKey authenticates a machine client. Only a hash of the key is stored,
+// Prefix is the beginning of the key that lets people recognize it
+type APIKey struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	Hash       string       `json:"-"`
+	Scopes     []Permission `json:"scopes"`
+	AllowedIPs []string     `json:"allowedIps"`
+	ExpiresAt  *time.Time   `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time   `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time   `json:"revokedAt,omitempty"`
+	CreatedBy  string       `json:"createdBy"`
+	CreatedAt  time.Time    `json:"createdAt"`
+}
+
+// Can tells whether the key has been granted the given permission
+func (k APIKey) Can(permission Permission) bool {
+	for _, scope := range k.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Active tells whether the key is neither revoked nor expired at the given time
+func (k APIKey) Active(at time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || at.Before(*k.ExpiresAt))
+}
+
+// AllowsIP tells whether the key may be used from the given address.
+// Allowed IPs are either single addresses or CIDR networks, a key without them may be used from anywhere
+func (k APIKey) AllowsIP(ip net.IP) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, allowed := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
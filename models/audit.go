@@ -0,0 +1,64 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditEntry is an entry of the audit trail of customer changes. Entries are chained:
+// every entry carries the hash of the previous one, so changing or removing an entry breaks the chain.
+// Entries name changed fields rather than their values, so that the trail outlives erasure of personal data
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Action     EventType `json:"action"`
+	CustomerID int       `json:"customerId"`
+	Actor      string    `json:"actor"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Fields     []string  `json:"fields,omitempty"`
+	// Note justifies the action, e.g. the request of a data subject that has been handled
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	PrevHash  string    `json:"prevHash"`
+	Hash      string    `json:"hash"`
+}
+
+// ComputeHash returns the hash of the entry, which covers all its fields but the hash itself
+func (e AuditEntry) ComputeHash() string {
+	parts := []string{
+		strconv.FormatInt(e.ID, 10),
+		string(e.Action),
+		strconv.Itoa(e.CustomerID),
+		strconv.Quote(e.Actor),
+		strconv.Quote(e.RequestID),
+		strconv.Quote(strings.Join(e.Fields, ",")),
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.PrevHash,
+	}
+	// an empty note adds no part, so the hash of an entry without a note doesn't depend on the note field
+	if e.Note != "" {
+		parts = append(parts, strconv.Quote(e.Note))
+	}
+	content := strings.Join(parts, "\n")
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditCheckpoint is a signed statement that the audit trail has reached the given entry,
+// which lets auditors tell whether entries have been removed from the end of the trail or the trail has been rebuilt
+type AuditCheckpoint struct {
+	ID        int64     `json:"id"`
+	EntryID   int64     `json:"entryId"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+	// PublicKey is the base64 encoded Ed25519 key the checkpoint has been signed with
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
+// SignedContent returns what the signature of the checkpoint covers
+func (c AuditCheckpoint) SignedContent() []byte {
+	return []byte(strconv.FormatInt(c.EntryID, 10) + "\n" + c.Hash + "\n" + c.CreatedAt.UTC().Format(time.RFC3339Nano))
+}
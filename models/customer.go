@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // Gender is a helper enum that represents customer gender
 type Gender string
@@ -21,12 +24,64 @@ func IsValidGender(gender string) bool {
 
 // Customer represents a basic info for a customer
 type Customer struct {
-	ID        int
-	Revision  int
+	ID        int       `json:"id"`
+	Revision  int       `json:"revision"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	BirthDate time.Time `json:"birthDate"`
+	Gender    Gender    `json:"gender"`
+	Email     string    `json:"email"`
+	Address   string    `json:"address"`
+}
+
+// FieldChange describes a change of a single customer field
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Diff returns changes of the customer fields required to turn the customer into the given one
+func (c Customer) Diff(to Customer) []FieldChange {
+	var changes []FieldChange
+	appendChange := func(field string, oldValue, newValue interface{}, changed bool) {
+		if changed {
+			changes = append(changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	appendChange("firstName", c.FirstName, to.FirstName, c.FirstName != to.FirstName)
+	appendChange("lastName", c.LastName, to.LastName, c.LastName != to.LastName)
+	appendChange("birthDate", c.BirthDate, to.BirthDate, !c.BirthDate.Equal(to.BirthDate))
+	appendChange("gender", c.Gender, to.Gender, c.Gender != to.Gender)
+	appendChange("email", c.Email, to.Email, c.Email != to.Email)
+	appendChange("address", c.Address, to.Address, c.Address != to.Address)
+	return changes
+}
+
+// CustomerListFilter represents filtering options
+type CustomerListFilter struct {
 	FirstName string
 	LastName  string
-	BirthDate time.Time
-	Gender    Gender
-	Email     string
-	Address   string
+	// Email is matched exactly, though case-insensitively
+	Email string `json:",omitempty"`
+}
+
+// Matches reports whether the customer satisfies the filter, i.e. customer names start with the filter ones
+// and the email is the filter one
+func (f CustomerListFilter) Matches(customer Customer) bool {
+	return hasPrefixFold(customer.FirstName, f.FirstName) && hasPrefixFold(customer.LastName, f.LastName) &&
+		(f.Email == "" || strings.EqualFold(strings.TrimSpace(customer.Email), strings.TrimSpace(f.Email)))
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// GeneratedCustomer is a customer made up by a data generator
+type GeneratedCustomer struct {
+	Customer
+	// Invalid names the field the generator has deliberately made invalid, it's empty for valid customers
+	Invalid string `json:"invalid,omitempty"`
+	// DuplicateOf is the position of the generated customer this one duplicates, it's negative for originals
+	DuplicateOf int `json:"duplicateOf"`
 }
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// EventType is a type of a customer domain event
+type EventType string
+
+const (
+	// CustomerCreated occurs when a customer is created
+	CustomerCreated EventType = "CustomerCreated"
+	// CustomerUpdated occurs when a customer is updated
+	CustomerUpdated EventType = "CustomerUpdated"
+	// CustomerDeleted occurs when a customer is deleted
+	CustomerDeleted EventType = "CustomerDeleted"
+	// CustomerRestored occurs when a deleted customer is brought back
+	CustomerRestored EventType = "CustomerRestored"
+	// CustomerErased occurs when personal data of a customer is erased on request. The event carries
+	// the anonymized customer, or no customer at all if it has been deleted for good
+	CustomerErased EventType = "CustomerErased"
+)
+
+// EventPosition is a position of an event in the outbox.
+// Events are ordered by the transaction that emitted them first and by their ID then
+type EventPosition struct {
+	TxID int64
+	ID   int64
+}
+
+// After reports whether the position goes after the given one
+func (p EventPosition) After(other EventPosition) bool {
+	return p.TxID > other.TxID || (p.TxID == other.TxID && p.ID > other.ID)
+}
+
+// Event represents a customer domain event
+type Event struct {
+	ID         int64         `json:"id"`
+	TxID       int64         `json:"-"`
+	Type       EventType     `json:"type"`
+	CustomerID int           `json:"customerId"`
+	Customer   *Customer     `json:"customer,omitempty"`
+	Changes    []FieldChange `json:"changes,omitempty"`
+	OccurredAt time.Time     `json:"occurredAt"`
+}
+
+// Position returns the position of the event in the outbox
+func (e Event) Position() EventPosition {
+	return EventPosition{TxID: e.TxID, ID: e.ID}
+}
+
+// ChangeType is a kind of a change of a customer row
+type ChangeType string
+
+const (
+	// ChangeCreate means a customer has been created
+	ChangeCreate ChangeType = "create"
+	// ChangeUpdate means a customer has been updated
+	ChangeUpdate ChangeType = "update"
+	// ChangeDelete means a customer has been deleted
+	ChangeDelete ChangeType = "delete"
+)
+
+// CustomerChange is a lightweight notification about a committed change of a customer row
+type CustomerChange struct {
+	Type     ChangeType `json:"type"`
+	ID       int        `json:"id"`
+	Revision int        `json:"revision,omitempty"`
+}
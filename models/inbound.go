@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// InboundStatus is an outcome of an inbound webhook delivery
+type InboundStatus string
+
+const (
+	// InboundApplied is a delivery that has been applied to a customer
+	InboundApplied InboundStatus = "applied"
+	// InboundFailed is a verified delivery that couldn't be applied and may be replayed
+	InboundFailed InboundStatus = "failed"
+)
+
+// InboundDelivery is an update of customer data pushed by an upstream system along with its outcome
+type InboundDelivery struct {
+	ID         int64         `json:"id"`
+	ReceivedAt time.Time     `json:"receivedAt"`
+	Signature  string        `json:"signature"`
+	Timestamp  string        `json:"timestamp"`
+	Body       string        `json:"body"`
+	Status     InboundStatus `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	CustomerID int           `json:"customerId,omitempty"`
+	Attempts   int           `json:"attempts"`
+	UpdatedAt  time.Time     `json:"updatedAt"`
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maskedText replaces hidden parts of masked values
+const maskedText = "***"
+
+// houseNumber matches house, flat and postal numbers along with suffixes like 12a or 3/4
+var houseNumber = regexp.MustCompile(`[0-9][0-9A-Za-z/-]*`)
+
+// fieldMasks are masks of customer fields that hold personal data
+var fieldMasks = map[string]func(string) string{
+	"email":   MaskEmail,
+	"address": MaskAddress,
+}
+
+// MaskedFields lists customer fields that are masked for users who may not view personal data
+var MaskedFields = []string{"email", "address"}
+
+// MaskEmail hides all but the first character of the local part of the email, e.g. j***@example.com
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return maskedText
+	}
+	first := []rune(email[:at])[0]
+	return string(first) + maskedText + email[at:]
+}
+
+// MaskAddress hides numbers of the address, e.g. Main St. ***, which leaves the street and the city
+func MaskAddress(address string) string {
+	return houseNumber.ReplaceAllString(address, maskedText)
+}
+
+// Masked returns the customer with personal data masked
+func (c Customer) Masked() Customer {
+	c.Email = MaskEmail(c.Email)
+	c.Address = MaskAddress(c.Address)
+	return c
+}
+
+// Unmask restores fields of the customer that have been masked and left as they are, e.g. in a form
+// a user who may not view personal data has submitted, from the original customer
+func (c Customer) Unmask(original Customer) Customer {
+	if c.Email == MaskEmail(original.Email) {
+		c.Email = original.Email
+	}
+	if c.Address == MaskAddress(original.Address) {
+		c.Address = original.Address
+	}
+	return c
+}
+
+// Masked returns the change with values of personal data fields masked
+func (c FieldChange) Masked() FieldChange {
+	mask, ok := fieldMasks[c.Field]
+	if !ok {
+		return c
+	}
+	if value, ok := c.Old.(string); ok {
+		c.Old = mask(value)
+	}
+	if value, ok := c.New.(string); ok {
+		c.New = mask(value)
+	}
+	return c
+}
+
+// Reveal records that a user has seen personal data of a customer unmasked
+type Reveal struct {
+	ID         int64     `json:"id"`
+	CustomerID int       `json:"customerId"`
+	Actor      string    `json:"actor"`
+	Fields     []string  `json:"fields"`
+	RequestID  string    `json:"requestId,omitempty"`
+	RevealedAt time.Time `json:"revealedAt"`
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy tells how long customers are kept once the relationship with them has ended.
+// A zero period disables its rule
+type RetentionPolicy struct {
+	// PurgeDeletedAfter is the time after which deleted customers are erased for good
+	PurgeDeletedAfter time.Duration
+	// AnonymizeInactiveAfter is the time without changes after which customers are anonymized
+	AnonymizeInactiveAfter time.Duration
+}
+
+// Enabled tells whether any rule of the policy applies
+func (p RetentionPolicy) Enabled() bool {
+	return p.PurgeDeletedAfter > 0 || p.AnonymizeInactiveAfter > 0
+}
+
+// retentionDay and retentionYear are the units of retention periods, a year is 365 days
+const (
+	retentionDay  = 24 * time.Hour
+	retentionYear = 365 * retentionDay
+)
+
+// ParseRetentionPeriod parses a period such as 30d or 5y, any Go duration, or off, which disables a rule
+func ParseRetentionPeriod(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return 0, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": retentionDay, "y": retentionYear} {
+		if number := strings.TrimSuffix(value, suffix); number != value {
+			count, err := strconv.Atoi(number)
+			if err != nil || count <= 0 {
+				return 0, fmt.Errorf("invalid retention period %q", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	period, err := time.ParseDuration(value)
+	if err != nil || period < 0 {
+		return 0, fmt.Errorf("invalid retention period %q: expected e.g. 30d, 5y or off", value)
+	}
+	return period, nil
+}
+
+// FormatRetentionPeriod formats a period in the largest unit it's a whole number of, or as off if it's zero
+func FormatRetentionPeriod(period time.Duration) string {
+	switch {
+	case period == 0:
+		return "off"
+	case period%retentionYear == 0:
+		return fmt.Sprintf("%dy", period/retentionYear)
+	case period%retentionDay == 0:
+		return fmt.Sprintf("%dd", period/retentionDay)
+	}
+	return period.String()
+}
+
+// RetentionRun summarizes a run of retention rules. In a dry run customers are only counted, not changed
+type RetentionRun struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DryRun     bool      `json:"dryRun"`
+	Purged     int       `json:"purged"`
+	Anonymized int       `json:"anonymized"`
+	// PurgedIDs and AnonymizedIDs are the first of the affected customers
+	PurgedIDs     []int `json:"purgedIds,omitempty"`
+	AnonymizedIDs []int `json:"anonymizedIds,omitempty"`
+	// Skipped is the number of customers the rules have stopped applying to between finding and changing them
+	Skipped int `json:"skipped"`
+	// Failed is the number of customers that couldn't be changed, Errors are the first of the reasons
+	Failed int      `json:"failed"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (r RetentionRun) String() string {
+	verb := "purged %v, anonymized %v"
+	if r.DryRun {
+		verb = "dry run: would purge %v, would anonymize %v"
+	}
+	summary := fmt.Sprintf(verb, r.Purged, r.Anonymized)
+	if r.Skipped > 0 {
+		summary += fmt.Sprintf(", %v skipped", r.Skipped)
+	}
+	if r.Failed > 0 {
+		summary += fmt.Sprintf(", %v failed", r.Failed)
+	}
+	return summary + fmt.Sprintf(" in %v", r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// SubjectDataExported is the audit action of handing data of a customer over to the customer.
+// Nothing changes, so it's never emitted as an event
+const SubjectDataExported EventType = "SubjectDataExported"
+
+// ErasureMode tells how personal data of a customer is erased
+type ErasureMode string
+
+const (
+	// ErasureAnonymize replaces personal data of the customer, so that the customer is still counted but can't be identified
+	ErasureAnonymize ErasureMode = "anonymize"
+	// ErasureDelete deletes the customer for good
+	ErasureDelete ErasureMode = "delete"
+)
+
+// ErasureModes lists all known erasure modes
+var ErasureModes = []ErasureMode{ErasureAnonymize, ErasureDelete}
+
+// Valid tells whether the erasure mode is known
+func (m ErasureMode) Valid() bool {
+	return m == ErasureAnonymize || m == ErasureDelete
+}
+
+// ErasedFields are the customer fields an erasure changes
+var ErasedFields = []string{"firstName", "lastName", "birthDate", "email", "address"}
+
+// Anonymized returns the customer with personal data replaced. Only the gender and the year of birth are kept,
+// so that statistics still add up
+func (c Customer) Anonymized() Customer {
+	c.FirstName = "Erased"
+	c.LastName = "Erased"
+	c.BirthDate = time.Date(c.BirthDate.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	c.Email = fmt.Sprintf("erased-%d@erased.invalid", c.ID)
+	c.Address = ""
+	return c
+}
+
+// SubjectData is everything stored about a customer, as handed over on a data subject access request
+type SubjectData struct {
+	CustomerID  int       `json:"customerId"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	GeneratedBy string    `json:"generatedBy"`
+	Note        string    `json:"note"`
+	// Customer is nil if the customer has been deleted for good
+	Customer  *Customer  `json:"customer"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Events are changes of the customer as they have been recorded and sent to other systems
+	Events            []Event           `json:"events"`
+	WebhookDeliveries []WebhookDelivery `json:"webhookDeliveries"`
+	InboundDeliveries []InboundDelivery `json:"inboundDeliveries"`
+	// Reveals, Accesses and Audit tell who has seen or changed the customer
+	Reveals  []Reveal     `json:"reveals"`
+	Accesses []Access     `json:"accesses"`
+	Audit    []AuditEntry `json:"audit"`
+}
+
+// Empty tells whether nothing is stored about the customer
+func (d SubjectData) Empty() bool {
+	return d.Customer == nil && len(d.Events) == 0 && len(d.WebhookDeliveries) == 0 && len(d.InboundDeliveries) == 0 &&
+		len(d.Reveals) == 0 && len(d.Accesses) == 0 && len(d.Audit) == 0
+}
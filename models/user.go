@@ -0,0 +1,134 @@
+package models
+
+import "time"
+
+// Role defines what a user is allowed to do with customers
+type Role string
+
+const (
+	// RoleIntern can only view customers, with their personal data masked
+	RoleIntern Role = "intern"
+	// RoleSupport can view, create and edit customers, and reveal personal data of a customer
+	RoleSupport Role = "support"
+	// RoleAdmin can do anything, including deleting and generating customers
+	RoleAdmin Role = "admin"
+)
+
+// Roles lists all known roles from the least to the most privileged
+var Roles = []Role{RoleIntern, RoleSupport, RoleAdmin}
+
+// Permission is a right to perform a certain kind of operations
+type Permission string
+
+const (
+	// ReadCustomers allows to read customers and their changes
+	ReadCustomers Permission = "customers:read"
+	// WriteCustomers allows to create and update customers
+	WriteCustomers Permission = "customers:write"
+	// DeleteCustomers allows to delete customers
+	DeleteCustomers Permission = "customers:delete"
+	// ViewPII allows to see personal data of customers, such as emails and addresses, unmasked
+	ViewPII Permission = "customers:pii"
+	// RevealPII allows to unmask personal data of a single customer, which is recorded
+	RevealPII Permission = "customers:reveal"
+	// HandleSubjectRequests allows to hand all data of a customer over and to erase it on the customer's request
+	HandleSubjectRequests Permission = "customers:dsar"
+	// GenerateCustomers allows to spawn random customers
+	GenerateCustomers Permission = "customers:generate"
+	// ExportCustomers allows to export customers in bulk
+	ExportCustomers Permission = "export"
+	// ManageIntegrations allows to manage webhooks and inbound deliveries
+	ManageIntegrations Permission = "integrations:manage"
+	// ManageAPIKeys allows to create and revoke API keys
+	ManageAPIKeys Permission = "apikeys:manage"
+	// ManageSettings allows to change security settings of the application
+	ManageSettings Permission = "settings:manage"
+	// ReadAccessLog allows to see who has read which customers
+	ReadAccessLog Permission = "audit:read"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleIntern:  {ReadCustomers},
+	RoleSupport: {ReadCustomers, WriteCustomers, RevealPII},
+	RoleAdmin:   {ReadCustomers, WriteCustomers, ViewPII, RevealPII, DeleteCustomers, HandleSubjectRequests, GenerateCustomers, ExportCustomers, ManageIntegrations, ManageAPIKeys, ManageSettings, ReadAccessLog},
+}
+
+// Valid tells whether the role is known
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can tells whether the role grants the given permission
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// User is a staff member who works with customers through the web UI
+type User struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Role         Role   `json:"role"`
+	PasswordHash string `json:"-"`
+	// TOTPSecret is a base32 encoded secret of the user's authenticator, empty if the user hasn't enrolled
+	TOTPSecret string `json:"-"`
+	// TOTPCounter is the time step of the last accepted code, which prevents codes from being reused
+	TOTPCounter int64 `json:"-"`
+	// ExternalID identifies the user at the identity provider the user logs in through, empty for local users
+	ExternalID string    `json:"externalId,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Can tells whether the user's role grants the given permission
+func (u User) Can(permission Permission) bool {
+	return u.Role.Can(permission)
+}
+
+// External tells whether the user logs in through an identity provider rather than with a password
+func (u User) External() bool {
+	return u.ExternalID != ""
+}
+
+// TwoFactorEnabled tells whether the user has enrolled an authenticator
+func (u User) TwoFactorEnabled() bool {
+	return u.TOTPSecret != ""
+}
+
+// Session is a server-side session of a logged in user.
+// ID is a hash of the session token, so the token itself never hits the database.
+// A pending session belongs to a user who has entered the password but not the second factor yet
+type Session struct {
+	ID         string
+	UserID     int
+	Pending    bool
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	// TOTPSecret is the secret the user of the session is enrolling, kept server-side until the enrollment completes
+	TOTPSecret string
+}
+
+// FlashKind tells how a flash message is presented
+type FlashKind string
+
+const (
+	// FlashSuccess reports that an action has succeeded
+	FlashSuccess FlashKind = "success"
+	// FlashInfo is a neutral notice
+	FlashInfo FlashKind = "info"
+	// FlashWarning reports something that needs attention
+	FlashWarning FlashKind = "warning"
+)
+
+// Flash is a one-time message shown on the next page of a session, typically after a form post has redirected.
+// It may offer an action, e.g. to undo what has been done, which is posted to the Action path
+type Flash struct {
+	Kind        FlashKind `json:"kind"`
+	Message     string    `json:"message"`
+	Action      string    `json:"action,omitempty"`
+	ActionLabel string    `json:"actionLabel,omitempty"`
+}
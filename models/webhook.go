@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// Webhook is a subscription of an external URL to customer events
+type Webhook struct {
+	ID         int                `json:"id"`
+	URL        string             `json:"url"`
+	Secret     string             `json:"-"`
+	EventTypes []EventType        `json:"eventTypes"`
+	Filter     CustomerListFilter `json:"filter"`
+	Active     bool               `json:"active"`
+	CreatedAt  time.Time          `json:"createdAt"`
+}
+
+// Accepts reports whether the event should be delivered to the webhook
+func (w Webhook) Accepts(event Event) bool {
+	if !w.Active {
+		return false
+	}
+	var typeOK bool
+	for _, eventType := range w.EventTypes {
+		typeOK = typeOK || eventType == event.Type
+	}
+	if !typeOK {
+		return false
+	}
+	if event.Customer == nil {
+		return w.Filter == CustomerListFilter{}
+	}
+	return w.Filter.Matches(*event.Customer)
+}
+
+// DeliveryStatus is a state of a webhook delivery
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is a delivery that hasn't been attempted yet
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliveryFailed is a delivery that failed and is going to be retried
+	DeliveryFailed DeliveryStatus = "failed"
+	// DeliverySucceeded is a delivery that has been accepted by the receiver
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryDead is a delivery that failed too many times and won't be retried automatically
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// WebhookDelivery is a delivery of an event to a webhook along with the result of its last attempt
+type WebhookDelivery struct {
+	ID              int64          `json:"id"`
+	WebhookID       int            `json:"webhookId"`
+	EventID         int64          `json:"eventId"`
+	EventType       EventType      `json:"eventType"`
+	CustomerID      int            `json:"customerId"`
+	Payload         string         `json:"payload"`
+	Status          DeliveryStatus `json:"status"`
+	Attempts        int            `json:"attempts"`
+	NextAttemptAt   time.Time      `json:"nextAttemptAt"`
+	RequestHeaders  string         `json:"requestHeaders"`
+	ResponseStatus  int            `json:"responseStatus"`
+	ResponseHeaders string         `json:"responseHeaders"`
+	ResponseBody    string         `json:"responseBody"`
+	Error           string         `json:"error"`
+	CreatedAt       time.Time      `json:"createdAt"`
+	UpdatedAt       time.Time      `json:"updatedAt"`
+}
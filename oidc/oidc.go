@@ -0,0 +1,204 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE for logging users in
+// through an external identity provider
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultScopes are the scopes requested if the config has none
+var DefaultScopes = []string{"openid", "profile", "email", "groups"}
+
+// Config describes the client registration at an identity provider
+type Config struct {
+	// Issuer is the URL of the identity provider, which serves /.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL of the application registered at the provider
+	RedirectURL string
+	Scopes      []string
+	// GroupsClaim is the name of the ID token claim that lists groups of the user, "groups" by default
+	GroupsClaim string
+}
+
+// Client performs the authorization code flow against the identity provider described by the config
+type Client struct {
+	config     Config
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewClient creates a client for the given config. The provider is discovered on the first use
+func NewClient(config Config, httpClient *http.Client) *Client {
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	config.Issuer = strings.TrimRight(config.Issuer, "/")
+	return &Client{
+		config:     config,
+		httpClient: httpClient,
+	}
+}
+
+// Issuer returns the identifier of the identity provider
+func (c *Client) Issuer() string {
+	return c.config.Issuer
+}
+
+// AuthRequest holds the values that bind an authorization request to its callback.
+// It should be kept by the user agent until the callback, e.g. in a cookie
+type AuthRequest struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// NewAuthRequest generates random state, nonce and PKCE verifier for a new authorization request
+func NewAuthRequest() (AuthRequest, error) {
+	var request AuthRequest
+	for _, value := range []*string{&request.State, &request.Nonce, &request.Verifier} {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return AuthRequest{}, err
+		}
+		*value = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return request, nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge of the given verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL of the provider to send the user to in order to log in
+func (c *Client) AuthCodeURL(ctx context.Context, request AuthRequest) (string, error) {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", c.config.ClientID)
+	query.Set("redirect_uri", c.config.RedirectURL)
+	query.Set("scope", strings.Join(c.config.Scopes, " "))
+	query.Set("state", request.State)
+	query.Set("nonce", request.Nonce)
+	query.Set("code_challenge", CodeChallenge(request.Verifier))
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code the provider has passed to the callback and returns the verified claims of the ID token
+func (c *Client) Exchange(ctx context.Context, request AuthRequest, state, code string) (Claims, error) {
+	if state == "" || state != request.State {
+		return Claims{}, fmt.Errorf("state mismatch")
+	}
+	if code == "" {
+		return Claims{}, fmt.Errorf("no authorization code")
+	}
+	d, err := c.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.config.RedirectURL)
+	form.Set("client_id", c.config.ClientID)
+	form.Set("code_verifier", request.Verifier)
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := c.doJSON(req.WithContext(ctx), &response); err != nil {
+		return Claims{}, errors.Wrapf(err, "exchange authorization code")
+	}
+	if response.Error != "" {
+		return Claims{}, fmt.Errorf("exchange authorization code: %s %s", response.Error, response.ErrorDescription)
+	}
+	if response.IDToken == "" {
+		return Claims{}, fmt.Errorf("exchange authorization code: no id token in response")
+	}
+	return c.Verify(ctx, response.IDToken, request.Nonce)
+}
+
+func (c *Client) discover(ctx context.Context) (*discovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, c.config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var d discovery
+	if err := c.doJSON(req.WithContext(ctx), &d); err != nil {
+		return nil, errors.Wrapf(err, "discover provider %s", c.config.Issuer)
+	}
+	if strings.TrimRight(d.Issuer, "/") != c.config.Issuer {
+		return nil, fmt.Errorf("discover provider %s: it claims to be %s", c.config.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("discover provider %s: incomplete configuration", c.config.Issuer)
+	}
+	c.discovery = &d
+	return c.discovery, nil
+}
+
+func (c *Client) doJSON(req *http.Request, value interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		return errors.Wrapf(err, "decode response")
+	}
+	return nil
+}
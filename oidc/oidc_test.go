@@ -0,0 +1,318 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/oidc"
+)
+
+const (
+	clientID     = "customers"
+	clientSecret = "client secret"
+	redirectURL  = "https://customers.example.com/login/oidc/callback"
+)
+
+func TestLogin(t *testing.T) {
+	idp := newMockIdP(t)
+	client := idp.client()
+
+	request, claims, err := login(idp, client)
+	require.NoError(t, err)
+	require.Equal(t, "user-1", claims.Subject)
+	require.Equal(t, idp.server.URL, claims.Issuer)
+	require.Equal(t, "jane", claims.Username())
+	require.Equal(t, []string{"staff", "support"}, claims.Groups)
+
+	// an authorization code is redeemed only once
+	_, err = client.Exchange(context.Background(), request, request.State, idp.lastCode)
+	require.Error(t, err)
+}
+
+func TestLoginRequiresVerifierAndState(t *testing.T) {
+	idp := newMockIdP(t)
+	client := idp.client()
+	ctx := context.Background()
+
+	request, code, state := authorize(t, idp, client)
+	_, err := client.Exchange(ctx, request, "forged", code)
+	require.Error(t, err, "state must match the request")
+
+	stolen := request
+	stolen.Verifier = "intercepted code without the verifier"
+	_, err = client.Exchange(ctx, stolen, state, code)
+	require.Error(t, err, "the provider must reject a wrong verifier")
+
+	request, code, state = authorize(t, idp, client)
+	forged := request
+	forged.Nonce = "another nonce"
+	_, err = client.Exchange(ctx, forged, state, code)
+	require.Error(t, err, "nonce must match the request")
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newMockIdP(t)
+	client := idp.client()
+	ctx := context.Background()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	valid, err := client.Verify(ctx, idp.sign(idp.key, idp.keyID, "RS256", idp.claims("nonce")), "nonce")
+	require.NoError(t, err)
+	require.Equal(t, "user-1", valid.Subject)
+
+	for name, token := range map[string]string{
+		"wrong audience": idp.sign(idp.key, idp.keyID, "RS256", with(idp.claims("nonce"), "aud", "another client")),
+		"wrong issuer":   idp.sign(idp.key, idp.keyID, "RS256", with(idp.claims("nonce"), "iss", "https://evil.example.com")),
+		"expired":        idp.sign(idp.key, idp.keyID, "RS256", with(idp.claims("nonce"), "exp", time.Now().Add(-time.Hour).Unix())),
+		"not yet valid":  idp.sign(idp.key, idp.keyID, "RS256", with(idp.claims("nonce"), "nbf", time.Now().Add(time.Hour).Unix())),
+		"wrong nonce":    idp.sign(idp.key, idp.keyID, "RS256", idp.claims("another nonce")),
+		"foreign key":    idp.sign(otherKey, idp.keyID, "RS256", idp.claims("nonce")),
+		"unknown key":    idp.sign(otherKey, "unknown", "RS256", idp.claims("nonce")),
+		"unsigned":       idp.sign(idp.key, idp.keyID, "none", idp.claims("nonce")),
+		"other party":    idp.sign(idp.key, idp.keyID, "RS256", with(idp.claims("nonce"), "aud", []string{clientID, "another client"})),
+		"malformed":      "not a token",
+	} {
+		_, err := client.Verify(ctx, token, "nonce")
+		require.Error(t, err, name)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	client := idp.client()
+	_, _, err := login(idp, client)
+	require.NoError(t, err)
+
+	idp.rotateKey(t)
+	_, _, err = login(idp, client)
+	require.NoError(t, err, "keys unknown to the client must be fetched again")
+}
+
+func TestGroupRoles(t *testing.T) {
+	roles, err := oidc.ParseGroupRoles("staff=intern, support=support,admins=admin")
+	require.NoError(t, err)
+
+	role, ok := roles.RoleFor([]string{"staff", "support"})
+	require.True(t, ok)
+	require.Equal(t, models.RoleSupport, role, "the most privileged role wins")
+	_, ok = roles.RoleFor([]string{"guests"})
+	require.False(t, ok)
+
+	roles, err = oidc.ParseGroupRoles("*=intern,admins=admin")
+	require.NoError(t, err)
+	role, ok = roles.RoleFor(nil)
+	require.True(t, ok)
+	require.Equal(t, models.RoleIntern, role)
+
+	_, err = oidc.ParseGroupRoles("admins=root")
+	require.Error(t, err)
+	_, err = oidc.ParseGroupRoles("admins")
+	require.Error(t, err)
+}
+
+// login goes through the whole authorization code flow
+func login(idp *mockIdP, client *oidc.Client) (oidc.AuthRequest, oidc.Claims, error) {
+	request, code, state, err := idp.authorize(client)
+	if err != nil {
+		return request, oidc.Claims{}, err
+	}
+	claims, err := client.Exchange(context.Background(), request, state, code)
+	return request, claims, err
+}
+
+func authorize(t *testing.T, idp *mockIdP, client *oidc.Client) (oidc.AuthRequest, string, string) {
+	request, code, state, err := idp.authorize(client)
+	require.NoError(t, err)
+	return request, code, state
+}
+
+// mockIdP is a minimal OpenID Connect provider that logs in a fixed user without asking
+type mockIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	keyID    string
+	lastCode string
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{codes: make(map[string]url.Values)}
+	idp.rotateKey(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/authorize", idp.authorizeEndpoint)
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) client() *oidc.Client {
+	return oidc.NewClient(oidc.Config{
+		Issuer:       idp.server.URL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+	}, idp.server.Client())
+}
+
+func (idp *mockIdP) rotateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.key, idp.keyID = key, fmt.Sprintf("key-%d", time.Now().UnixNano())
+}
+
+// authorize follows the client to the authorization endpoint and returns the code and the state it is redirected back with
+func (idp *mockIdP) authorize(client *oidc.Client) (request oidc.AuthRequest, code, state string, err error) {
+	if request, err = oidc.NewAuthRequest(); err != nil {
+		return
+	}
+	authURL, err := client.AuthCodeURL(context.Background(), request)
+	if err != nil {
+		return
+	}
+	browser := idp.server.Client()
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := browser.Get(authURL)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return request, "", "", fmt.Errorf("authorization failed: %s", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return
+	}
+	code, state = location.Query().Get("code"), location.Query().Get("state")
+	idp.lastCode = code
+	return
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 idp.server.URL,
+		"authorization_endpoint": idp.server.URL + "/authorize",
+		"token_endpoint":         idp.server.URL + "/token",
+		"jwks_uri":               idp.server.URL + "/jwks",
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": idp.keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *mockIdP) authorizeEndpoint(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != clientID || query.Get("redirect_uri") != redirectURL ||
+		query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	idp.mu.Lock()
+	idp.codes[code] = query
+	idp.mu.Unlock()
+	http.Redirect(w, r, redirectURL+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != clientID || secret != url.QueryEscape(clientSecret) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	idp.mu.Lock()
+	authorization, ok := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	idp.mu.Unlock()
+	if !ok || r.FormValue("grant_type") != "authorization_code" || r.FormValue("redirect_uri") != authorization.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.Get("code_challenge") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code verifier mismatch"})
+		return
+	}
+	idp.mu.Lock()
+	key, keyID := idp.key, idp.keyID
+	idp.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": "access token",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign(key, keyID, "RS256", idp.claims(authorization.Get("nonce"))),
+	})
+}
+
+func (idp *mockIdP) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                idp.server.URL,
+		"sub":                "user-1",
+		"aud":                clientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"email":              "jane@example.com",
+		"preferred_username": "jane",
+		"groups":             []string{"staff", "support"},
+	}
+}
+
+func with(claims map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	claims[name] = value
+	return claims
+}
+
+func (idp *mockIdP) sign(key *rsa.PrivateKey, keyID, algorithm string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
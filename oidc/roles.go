@@ -0,0 +1,56 @@
+package oidc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/havr/customers/models"
+)
+
+// AnyGroup is the group every user of the identity provider belongs to
+const AnyGroup = "*"
+
+// GroupRoles maps groups of the identity provider to application roles
+type GroupRoles map[string]models.Role
+
+// ParseGroupRoles parses a comma separated list of group=role pairs
+func ParseGroupRoles(spec string) (GroupRoles, error) {
+	roles := make(GroupRoles)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		separator := strings.LastIndex(pair, "=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("invalid group mapping %q, expected group=role", pair)
+		}
+		group, role := strings.TrimSpace(pair[:separator]), models.Role(strings.TrimSpace(pair[separator+1:]))
+		if !role.Valid() {
+			return nil, fmt.Errorf("unknown role %q for group %s", role, group)
+		}
+		roles[group] = role
+	}
+	return roles, nil
+}
+
+// RoleFor returns the most privileged role granted by any of the groups.
+// It returns false if none of the groups is mapped to a role
+func (g GroupRoles) RoleFor(groups []string) (models.Role, bool) {
+	best := -1
+	for _, group := range append([]string{AnyGroup}, groups...) {
+		role, ok := g[group]
+		if !ok {
+			continue
+		}
+		for rank, known := range models.Roles {
+			if known == role && rank > best {
+				best = rank
+			}
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	return models.Roles[best], true
+}
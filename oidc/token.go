@@ -0,0 +1,241 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// clockSkew is how far the clocks of the application and the provider may drift apart
+const clockSkew = time.Minute
+
+// Claims are the verified claims of an ID token the application relies on
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	PreferredUsername string
+	Name              string
+	Groups            []string
+}
+
+// Username returns the name to register a user under: the preferred username, the email or the subject, whichever is set
+func (c Claims) Username() string {
+	switch {
+	case c.PreferredUsername != "":
+		return c.PreferredUsername
+	case c.Email != "":
+		return c.Email
+	}
+	return c.Subject
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type tokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	NotBefore         int64    `json:"nbf"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+}
+
+// audience is either a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, item := range a {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature of the ID token against the provider's keys, its issuer, audience, lifetime and nonce,
+// and returns its claims
+func (c *Client) Verify(ctx context.Context, rawToken, nonce string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("malformed id token")
+	}
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, errors.Wrapf(err, "decode id token header")
+	}
+	if header.Algorithm != "RS256" {
+		return Claims{}, fmt.Errorf("unsupported id token algorithm %q", header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, errors.Wrapf(err, "decode id token signature")
+	}
+	key, err := c.key(ctx, header.KeyID)
+	if err != nil {
+		return Claims{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return Claims{}, fmt.Errorf("invalid id token signature")
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, errors.Wrapf(err, "decode id token claims")
+	}
+	now := time.Now()
+	switch {
+	case strings.TrimRight(claims.Issuer, "/") != c.config.Issuer:
+		return Claims{}, fmt.Errorf("id token issued by %s", claims.Issuer)
+	case !claims.Audience.contains(c.config.ClientID):
+		return Claims{}, fmt.Errorf("id token is not issued for this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.config.ClientID:
+		return Claims{}, fmt.Errorf("id token is authorized for another party")
+	case claims.Subject == "":
+		return Claims{}, fmt.Errorf("id token has no subject")
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return Claims{}, fmt.Errorf("id token expired")
+	case claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return Claims{}, fmt.Errorf("id token is not valid yet")
+	case claims.Nonce != nonce:
+		return Claims{}, fmt.Errorf("id token nonce mismatch")
+	}
+
+	groups, err := c.groups(parts[1])
+	if err != nil {
+		return Claims{}, err
+	}
+	return Claims{
+		Issuer:            c.config.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+		Groups:            groups,
+	}, nil
+}
+
+// groups extracts the configured groups claim, which is either a list or a single string
+func (c *Client) groups(segment string) ([]string, error) {
+	var raw map[string]json.RawMessage
+	if err := decodeSegment(segment, &raw); err != nil {
+		return nil, errors.Wrapf(err, "decode id token claims")
+	}
+	value, ok := raw[c.config.GroupsClaim]
+	if !ok {
+		return nil, nil
+	}
+	var groups audience
+	if err := json.Unmarshal(value, &groups); err != nil {
+		return nil, errors.Wrapf(err, "decode %s claim", c.config.GroupsClaim)
+	}
+	return groups, nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// key returns the signing key with the given ID. Keys are cached, an unknown ID makes the client
+// refetch the key set, so keys rotated by the provider are picked up
+func (c *Client) key(ctx context.Context, id string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[id]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	d, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := c.doJSON(req.WithContext(ctx), &set); err != nil {
+		return nil, errors.Wrapf(err, "fetch provider keys")
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		publicKey, err := jwk.rsaKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse provider key %s", jwk.KeyID)
+		}
+		keys[jwk.KeyID] = publicKey
+	}
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	if key, ok := keys[id]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown id token signing key %q", id)
+}
+
+func (k jsonWebKey) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("exponent is too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
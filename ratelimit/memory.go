@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in memory, so every instance of the application limits clients on its own
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+}
+
+// NewMemoryStore creates a store without buckets
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*Bucket),
+	}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &Bucket{}
+		s.buckets[key] = bucket
+	}
+	return bucket.Take(limit, now), nil
+}
+
+// Purge implements Store
+func (s *MemoryStore) Purge(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, bucket := range s.buckets {
+		if bucket.UpdatedAt.Before(before) {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Package ratelimit limits how often clients may make requests with token buckets.
+// Every client has a bucket per group of routes, which holds up to Limit.Requests tokens and is refilled evenly
+// over Limit.Period. A request takes a token and is rejected if there is none left
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Group is a class of routes that share a budget
+type Group string
+
+const (
+	// Read is the group of requests that only read data
+	Read Group = "read"
+	// Write is the group of requests that change data
+	Write Group = "write"
+	// Generate is the group of requests that spawn random customers
+	Generate Group = "generate"
+	// Auth is the group of login requests, which are limited tightly against password guessing
+	Auth Group = "auth"
+	// Client is the group of all requests from an IP address, which is checked before authentication
+	// against guessing of API keys and session tokens
+	Client Group = "client"
+)
+
+// Groups lists all known groups
+var Groups = []Group{Read, Write, Generate, Auth, Client}
+
+// Limit allows a number of requests per period, which may be made all at once
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (l Limit) String() string {
+	return strconv.Itoa(l.Requests) + "/" + l.Period.String()
+}
+
+// rate returns how many tokens are added per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// ParseLimit parses a limit of the form <requests>/<period>, e.g. 10/1m
+func ParseLimit(spec string) (Limit, error) {
+	separator := strings.Index(spec, "/")
+	if separator <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected <requests>/<period>", spec)
+	}
+	requests, err := strconv.Atoi(spec[:separator])
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid number of requests in limit %q", spec)
+	}
+	period, err := time.ParseDuration(spec[separator+1:])
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("invalid period in limit %q", spec)
+	}
+	return Limit{Requests: requests, Period: period}, nil
+}
+
+// Limits are limits of route groups. Groups without a limit aren't limited
+type Limits map[Group]Limit
+
+// DefaultLimits are limits that are used unless configured otherwise
+var DefaultLimits = Limits{
+	Read:     {Requests: 600, Period: time.Minute},
+	Write:    {Requests: 120, Period: time.Minute},
+	Generate: {Requests: 10, Period: time.Minute},
+	Auth:     {Requests: 10, Period: time.Minute},
+	Client:   {Requests: 1200, Period: time.Minute},
+}
+
+// ParseLimits parses a comma separated list of group=limit pairs that override DefaultLimits,
+// e.g. "auth=5/1m,generate=1/10s". A group is not limited at all if its limit is "off"
+func ParseLimits(spec string) (Limits, error) {
+	limits := make(Limits)
+	for group, limit := range DefaultLimits {
+		limits[group] = limit
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		separator := strings.Index(pair, "=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("invalid group limit %q, expected group=limit", pair)
+		}
+		group, value := Group(strings.TrimSpace(pair[:separator])), strings.TrimSpace(pair[separator+1:])
+		if _, ok := DefaultLimits[group]; !ok {
+			return nil, fmt.Errorf("unknown route group %q: expected one of %v", group, Groups)
+		}
+		if value == "off" {
+			delete(limits, group)
+			continue
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits[group] = limit
+	}
+	return limits, nil
+}
+
+// Bucket is the state of a token bucket. The zero bucket is full
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Take refills the bucket for the time that has passed since it was updated and takes a token out of it.
+// It returns 0 if there has been a token, or how long it takes for one to appear otherwise
+func (b *Bucket) Take(limit Limit, now time.Time) time.Duration {
+	capacity := float64(limit.Requests)
+	if b.UpdatedAt.IsZero() {
+		b.Tokens = capacity
+	} else if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+elapsed.Seconds()*limit.rate())
+	}
+	b.UpdatedAt = now
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.Tokens) * float64(limit.Period) / float64(limit.Requests)))
+}
+
+// Store keeps buckets of clients
+type Store interface {
+	// Take takes a token out of the bucket with the given key, see Bucket.Take
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (time.Duration, error)
+	// Purge deletes buckets that haven't been used since the given time
+	Purge(ctx context.Context, before time.Time) error
+}
+
+// Limiter decides whether clients may make requests
+type Limiter struct {
+	store  Store
+	limits Limits
+	Now    func() time.Time
+}
+
+// NewLimiter creates a limiter that keeps buckets in the given store
+func NewLimiter(store Store, limits Limits) *Limiter {
+	return &Limiter{
+		store:  store,
+		limits: limits,
+		Now:    time.Now,
+	}
+}
+
+// Allow takes a token of the client for the group. It returns 0 if the request is allowed,
+// or how long the client should wait before retrying
+func (l *Limiter) Allow(ctx context.Context, group Group, client string) (time.Duration, error) {
+	limit, ok := l.limits[group]
+	if !ok {
+		return 0, nil
+	}
+	return l.store.Take(ctx, string(group)+":"+client, limit, l.Now())
+}
+
+// Purge deletes buckets that have been refilled completely, since they are no different from missing ones
+func (l *Limiter) Purge(ctx context.Context) error {
+	var longest time.Duration
+	for _, limit := range l.limits {
+		if limit.Period > longest {
+			longest = limit.Period
+		}
+	}
+	return l.store.Purge(ctx, l.Now().Add(-longest))
+}
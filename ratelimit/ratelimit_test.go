@@ -0,0 +1,78 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/ratelimit"
+)
+
+func TestBucket(t *testing.T) {
+	limit := ratelimit.Limit{Requests: 2, Period: time.Minute}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var bucket ratelimit.Bucket
+
+	require.Zero(t, bucket.Take(limit, now))
+	require.Zero(t, bucket.Take(limit, now))
+	require.Equal(t, 30*time.Second, bucket.Take(limit, now))
+	require.InDelta(t, float64(10*time.Second), float64(bucket.Take(limit, now.Add(20*time.Second))), float64(time.Millisecond))
+	require.Zero(t, bucket.Take(limit, now.Add(30*time.Second)))
+
+	// an idle bucket doesn't grow beyond its capacity
+	later := now.Add(time.Hour)
+	require.Zero(t, bucket.Take(limit, later))
+	require.Zero(t, bucket.Take(limit, later))
+	require.NotZero(t, bucket.Take(limit, later))
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ratelimit.ParseLimits(" auth=5/1m, generate=off,read=1000/1h ")
+	require.NoError(t, err)
+	require.Equal(t, ratelimit.Limits{
+		ratelimit.Auth:   {Requests: 5, Period: time.Minute},
+		ratelimit.Read:   {Requests: 1000, Period: time.Hour},
+		ratelimit.Write:  ratelimit.DefaultLimits[ratelimit.Write],
+		ratelimit.Client: ratelimit.DefaultLimits[ratelimit.Client],
+	}, limits)
+
+	limits, err = ratelimit.ParseLimits("")
+	require.NoError(t, err)
+	require.Equal(t, ratelimit.DefaultLimits, limits)
+
+	for _, spec := range []string{"auth", "search=1/1m", "auth=0/1m", "auth=5", "auth=5/soon", "auth=5/-1m"} {
+		_, err := ratelimit.ParseLimits(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := ratelimit.NewMemoryStore()
+	limiter := ratelimit.NewLimiter(store, ratelimit.Limits{ratelimit.Generate: {Requests: 1, Period: time.Minute}})
+	limiter.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	wait, err := limiter.Allow(ctx, ratelimit.Generate, "user:1")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+	wait, err = limiter.Allow(ctx, ratelimit.Generate, "user:1")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, wait)
+
+	// clients and groups have buckets of their own, groups without a limit aren't limited
+	wait, _ = limiter.Allow(ctx, ratelimit.Generate, "user:2")
+	require.Zero(t, wait)
+	for i := 0; i < 10; i++ {
+		wait, _ = limiter.Allow(ctx, ratelimit.Read, "user:1")
+		require.Zero(t, wait)
+	}
+
+	// purged buckets are full again
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, limiter.Purge(ctx))
+	wait, _ = store.Take(ctx, "generate:user:1", ratelimit.Limit{Requests: 1, Period: time.Hour}, now)
+	require.Zero(t, wait)
+}
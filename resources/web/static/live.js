@@ -0,0 +1,61 @@
+// Live customer updates streamed by the server.
+// The list page highlights rows changed by other operators,
+// the edit page warns the editor before they submit a stale revision.
+(function () {
+    if (!window.EventSource) {
+        return;
+    }
+
+    function listPage(table, change) {
+        var row = table.querySelector('tr[data-id="' + change.id + '"]');
+        if (change.type === 'create') {
+            document.getElementById('live-notice').classList.remove('hidden');
+            return;
+        }
+        if (!row) {
+            return;
+        }
+        row.classList.remove('live-updated', 'live-deleted');
+        row.classList.add(change.type === 'delete' ? 'live-deleted' : 'live-updated');
+    }
+
+    function editPage(form, change) {
+        if (String(change.id) !== form.dataset.customerId) {
+            return;
+        }
+        var revision = form.querySelector('input[name="revision"]').value;
+        if (change.type === 'update' && String(change.revision) === revision) {
+            return;
+        }
+        form.dataset.stale = change.type;
+        var warning = document.getElementById('stale-warning');
+        warning.textContent = change.type === 'delete'
+            ? 'Somebody has deleted this customer.'
+            : 'Somebody has updated this customer. Reload the page to see their changes before editing.';
+        warning.classList.remove('hidden');
+    }
+
+    document.addEventListener('DOMContentLoaded', function () {
+        var table = document.getElementById('customers');
+        var form = document.getElementById('customer-form');
+        if (!table && !(form && form.dataset.customerId)) {
+            return;
+        }
+        if (form) {
+            form.addEventListener('submit', function (event) {
+                if (form.dataset.stale && !window.confirm('The customer has been changed since you opened it. Submit anyway?')) {
+                    event.preventDefault();
+                }
+            });
+        }
+        var source = new EventSource('/ui/customer/changes');
+        source.addEventListener('change', function (event) {
+            var change = JSON.parse(event.data);
+            if (table) {
+                listPage(table, change);
+            } else {
+                editPage(form, change);
+            }
+        });
+    });
+})();
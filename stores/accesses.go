@@ -0,0 +1,112 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+// AccessTable is the name for table that logs reads of customer data
+const AccessTable = "customer_accesses"
+
+// NewAccessStore creates new access store for the given database connection
+func NewAccessStore(db *sql.DB) AccessStore {
+	return &accessStore{
+		db: db,
+	}
+}
+
+type accessStore struct {
+	db *sql.DB
+}
+
+// RecordAccesses saves the given accesses with a single statement
+func (s *accessStore) RecordAccesses(ctx context.Context, accesses []models.Access) error {
+	if len(accesses) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(accesses))
+	args := make([]interface{}, 0, 5*len(accesses))
+	for _, access := range accesses {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+		args = append(args, access.CustomerID, access.Actor, string(access.Purpose), access.RequestID, access.AccessedAt.UTC())
+	}
+	query := "INSERT INTO " + AccessTable + "(customer_id, actor, purpose, request_id, accessed_at) VALUES " + strings.Join(values, ", ")
+	if _, err := conn(ctx, s.db).ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrapf(err, "record %v accesses", len(accesses))
+	}
+	return nil
+}
+
+// ListAccesses returns accesses that match the filter, the latest first
+func (s *accessStore) ListAccesses(ctx context.Context, filter models.AccessFilter, offset, limit int) ([]models.Access, error) {
+	conditions, args := accessConditions(filter)
+	args = append(args, offset, limit)
+	return s.listAccesses(ctx, conditions, fmt.Sprintf("ORDER BY accessed_at DESC, id DESC OFFSET $%d LIMIT $%d", len(args)-1, len(args)), args)
+}
+
+// ListAccessesAfter returns accesses that match the filter and go after the given ID, in the order of IDs.
+// Unlike an offset, the ID a batch ends with isn't shifted by accesses recorded meanwhile
+func (s *accessStore) ListAccessesAfter(ctx context.Context, filter models.AccessFilter, afterID int64, limit int) ([]models.Access, error) {
+	conditions, args := accessConditions(filter)
+	args = append(args, afterID)
+	conditions = append(conditions, fmt.Sprintf("id > $%d", len(args)))
+	args = append(args, limit)
+	return s.listAccesses(ctx, conditions, fmt.Sprintf("ORDER BY id LIMIT $%d", len(args)), args)
+}
+
+// accessConditions formats query conditions that correspond the given filter along with their query args
+func accessConditions(filter models.AccessFilter) (conditions []string, args []interface{}) {
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.CustomerID != 0 {
+		where("customer_id = $%d", filter.CustomerID)
+	}
+	if filter.Actor != "" {
+		where("actor = $%d", filter.Actor)
+	}
+	if filter.Purpose != "" {
+		where("purpose = $%d", string(filter.Purpose))
+	}
+	if !filter.Since.IsZero() {
+		where("accessed_at >= $%d", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where("accessed_at < $%d", filter.Until.UTC())
+	}
+	return conditions, args
+}
+
+// listAccesses reads accesses that satisfy the conditions, the rest of the query orders and limits them
+func (s *accessStore) listAccesses(ctx context.Context, conditions []string, rest string, args []interface{}) ([]models.Access, error) {
+	query := "SELECT id, customer_id, actor, purpose, request_id, accessed_at FROM " + AccessTable
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " " + rest
+	rows, err := conn(ctx, s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list accesses")
+	}
+	defer rows.Close()
+	var result []models.Access
+	for rows.Next() {
+		var access models.Access
+		var purpose string
+		if err := rows.Scan(&access.ID, &access.CustomerID, &access.Actor, &purpose, &access.RequestID, &access.AccessedAt); err != nil {
+			return nil, errors.Wrapf(err, "list accesses")
+		}
+		access.Purpose = models.AccessPurpose(purpose)
+		access.AccessedAt = access.AccessedAt.UTC()
+		result = append(result, access)
+	}
+	return result, errors.Wrapf(rows.Err(), "list accesses")
+}
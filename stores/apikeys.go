@@ -0,0 +1,135 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+// APIKeyTable is the name for table that contains API keys
+const APIKeyTable = "api_keys"
+
+var selectAPIKeyExpr = `SELECT id, name, prefix, hash, scopes, allowed_ips, expires_at, last_used_at, revoked_at, created_by, created_at FROM ` + APIKeyTable
+
+// NewAPIKeyStore creates new API key store for the given database connection
+func NewAPIKeyStore(db *sql.DB) APIKeyStore {
+	return &apiKeyStore{
+		db: db,
+	}
+}
+
+type apiKeyStore struct {
+	db *sql.DB
+}
+
+// CreateAPIKey creates the given key and returns it with ID set
+func (s *apiKeyStore) CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	query := "INSERT INTO " + APIKeyTable + `(name, prefix, hash, scopes, allowed_ips, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	allowedIPs := key.AllowedIPs
+	if allowedIPs == nil {
+		allowedIPs = []string{}
+	}
+	row := conn(ctx, s.db).QueryRowContext(ctx, query, key.Name, key.Prefix, key.Hash, pq.Array(permissionStrings(key.Scopes)),
+		pq.Array(allowedIPs), nullTime(key.ExpiresAt), key.CreatedBy)
+	result := key
+	if err := row.Scan(&result.ID, &result.CreatedAt); err != nil {
+		return models.APIKey{}, errors.Wrapf(err, "create api key")
+	}
+	result.CreatedAt = result.CreatedAt.UTC()
+	return result, nil
+}
+
+// ListAPIKeys returns all API keys including revoked ones
+func (s *apiKeyStore) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := conn(ctx, s.db).QueryContext(ctx, selectAPIKeyExpr+" ORDER BY id")
+	if err != nil {
+		return nil, errors.Wrapf(err, "query api keys")
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := s.scanAPIKey(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read api key from database")
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetAPIKeyByHash returns an API key by the hash of its value
+func (s *apiKeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	key, err := s.scanAPIKey(conn(ctx, s.db).QueryRowContext(ctx, selectAPIKeyExpr+" WHERE hash = $1", hash))
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return models.APIKey{}, errors.Wrapf(err, "get api key")
+	}
+	return key, nil
+}
+
+// RevokeAPIKey marks the given key as revoked at the given time, unless it has been already revoked
+func (s *apiKeyStore) RevokeAPIKey(ctx context.Context, id int, at time.Time) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, "UPDATE "+APIKeyTable+" SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", at.UTC(), id)
+	if err != nil {
+		return errors.Wrapf(err, "revoke api key %v", id)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.Wrapf(err, "revoke api key %v", id)
+	} else if affected == 0 {
+		return errors.Wrapf(ErrNotFound, "revoke api key %v", id)
+	}
+	return nil
+}
+
+// TouchAPIKey sets the time the key has been used last
+func (s *apiKeyStore) TouchAPIKey(ctx context.Context, id int, at time.Time) error {
+	_, err := conn(ctx, s.db).ExecContext(ctx, "UPDATE "+APIKeyTable+" SET last_used_at = $1 WHERE id = $2", at.UTC(), id)
+	return err
+}
+
+func (s *apiKeyStore) scanAPIKey(scanner rowScanner) (key models.APIKey, _ error) {
+	var scopes []string
+	var expiresAt, lastUsedAt, revokedAt pq.NullTime
+	if err := scanner.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, pq.Array(&scopes), pq.Array(&key.AllowedIPs),
+		&expiresAt, &lastUsedAt, &revokedAt, &key.CreatedBy, &key.CreatedAt); err != nil {
+		return models.APIKey{}, err
+	}
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, models.Permission(scope))
+	}
+	key.ExpiresAt, key.LastUsedAt, key.RevokedAt = timePtr(expiresAt), timePtr(lastUsedAt), timePtr(revokedAt)
+	key.CreatedAt = key.CreatedAt.UTC()
+	return
+}
+
+func permissionStrings(permissions []models.Permission) []string {
+	strs := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		strs = append(strs, string(permission))
+	}
+	return strs
+}
+
+func nullTime(t *time.Time) pq.NullTime {
+	if t == nil {
+		return pq.NullTime{}
+	}
+	return pq.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func timePtr(t pq.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
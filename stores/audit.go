@@ -0,0 +1,141 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+const (
+	// AuditTable is the name for table that contains the audit trail, which only accepts inserts
+	AuditTable = "audit_log"
+	// CheckpointTable is the name for table that contains signed checkpoints of the audit trail
+	CheckpointTable = "audit_checkpoints"
+
+	// auditLock is the key of the advisory lock that serializes appends, so that the chain never forks
+	auditLock = 46046
+)
+
+var selectAuditExpr = `SELECT id, action, customer_id, actor, request_id, fields, note, created_at, prev_hash, hash FROM ` + AuditTable
+
+// NewAuditStore creates new audit store for the given database connection
+func NewAuditStore(db *sql.DB) AuditStore {
+	return &auditStore{
+		db: db,
+		tx: NewTransactor(db),
+	}
+}
+
+type auditStore struct {
+	db *sql.DB
+	tx Transactor
+}
+
+// AppendAudit chains the entry to the last one and saves it. It returns the entry with ID, time and hashes set.
+// Appends are serialized until the end of the transaction, so the entry should be appended
+// as late in the transaction as possible
+func (s *auditStore) AppendAudit(ctx context.Context, entry models.AuditEntry) (result models.AuditEntry, _ error) {
+	err := s.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, s.db)
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditLock); err != nil {
+			return err
+		}
+		last, err := s.LastAudit(ctx)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, "SELECT nextval('"+AuditTable+"_id_seq')").Scan(&entry.ID); err != nil {
+			return err
+		}
+		// Postgres keeps microseconds, and the hash must match the time that is read back
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.PrevHash = last.Hash
+		entry.Hash = entry.ComputeHash()
+		query := "INSERT INTO " + AuditTable + `(id, action, customer_id, actor, request_id, fields, note, created_at, prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+		_, err = tx.ExecContext(ctx, query, entry.ID, string(entry.Action), entry.CustomerID, entry.Actor, entry.RequestID,
+			pq.Array(entry.Fields), entry.Note, entry.CreatedAt, entry.PrevHash, entry.Hash)
+		result = entry
+		return err
+	})
+	if err != nil {
+		return models.AuditEntry{}, errors.Wrapf(err, "append audit entry")
+	}
+	return result, nil
+}
+
+// ListAudit returns entries that follow the given ID in the order of the chain
+func (s *auditStore) ListAudit(ctx context.Context, afterID int64, limit int) ([]models.AuditEntry, error) {
+	rows, err := conn(ctx, s.db).QueryContext(ctx, selectAuditExpr+" WHERE id > $1 ORDER BY id LIMIT $2", afterID, limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list audit entries")
+	}
+	defer rows.Close()
+	var result []models.AuditEntry
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "list audit entries")
+		}
+		result = append(result, entry)
+	}
+	return result, errors.Wrapf(rows.Err(), "list audit entries")
+}
+
+// LastAudit returns the last entry of the chain, or ErrNotFound if the chain is empty
+func (s *auditStore) LastAudit(ctx context.Context) (models.AuditEntry, error) {
+	entry, err := scanAuditEntry(conn(ctx, s.db).QueryRowContext(ctx, selectAuditExpr+" ORDER BY id DESC LIMIT 1"))
+	if err == sql.ErrNoRows {
+		return models.AuditEntry{}, ErrNotFound
+	} else if err != nil {
+		return models.AuditEntry{}, errors.Wrapf(err, "get last audit entry")
+	}
+	return entry, nil
+}
+
+func scanAuditEntry(scanner rowScanner) (models.AuditEntry, error) {
+	var entry models.AuditEntry
+	var action string
+	if err := scanner.Scan(&entry.ID, &action, &entry.CustomerID, &entry.Actor, &entry.RequestID, pq.Array(&entry.Fields), &entry.Note,
+		&entry.CreatedAt, &entry.PrevHash, &entry.Hash); err != nil {
+		return models.AuditEntry{}, err
+	}
+	entry.Action = models.EventType(action)
+	entry.CreatedAt = entry.CreatedAt.UTC()
+	return entry, nil
+}
+
+// SaveCheckpoint saves the given checkpoint and returns it with ID set
+func (s *auditStore) SaveCheckpoint(ctx context.Context, c models.AuditCheckpoint) (models.AuditCheckpoint, error) {
+	query := "INSERT INTO " + CheckpointTable + `(entry_id, hash, created_at, public_key, signature) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	row := conn(ctx, s.db).QueryRowContext(ctx, query, c.EntryID, c.Hash, c.CreatedAt.UTC(), c.PublicKey, c.Signature)
+	if err := row.Scan(&c.ID); err != nil {
+		return models.AuditCheckpoint{}, errors.Wrapf(err, "save audit checkpoint")
+	}
+	return c, nil
+}
+
+// ListCheckpoints returns all checkpoints, the oldest first
+func (s *auditStore) ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error) {
+	query := "SELECT id, entry_id, hash, created_at, public_key, signature FROM " + CheckpointTable + " ORDER BY id"
+	rows, err := conn(ctx, s.db).QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list audit checkpoints")
+	}
+	defer rows.Close()
+	var result []models.AuditCheckpoint
+	for rows.Next() {
+		var c models.AuditCheckpoint
+		if err := rows.Scan(&c.ID, &c.EntryID, &c.Hash, &c.CreatedAt, &c.PublicKey, &c.Signature); err != nil {
+			return nil, errors.Wrapf(err, "list audit checkpoints")
+		}
+		c.CreatedAt = c.CreatedAt.UTC()
+		result = append(result, c)
+	}
+	return result, errors.Wrapf(rows.Err(), "list audit checkpoints")
+}
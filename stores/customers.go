@@ -10,6 +10,8 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/encryption"
 	"github.com/havr/customers/models"
 )
 
@@ -23,28 +25,76 @@ var selectExpr = func() []string {
 	return []string{`SELECT id, xmin, lastname, firstname, birthdate, gender, email, address FROM ` + CustomerTable}
 }
 
-// ErrChanged occurs when one tries to update an object that has been modified since initial read
-var ErrChanged = fmt.Errorf("the object has been changed")
+// EncryptableCustomerFields are the customer fields that may be encrypted.
+// Names can't be, since customers are filtered and ordered by them
+var EncryptableCustomerFields = []string{"email", "address"}
+
+var (
+	// ErrNotFound occurs when the requested object doesn't exist
+	ErrNotFound = apperr.New(apperr.NotFound, "not found")
+	// ErrChanged occurs when one tries to update an object that has been modified since initial read
+	ErrChanged = apperr.New(apperr.Conflict, "the object has been changed")
+)
+
+// CustomerStoreOption configures optional features of a customer store
+type CustomerStoreOption func(c *customerStore)
+
+// WithFieldEncryption encrypts the given fields, which must be among EncryptableCustomerFields, with the cipher.
+// Customers also get a blind index of their email, which finds them by exact email without decrypting it.
+// Values that have been stored before are read as they are until RotateCustomerKeys encrypts them
+func WithFieldEncryption(cipher *encryption.Cipher, fields ...string) CustomerStoreOption {
+	return func(c *customerStore) {
+		c.cipher = cipher
+		for _, field := range fields {
+			c.encrypted[strings.ToLower(field)] = true
+		}
+	}
+}
 
 // NewCustomerStore creates new customer store for the given database connection
-func NewCustomerStore(db *sql.DB) CustomerStore {
-	return &customerStore{
-		db: db,
+func NewCustomerStore(db *sql.DB, options ...CustomerStoreOption) CustomerStore {
+	store := &customerStore{
+		db:        db,
+		tx:        NewTransactor(db),
+		encrypted: make(map[string]bool),
+	}
+	for _, option := range options {
+		option(store)
 	}
+	return store
 }
 
 // CustomerStore represents SQL persistence layer for customers
 type customerStore struct {
-	db *sql.DB
+	db        *sql.DB
+	tx        Transactor
+	cipher    *encryption.Cipher
+	encrypted map[string]bool
 }
 
 // CreateCustomer creates the given customer entry and returns the entry with ID and revision set
 func (c *customerStore) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
-	query := "INSERT INTO " + CustomerTable + `(lastname, firstname, birthdate, gender, email, address) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, xmin`
-	row := c.db.QueryRowContext(ctx, query, customer.LastName, customer.FirstName, time.Time(customer.BirthDate).UTC(), string(customer.Gender), customer.Email, customer.Address)
 	result := customer
-	if err := row.Scan(&result.ID, &result.Revision); err != nil {
-		return models.Customer{}, errors.Wrapf(err, "create customer")
+	err := c.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, c.db)
+		// the ID is taken ahead of the insert, since encrypted fields are bound to their row
+		query := "SELECT nextval(pg_get_serial_sequence('" + CustomerTable + "', 'id'))"
+		if err := tx.QueryRowContext(ctx, query).Scan(&result.ID); err != nil {
+			return errors.Wrapf(err, "create customer")
+		}
+		email, address, emailIndex, err := c.sealFields(ctx, result)
+		if err != nil {
+			return errors.Wrapf(err, "create customer")
+		}
+		query = "INSERT INTO " + CustomerTable + `(id, lastname, firstname, birthdate, gender, email, address, email_index) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING xmin`
+		row := tx.QueryRowContext(ctx, query, result.ID, customer.LastName, customer.FirstName, time.Time(customer.BirthDate).UTC(), string(customer.Gender), email, address, emailIndex)
+		if err := row.Scan(&result.Revision); err != nil {
+			return errors.Wrapf(err, "create customer")
+		}
+		return notifyChange(ctx, tx, models.CustomerChange{Type: models.ChangeCreate, ID: result.ID, Revision: result.Revision})
+	})
+	if err != nil {
+		return models.Customer{}, err
 	}
 	return result, nil
 }
@@ -54,7 +104,7 @@ func (c *customerStore) CountCustomers(ctx context.Context, filter CustomerListF
 	var count int
 	where, args := c.filterWhere(filter, nil)
 	query := "SELECT COUNT(*) FROM " + CustomerTable + " " + where
-	if err := c.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+	if err := conn(ctx, c.db).QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
 		return 0, errors.Wrapf(err, "count customers")
 	}
 	return count, nil
@@ -63,7 +113,7 @@ func (c *customerStore) CountCustomers(ctx context.Context, filter CustomerListF
 // filterWhere formats a WHERE query part that corresponds the given filter and appends values to filter in query args
 func (c *customerStore) filterWhere(filter CustomerListFilter, args []interface{}) (string, []interface{}) {
 	resultArgs := args
-	var whereConditions []string
+	whereConditions := []string{"deleted_at IS NULL"}
 	if filter.FirstName != "" {
 		resultArgs = append(resultArgs, filter.FirstName+"%")
 		whereConditions = append(whereConditions, fmt.Sprintf("firstName ILIKE $%d", len(resultArgs)))
@@ -72,8 +122,12 @@ func (c *customerStore) filterWhere(filter CustomerListFilter, args []interface{
 		resultArgs = append(resultArgs, filter.LastName+"%")
 		whereConditions = append(whereConditions, fmt.Sprintf("lastName ILIKE $%d", len(resultArgs)))
 	}
-	if len(whereConditions) == 0 {
-		return "", args
+	if filter.Email != "" && c.cipher != nil {
+		resultArgs = append(resultArgs, c.cipher.BlindIndex(filter.Email))
+		whereConditions = append(whereConditions, fmt.Sprintf("email_index = $%d", len(resultArgs)))
+	} else if filter.Email != "" {
+		resultArgs = append(resultArgs, strings.TrimSpace(filter.Email))
+		whereConditions = append(whereConditions, fmt.Sprintf("lower(email) = lower($%d)", len(resultArgs)))
 	}
 	return "WHERE " + strings.Join(whereConditions, " AND "), resultArgs
 }
@@ -89,12 +143,19 @@ func (c *customerStore) ListCustomers(ctx context.Context, filter CustomerListFi
 	if !ok && options.OrderBy != "" {
 		return nil, fmt.Errorf("unknown field to order by: %q", options.OrderBy)
 	}
+	if c.encrypted[strings.ToLower(options.OrderBy)] {
+		return nil, apperr.New(apperr.Validation, "customers can't be ordered by %s, since it's encrypted", options.OrderBy)
+	}
 
 	queryStr := selectExpr()
 	where, args := c.filterWhere(filter, nil)
+	if options.AfterID != 0 {
+		args = append(args, options.AfterID)
+		where += fmt.Sprintf(" AND id > $%d", len(args))
+	}
 	queryStr = append(queryStr, where)
 	queryStr = append(queryStr, c.viewOptionsQuery(options)...)
-	rows, err := c.db.QueryContext(ctx, strings.Join(queryStr, " "), args...)
+	rows, err := conn(ctx, c.db).QueryContext(ctx, strings.Join(queryStr, " "), args...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "query customer list")
 	}
@@ -124,6 +185,8 @@ func (c *customerStore) orderQuery(orderBy string, orderDesc bool) string {
 func (c *customerStore) viewOptionsQuery(options CustomerViewOptions) (queryStr []string) {
 	if options.OrderBy != "" {
 		queryStr = append(queryStr, c.orderQuery(options.OrderBy, options.OrderDesc))
+	} else {
+		queryStr = append(queryStr, "ORDER BY ID ASC")
 	}
 	if options.Offset != 0 {
 		queryStr = append(queryStr, "OFFSET "+strconv.Itoa(options.Offset))
@@ -135,47 +198,75 @@ func (c *customerStore) viewOptionsQuery(options CustomerViewOptions) (queryStr
 }
 
 // UpdateCustomer updates replaces a customer model with the given one based on its ID
-func (c *customerStore) UpdateCustomer(ctx context.Context, customer models.Customer) (gerr error) {
-	tx, err := c.db.BeginTx(ctx, nil)
+func (c *customerStore) UpdateCustomer(ctx context.Context, customer models.Customer) error {
+	email, address, emailIndex, err := c.sealFields(ctx, customer)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "update customer %v", customer.ID)
 	}
-	defer func() {
-		if gerr != nil {
-			tx.Rollback()
-		} else if err := tx.Commit(); err != nil {
-			gerr = err
+	return c.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, c.db)
+		row := tx.QueryRowContext(ctx, "SELECT xmin FROM "+CustomerTable+" WHERE id = $1 AND deleted_at IS NULL", customer.ID)
+		var revision int
+		if serr := row.Scan(&revision); serr == sql.ErrNoRows {
+			return ErrNotFound
+		} else if serr != nil {
+			return serr
+		}
+		if revision != customer.Revision {
+			return ErrChanged
 		}
-	}()
-	row := tx.QueryRowContext(ctx, "SELECT xmin FROM "+CustomerTable+" WHERE id = $1", customer.ID)
-	var revision int
-	if serr := row.Scan(&revision); serr != nil {
-		return serr
-	}
-	if revision != customer.Revision {
-		return ErrChanged
-	}
 
-	query := "UPDATE " + CustomerTable + ` SET lastname = $1, firstname = $2, birthdate = $3, gender = $4, email = $5, address = $6 WHERE id = $7`
-	_, err = tx.ExecContext(ctx, query, customer.LastName, customer.FirstName, time.Time(customer.BirthDate), string(customer.Gender), customer.Email, customer.Address, customer.ID)
-	if err != nil {
-		return errors.Wrapf(err, "update customer %v", customer.ID)
-	}
-	return nil
+		query := "UPDATE " + CustomerTable + ` SET lastname = $1, firstname = $2, birthdate = $3, gender = $4, email = $5, address = $6, email_index = $7,
+			updated_at = (now() AT TIME ZONE 'utc') WHERE id = $8 RETURNING xmin`
+		row = tx.QueryRowContext(ctx, query, customer.LastName, customer.FirstName, time.Time(customer.BirthDate), string(customer.Gender), email, address, emailIndex, customer.ID)
+		if err := row.Scan(&revision); err != nil {
+			return errors.Wrapf(err, "update customer %v", customer.ID)
+		}
+		return notifyChange(ctx, tx, models.CustomerChange{Type: models.ChangeUpdate, ID: customer.ID, Revision: revision})
+	})
 }
 
-// DeleteCustomer deletes a customer by its ID
+// DeleteCustomer deletes a customer by its ID. The customer is only marked as deleted, so it may be restored
 func (c *customerStore) DeleteCustomer(ctx context.Context, id int) error {
-	_, err := c.db.ExecContext(ctx, "DELETE FROM "+CustomerTable+" WHERE id = $1", id)
-	return err
+	return c.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, c.db)
+		query := "UPDATE " + CustomerTable + " SET deleted_at = (now() AT TIME ZONE 'utc') WHERE id = $1 AND deleted_at IS NULL"
+		result, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		if deleted, err := result.RowsAffected(); err != nil {
+			return err
+		} else if deleted == 0 {
+			return ErrNotFound
+		}
+		return notifyChange(ctx, tx, models.CustomerChange{Type: models.ChangeDelete, ID: id})
+	})
+}
+
+// RestoreCustomer brings back a deleted customer by its ID
+func (c *customerStore) RestoreCustomer(ctx context.Context, id int) error {
+	return c.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, c.db)
+		query := "UPDATE " + CustomerTable + " SET deleted_at = NULL, updated_at = (now() AT TIME ZONE 'utc') WHERE id = $1 AND deleted_at IS NOT NULL RETURNING xmin"
+		var revision int
+		err := tx.QueryRowContext(ctx, query, id).Scan(&revision)
+		if err == sql.ErrNoRows {
+			err = ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrapf(err, "restore customer %v", id)
+		}
+		return notifyChange(ctx, tx, models.CustomerChange{Type: models.ChangeCreate, ID: id, Revision: revision})
+	})
 }
 
 // GetCustomer returns a customer by its ID
 func (c *customerStore) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
-	query := append(selectExpr(), "WHERE id = $1")
-	customer, err := c.scanRow(ctx, c.db.QueryRowContext(ctx, strings.Join(query, " "), id))
+	query := append(selectExpr(), "WHERE id = $1 AND deleted_at IS NULL")
+	customer, err := c.scanRow(ctx, conn(ctx, c.db).QueryRowContext(ctx, strings.Join(query, " "), id))
 	if err == sql.ErrNoRows {
-		err = fmt.Errorf("not found")
+		err = ErrNotFound
 	}
 	if err != nil {
 		return models.Customer{}, errors.Wrapf(err, "get customer %v", id)
@@ -189,9 +280,166 @@ func (c *customerStore) scanRow(ctx context.Context, scanner rowScanner) (result
 		return models.Customer{}, err
 	}
 	result.BirthDate = result.BirthDate.UTC()
+	var err error
+	if result.Email, err = c.openField(ctx, result.ID, "email", result.Email); err != nil {
+		return models.Customer{}, err
+	}
+	if result.Address, err = c.openField(ctx, result.ID, "address", result.Address); err != nil {
+		return models.Customer{}, err
+	}
 	return
 }
 
+// sealFields returns the email and the address as they are stored, along with the blind index of the email,
+// which is NULL without encryption. The customer must have its ID, which encrypted fields are bound to
+func (c *customerStore) sealFields(ctx context.Context, customer models.Customer) (email, address string, emailIndex sql.NullString, err error) {
+	if email, err = c.sealField(ctx, customer.ID, "email", customer.Email); err != nil {
+		return
+	}
+	if address, err = c.sealField(ctx, customer.ID, "address", customer.Address); err != nil {
+		return
+	}
+	if c.cipher != nil {
+		emailIndex = sql.NullString{String: c.cipher.BlindIndex(customer.Email), Valid: true}
+	}
+	return
+}
+
+func (c *customerStore) sealField(ctx context.Context, id int, field, value string) (string, error) {
+	if !c.encrypted[field] {
+		return value, nil
+	}
+	return c.cipher.Encrypt(ctx, value, fieldLabel(id, field))
+}
+
+// fieldLabel is the label values of the field of the customer with the given ID are encrypted with.
+// It binds them to their row, so that an encrypted value copied to another customer can't be decrypted
+func fieldLabel(id int, field string) string {
+	return fmt.Sprintf("%s.%s.%d", CustomerTable, field, id)
+}
+
+// legacyFieldLabel is the label values have been encrypted with before they were bound to their row
+func legacyFieldLabel(field string) string {
+	return CustomerTable + "." + field
+}
+
+// sealer encrypts copies of the fields the store encrypts
+func (c *customerStore) sealer() fieldSealer {
+	return fieldSealer{cipher: c.cipher, encrypted: c.encrypted}
+}
+
+// openField decrypts a stored value, even if the field is no longer configured to be encrypted
+func (c *customerStore) openField(ctx context.Context, id int, field, value string) (string, error) {
+	plain, _, err := c.open(ctx, id, field, value)
+	return plain, err
+}
+
+// open decrypts a stored value of the customer with the given ID. Values encrypted before they were bound to their row
+// are still read, and reported as legacy, until rotation encrypts them anew
+func (c *customerStore) open(ctx context.Context, id int, field, value string) (plain string, legacy bool, _ error) {
+	if c.cipher == nil {
+		if encryption.IsEncrypted(value) {
+			return "", false, fmt.Errorf("customer %s is encrypted, but no keys are configured", field)
+		}
+		return value, false, nil
+	}
+	plain, err := c.cipher.Decrypt(ctx, value, fieldLabel(id, field))
+	if err == nil || !encryption.IsEncrypted(value) {
+		return plain, false, err
+	}
+	if plain, legacyErr := c.cipher.Decrypt(ctx, value, legacyFieldLabel(field)); legacyErr == nil {
+		return plain, true, nil
+	}
+	return "", false, err
+}
+
+// RotateCustomerKeys re-encrypts a batch of customers, deleted ones included, that follow the given ID.
+// Values of encrypted fields are encrypted with the current key and bound to their row unless they already are, values of fields
+// that are no longer encrypted are decrypted, and blind indexes are filled in.
+// Rows are locked only while their batch is rotated, so the application keeps working.
+// It returns the ID to continue after, which is 0 when there are no customers left, and the number of rewritten ones
+func (c *customerStore) RotateCustomerKeys(ctx context.Context, afterID, limit int) (lastID, rotated int, err error) {
+	if c.cipher == nil {
+		return 0, 0, fmt.Errorf("no encryption keys are configured")
+	}
+	type storedCustomer struct {
+		id             int
+		email, address string
+		emailIndex     sql.NullString
+	}
+	err = c.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, c.db)
+		query := "SELECT id, email, address, email_index FROM " + CustomerTable + " WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE"
+		rows, err := tx.QueryContext(ctx, query, afterID, limit)
+		if err != nil {
+			return err
+		}
+		var batch []storedCustomer
+		for rows.Next() {
+			var stored storedCustomer
+			if err := rows.Scan(&stored.id, &stored.email, &stored.address, &stored.emailIndex); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, stored)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, stored := range batch {
+			lastID = stored.id
+			customer := models.Customer{ID: stored.id}
+			var legacyEmail, legacyAddress bool
+			if customer.Email, legacyEmail, err = c.open(ctx, stored.id, "email", stored.email); err != nil {
+				return errors.Wrapf(err, "customer %v", stored.id)
+			}
+			if customer.Address, legacyAddress, err = c.open(ctx, stored.id, "address", stored.address); err != nil {
+				return errors.Wrapf(err, "customer %v", stored.id)
+			}
+			email, address, emailIndex := stored.email, stored.address, c.cipher.BlindIndex(customer.Email)
+			if legacyEmail || c.needsRotation("email", email) {
+				if email, err = c.sealField(ctx, stored.id, "email", customer.Email); err != nil {
+					return err
+				}
+				if !c.encrypted["email"] {
+					email = customer.Email
+				}
+			}
+			if legacyAddress || c.needsRotation("address", address) {
+				if address, err = c.sealField(ctx, stored.id, "address", customer.Address); err != nil {
+					return err
+				}
+				if !c.encrypted["address"] {
+					address = customer.Address
+				}
+			}
+			if email == stored.email && address == stored.address && stored.emailIndex.String == emailIndex {
+				continue
+			}
+			update := "UPDATE " + CustomerTable + " SET email = $2, address = $3, email_index = $4 WHERE id = $1"
+			if _, err := tx.ExecContext(ctx, update, stored.id, email, address, emailIndex); err != nil {
+				return err
+			}
+			rotated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "rotate customer keys after %v", afterID)
+	}
+	return lastID, rotated, nil
+}
+
+// needsRotation tells whether the stored value of the field differs from how it should be stored
+func (c *customerStore) needsRotation(field, stored string) bool {
+	if c.encrypted[field] {
+		return !c.cipher.IsCurrent(stored)
+	}
+	return encryption.IsEncrypted(stored)
+}
+
 type rowScanner interface {
 	Scan(...interface{}) error
 }
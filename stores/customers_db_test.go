@@ -29,8 +29,10 @@ func TestCustomers(t *testing.T) {
 		"listAndCount":      tListAndCount,
 		"listAndSort":       tListAndSort,
 		"listAndPagination": tListAndPagination,
+		"listAfterID":       tListAfterID,
 		"get":               tGet,
 		"delete":            tDelete,
+		"restore":           tRestore,
 		"update":            tUpdate,
 		"count":             tCount,
 		"filterAndCount":    tFilterAndCount,
@@ -96,6 +98,23 @@ func tDelete(t *testing.T, store stores.CustomerStore) {
 	require.Len(t, list, 0)
 }
 
+func tRestore(t *testing.T, store stores.CustomerStore) {
+	ctx := context.Background()
+	customers := spawnCustomers(t, ctx, store, 10)
+	for customer := range customers {
+		require.NoError(t, store.DeleteCustomer(ctx, customer.ID))
+		require.NoError(t, store.RestoreCustomer(ctx, customer.ID))
+		require.Error(t, store.RestoreCustomer(ctx, customer.ID), "only deleted customers can be restored")
+		restored, err := store.GetCustomer(ctx, customer.ID)
+		require.NoError(t, err)
+		restored.Revision = customer.Revision
+		require.Equal(t, customer, restored)
+	}
+	count, err := store.CountCustomers(ctx, stores.CustomerListFilter{})
+	require.NoError(t, err)
+	require.Equal(t, len(customers), count)
+}
+
 func tGet(t *testing.T, store stores.CustomerStore) {
 	ctx := context.Background()
 	customers := spawnCustomers(t, ctx, store, 100)
@@ -290,3 +309,19 @@ func tListAndPagination(t *testing.T, store stores.CustomerStore) {
 	require.NoError(t, err)
 	require.Equal(t, customers[testOffset:testOffset+testLimit], result)
 }
+
+func tListAfterID(t *testing.T, store stores.CustomerStore) {
+	ctx := context.Background()
+	var customers []models.Customer
+	for customer := range spawnCustomers(t, ctx, store, 20) {
+		customers = append(customers, customer)
+	}
+	sort.Slice(customers, func(i, j int) bool { return customers[i].ID < customers[j].ID })
+	require.NoError(t, store.DeleteCustomer(ctx, customers[0].ID))
+	result, err := store.ListCustomers(ctx, stores.CustomerListFilter{}, stores.CustomerViewOptions{
+		AfterID: customers[4].ID,
+		Limit:   10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, customers[5:15], result, "customers deleted before the ID don't shift the rest")
+}
@@ -0,0 +1,167 @@
+package stores_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/encryption"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+func testCipher(t *testing.T, current string) *encryption.Cipher {
+	kms, err := encryption.NewLocalKMS(current, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	})
+	require.NoError(t, err)
+	cipher, err := encryption.NewCipher(kms, bytes.Repeat([]byte{9}, 32))
+	require.NoError(t, err)
+	return cipher
+}
+
+func TestEncryptedCustomers(t *testing.T) {
+	dbUrl := os.Getenv("TEST_DB")
+	if dbUrl == "" {
+		t.Skip("no test database provided")
+	}
+	ctx := context.Background()
+	parsed, err := url.Parse(dbUrl)
+	require.NoError(t, err)
+	dbName := fmt.Sprintf("test%v", time.Now().Nanosecond())
+	parsed.Path = dbName
+	db, err := stores.PrepareDB(ctx, parsed.String())
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+		if err := stores.DropDB(ctx, dbUrl, dbName); err != nil {
+			fmt.Println("drop db:", err)
+		}
+	}()
+
+	// customers created before encryption has been enabled are readable, and rotation encrypts them
+	plainCustomers := spawnCustomers(t, ctx, stores.NewCustomerStore(db), 10)
+	store := stores.NewCustomerStore(db, stores.WithFieldEncryption(testCipher(t, "k1"), "email", "address"))
+	customers := spawnCustomers(t, ctx, store, 10)
+	for customer := range plainCustomers {
+		customers[customer] = true
+	}
+	for customer := range customers {
+		stored, err := store.GetCustomer(ctx, customer.ID)
+		require.NoError(t, err)
+		require.Equal(t, customer, stored)
+	}
+
+	rotated := stores.NewCustomerStore(db, stores.WithFieldEncryption(testCipher(t, "k2"), "email", "address"))
+	var total int
+	for lastID := 0; ; {
+		next, n, err := rotated.(stores.CustomerKeyRotator).RotateCustomerKeys(ctx, lastID, 3)
+		require.NoError(t, err)
+		if next == 0 {
+			break
+		}
+		lastID, total = next, total+n
+	}
+	require.Equal(t, len(customers), total)
+
+	var email, address string
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT email, address FROM customers LIMIT 1").Scan(&email, &address))
+	require.Equal(t, "k2", encryption.KeyID(email))
+	require.Equal(t, "k2", encryption.KeyID(address))
+
+	for customer := range customers {
+		stored, err := rotated.GetCustomer(ctx, customer.ID)
+		require.NoError(t, err)
+		require.Equal(t, customer, stored)
+
+		found, err := rotated.ListCustomers(ctx, stores.CustomerListFilter{Email: strings.ToUpper(customer.Email)}, stores.CustomerViewOptions{})
+		require.NoError(t, err)
+		require.Contains(t, found, stored)
+	}
+
+	// values are bound to their row, so one copied to another customer can't be read
+	var ids []int
+	for customer := range customers {
+		ids = append(ids, customer.ID)
+	}
+	_, err = db.ExecContext(ctx, "UPDATE customers SET email = (SELECT email FROM customers WHERE id = $1) WHERE id = $2", ids[0], ids[1])
+	require.NoError(t, err)
+	_, err = rotated.GetCustomer(ctx, ids[1])
+	require.Error(t, err, "an encrypted value copied from another customer is refused")
+
+	// values encrypted before they were bound to their row are read, and rotation binds them
+	legacy, err := testCipher(t, "k2").Encrypt(ctx, "legacy@example.com", "customers.email")
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "UPDATE customers SET email = $1 WHERE id = $2", legacy, ids[1])
+	require.NoError(t, err)
+	stored, err := rotated.GetCustomer(ctx, ids[1])
+	require.NoError(t, err)
+	require.Equal(t, "legacy@example.com", stored.Email)
+	_, n, err := rotated.(stores.CustomerKeyRotator).RotateCustomerKeys(ctx, ids[1]-1, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT email FROM customers WHERE id = $1", ids[1]).Scan(&email))
+	require.NotEqual(t, legacy, email)
+
+	_, err = rotated.ListCustomers(ctx, stores.CustomerListFilter{}, stores.CustomerViewOptions{OrderBy: "email"})
+	require.Error(t, err, "customers can't be ordered by encrypted fields")
+	_, err = stores.NewCustomerStore(db).ListCustomers(ctx, stores.CustomerListFilter{}, stores.CustomerViewOptions{})
+	require.Error(t, err, "encrypted customers can't be read without keys")
+}
+
+func TestEncryptedPayloads(t *testing.T) {
+	db, drop := prepareTestDB(t)
+	defer drop()
+	ctx := context.Background()
+	cipher := testCipher(t, "k1")
+	store := stores.NewCustomerStore(db, stores.WithFieldEncryption(cipher, "email", "address"))
+	outbox := stores.NewOutboxStore(db, stores.WithPayloadEncryption(cipher, "email", "address"))
+	webhooks := stores.NewWebhookStore(db, stores.WithPayloadEncryption(cipher, "email", "address"))
+
+	customer, err := store.CreateCustomer(ctx, generateCustomers(t, 1)[0])
+	require.NoError(t, err)
+	changes := []models.FieldChange{{Field: "email", Old: "old@example.com", New: customer.Email}}
+	event, err := outbox.AppendEvent(ctx, models.Event{Type: models.CustomerUpdated, CustomerID: customer.ID, Customer: &customer, Changes: changes})
+	require.NoError(t, err)
+	webhook, err := webhooks.CreateWebhook(ctx, models.Webhook{URL: "https://example.com", Secret: "secret", EventTypes: []models.EventType{models.CustomerUpdated}})
+	require.NoError(t, err)
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+	require.NoError(t, webhooks.CreateDelivery(ctx, models.WebhookDelivery{WebhookID: webhook.ID, EventID: event.ID, EventType: event.Type,
+		CustomerID: customer.ID, Payload: string(payload), Status: models.DeliveryPending, NextAttemptAt: time.Now()}))
+
+	for _, query := range []string{
+		"SELECT COUNT(*) FROM outbox WHERE payload::text LIKE '%' || $1 || '%'",
+		"SELECT COUNT(*) FROM webhook_deliveries WHERE payload LIKE '%' || $1 || '%'",
+	} {
+		for _, value := range []string{customer.Email, customer.Address, "old@example.com"} {
+			var count int
+			require.NoError(t, db.QueryRowContext(ctx, query, value).Scan(&count))
+			require.Zero(t, count, "%q is stored in plaintext by %s", value, query)
+		}
+	}
+
+	events, err := outbox.ListEvents(ctx, models.EventPosition{}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, customer, *events[0].Customer)
+	require.Equal(t, changes, events[0].Changes)
+	deliveries, err := webhooks.ListDeliveries(ctx, webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, string(payload), deliveries[0].Payload)
+
+	data, err := store.(stores.SubjectStore).CollectSubjectData(ctx, customer.ID)
+	require.NoError(t, err)
+	require.Equal(t, changes, data.Events[0].Changes)
+	require.Equal(t, string(payload), data.WebhookDeliveries[0].Payload)
+}
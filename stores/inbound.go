@@ -0,0 +1,102 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/models"
+)
+
+const (
+	// InboundTable is the name for table that contains inbound webhook deliveries
+	InboundTable = "inbound_deliveries"
+
+	uniqueViolation = "23505"
+)
+
+// ErrDuplicate occurs when one tries to create an object that already exists
+var ErrDuplicate = apperr.New(apperr.Conflict, "the object already exists")
+
+var selectInboundExpr = `SELECT id, received_at, signature, timestamp, body, status, error, customer_id, attempts, updated_at FROM ` + InboundTable
+
+// NewInboundStore creates new inbound delivery store for the given database connection
+func NewInboundStore(db *sql.DB) InboundStore {
+	return &inboundStore{
+		db: db,
+	}
+}
+
+type inboundStore struct {
+	db *sql.DB
+}
+
+// CreateInbound saves the given delivery and returns it with ID set.
+// It returns ErrDuplicate if a verified delivery with the same signature exists
+func (s *inboundStore) CreateInbound(ctx context.Context, d models.InboundDelivery) (models.InboundDelivery, error) {
+	query := "INSERT INTO " + InboundTable + `(signature, timestamp, body, status, error, customer_id, attempts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, received_at, updated_at`
+	row := conn(ctx, s.db).QueryRowContext(ctx, query, d.Signature, d.Timestamp, d.Body, string(d.Status), d.Error, d.CustomerID, d.Attempts)
+	result := d
+	if err := row.Scan(&result.ID, &result.ReceivedAt, &result.UpdatedAt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return models.InboundDelivery{}, ErrDuplicate
+		}
+		return models.InboundDelivery{}, errors.Wrapf(err, "create inbound delivery")
+	}
+	result.ReceivedAt, result.UpdatedAt = result.ReceivedAt.UTC(), result.UpdatedAt.UTC()
+	return result, nil
+}
+
+// UpdateInbound saves the outcome of the given delivery
+func (s *inboundStore) UpdateInbound(ctx context.Context, d models.InboundDelivery) error {
+	query := "UPDATE " + InboundTable + ` SET status = $1, error = $2, customer_id = $3, attempts = $4,
+		updated_at = (now() AT TIME ZONE 'utc') WHERE id = $5`
+	if _, err := conn(ctx, s.db).ExecContext(ctx, query, string(d.Status), d.Error, d.CustomerID, d.Attempts, d.ID); err != nil {
+		return errors.Wrapf(err, "update inbound delivery %v", d.ID)
+	}
+	return nil
+}
+
+// GetInbound returns an inbound delivery by its ID
+func (s *inboundStore) GetInbound(ctx context.Context, id int64) (models.InboundDelivery, error) {
+	delivery, err := s.scanInbound(conn(ctx, s.db).QueryRowContext(ctx, selectInboundExpr+" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return models.InboundDelivery{}, errors.Wrapf(err, "get inbound delivery %v", id)
+	}
+	return delivery, nil
+}
+
+// ListInbound returns the latest inbound deliveries with the given status, or with any status if it's empty
+func (s *inboundStore) ListInbound(ctx context.Context, status models.InboundStatus, limit int) ([]models.InboundDelivery, error) {
+	query := selectInboundExpr + " WHERE $1 = '' OR status = $1 ORDER BY id DESC LIMIT $2"
+	rows, err := conn(ctx, s.db).QueryContext(ctx, query, string(status), limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query inbound deliveries")
+	}
+	defer rows.Close()
+
+	var deliveries []models.InboundDelivery
+	for rows.Next() {
+		delivery, err := s.scanInbound(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read inbound delivery from database")
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *inboundStore) scanInbound(scanner rowScanner) (d models.InboundDelivery, _ error) {
+	if err := scanner.Scan(&d.ID, &d.ReceivedAt, &d.Signature, &d.Timestamp, &d.Body, &d.Status, &d.Error, &d.CustomerID, &d.Attempts, &d.UpdatedAt); err != nil {
+		return models.InboundDelivery{}, err
+	}
+	d.ReceivedAt, d.UpdatedAt = d.ReceivedAt.UTC(), d.UpdatedAt.UTC()
+	return
+}
@@ -12,6 +12,12 @@ import (
 
 const protoPrefix = "postgres://"
 
+// MigrationTable is the name for table that records applied migrations
+const MigrationTable = "schema_migrations"
+
+// migrationLock is the key of the advisory lock that serializes migrations
+const migrationLock = 26026
+
 func ensureProtoPrefix(str string) string {
 	if !strings.HasPrefix(str, protoPrefix) {
 		str = protoPrefix + str
@@ -31,7 +37,7 @@ func DropDB(ctx context.Context, dbURLStr, dbName string) error {
 	return err
 }
 
-// PrepareDB creates the given database if it doesn't exists and initializes it
+// PrepareDB creates the given database if it doesn't exists and applies migrations it hasn't seen yet
 // In all cases it returns connection to a database with valid schema
 func PrepareDB(ctx context.Context, dbURLStr string) (_ *sql.DB, gerr error) {
 	dbURLStr = ensureProtoPrefix(dbURLStr)
@@ -54,7 +60,7 @@ func PrepareDB(ctx context.Context, dbURLStr string) (_ *sql.DB, gerr error) {
 		defer dropOnError(ctx, db, dbName, &gerr)
 	}
 
-	return openDB(ctx, dbURLStr, !exists)
+	return openDB(ctx, dbURLStr)
 }
 
 func withoutDbName(dbURLStr string) (string, string) {
@@ -80,21 +86,52 @@ func dropOnError(ctx context.Context, db *sql.DB, dbName string, err *error) {
 	}
 }
 
-func openDB(ctx context.Context, url string, init bool) (*sql.DB, error) {
+func openDB(ctx context.Context, url string) (*sql.DB, error) {
 	db, err := sql.Open("postgres", url)
 	if err != nil {
 		return nil, err
 	}
 
-	if init {
-		if _, err := db.ExecContext(ctx, string(schema)); err != nil {
-			return nil, err
-		}
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "migrate database")
 	}
 
 	return db, nil
 }
 
+// migrate applies the migrations that haven't been applied yet in a single transaction.
+// It holds an advisory lock, so that instances started at once don't apply them concurrently
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+MigrationTable+` (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc'))`); err != nil {
+		return err
+	}
+	var applied int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM "+MigrationTable).Scan(&applied); err != nil {
+		return err
+	}
+	for version := applied + 1; version <= len(migrations); version++ {
+		if _, err := tx.ExecContext(ctx, migrations[version-1]); err != nil {
+			return errors.Wrapf(err, "apply migration %v", version)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+MigrationTable+"(version) VALUES ($1)", version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func databaseExists(ctx context.Context, db *sql.DB, dbName string) (bool, error) {
 	row := db.QueryRowContext(ctx, `SELECT 1 FROM pg_database WHERE datname=$1`, dbName)
 	var n int
@@ -0,0 +1,89 @@
+package stores_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+// baselineSchema is the schema of databases created before migrations
+const baselineSchema = `
+CREATE TABLE customers (
+    id SERIAL,
+    firstname VARCHAR(100) NOT NULL,
+    lastname VARCHAR(100) NOT NULL,
+    birthdate TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    gender VARCHAR(6) NOT NULL,
+    email VARCHAR(254) NOT NULL,
+    address VARCHAR(200) NOT NULL,
+
+    CHECK (gender = 'Female' OR gender = 'Male')
+);
+CREATE INDEX customers_email_idx ON customers(email);
+`
+
+func TestMigrationsUpgradeExistingDatabase(t *testing.T) {
+	dbUrl := os.Getenv("TEST_DB")
+	if dbUrl == "" {
+		t.Skip("no test database provided")
+	}
+	ctx := context.Background()
+	server, err := sql.Open("postgres", dbUrl)
+	require.NoError(t, err)
+	defer server.Close()
+	dbName := fmt.Sprintf("test%v", time.Now().Nanosecond())
+	_, err = server.ExecContext(ctx, "CREATE DATABASE "+dbName)
+	require.NoError(t, err)
+	defer func() {
+		if err := stores.DropDB(ctx, dbUrl, dbName); err != nil {
+			fmt.Println("drop db:", err)
+		}
+	}()
+	parsed, err := url.Parse(dbUrl)
+	require.NoError(t, err)
+	parsed.Path = dbName
+
+	old, err := sql.Open("postgres", parsed.String())
+	require.NoError(t, err)
+	_, err = old.ExecContext(ctx, baselineSchema)
+	require.NoError(t, err)
+	_, err = old.ExecContext(ctx, `INSERT INTO customers(firstname, lastname, birthdate, gender, email, address)
+		VALUES ('John', 'Doe', '1980-01-02', 'Male', 'john@doe.com', 'Main St. 1')`)
+	require.NoError(t, err)
+	require.NoError(t, old.Close())
+
+	for i := 0; i < 2; i++ {
+		db, err := stores.PrepareDB(ctx, parsed.String())
+		require.NoError(t, err, "migrations apply to an existing database and are applied once")
+		var version int
+		require.NoError(t, db.QueryRowContext(ctx, "SELECT MAX(version) FROM "+stores.MigrationTable).Scan(&version))
+		require.True(t, version > 1)
+		require.NoError(t, db.Close())
+	}
+
+	db, err := stores.PrepareDB(ctx, parsed.String())
+	require.NoError(t, err)
+	defer db.Close()
+	store := stores.NewCustomerStore(db)
+	customers, err := store.ListCustomers(ctx, stores.CustomerListFilter{}, stores.CustomerViewOptions{})
+	require.NoError(t, err)
+	require.Len(t, customers, 1)
+	require.Equal(t, "john@doe.com", customers[0].Email)
+
+	customer := generateCustomers(t, 1)[0]
+	customer.Address = strings.Repeat("a long address ", 50)
+	_, err = stores.NewCustomerStore(db, stores.WithFieldEncryption(testCipher(t, "k1"), "email", "address")).CreateCustomer(ctx, customer)
+	require.NoError(t, err, "ciphertexts fit into columns of existing databases")
+	_, err = stores.NewOutboxStore(db).ListEvents(ctx, models.EventPosition{}, 10)
+	require.NoError(t, err)
+}
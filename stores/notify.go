@@ -0,0 +1,65 @@
+package stores
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+// CustomerChannel is the name of the channel customer changes are notified on
+const CustomerChannel = "customer_changes"
+
+// notifyChange notifies listeners about the given change. Postgres delivers the notification
+// when the transaction of the context commits and drops it if the transaction rolls back
+func notifyChange(ctx context.Context, q querier, change models.CustomerChange) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, "SELECT pg_notify($1, $2)", CustomerChannel, string(payload)); err != nil {
+		return errors.Wrapf(err, "notify %s of customer %v", change.Type, change.ID)
+	}
+	return nil
+}
+
+// ListenCustomerChanges calls the given function for every committed customer change until the context is done.
+// It reconnects to the database if the connection is lost, and the changes made meanwhile are missed
+func ListenCustomerChanges(ctx context.Context, dbURLStr string, fn func(change models.CustomerChange)) error {
+	listener := pq.NewListener(ensureProtoPrefix(dbURLStr), time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			fmt.Println("listen customer changes:", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(CustomerChannel); err != nil {
+		return errors.Wrapf(err, "listen %s", CustomerChannel)
+	}
+
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ping.C:
+			go listener.Ping()
+		case notification := <-listener.Notify:
+			// a nil notification means the connection has been re-established
+			if notification == nil {
+				continue
+			}
+			var change models.CustomerChange
+			if err := json.Unmarshal([]byte(notification.Extra), &change); err != nil {
+				fmt.Println("decode customer change:", err)
+				continue
+			}
+			fn(change)
+		}
+	}
+}
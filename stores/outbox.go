@@ -0,0 +1,124 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+const (
+	// OutboxTable is the name for table that contains customer domain events
+	OutboxTable = "outbox"
+	// OutboxOffsetTable is the name for table that contains positions of outbox consumers
+	OutboxOffsetTable = "outbox_offsets"
+)
+
+// NewOutboxStore creates new outbox store for the given database connection
+func NewOutboxStore(db *sql.DB, options ...PayloadOption) OutboxStore {
+	return &outboxStore{
+		db:     db,
+		sealer: newFieldSealer(options),
+	}
+}
+
+type outboxStore struct {
+	db     *sql.DB
+	sealer fieldSealer
+}
+
+type eventPayload struct {
+	Customer *models.Customer     `json:"customer,omitempty"`
+	Changes  []models.FieldChange `json:"changes,omitempty"`
+}
+
+// AppendEvent appends the given event to the outbox and returns it with ID and position set.
+// It should be called within the transaction that performs the change the event describes
+func (o *outboxStore) AppendEvent(ctx context.Context, event models.Event) (models.Event, error) {
+	sealed, err := o.sealer.sealEvent(ctx, eventPayload{Customer: event.Customer, Changes: event.Changes})
+	if err != nil {
+		return models.Event{}, errors.Wrapf(err, "encrypt event payload")
+	}
+	payload, err := json.Marshal(sealed)
+	if err != nil {
+		return models.Event{}, errors.Wrapf(err, "marshal event payload")
+	}
+	query := "INSERT INTO " + OutboxTable + `(customer_id, type, payload) VALUES ($1, $2, $3) RETURNING id, tx_id, occurred_at`
+	row := conn(ctx, o.db).QueryRowContext(ctx, query, event.CustomerID, string(event.Type), payload)
+	result := event
+	if err := row.Scan(&result.ID, &result.TxID, &result.OccurredAt); err != nil {
+		return models.Event{}, errors.Wrapf(err, "append %s event", event.Type)
+	}
+	result.OccurredAt = result.OccurredAt.UTC()
+	return result, nil
+}
+
+// ListEvents returns committed events that go after the given position, ordered by transaction ID and then by event ID.
+// Transaction IDs are assigned as transactions first write, so the order may differ from the commit order.
+// Events of transactions that are still in progress are held back along with all the events of transactions
+// with higher IDs, so that a consumer never skips an event that commits out of order
+func (o *outboxStore) ListEvents(ctx context.Context, after models.EventPosition, limit int) ([]models.Event, error) {
+	query := `SELECT id, tx_id, customer_id, type, payload, occurred_at FROM ` + OutboxTable + `
+		WHERE (tx_id, id) > ($1, $2) AND tx_id < txid_snapshot_xmin(txid_current_snapshot())
+		ORDER BY tx_id, id LIMIT $3`
+	rows, err := conn(ctx, o.db).QueryContext(ctx, query, after.TxID, after.ID, limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query outbox events")
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		event, err := scanEvent(ctx, rows, o.sealer)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// scanEvent reads an event selected as id, tx_id, customer_id, type, payload and occurred_at, decrypting its payload
+func scanEvent(ctx context.Context, scanner rowScanner, sealer fieldSealer) (models.Event, error) {
+	var event models.Event
+	var payload []byte
+	if err := scanner.Scan(&event.ID, &event.TxID, &event.CustomerID, &event.Type, &payload, &event.OccurredAt); err != nil {
+		return models.Event{}, errors.Wrapf(err, "read outbox event")
+	}
+	var decoded eventPayload
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return models.Event{}, errors.Wrapf(err, "decode payload of event %v", event.ID)
+	}
+	decoded, err := sealer.openEvent(ctx, decoded)
+	if err != nil {
+		return models.Event{}, errors.Wrapf(err, "decrypt payload of event %v", event.ID)
+	}
+	event.Customer, event.Changes = decoded.Customer, decoded.Changes
+	event.OccurredAt = event.OccurredAt.UTC()
+	return event, nil
+}
+
+// GetOffset returns the position of the last event the given consumer has processed
+func (o *outboxStore) GetOffset(ctx context.Context, consumer string) (models.EventPosition, error) {
+	var position models.EventPosition
+	row := conn(ctx, o.db).QueryRowContext(ctx, "SELECT tx_id, event_id FROM "+OutboxOffsetTable+" WHERE consumer = $1", consumer)
+	if err := row.Scan(&position.TxID, &position.ID); err == sql.ErrNoRows {
+		return models.EventPosition{}, nil
+	} else if err != nil {
+		return models.EventPosition{}, errors.Wrapf(err, "get offset of %q", consumer)
+	}
+	return position, nil
+}
+
+// SetOffset saves the position of the last event the given consumer has processed
+func (o *outboxStore) SetOffset(ctx context.Context, consumer string, position models.EventPosition) error {
+	query := "INSERT INTO " + OutboxOffsetTable + `(consumer, tx_id, event_id) VALUES ($1, $2, $3)
+		ON CONFLICT (consumer) DO UPDATE SET tx_id = EXCLUDED.tx_id, event_id = EXCLUDED.event_id`
+	if _, err := conn(ctx, o.db).ExecContext(ctx, query, consumer, position.TxID, position.ID); err != nil {
+		return errors.Wrapf(err, "set offset of %q", consumer)
+	}
+	return nil
+}
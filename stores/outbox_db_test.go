@@ -0,0 +1,92 @@
+package stores_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+	"github.com/havr/customers/util/customeru"
+)
+
+// prepareTestDB creates a database for a single test and returns it along with a function that drops it
+func prepareTestDB(t *testing.T) (*sql.DB, func()) {
+	dbUrl := os.Getenv("TEST_DB")
+	if dbUrl == "" {
+		t.Skip("no test database provided")
+	}
+	ctx := context.Background()
+	parsed, err := url.Parse(dbUrl)
+	require.NoError(t, err)
+	dbName := fmt.Sprintf("test%v", time.Now().Nanosecond())
+	parsed.Path = dbName
+	db, err := stores.PrepareDB(ctx, parsed.String())
+	require.NoError(t, err)
+	return db, func() {
+		_ = db.Close()
+		if err := stores.DropDB(ctx, dbUrl, dbName); err != nil {
+			fmt.Println("drop db:", err)
+		}
+	}
+}
+
+// generateCustomers returns valid customers the generator produces with a fixed seed, so that a failing test
+// can be reproduced with the same data
+func generateCustomers(t *testing.T, count int) []models.Customer {
+	generated, err := customeru.Generate(customeru.DefaultGeneratorOptions(42, count))
+	require.NoError(t, err)
+	customers := make([]models.Customer, 0, count)
+	for _, customer := range generated {
+		customers = append(customers, customer.Customer)
+	}
+	return customers
+}
+
+func TestOutboxHoldsBackUncommitted(t *testing.T) {
+	db, drop := prepareTestDB(t)
+	defer drop()
+	ctx := context.Background()
+	outbox := stores.NewOutboxStore(db)
+	transactor := stores.NewTransactor(db)
+
+	appended, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- transactor.InTx(ctx, func(ctx context.Context) error {
+			if _, err := outbox.AppendEvent(ctx, models.Event{Type: models.CustomerCreated, CustomerID: 1}); err != nil {
+				return err
+			}
+			close(appended)
+			<-release
+			return nil
+		})
+	}()
+	<-appended
+	require.NoError(t, transactor.InTx(ctx, func(ctx context.Context) error {
+		_, err := outbox.AppendEvent(ctx, models.Event{Type: models.CustomerCreated, CustomerID: 2})
+		return err
+	}))
+
+	events, err := outbox.ListEvents(ctx, models.EventPosition{}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 0, "the later transaction must wait for the earlier one")
+
+	close(release)
+	require.NoError(t, <-done)
+	events, err = outbox.ListEvents(ctx, models.EventPosition{}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, 1, events[0].CustomerID)
+	require.Equal(t, 2, events[1].CustomerID)
+
+	events, err = outbox.ListEvents(ctx, events[0].Position(), 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, 2, events[0].CustomerID)
+}
@@ -0,0 +1,57 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/ratelimit"
+)
+
+const (
+	// RateLimitTable is the name for table that contains rate limit buckets
+	RateLimitTable = "rate_limits"
+)
+
+// NewRateLimitStore creates a rate limit store for the given database connection,
+// which lets multiple instances of the application share buckets
+func NewRateLimitStore(db *sql.DB) ratelimit.Store {
+	return &rateLimitStore{
+		db: db,
+		tx: NewTransactor(db),
+	}
+}
+
+type rateLimitStore struct {
+	db *sql.DB
+	tx Transactor
+}
+
+// Take implements ratelimit.Store. The bucket row is locked, so concurrent requests of a client take tokens one by one
+func (s *rateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (wait time.Duration, err error) {
+	err = s.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, s.db)
+		// a missing bucket is full, the row is created first so that it can be locked
+		insert := "INSERT INTO " + RateLimitTable + " (key, tokens, updated_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING"
+		if _, err := tx.ExecContext(ctx, insert, key, float64(limit.Requests), now.UTC()); err != nil {
+			return err
+		}
+		var bucket ratelimit.Bucket
+		row := tx.QueryRowContext(ctx, "SELECT tokens, updated_at FROM "+RateLimitTable+" WHERE key = $1 FOR UPDATE", key)
+		if err := row.Scan(&bucket.Tokens, &bucket.UpdatedAt); err != nil {
+			return err
+		}
+		wait = bucket.Take(limit, now.UTC())
+		_, err := tx.ExecContext(ctx, "UPDATE "+RateLimitTable+" SET tokens = $2, updated_at = $3 WHERE key = $1", key, bucket.Tokens, bucket.UpdatedAt)
+		return err
+	})
+	return wait, errors.Wrapf(err, "take rate limit token of %s", key)
+}
+
+// Purge implements ratelimit.Store
+func (s *rateLimitStore) Purge(ctx context.Context, before time.Time) error {
+	_, err := conn(ctx, s.db).ExecContext(ctx, "DELETE FROM "+RateLimitTable+" WHERE updated_at < $1", before.UTC())
+	return errors.Wrapf(err, "purge rate limits")
+}
@@ -0,0 +1,41 @@
+package stores
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ListDeletedBefore returns IDs of customers that have been deleted before the given time
+func (c *customerStore) ListDeletedBefore(ctx context.Context, before time.Time, afterID, limit int) ([]int, error) {
+	query := "SELECT id FROM " + CustomerTable + " WHERE deleted_at < $1 AND id > $2 ORDER BY id LIMIT $3"
+	ids, err := c.queryIDs(ctx, query, before.UTC(), afterID, limit)
+	return ids, errors.Wrapf(err, "list customers deleted before %v", before)
+}
+
+// ListInactiveBefore returns IDs of customers that haven't been created, updated or restored since the given time,
+// leaving out deleted and anonymized ones
+func (c *customerStore) ListInactiveBefore(ctx context.Context, before time.Time, afterID, limit int) ([]int, error) {
+	query := "SELECT id FROM " + CustomerTable + ` WHERE updated_at < $1 AND deleted_at IS NULL AND anonymized_at IS NULL
+		AND id > $2 ORDER BY id LIMIT $3`
+	ids, err := c.queryIDs(ctx, query, before.UTC(), afterID, limit)
+	return ids, errors.Wrapf(err, "list customers inactive since %v", before)
+}
+
+func (c *customerStore) queryIDs(ctx context.Context, query string, args ...interface{}) ([]int, error) {
+	rows, err := conn(ctx, c.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
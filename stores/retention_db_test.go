@@ -0,0 +1,90 @@
+package stores_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+func TestRetentionCandidates(t *testing.T) {
+	db, drop := prepareTestDB(t)
+	defer drop()
+	ctx := context.Background()
+	store := stores.NewCustomerStore(db)
+	retention := store.(stores.RetentionStore)
+
+	var ids []int
+	for _, customer := range generateCustomers(t, 3) {
+		customer, err := store.CreateCustomer(ctx, customer)
+		require.NoError(t, err)
+		ids = append(ids, customer.ID)
+	}
+	require.NoError(t, store.DeleteCustomer(ctx, ids[0]))
+	_, err := store.(stores.SubjectStore).EraseSubject(ctx, ids[1], models.ErasureAnonymize, time.Time{})
+	require.NoError(t, err)
+
+	later := time.Now().Add(time.Hour)
+	deleted, err := retention.ListDeletedBefore(ctx, later, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, ids[:1], deleted)
+	inactive, err := retention.ListInactiveBefore(ctx, later, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, ids[2:], inactive, "deleted and anonymized customers aren't inactive")
+	inactive, err = retention.ListInactiveBefore(ctx, later, ids[2], 10)
+	require.NoError(t, err)
+	require.Empty(t, inactive)
+
+	earlier := time.Now().Add(-time.Hour)
+	deleted, err = retention.ListDeletedBefore(ctx, earlier, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+	inactive, err = retention.ListInactiveBefore(ctx, earlier, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, inactive)
+}
+
+func TestRetentionRechecksRulesOnErase(t *testing.T) {
+	db, drop := prepareTestDB(t)
+	defer drop()
+	ctx := context.Background()
+	store := stores.NewCustomerStore(db)
+	retention := store.(stores.RetentionStore)
+	subjects := store.(stores.SubjectStore)
+
+	customers := generateCustomers(t, 2)
+	restored, err := store.CreateCustomer(ctx, customers[0])
+	require.NoError(t, err)
+	purged, err := store.CreateCustomer(ctx, customers[1])
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteCustomer(ctx, restored.ID))
+	require.NoError(t, store.DeleteCustomer(ctx, purged.ID))
+
+	later := time.Now().Add(time.Hour)
+	deleted, err := retention.ListDeletedBefore(ctx, later, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int{restored.ID, purged.ID}, deleted)
+	require.NoError(t, store.RestoreCustomer(ctx, restored.ID))
+
+	_, err = subjects.EraseSubject(ctx, restored.ID, models.ErasureDelete, later)
+	require.Equal(t, stores.ErrChanged, errors.Cause(err), "restored customers aren't purged")
+	_, err = store.GetCustomer(ctx, restored.ID)
+	require.NoError(t, err)
+	_, err = subjects.EraseSubject(ctx, purged.ID, models.ErasureDelete, later)
+	require.NoError(t, err)
+
+	inactive, err := retention.ListInactiveBefore(ctx, later, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int{restored.ID}, inactive)
+	_, err = subjects.EraseSubject(ctx, restored.ID, models.ErasureAnonymize, time.Now().Add(-time.Hour))
+	require.Equal(t, stores.ErrChanged, errors.Cause(err), "customers changed since the cutoff aren't anonymized")
+	_, err = subjects.EraseSubject(ctx, restored.ID, models.ErasureAnonymize, later)
+	require.NoError(t, err)
+	_, err = subjects.EraseSubject(ctx, restored.ID, models.ErasureAnonymize, later)
+	require.Equal(t, stores.ErrChanged, errors.Cause(err), "anonymized customers aren't anonymized again")
+}
@@ -0,0 +1,36 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+// RevealTable is the name for table that records who has revealed personal data of customers
+const RevealTable = "pii_reveals"
+
+// NewRevealStore creates new reveal store for the given database connection
+func NewRevealStore(db *sql.DB) RevealStore {
+	return &revealStore{
+		db: db,
+	}
+}
+
+type revealStore struct {
+	db *sql.DB
+}
+
+// RecordReveal saves the given reveal and returns it with ID and time set
+func (s *revealStore) RecordReveal(ctx context.Context, reveal models.Reveal) (models.Reveal, error) {
+	query := "INSERT INTO " + RevealTable + `(customer_id, actor, fields, request_id) VALUES ($1, $2, $3, $4) RETURNING id, revealed_at`
+	row := conn(ctx, s.db).QueryRowContext(ctx, query, reveal.CustomerID, reveal.Actor, pq.Array(reveal.Fields), reveal.RequestID)
+	if err := row.Scan(&reveal.ID, &reveal.RevealedAt); err != nil {
+		return models.Reveal{}, errors.Wrapf(err, "record reveal of customer %v", reveal.CustomerID)
+	}
+	reveal.RevealedAt = reveal.RevealedAt.UTC()
+	return reveal, nil
+}
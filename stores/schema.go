@@ -1,7 +1,12 @@
 package stores
 
-const schema = `
-CREATE TABLE customers (
+// migrations bring the schema of a database up to date. They are applied in order on every start, and the number
+// of those applied is kept in schema_migrations, so each of them runs once. They are idempotent nevertheless,
+// since databases created before migrations were numbered have tables of later ones but no record of them
+var migrations = []string{
+	// 1: customers
+	`
+CREATE TABLE IF NOT EXISTS customers (
     id SERIAL,
     firstname VARCHAR(100) NOT NULL,
     lastname VARCHAR(100) NOT NULL,
@@ -13,10 +18,246 @@ CREATE TABLE customers (
     CHECK (gender = 'Female' OR gender = 'Male')
 );
 
-CREATE INDEX customers_firstname_idx ON customers(firstname);
-CREATE INDEX customers_lastname_idx ON customers(lastname);
-CREATE INDEX customers_birthdate_idx ON customers(birthdate);
-CREATE INDEX customers_gender_idx ON customers(gender);
-CREATE INDEX customers_email_idx ON customers(email);
-CREATE INDEX customers_address_idx ON customers(address);
-`
+CREATE INDEX IF NOT EXISTS customers_firstname_idx ON customers(firstname);
+CREATE INDEX IF NOT EXISTS customers_lastname_idx ON customers(lastname);
+CREATE INDEX IF NOT EXISTS customers_birthdate_idx ON customers(birthdate);
+CREATE INDEX IF NOT EXISTS customers_gender_idx ON customers(gender);
+CREATE INDEX IF NOT EXISTS customers_email_idx ON customers(email);
+CREATE INDEX IF NOT EXISTS customers_address_idx ON customers(address);
+`,
+	// 2: encrypted fields, soft deletion and retention of customers, events, webhooks, users and audit
+	`
+ALTER TABLE customers ALTER COLUMN email TYPE TEXT;
+ALTER TABLE customers ALTER COLUMN address TYPE TEXT;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS email_index VARCHAR(64);
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITHOUT TIME ZONE;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc');
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP WITHOUT TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS customers_email_index_idx ON customers(email_index);
+CREATE INDEX IF NOT EXISTS customers_deleted_at_idx ON customers(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS customers_updated_at_idx ON customers(updated_at) WHERE deleted_at IS NULL AND anonymized_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    tx_id BIGINT NOT NULL DEFAULT txid_current(),
+    customer_id INTEGER NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    occurred_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc')
+);
+
+CREATE INDEX IF NOT EXISTS outbox_position_idx ON outbox(tx_id, id);
+CREATE INDEX IF NOT EXISTS outbox_customer_id_idx ON outbox(customer_id);
+
+CREATE TABLE IF NOT EXISTS outbox_offsets (
+    consumer VARCHAR(500) PRIMARY KEY,
+    tx_id BIGINT NOT NULL,
+    event_id BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    url VARCHAR(2000) NOT NULL,
+    secret VARCHAR(200) NOT NULL,
+    event_types VARCHAR(50)[] NOT NULL,
+    filter_firstname VARCHAR(100) NOT NULL DEFAULT '',
+    filter_lastname VARCHAR(100) NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc')
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    customer_id INTEGER NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    request_headers TEXT NOT NULL DEFAULT '',
+    response_status INTEGER NOT NULL DEFAULT 0,
+    response_headers TEXT NOT NULL DEFAULT '',
+    response_body TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+    updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries(next_attempt_at) WHERE status IN ('pending', 'failed');
+CREATE INDEX IF NOT EXISTS webhook_deliveries_customer_id_idx ON webhook_deliveries(customer_id);
+
+CREATE TABLE IF NOT EXISTS inbound_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    received_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+    signature VARCHAR(200) NOT NULL,
+    timestamp VARCHAR(50) NOT NULL,
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    customer_id INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc')
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS inbound_deliveries_signature_idx ON inbound_deliveries(signature) WHERE status <> 'rejected';
+CREATE INDEX IF NOT EXISTS inbound_deliveries_status_idx ON inbound_deliveries(status);
+
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(100) NOT NULL UNIQUE,
+    role VARCHAR(16) NOT NULL DEFAULT 'intern',
+    password_hash VARCHAR(200) NOT NULL,
+    totp_secret VARCHAR(64) NOT NULL DEFAULT '',
+    totp_counter BIGINT NOT NULL DEFAULT 0,
+    external_id VARCHAR(255) UNIQUE,
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc')
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    pending BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    last_seen_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions(user_id);
+
+CREATE TABLE IF NOT EXISTS flashes (
+    id BIGSERIAL PRIMARY KEY,
+    session_id VARCHAR(64) NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL,
+    message VARCHAR(500) NOT NULL,
+    action VARCHAR(500) NOT NULL DEFAULT '',
+    action_label VARCHAR(100) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS flashes_session_id_idx ON flashes(session_id);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP WITHOUT TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes(user_id);
+
+CREATE TABLE IF NOT EXISTS settings (
+    name VARCHAR(100) PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP WITHOUT TIME ZONE,
+    last_used_at TIMESTAMP WITHOUT TIME ZONE,
+    revoked_at TIMESTAMP WITHOUT TIME ZONE,
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc')
+);
+
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pii_reveals (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL,
+    actor VARCHAR(200) NOT NULL,
+    fields TEXT[] NOT NULL,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    revealed_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc')
+);
+
+CREATE INDEX IF NOT EXISTS pii_reveals_customer_id_idx ON pii_reveals(customer_id);
+
+CREATE TABLE IF NOT EXISTS customer_accesses (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL,
+    actor VARCHAR(200) NOT NULL,
+    purpose VARCHAR(20) NOT NULL,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    accessed_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS customer_accesses_customer_idx ON customer_accesses(customer_id, accessed_at);
+CREATE INDEX IF NOT EXISTS customer_accesses_actor_idx ON customer_accesses(actor, accessed_at);
+CREATE INDEX IF NOT EXISTS customer_accesses_accessed_at_idx ON customer_accesses(accessed_at);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(50) NOT NULL,
+    customer_id INTEGER NOT NULL,
+    actor VARCHAR(200) NOT NULL,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    fields TEXT[] NOT NULL DEFAULT '{}',
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    prev_hash VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_customer_id_idx ON audit_log(customer_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    public_key VARCHAR(64) NOT NULL,
+    signature VARCHAR(128) NOT NULL
+);
+`,
+	// 3: append-only audit checkpoints
+	`
+CREATE OR REPLACE FUNCTION audit_checkpoints_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_checkpoints is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_checkpoints_append_only ON audit_checkpoints;
+CREATE TRIGGER audit_checkpoints_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_checkpoints
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_checkpoints_append_only();
+`,
+	// 4: secrets being enrolled, kept in sessions
+	`
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64) NOT NULL DEFAULT '';
+`,
+	// 5: failed attempts to enter the second factor
+	`
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
+`,
+	// 6: emails and addresses may be encrypted, so they are looked up by the blind index instead
+	`
+DROP INDEX IF EXISTS customers_email_idx;
+DROP INDEX IF EXISTS customers_address_idx;
+`,
+	// 7: rejected inbound deliveries are no longer stored
+	`
+DELETE FROM inbound_deliveries WHERE status = 'rejected';
+`,
+}
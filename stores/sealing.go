@@ -0,0 +1,121 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/havr/customers/encryption"
+	"github.com/havr/customers/models"
+)
+
+// PayloadOption configures encryption of customer fields in payloads that outlive the change of a customer,
+// i.e. outbox events and webhook deliveries
+type PayloadOption func(s *fieldSealer)
+
+// WithPayloadEncryption encrypts values of the given customer fields in stored payloads with the cipher,
+// so that encrypting the fields in the customers table isn't defeated by their copies. Payloads that have been stored
+// before are read as they are
+func WithPayloadEncryption(cipher *encryption.Cipher, fields ...string) PayloadOption {
+	return func(s *fieldSealer) {
+		s.cipher = cipher
+		for _, field := range fields {
+			s.encrypted[strings.ToLower(field)] = true
+		}
+	}
+}
+
+// fieldSealer encrypts values of encrypted customer fields wherever they are copied to
+type fieldSealer struct {
+	cipher    *encryption.Cipher
+	encrypted map[string]bool
+}
+
+func newFieldSealer(options []PayloadOption) fieldSealer {
+	sealer := fieldSealer{encrypted: make(map[string]bool)}
+	for _, option := range options {
+		option(&sealer)
+	}
+	return sealer
+}
+
+// enabled tells whether any field is encrypted
+func (s fieldSealer) enabled() bool {
+	return s.cipher != nil && len(s.encrypted) > 0
+}
+
+func (s fieldSealer) seal(ctx context.Context, label, field, value string) (string, error) {
+	if !s.encrypted[strings.ToLower(field)] || s.cipher == nil {
+		return value, nil
+	}
+	return s.cipher.Encrypt(ctx, value, label+"."+field)
+}
+
+// open decrypts a stored value, even if the field is no longer configured to be encrypted
+func (s fieldSealer) open(ctx context.Context, label, field, value string) (string, error) {
+	if !encryption.IsEncrypted(value) {
+		return value, nil
+	}
+	if s.cipher == nil {
+		return "", fmt.Errorf("%s %s is encrypted, but no keys are configured", label, field)
+	}
+	return s.cipher.Decrypt(ctx, value, label+"."+field)
+}
+
+// sealEvent encrypts values of encrypted fields in the customer of an event and in its changes.
+// Names of changed fields are kept as they are, so that erasure may strip the values
+func (s fieldSealer) sealEvent(ctx context.Context, payload eventPayload) (eventPayload, error) {
+	return s.transformEvent(ctx, payload, s.seal)
+}
+
+// openEvent decrypts what sealEvent has encrypted
+func (s fieldSealer) openEvent(ctx context.Context, payload eventPayload) (eventPayload, error) {
+	return s.transformEvent(ctx, payload, s.open)
+}
+
+func (s fieldSealer) transformEvent(ctx context.Context, payload eventPayload,
+	transform func(ctx context.Context, label, field, value string) (string, error)) (eventPayload, error) {
+	var err error
+	if payload.Customer != nil {
+		customer := *payload.Customer
+		if customer.Email, err = transform(ctx, OutboxTable, "email", customer.Email); err != nil {
+			return payload, err
+		}
+		if customer.Address, err = transform(ctx, OutboxTable, "address", customer.Address); err != nil {
+			return payload, err
+		}
+		payload.Customer = &customer
+	}
+	if len(payload.Changes) == 0 {
+		return payload, nil
+	}
+	changes := make([]models.FieldChange, len(payload.Changes))
+	for i, change := range payload.Changes {
+		if old, ok := change.Old.(string); ok {
+			if change.Old, err = transform(ctx, OutboxTable, change.Field, old); err != nil {
+				return payload, err
+			}
+		}
+		if value, ok := change.New.(string); ok {
+			if change.New, err = transform(ctx, OutboxTable, change.Field, value); err != nil {
+				return payload, err
+			}
+		}
+		changes[i] = change
+	}
+	payload.Changes = changes
+	return payload, nil
+}
+
+// sealDelivery encrypts the payload of a webhook delivery as a whole, since it's sent as it is
+func (s fieldSealer) sealDelivery(ctx context.Context, payload string) (string, error) {
+	if !s.enabled() {
+		return payload, nil
+	}
+	return s.cipher.Encrypt(ctx, payload, WebhookDeliveryTable+".payload")
+}
+
+// openDelivery decrypts what sealDelivery has encrypted
+func (s fieldSealer) openDelivery(ctx context.Context, payload string) (string, error) {
+	return s.open(ctx, WebhookDeliveryTable, "payload", payload)
+}
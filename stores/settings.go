@@ -0,0 +1,43 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// SettingTable is the name for table that contains application settings
+const SettingTable = "settings"
+
+// NewSettingStore creates new setting store for the given database connection
+func NewSettingStore(db *sql.DB) SettingStore {
+	return &settingStore{
+		db: db,
+	}
+}
+
+type settingStore struct {
+	db *sql.DB
+}
+
+// GetSetting returns the value of the given setting, or an empty string if it hasn't been set
+func (s *settingStore) GetSetting(ctx context.Context, name string) (string, error) {
+	var value string
+	err := conn(ctx, s.db).QueryRowContext(ctx, "SELECT value FROM "+SettingTable+" WHERE name = $1", name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Wrapf(err, "get setting %q", name)
+	}
+	return value, nil
+}
+
+// SetSetting sets the value of the given setting
+func (s *settingStore) SetSetting(ctx context.Context, name, value string) error {
+	query := "INSERT INTO " + SettingTable + `(name, value) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value`
+	if _, err := conn(ctx, s.db).ExecContext(ctx, query, name, value); err != nil {
+		return errors.Wrapf(err, "set setting %q", name)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package stores
 
 import (
 	"context"
+	"time"
 
 	"github.com/havr/customers/models"
 )
@@ -12,13 +13,13 @@ type CustomerViewOptions struct {
 	OrderDesc bool
 	Offset    int
 	Limit     int
+	// AfterID skips customers up to the given ID. Customers are ordered by ID unless OrderBy is set, so that they
+	// can be read in batches that customers created or deleted meanwhile don't shift
+	AfterID int
 }
 
 // CustomerListFilter represents filtering options
-type CustomerListFilter struct {
-	FirstName string
-	LastName  string
-}
+type CustomerListFilter = models.CustomerListFilter
 
 // CustomerStore is a generic interface for customer persistence
 type CustomerStore interface {
@@ -27,5 +28,119 @@ type CustomerStore interface {
 	ListCustomers(ctx context.Context, filter CustomerListFilter, options CustomerViewOptions) ([]models.Customer, error)
 	UpdateCustomer(ctx context.Context, customer models.Customer) error
 	DeleteCustomer(ctx context.Context, id int) error
+	RestoreCustomer(ctx context.Context, id int) error
 	GetCustomer(ctx context.Context, id int) (models.Customer, error)
 }
+
+// CustomerKeyRotator re-encrypts customers with the current encryption key
+type CustomerKeyRotator interface {
+	RotateCustomerKeys(ctx context.Context, afterID, limit int) (lastID, rotated int, err error)
+}
+
+// OutboxStore is a generic interface for the customer domain events outbox
+type OutboxStore interface {
+	AppendEvent(ctx context.Context, event models.Event) (models.Event, error)
+	ListEvents(ctx context.Context, after models.EventPosition, limit int) ([]models.Event, error)
+	GetOffset(ctx context.Context, consumer string) (models.EventPosition, error)
+	SetOffset(ctx context.Context, consumer string, position models.EventPosition) error
+}
+
+// WebhookStore is a generic interface for webhook subscriptions and their deliveries persistence
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	GetWebhook(ctx context.Context, id int) (models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	CreateDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	ListDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error)
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+}
+
+// InboundStore is a generic interface for inbound webhook deliveries persistence
+type InboundStore interface {
+	CreateInbound(ctx context.Context, delivery models.InboundDelivery) (models.InboundDelivery, error)
+	UpdateInbound(ctx context.Context, delivery models.InboundDelivery) error
+	GetInbound(ctx context.Context, id int64) (models.InboundDelivery, error)
+	ListInbound(ctx context.Context, status models.InboundStatus, limit int) ([]models.InboundDelivery, error)
+}
+
+// UserStore is a generic interface for users persistence
+type UserStore interface {
+	CreateUser(ctx context.Context, user models.User) (models.User, error)
+	GetUser(ctx context.Context, id int) (models.User, error)
+	GetUserByName(ctx context.Context, username string) (models.User, error)
+	GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool, error)
+	SetUserRole(ctx context.Context, id int, role models.Role) error
+	SetTOTPSecret(ctx context.Context, id int, secret string) error
+	AdvanceTOTPCounter(ctx context.Context, id int, counter int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, hash string, at time.Time) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+}
+
+// SessionStore is a generic interface for user sessions persistence
+type SessionStore interface {
+	CreateSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, id string) (models.Session, error)
+	TouchSession(ctx context.Context, id string, at time.Time) error
+	SetSessionTOTPSecret(ctx context.Context, id, secret string) error
+	AddFailedAttempt(ctx context.Context, id string) (int, error)
+	DeleteSession(ctx context.Context, id string) error
+	DeleteStaleSessions(ctx context.Context, lastSeenBefore, createdBefore time.Time) error
+	AddFlash(ctx context.Context, sessionID string, flash models.Flash) error
+	TakeFlashes(ctx context.Context, sessionID string) ([]models.Flash, error)
+}
+
+// APIKeyStore is a generic interface for API keys persistence
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int, at time.Time) error
+	TouchAPIKey(ctx context.Context, id int, at time.Time) error
+}
+
+// SettingStore is a generic interface for application settings persistence
+type SettingStore interface {
+	GetSetting(ctx context.Context, name string) (string, error)
+	SetSetting(ctx context.Context, name, value string) error
+}
+
+// RevealStore is a generic interface for records of revealed personal data
+type RevealStore interface {
+	RecordReveal(ctx context.Context, reveal models.Reveal) (models.Reveal, error)
+}
+
+// AccessStore is a generic interface for the log of customer data reads
+type AccessStore interface {
+	RecordAccesses(ctx context.Context, accesses []models.Access) error
+	ListAccesses(ctx context.Context, filter models.AccessFilter, offset, limit int) ([]models.Access, error)
+	ListAccessesAfter(ctx context.Context, filter models.AccessFilter, afterID int64, limit int) ([]models.Access, error)
+}
+
+// AuditStore is a generic interface for the hash-chained audit trail persistence.
+// AppendAudit chains the entry to the last one, so entries must be appended within a transaction
+type AuditStore interface {
+	AppendAudit(ctx context.Context, entry models.AuditEntry) (models.AuditEntry, error)
+	ListAudit(ctx context.Context, afterID int64, limit int) ([]models.AuditEntry, error)
+	LastAudit(ctx context.Context) (models.AuditEntry, error)
+	SaveCheckpoint(ctx context.Context, checkpoint models.AuditCheckpoint) (models.AuditCheckpoint, error)
+	ListCheckpoints(ctx context.Context) ([]models.AuditCheckpoint, error)
+}
+
+// SubjectStore is a generic interface for requests of data subjects, which deal with everything stored about a customer.
+// EraseSubject returns the customer as it is left, which is zero if it has been deleted. Unless before is zero,
+// it erases the customer only if the retention rule of the mode still applies as of before, and returns ErrChanged otherwise
+type SubjectStore interface {
+	CollectSubjectData(ctx context.Context, customerID int) (models.SubjectData, error)
+	EraseSubject(ctx context.Context, customerID int, mode models.ErasureMode, before time.Time) (models.Customer, error)
+}
+
+// RetentionStore finds customers that retention rules apply to: ones deleted before the given time,
+// and ones that haven't changed since it and haven't been anonymized yet. IDs follow afterID in ascending order
+type RetentionStore interface {
+	ListDeletedBefore(ctx context.Context, before time.Time, afterID, limit int) ([]int, error)
+	ListInactiveBefore(ctx context.Context, before time.Time, afterID, limit int) ([]int, error)
+}
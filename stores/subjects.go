@@ -0,0 +1,223 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+// CollectSubjectData returns everything stored about the given customer, even if it has been deleted.
+// It returns ErrNotFound if nothing is stored about the customer
+func (c *customerStore) CollectSubjectData(ctx context.Context, customerID int) (models.SubjectData, error) {
+	data := models.SubjectData{CustomerID: customerID}
+	err := c.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, c.db)
+		query := append(selectExpr(), "WHERE id = $1")
+		customer, err := c.scanRow(ctx, tx.QueryRowContext(ctx, strings.Join(query, " "), customerID))
+		if err != nil && err != sql.ErrNoRows {
+			return errors.Wrapf(err, "get customer")
+		}
+		if err == nil {
+			data.Customer = &customer
+			var deletedAt pq.NullTime
+			if err := tx.QueryRowContext(ctx, "SELECT deleted_at FROM "+CustomerTable+" WHERE id = $1", customerID).Scan(&deletedAt); err != nil {
+				return errors.Wrapf(err, "get customer")
+			}
+			if deletedAt.Valid {
+				at := deletedAt.Time.UTC()
+				data.DeletedAt = &at
+			}
+		}
+		if data.Events, err = c.subjectEvents(ctx, customerID); err != nil {
+			return err
+		}
+		webhooks := &webhookStore{db: c.db, sealer: c.sealer()}
+		if data.WebhookDeliveries, err = webhooks.queryDeliveries(ctx, selectDeliveryExpr+" WHERE customer_id = $1 ORDER BY id", customerID); err != nil {
+			return err
+		}
+		if data.InboundDeliveries, err = c.subjectInbound(ctx, customerID); err != nil {
+			return err
+		}
+		if data.Reveals, err = c.subjectReveals(ctx, customerID); err != nil {
+			return err
+		}
+		accesses := &accessStore{db: c.db}
+		if data.Accesses, err = accesses.ListAccesses(ctx, models.AccessFilter{CustomerID: customerID}, 0, maxSubjectRecords); err != nil {
+			return err
+		}
+		data.Audit, err = c.subjectAudit(ctx, customerID)
+		return err
+	})
+	if err != nil {
+		return models.SubjectData{}, errors.Wrapf(err, "collect data of customer %v", customerID)
+	}
+	if data.Empty() {
+		return models.SubjectData{}, ErrNotFound
+	}
+	return data, nil
+}
+
+// maxSubjectRecords bounds records of a kind collected about a customer, which is far more than a customer ever has
+const maxSubjectRecords = 1000000
+
+func (c *customerStore) subjectEvents(ctx context.Context, customerID int) ([]models.Event, error) {
+	query := "SELECT id, tx_id, customer_id, type, payload, occurred_at FROM " + OutboxTable + " WHERE customer_id = $1 ORDER BY id"
+	rows, err := conn(ctx, c.db).QueryContext(ctx, query, customerID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query outbox events")
+	}
+	defer rows.Close()
+	var events []models.Event
+	for rows.Next() {
+		event, err := scanEvent(ctx, rows, c.sealer())
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (c *customerStore) subjectInbound(ctx context.Context, customerID int) ([]models.InboundDelivery, error) {
+	rows, err := conn(ctx, c.db).QueryContext(ctx, selectInboundExpr+" WHERE customer_id = $1 ORDER BY id", customerID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query inbound deliveries")
+	}
+	defer rows.Close()
+	inbound := &inboundStore{db: c.db}
+	var deliveries []models.InboundDelivery
+	for rows.Next() {
+		delivery, err := inbound.scanInbound(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read inbound delivery")
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (c *customerStore) subjectReveals(ctx context.Context, customerID int) ([]models.Reveal, error) {
+	query := "SELECT id, customer_id, actor, fields, request_id, revealed_at FROM " + RevealTable + " WHERE customer_id = $1 ORDER BY id"
+	rows, err := conn(ctx, c.db).QueryContext(ctx, query, customerID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query reveals")
+	}
+	defer rows.Close()
+	var reveals []models.Reveal
+	for rows.Next() {
+		var reveal models.Reveal
+		if err := rows.Scan(&reveal.ID, &reveal.CustomerID, &reveal.Actor, pq.Array(&reveal.Fields), &reveal.RequestID, &reveal.RevealedAt); err != nil {
+			return nil, errors.Wrapf(err, "read reveal")
+		}
+		reveal.RevealedAt = reveal.RevealedAt.UTC()
+		reveals = append(reveals, reveal)
+	}
+	return reveals, rows.Err()
+}
+
+func (c *customerStore) subjectAudit(ctx context.Context, customerID int) ([]models.AuditEntry, error) {
+	rows, err := conn(ctx, c.db).QueryContext(ctx, selectAuditExpr+" WHERE customer_id = $1 ORDER BY id", customerID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query audit entries")
+	}
+	defer rows.Close()
+	var entries []models.AuditEntry
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read audit entry")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// EraseSubject erases personal data of the given customer, even if it has been deleted, wherever it's stored:
+// the customer is anonymized or deleted, values are stripped from its events, bodies of its inbound deliveries
+// are cleared and its webhook deliveries are dropped. Reveals, accesses and the audit trail are kept,
+// since they are required to account for the processing and hold no personal data besides the customer ID.
+// Retention rules pass the time they go back to as before, and the rule is checked again under the row lock,
+// so that a customer restored or changed since it has been found isn't erased
+func (c *customerStore) EraseSubject(ctx context.Context, customerID int, mode models.ErasureMode, before time.Time) (result models.Customer, _ error) {
+	if !mode.Valid() {
+		return models.Customer{}, fmt.Errorf("unknown erasure mode %q", mode)
+	}
+	err := c.tx.InTx(ctx, func(ctx context.Context) error {
+		tx := conn(ctx, c.db)
+		query := append(selectExpr(), "WHERE id = $1 FOR UPDATE")
+		customer, err := c.scanRow(ctx, tx.QueryRowContext(ctx, strings.Join(query, " "), customerID))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if !before.IsZero() {
+			if err := c.checkRetention(ctx, customerID, mode, before); err != nil {
+				return err
+			}
+		}
+		if mode == models.ErasureDelete {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+CustomerTable+" WHERE id = $1", customerID); err != nil {
+				return err
+			}
+			if err := notifyChange(ctx, tx, models.CustomerChange{Type: models.ChangeDelete, ID: customerID}); err != nil {
+				return err
+			}
+		} else {
+			result = customer.Anonymized()
+			email, address, emailIndex, err := c.sealFields(ctx, result)
+			if err != nil {
+				return err
+			}
+			query := "UPDATE " + CustomerTable + ` SET lastname = $1, firstname = $2, birthdate = $3, email = $4, address = $5, email_index = $6,
+				anonymized_at = (now() AT TIME ZONE 'utc') WHERE id = $7 RETURNING xmin`
+			row := tx.QueryRowContext(ctx, query, result.LastName, result.FirstName, result.BirthDate, email, address, emailIndex, customerID)
+			if err := row.Scan(&result.Revision); err != nil {
+				return err
+			}
+			if err := notifyChange(ctx, tx, models.CustomerChange{Type: models.ChangeUpdate, ID: customerID, Revision: result.Revision}); err != nil {
+				return err
+			}
+		}
+		// events keep their type and the names of changed fields, so that the history of the customer still adds up
+		scrub := "UPDATE " + OutboxTable + ` SET payload = jsonb_build_object('changes', COALESCE(
+			(SELECT jsonb_agg(jsonb_build_object('field', change->'field')) FROM jsonb_array_elements(payload->'changes') change), '[]'::jsonb))
+			WHERE customer_id = $1`
+		if _, err := tx.ExecContext(ctx, scrub, customerID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+WebhookDeliveryTable+" WHERE customer_id = $1", customerID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE "+InboundTable+" SET body = '' WHERE customer_id = $1", customerID)
+		return err
+	})
+	if err != nil {
+		return models.Customer{}, errors.Wrapf(err, "erase customer %v", customerID)
+	}
+	return result, nil
+}
+
+// checkRetention returns ErrChanged unless the retention rule of the mode applies to the locked customer as of before:
+// purged customers must have been deleted before it, anonymized ones must have been left unchanged since it
+func (c *customerStore) checkRetention(ctx context.Context, customerID int, mode models.ErasureMode, before time.Time) error {
+	query := "SELECT deleted_at < $2 FROM " + CustomerTable + " WHERE id = $1"
+	if mode == models.ErasureAnonymize {
+		query = "SELECT updated_at < $2 AND deleted_at IS NULL AND anonymized_at IS NULL FROM " + CustomerTable + " WHERE id = $1"
+	}
+	var applies sql.NullBool
+	if err := conn(ctx, c.db).QueryRowContext(ctx, query, customerID, before.UTC()).Scan(&applies); err != nil {
+		return err
+	}
+	if !applies.Bool {
+		return ErrChanged
+	}
+	return nil
+}
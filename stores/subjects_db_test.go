@@ -0,0 +1,62 @@
+package stores_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+func TestEraseSubject(t *testing.T) {
+	db, drop := prepareTestDB(t)
+	defer drop()
+	ctx := context.Background()
+	store := stores.NewCustomerStore(db)
+	subjects := store.(stores.SubjectStore)
+	outbox := stores.NewOutboxStore(db)
+	audit := stores.NewAuditStore(db)
+
+	customer, err := store.CreateCustomer(ctx, generateCustomers(t, 1)[0])
+	require.NoError(t, err)
+	changes := []models.FieldChange{{Field: "email", Old: "old@example.com", New: customer.Email}}
+	_, err = outbox.AppendEvent(ctx, models.Event{Type: models.CustomerUpdated, CustomerID: customer.ID, Customer: &customer, Changes: changes})
+	require.NoError(t, err)
+	_, err = stores.NewRevealStore(db).RecordReveal(ctx, models.Reveal{CustomerID: customer.ID, Actor: "support", Fields: models.MaskedFields})
+	require.NoError(t, err)
+	_, err = audit.AppendAudit(ctx, models.AuditEntry{Action: models.CustomerUpdated, CustomerID: customer.ID, Actor: "support", Fields: []string{"email"}})
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteCustomer(ctx, customer.ID))
+
+	data, err := subjects.CollectSubjectData(ctx, customer.ID)
+	require.NoError(t, err)
+	require.Equal(t, customer.Email, data.Customer.Email, "deleted customers are collected too")
+	require.NotNil(t, data.DeletedAt)
+	require.Len(t, data.Events, 1)
+	require.Equal(t, changes[0].Old, data.Events[0].Changes[0].Old)
+	require.Len(t, data.Reveals, 1)
+	require.Len(t, data.Audit, 1)
+
+	erased, err := subjects.EraseSubject(ctx, customer.ID, models.ErasureAnonymize, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, customer.Anonymized().Email, erased.Email)
+	data, err = subjects.CollectSubjectData(ctx, customer.ID)
+	require.NoError(t, err)
+	require.Equal(t, erased.FirstName, data.Customer.FirstName)
+	require.Nil(t, data.Events[0].Customer)
+	require.Equal(t, []models.FieldChange{{Field: "email"}}, data.Events[0].Changes, "only names of changed fields are left")
+	require.Len(t, data.Reveals, 1, "reveals are kept")
+	require.Len(t, data.Audit, 1, "the audit trail is kept")
+
+	_, err = subjects.EraseSubject(ctx, customer.ID, models.ErasureDelete, time.Time{})
+	require.NoError(t, err)
+	data, err = subjects.CollectSubjectData(ctx, customer.ID)
+	require.NoError(t, err)
+	require.Nil(t, data.Customer)
+	_, err = subjects.EraseSubject(ctx, customer.ID, models.ErasureDelete, time.Time{})
+	require.Equal(t, stores.ErrNotFound, errors.Cause(err))
+}
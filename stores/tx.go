@@ -0,0 +1,63 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+)
+
+type txKey struct{}
+
+// Transactor runs functions within a database transaction.
+// Stores called with the context passed to the function share the transaction
+type Transactor interface {
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// NewTransactor creates a transactor for the given database connection
+func NewTransactor(db *sql.DB) Transactor {
+	return &sqlTransactor{
+		db: db,
+	}
+}
+
+type sqlTransactor struct {
+	db *sql.DB
+}
+
+// InTx begins a transaction, unless the context already has one, and commits it if the function succeeds
+func (t *sqlTransactor) InTx(ctx context.Context, fn func(ctx context.Context) error) (gerr error) {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+		if gerr != nil {
+			tx.Rollback()
+		} else if err := tx.Commit(); err != nil {
+			gerr = err
+		}
+	}()
+	return fn(context.WithValue(ctx, txKey{}, tx))
+}
+
+// querier is a common interface of a database connection and a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the transaction of the given context, if any, or the database connection otherwise
+func conn(ctx context.Context, db *sql.DB) querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
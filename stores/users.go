@@ -0,0 +1,273 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+const (
+	// UserTable is the name for table that contains users
+	UserTable = "users"
+	// SessionTable is the name for table that contains user sessions
+	SessionTable = "sessions"
+	// RecoveryCodeTable is the name for table that contains two-factor recovery codes
+	RecoveryCodeTable = "recovery_codes"
+	// FlashTable is the name for table that contains flash messages of sessions
+	FlashTable = "flashes"
+)
+
+var selectUserExpr = `SELECT id, username, role, password_hash, totp_secret, totp_counter, COALESCE(external_id, ''), created_at FROM ` + UserTable
+
+// NewUserStore creates new user store for the given database connection
+func NewUserStore(db *sql.DB) UserStore {
+	return &userStore{
+		db: db,
+	}
+}
+
+type userStore struct {
+	db *sql.DB
+}
+
+// CreateUser creates the given user and returns it with ID set. It returns ErrDuplicate if the username is taken
+func (s *userStore) CreateUser(ctx context.Context, user models.User) (models.User, error) {
+	query := "INSERT INTO " + UserTable + `(username, role, password_hash, external_id) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id, created_at`
+	result := user
+	if err := conn(ctx, s.db).QueryRowContext(ctx, query, user.Username, string(user.Role), user.PasswordHash, user.ExternalID).Scan(&result.ID, &result.CreatedAt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return models.User{}, ErrDuplicate
+		}
+		return models.User{}, errors.Wrapf(err, "create user")
+	}
+	result.CreatedAt = result.CreatedAt.UTC()
+	return result, nil
+}
+
+// GetUser returns a user by its ID
+func (s *userStore) GetUser(ctx context.Context, id int) (models.User, error) {
+	return s.getUser(ctx, "WHERE id = $1", id)
+}
+
+// GetUserByName returns a user by its username
+func (s *userStore) GetUserByName(ctx context.Context, username string) (models.User, error) {
+	return s.getUser(ctx, "WHERE username = $1", username)
+}
+
+// GetUserByExternalID returns the user linked to the given identity at an identity provider.
+// It returns false if there is no such user
+func (s *userStore) GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool, error) {
+	user, err := s.scanUser(conn(ctx, s.db).QueryRowContext(ctx, selectUserExpr+" WHERE external_id = $1", externalID))
+	if err == sql.ErrNoRows {
+		return models.User{}, false, nil
+	} else if err != nil {
+		return models.User{}, false, errors.Wrapf(err, "get user %v", externalID)
+	}
+	return user, true, nil
+}
+
+// SetUserRole changes the role of the given user
+func (s *userStore) SetUserRole(ctx context.Context, id int, role models.Role) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, "UPDATE "+UserTable+" SET role = $1 WHERE id = $2", string(role), id)
+	if err != nil {
+		return errors.Wrapf(err, "set role of user %v", id)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.Wrapf(err, "set role of user %v", id)
+	} else if affected == 0 {
+		return errors.Wrapf(ErrNotFound, "set role of user %v", id)
+	}
+	return nil
+}
+
+// SetTOTPSecret sets the authenticator secret of the given user and resets the counter of used codes.
+// An empty secret disables two-factor authentication
+func (s *userStore) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	query := "UPDATE " + UserTable + " SET totp_secret = $1, totp_counter = 0 WHERE id = $2"
+	if _, err := conn(ctx, s.db).ExecContext(ctx, query, secret, id); err != nil {
+		return errors.Wrapf(err, "set totp secret of user %v", id)
+	}
+	return nil
+}
+
+// AdvanceTOTPCounter sets the time step of the last accepted code of the given user.
+// It returns false if a code of the same or a later time step has been already accepted
+func (s *userStore) AdvanceTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	query := "UPDATE " + UserTable + " SET totp_counter = $1 WHERE id = $2 AND totp_counter < $1"
+	result, err := conn(ctx, s.db).ExecContext(ctx, query, counter, id)
+	if err != nil {
+		return false, errors.Wrapf(err, "advance totp counter of user %v", id)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "advance totp counter of user %v", id)
+	}
+	return affected == 1, nil
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of the given user with the given hashes
+func (s *userStore) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	query := "DELETE FROM " + RecoveryCodeTable + " WHERE user_id = $1"
+	if _, err := conn(ctx, s.db).ExecContext(ctx, query, userID); err != nil {
+		return errors.Wrapf(err, "delete recovery codes of user %v", userID)
+	}
+	query = "INSERT INTO " + RecoveryCodeTable + "(user_id, hash) SELECT $1, unnest($2::text[])"
+	if _, err := conn(ctx, s.db).ExecContext(ctx, query, userID, pq.Array(hashes)); err != nil {
+		return errors.Wrapf(err, "create recovery codes of user %v", userID)
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the unused recovery code with the given hash as used.
+// It returns false if the user has no such unused code
+func (s *userStore) UseRecoveryCode(ctx context.Context, userID int, hash string, at time.Time) (bool, error) {
+	query := "UPDATE " + RecoveryCodeTable + " SET used_at = $1 WHERE user_id = $2 AND hash = $3 AND used_at IS NULL"
+	result, err := conn(ctx, s.db).ExecContext(ctx, query, at.UTC(), userID, hash)
+	if err != nil {
+		return false, errors.Wrapf(err, "use recovery code of user %v", userID)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "use recovery code of user %v", userID)
+	}
+	return affected > 0, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the given user
+func (s *userStore) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM " + RecoveryCodeTable + " WHERE user_id = $1 AND used_at IS NULL"
+	if err := conn(ctx, s.db).QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, errors.Wrapf(err, "count recovery codes of user %v", userID)
+	}
+	return count, nil
+}
+
+func (s *userStore) getUser(ctx context.Context, where string, arg interface{}) (models.User, error) {
+	user, err := s.scanUser(conn(ctx, s.db).QueryRowContext(ctx, selectUserExpr+" "+where, arg))
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return models.User{}, errors.Wrapf(err, "get user %v", arg)
+	}
+	return user, nil
+}
+
+func (s *userStore) scanUser(scanner rowScanner) (user models.User, _ error) {
+	if err := scanner.Scan(&user.ID, &user.Username, &user.Role, &user.PasswordHash, &user.TOTPSecret, &user.TOTPCounter, &user.ExternalID, &user.CreatedAt); err != nil {
+		return models.User{}, err
+	}
+	user.CreatedAt = user.CreatedAt.UTC()
+	return
+}
+
+// NewSessionStore creates new session store for the given database connection
+func NewSessionStore(db *sql.DB) SessionStore {
+	return &sessionStore{
+		db: db,
+	}
+}
+
+type sessionStore struct {
+	db *sql.DB
+}
+
+// CreateSession saves the given session
+func (s *sessionStore) CreateSession(ctx context.Context, session models.Session) error {
+	query := "INSERT INTO " + SessionTable + `(id, user_id, pending, created_at, last_seen_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err := conn(ctx, s.db).ExecContext(ctx, query, session.ID, session.UserID, session.Pending, session.CreatedAt.UTC(), session.LastSeenAt.UTC())
+	if err != nil {
+		return errors.Wrapf(err, "create session")
+	}
+	return nil
+}
+
+// GetSession returns a session by its ID
+func (s *sessionStore) GetSession(ctx context.Context, id string) (models.Session, error) {
+	var session models.Session
+	query := "SELECT id, user_id, pending, created_at, last_seen_at, totp_secret FROM " + SessionTable + " WHERE id = $1"
+	err := conn(ctx, s.db).QueryRowContext(ctx, query, id).Scan(&session.ID, &session.UserID, &session.Pending, &session.CreatedAt, &session.LastSeenAt, &session.TOTPSecret)
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return models.Session{}, errors.Wrapf(err, "get session")
+	}
+	session.CreatedAt, session.LastSeenAt = session.CreatedAt.UTC(), session.LastSeenAt.UTC()
+	return session, nil
+}
+
+// TouchSession sets the time the session has been used last
+func (s *sessionStore) TouchSession(ctx context.Context, id string, at time.Time) error {
+	_, err := conn(ctx, s.db).ExecContext(ctx, "UPDATE "+SessionTable+" SET last_seen_at = $1 WHERE id = $2", at.UTC(), id)
+	return err
+}
+
+// SetSessionTOTPSecret sets the secret the user of the session is enrolling; an empty secret clears it
+func (s *sessionStore) SetSessionTOTPSecret(ctx context.Context, id, secret string) error {
+	_, err := conn(ctx, s.db).ExecContext(ctx, "UPDATE "+SessionTable+" SET totp_secret = $1 WHERE id = $2", secret, id)
+	return err
+}
+
+// AddFailedAttempt counts a failed attempt to enter the second factor in the session and returns the number of them so far
+func (s *sessionStore) AddFailedAttempt(ctx context.Context, id string) (int, error) {
+	var attempts int
+	query := "UPDATE " + SessionTable + " SET failed_attempts = failed_attempts + 1 WHERE id = $1 RETURNING failed_attempts"
+	err := conn(ctx, s.db).QueryRowContext(ctx, query, id).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "add failed attempt")
+	}
+	return attempts, nil
+}
+
+// DeleteSession deletes a session by its ID
+func (s *sessionStore) DeleteSession(ctx context.Context, id string) error {
+	_, err := conn(ctx, s.db).ExecContext(ctx, "DELETE FROM "+SessionTable+" WHERE id = $1", id)
+	return err
+}
+
+// DeleteStaleSessions deletes sessions that haven't been used since the first given time or have been created before the second one
+func (s *sessionStore) DeleteStaleSessions(ctx context.Context, lastSeenBefore, createdBefore time.Time) error {
+	query := "DELETE FROM " + SessionTable + " WHERE last_seen_at < $1 OR created_at < $2"
+	_, err := conn(ctx, s.db).ExecContext(ctx, query, lastSeenBefore.UTC(), createdBefore.UTC())
+	return err
+}
+
+// AddFlash saves a flash message to be shown on the next page of the given session
+func (s *sessionStore) AddFlash(ctx context.Context, sessionID string, flash models.Flash) error {
+	query := "INSERT INTO " + FlashTable + `(session_id, kind, message, action, action_label) VALUES ($1, $2, $3, $4, $5)`
+	_, err := conn(ctx, s.db).ExecContext(ctx, query, sessionID, string(flash.Kind), flash.Message, flash.Action, flash.ActionLabel)
+	if err != nil {
+		return errors.Wrapf(err, "add flash")
+	}
+	return nil
+}
+
+// TakeFlashes deletes and returns flash messages of the given session in the order they have been added
+func (s *sessionStore) TakeFlashes(ctx context.Context, sessionID string) ([]models.Flash, error) {
+	query := "WITH taken AS (DELETE FROM " + FlashTable + ` WHERE session_id = $1 RETURNING id, kind, message, action, action_label)
+		SELECT kind, message, action, action_label FROM taken ORDER BY id`
+	rows, err := conn(ctx, s.db).QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "take flashes")
+	}
+	defer rows.Close()
+	var flashes []models.Flash
+	for rows.Next() {
+		var flash models.Flash
+		if err := rows.Scan(&flash.Kind, &flash.Message, &flash.Action, &flash.ActionLabel); err != nil {
+			return nil, errors.Wrapf(err, "take flashes")
+		}
+		flashes = append(flashes, flash)
+	}
+	return flashes, rows.Err()
+}
@@ -0,0 +1,189 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+const (
+	// WebhookTable is the name for table that contains webhook subscriptions
+	WebhookTable = "webhooks"
+	// WebhookDeliveryTable is the name for table that contains webhook deliveries
+	WebhookDeliveryTable = "webhook_deliveries"
+)
+
+var selectWebhookExpr = `SELECT id, url, secret, event_types, filter_firstname, filter_lastname, active, created_at FROM ` + WebhookTable
+var selectDeliveryExpr = `SELECT id, webhook_id, event_id, event_type, customer_id, payload, status, attempts, next_attempt_at,
+	request_headers, response_status, response_headers, response_body, error, created_at, updated_at FROM ` + WebhookDeliveryTable
+
+// NewWebhookStore creates new webhook store for the given database connection
+func NewWebhookStore(db *sql.DB, options ...PayloadOption) WebhookStore {
+	return &webhookStore{
+		db:     db,
+		sealer: newFieldSealer(options),
+	}
+}
+
+type webhookStore struct {
+	db     *sql.DB
+	sealer fieldSealer
+}
+
+// CreateWebhook creates the given webhook subscription and returns it with ID set
+func (s *webhookStore) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	query := "INSERT INTO " + WebhookTable + `(url, secret, event_types, filter_firstname, filter_lastname, active)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	row := conn(ctx, s.db).QueryRowContext(ctx, query, webhook.URL, webhook.Secret, pq.Array(eventTypeStrings(webhook.EventTypes)),
+		webhook.Filter.FirstName, webhook.Filter.LastName, webhook.Active)
+	result := webhook
+	if err := row.Scan(&result.ID, &result.CreatedAt); err != nil {
+		return models.Webhook{}, errors.Wrapf(err, "create webhook")
+	}
+	result.CreatedAt = result.CreatedAt.UTC()
+	return result, nil
+}
+
+// ListWebhooks returns all webhook subscriptions
+func (s *webhookStore) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	rows, err := conn(ctx, s.db).QueryContext(ctx, selectWebhookExpr+" ORDER BY id")
+	if err != nil {
+		return nil, errors.Wrapf(err, "query webhooks")
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		webhook, err := s.scanWebhook(rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read webhook from database")
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns a webhook subscription by its ID
+func (s *webhookStore) GetWebhook(ctx context.Context, id int) (models.Webhook, error) {
+	webhook, err := s.scanWebhook(conn(ctx, s.db).QueryRowContext(ctx, selectWebhookExpr+" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return models.Webhook{}, errors.Wrapf(err, "get webhook %v", id)
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook deletes a webhook subscription along with its deliveries
+func (s *webhookStore) DeleteWebhook(ctx context.Context, id int) error {
+	_, err := conn(ctx, s.db).ExecContext(ctx, "DELETE FROM "+WebhookTable+" WHERE id = $1", id)
+	return err
+}
+
+func (s *webhookStore) scanWebhook(scanner rowScanner) (result models.Webhook, _ error) {
+	var eventTypes []string
+	if err := scanner.Scan(&result.ID, &result.URL, &result.Secret, pq.Array(&eventTypes),
+		&result.Filter.FirstName, &result.Filter.LastName, &result.Active, &result.CreatedAt); err != nil {
+		return models.Webhook{}, err
+	}
+	for _, eventType := range eventTypes {
+		result.EventTypes = append(result.EventTypes, models.EventType(eventType))
+	}
+	result.CreatedAt = result.CreatedAt.UTC()
+	return
+}
+
+// CreateDelivery schedules the given delivery unless the event has been already scheduled for the webhook
+func (s *webhookStore) CreateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	payload, err := s.sealer.sealDelivery(ctx, delivery.Payload)
+	if err != nil {
+		return errors.Wrapf(err, "encrypt delivery of event %v to webhook %v", delivery.EventID, delivery.WebhookID)
+	}
+	query := "INSERT INTO " + WebhookDeliveryTable + `(webhook_id, event_id, event_type, customer_id, payload, status, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (webhook_id, event_id) DO NOTHING`
+	_, err = conn(ctx, s.db).ExecContext(ctx, query, delivery.WebhookID, delivery.EventID, string(delivery.EventType),
+		delivery.CustomerID, payload, string(delivery.Status), delivery.NextAttemptAt.UTC())
+	if err != nil {
+		return errors.Wrapf(err, "create delivery of event %v to webhook %v", delivery.EventID, delivery.WebhookID)
+	}
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries of the given webhook
+func (s *webhookStore) ListDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	return s.queryDeliveries(ctx, selectDeliveryExpr+" WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2", webhookID, limit)
+}
+
+// DueDeliveries returns deliveries that should be attempted at the given time
+func (s *webhookStore) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	query := selectDeliveryExpr + ` WHERE status IN ($1, $2) AND next_attempt_at <= $3 ORDER BY next_attempt_at, id LIMIT $4`
+	return s.queryDeliveries(ctx, query, string(models.DeliveryPending), string(models.DeliveryFailed), now.UTC(), limit)
+}
+
+func (s *webhookStore) queryDeliveries(ctx context.Context, query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	rows, err := conn(ctx, s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query webhook deliveries")
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := s.scanDelivery(ctx, rows)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read webhook delivery from database")
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetDelivery returns a webhook delivery by its ID
+func (s *webhookStore) GetDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	delivery, err := s.scanDelivery(ctx, conn(ctx, s.db).QueryRowContext(ctx, selectDeliveryExpr+" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return models.WebhookDelivery{}, errors.Wrapf(err, "get webhook delivery %v", id)
+	}
+	return delivery, nil
+}
+
+// UpdateDelivery saves the state of the given delivery and the result of its last attempt
+func (s *webhookStore) UpdateDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	query := "UPDATE " + WebhookDeliveryTable + ` SET status = $1, attempts = $2, next_attempt_at = $3, request_headers = $4,
+		response_status = $5, response_headers = $6, response_body = $7, error = $8, updated_at = (now() AT TIME ZONE 'utc') WHERE id = $9`
+	_, err := conn(ctx, s.db).ExecContext(ctx, query, string(d.Status), d.Attempts, d.NextAttemptAt.UTC(), d.RequestHeaders,
+		d.ResponseStatus, d.ResponseHeaders, d.ResponseBody, d.Error, d.ID)
+	if err != nil {
+		return errors.Wrapf(err, "update webhook delivery %v", d.ID)
+	}
+	return nil
+}
+
+func (s *webhookStore) scanDelivery(ctx context.Context, scanner rowScanner) (d models.WebhookDelivery, err error) {
+	if err := scanner.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.CustomerID, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.RequestHeaders, &d.ResponseStatus, &d.ResponseHeaders, &d.ResponseBody, &d.Error, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	if d.Payload, err = s.sealer.openDelivery(ctx, d.Payload); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	d.NextAttemptAt, d.CreatedAt, d.UpdatedAt = d.NextAttemptAt.UTC(), d.CreatedAt.UTC(), d.UpdatedAt.UTC()
+	return
+}
+
+func eventTypeStrings(eventTypes []models.EventType) []string {
+	strs := make([]string, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		strs = append(strs, string(eventType))
+	}
+	return strs
+}
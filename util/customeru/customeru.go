@@ -12,6 +12,8 @@ import (
 
 // RandomCustomer generates a valid customer with random life-like data
 func RandomCustomer() models.Customer {
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
 	customer := models.Customer{
 		BirthDate: fakeBirthday(managers.MinCustomerAge, managers.MaxCustomerAge).UTC(),
 		Email:     fake.EmailAddress(),
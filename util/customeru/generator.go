@@ -0,0 +1,208 @@
+package customeru
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/icrowley/fake"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+)
+
+// Age distributions of generated customers
+const (
+	// AgeUniform spreads ages evenly between the minimum and the maximum
+	AgeUniform = "uniform"
+	// AgeNormal gathers ages around the middle of the range
+	AgeNormal = "normal"
+	// AgeYoung leans towards the minimum age
+	AgeYoung = "young"
+	// AgeOld leans towards the maximum age
+	AgeOld = "old"
+)
+
+// AgeDistributions are the known age distributions
+var AgeDistributions = []string{AgeUniform, AgeNormal, AgeYoung, AgeOld}
+
+// MaxGeneratedCustomers is the largest number of customers generated at once
+const MaxGeneratedCustomers = 10000
+
+// GeneratorOptions tell what customers to generate. The same options always produce the same customers
+type GeneratorOptions struct {
+	Seed  int64
+	Count int
+	// Locale is the language of names and addresses, any of Locales
+	Locale          string
+	MinAge, MaxAge  int
+	AgeDistribution string
+	// FemaleRatio is the share of female customers, from 0 to 1
+	FemaleRatio float64
+	// DuplicateRatio is the share of customers that repeat one generated earlier
+	DuplicateRatio float64
+	// InvalidRatio is the share of customers with a deliberately invalid field
+	InvalidRatio float64
+	// Now is the time ages are counted from
+	Now time.Time
+}
+
+// DefaultGeneratorOptions generate valid customers of all allowed ages, half of them female
+func DefaultGeneratorOptions(seed int64, count int) GeneratorOptions {
+	return GeneratorOptions{
+		Seed:            seed,
+		Count:           count,
+		Locale:          "en",
+		MinAge:          managers.MinCustomerAge,
+		MaxAge:          managers.MaxCustomerAge,
+		AgeDistribution: AgeUniform,
+		FemaleRatio:     0.5,
+		Now:             time.Now(),
+	}
+}
+
+// Locales are the languages fake data is available in
+func Locales() []string {
+	return []string{"en", "ru"}
+}
+
+// Validate checks the options and returns all errors it encountered, if any
+func (o GeneratorOptions) Validate() error {
+	var errs managers.MultipleErrors
+	if o.Count <= 0 || o.Count > MaxGeneratedCustomers {
+		errs = append(errs, &managers.FieldError{Field: "count", Code: managers.CodeOutOfRange, Params: map[string]interface{}{"value": o.Count, "min": 1, "max": MaxGeneratedCustomers}})
+	}
+	if !contains(Locales(), o.Locale) {
+		errs = append(errs, &managers.FieldError{Field: "locale", Code: managers.CodeInvalidChoice, Params: map[string]interface{}{"value": o.Locale, "allowed": Locales()}})
+	}
+	if !contains(AgeDistributions, o.AgeDistribution) {
+		errs = append(errs, &managers.FieldError{Field: "ageDistribution", Code: managers.CodeInvalidChoice, Params: map[string]interface{}{"value": o.AgeDistribution, "allowed": AgeDistributions}})
+	}
+	if o.MinAge < managers.MinCustomerAge || o.MinAge > o.MaxAge {
+		errs = append(errs, &managers.FieldError{Field: "minAge", Code: managers.CodeOutOfRange, Params: map[string]interface{}{"value": o.MinAge, "min": managers.MinCustomerAge, "max": min(o.MaxAge, managers.MaxCustomerAge)}})
+	}
+	if o.MaxAge > managers.MaxCustomerAge {
+		errs = append(errs, &managers.FieldError{Field: "maxAge", Code: managers.CodeOutOfRange, Params: map[string]interface{}{"value": o.MaxAge, "min": max(o.MinAge, managers.MinCustomerAge), "max": managers.MaxCustomerAge}})
+	}
+	for field, ratio := range map[string]float64{"femaleRatio": o.FemaleRatio, "duplicateRatio": o.DuplicateRatio, "invalidRatio": o.InvalidRatio} {
+		if ratio < 0 || ratio > 1 || math.IsNaN(ratio) {
+			errs = append(errs, &managers.FieldError{Field: field, Code: managers.CodeOutOfRange, Params: map[string]interface{}{"value": ratio, "min": 0, "max": 1}})
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// Generate produces customers as the options tell. Unlike RandomCustomer, it draws from a random source of its own,
+// so that the same seed always gives the same customers
+func Generate(options GeneratorOptions) ([]models.GeneratedCustomer, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	random := rand.New(rand.NewSource(options.Seed))
+	customers := make([]models.GeneratedCustomer, 0, options.Count)
+	for i := 0; i < options.Count; i++ {
+		var customer models.GeneratedCustomer
+		if i > 0 && random.Float64() < options.DuplicateRatio {
+			customer = duplicate(random, customers)
+		} else {
+			customer = models.GeneratedCustomer{Customer: generateCustomer(random, options), DuplicateOf: -1}
+		}
+		// duplicates of invalid customers are invalid already, one broken field at a time is enough
+		if random.Float64() < options.InvalidRatio && customer.Invalid == "" {
+			invalidate(random, &customer, options.Now)
+		}
+		customers = append(customers, customer)
+	}
+	return customers, nil
+}
+
+func generateCustomer(random *rand.Rand, options GeneratorOptions) models.Customer {
+	customer := models.Customer{
+		BirthDate: birthDate(random, options),
+		Gender:    models.Male,
+	}
+	if random.Float64() < options.FemaleRatio {
+		customer.Gender = models.Female
+	}
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+	fake.Seed(random.Int63())
+	// emails of other languages than English aren't valid, so the locale applies only to names and addresses
+	customer.Email = fake.EmailAddress()
+	_ = fake.SetLang(options.Locale)
+	defer fake.SetLang("en")
+	if customer.Gender == models.Female {
+		customer.FirstName, customer.LastName = fake.FemaleFirstName(), fake.FemaleLastName()
+	} else {
+		customer.FirstName, customer.LastName = fake.MaleFirstName(), fake.MaleLastName()
+	}
+	customer.Address = fake.StreetAddress()
+	return customer
+}
+
+// birthDate returns the birth date of a customer whose age in whole years follows the age distribution
+func birthDate(random *rand.Rand, options GeneratorOptions) time.Time {
+	span := float64(options.MaxAge - options.MinAge + 1)
+	var position float64
+	switch options.AgeDistribution {
+	case AgeNormal:
+		position = math.Min(math.Max(0.5+random.NormFloat64()/6, 0), 0.999)
+	case AgeYoung:
+		position = math.Pow(random.Float64(), 2)
+	case AgeOld:
+		position = math.Min(1-math.Pow(random.Float64(), 2), 0.999)
+	default:
+		position = random.Float64()
+	}
+	age := options.MinAge + int(position*span)
+	now := options.Now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return today.AddDate(-age, 0, -random.Intn(364))
+}
+
+// duplicate repeats a customer generated earlier, with the case of the email changed now and then
+// as people type it differently
+func duplicate(random *rand.Rand, customers []models.GeneratedCustomer) models.GeneratedCustomer {
+	position := random.Intn(len(customers))
+	original := customers[position]
+	for original.DuplicateOf >= 0 {
+		position = original.DuplicateOf
+		original = customers[position]
+	}
+	customer := models.GeneratedCustomer{Customer: original.Customer, Invalid: original.Invalid, DuplicateOf: position}
+	if random.Intn(2) == 0 {
+		customer.Email = strings.ToUpper(customer.Email[:1]) + customer.Email[1:]
+	}
+	return customer
+}
+
+// invalidate breaks one field of the customer in a way the database still accepts
+func invalidate(random *rand.Rand, customer *models.GeneratedCustomer, now time.Time) {
+	switch random.Intn(4) {
+	case 0:
+		customer.FirstName = ""
+		customer.Invalid = "firstName"
+	case 1:
+		customer.Email = strings.Replace(customer.Email, "@", " at ", 1)
+		customer.Invalid = "email"
+	case 2:
+		customer.BirthDate = now.UTC().AddDate(-managers.MinCustomerAge+1, 0, 0).Truncate(24 * time.Hour)
+		customer.Invalid = "birthDate"
+	default:
+		customer.BirthDate = now.UTC().AddDate(-managers.MaxCustomerAge-2, 0, 0).Truncate(24 * time.Hour)
+		customer.Invalid = "birthDate"
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
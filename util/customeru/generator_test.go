@@ -0,0 +1,103 @@
+package customeru_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bearbin/go-age"
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/util/customeru"
+)
+
+func TestGenerateIsReproducible(t *testing.T) {
+	options := customeru.DefaultGeneratorOptions(42, 200)
+	options.Now = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	options.Locale = "ru"
+	options.DuplicateRatio = 0.1
+	options.InvalidRatio = 0.1
+
+	first, err := customeru.Generate(options)
+	require.NoError(t, err)
+	require.Len(t, first, 200)
+	// other users of the fake data in between don't change the outcome
+	customeru.RandomCustomer()
+	second, err := customeru.Generate(options)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+
+	options.Seed = 43
+	other, err := customeru.Generate(options)
+	require.NoError(t, err)
+	require.NotEqual(t, first, other)
+}
+
+func TestGenerateFollowsOptions(t *testing.T) {
+	options := customeru.DefaultGeneratorOptions(7, 1000)
+	options.MinAge, options.MaxAge = 20, 30
+	options.AgeDistribution = customeru.AgeYoung
+	options.FemaleRatio = 0.8
+	options.DuplicateRatio = 0.2
+	options.InvalidRatio = 0.1
+
+	customers, err := customeru.Generate(options)
+	require.NoError(t, err)
+	var female, duplicates, invalid, young int
+	validator := managers.CustomerManager{}
+	for i, customer := range customers {
+		err := validator.ValidateCustomer(customer.Customer)
+		if customer.Invalid != "" {
+			invalid++
+			fields, _ := managers.FieldErrors(err)
+			require.Len(t, fields, 1, "customer %v", i)
+			require.Equal(t, customer.Invalid, fields[0].Field)
+			continue
+		}
+		require.NoError(t, err, "customer %v", i)
+		if customer.DuplicateOf >= 0 {
+			duplicates++
+			original := customers[customer.DuplicateOf]
+			require.True(t, strings.EqualFold(original.Email, customer.Email))
+			require.Equal(t, original.FirstName, customer.FirstName)
+			continue
+		}
+		customerAge := age.AgeAt(customer.BirthDate, options.Now)
+		require.True(t, customerAge >= 20 && customerAge <= 30, "customer %v is %v", i, customerAge)
+		if customerAge < 25 {
+			young++
+		}
+		if customer.Gender == models.Female {
+			female++
+		}
+	}
+	originals := len(customers) - duplicates - invalid
+	require.InDelta(t, 0.8, float64(female)/float64(originals), 0.05)
+	require.InDelta(t, 0.2, float64(duplicates)/float64(len(customers)), 0.05)
+	require.InDelta(t, 0.1, float64(invalid)/float64(len(customers)), 0.03)
+	require.True(t, young > originals/2, "young customers prevail")
+}
+
+func TestGeneratorOptionsValidation(t *testing.T) {
+	options := customeru.DefaultGeneratorOptions(1, 0)
+	options.Locale = "fr"
+	options.MinAge = 10
+	options.FemaleRatio = 1.5
+	_, err := customeru.Generate(options)
+	require.True(t, apperr.Is(err, apperr.Validation))
+	fields, _ := managers.FieldErrors(err)
+	require.Len(t, fields, 4)
+	codes := map[string]string{}
+	for _, field := range fields {
+		codes[field.Field] = field.Code
+	}
+	require.Equal(t, map[string]string{
+		"count":       managers.CodeOutOfRange,
+		"locale":      managers.CodeInvalidChoice,
+		"minAge":      managers.CodeOutOfRange,
+		"femaleRatio": managers.CodeOutOfRange,
+	}, codes)
+}
@@ -0,0 +1,95 @@
+package customeru
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icrowley/fake"
+
+	"github.com/havr/customers/models"
+)
+
+// fakeLock serializes seeding of the fake data generators, whose random source is shared
+var fakeLock sync.Mutex
+
+// Pseudonymizer replaces personal data of customers with fake data deterministically:
+// a value is always replaced by the same fake one for the same key, so duplicates stay duplicates.
+// Gender and the month of birth are kept, so that the gender and age distribution of customers is kept too.
+// Values can't be told from their pseudonyms without the key, so the key must be kept apart from the data
+type Pseudonymizer struct {
+	key []byte
+}
+
+// NewPseudonymizer creates a pseudonymizer with the given secret key
+func NewPseudonymizer(key []byte) *Pseudonymizer {
+	return &Pseudonymizer{key: key}
+}
+
+// Customer returns the customer with its names, birth date, email and address replaced
+func (p *Pseudonymizer) Customer(customer models.Customer) models.Customer {
+	female := customer.Gender == models.Female
+	customer.FirstName = p.fake("firstName", customer.FirstName, func() string {
+		if female {
+			return fake.FemaleFirstName()
+		}
+		return fake.MaleFirstName()
+	})
+	customer.LastName = p.fake("lastName", customer.LastName, func() string {
+		if female {
+			return fake.FemaleLastName()
+		}
+		return fake.MaleLastName()
+	})
+	customer.BirthDate = p.BirthDate(customer.BirthDate)
+	customer.Email = p.Email(customer.Email)
+	customer.Address = p.fake("address", customer.Address, fake.StreetAddress)
+	return customer
+}
+
+// BirthDate moves the date to a day of the same month
+func (p *Pseudonymizer) BirthDate(date time.Time) time.Time {
+	date = date.UTC()
+	first := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	days := first.AddDate(0, 1, -1).Day()
+	seed := p.seed("birthDate", date.Format("2006-01-02"))
+	return first.AddDate(0, 0, int(seed%uint64(days)))
+}
+
+// Email replaces the email, ignoring its case and surrounding spaces as the email index does
+func (p *Pseudonymizer) Email(email string) string {
+	if strings.TrimSpace(email) == "" {
+		return email
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	pseudonym := p.fake("email", email, fake.EmailAddress)
+	// fake emails are picked from a few thousand names, the suffix keeps different emails from becoming duplicates
+	at := strings.LastIndex(pseudonym, "@")
+	return strings.ToLower(pseudonym[:at]) + "." + p.hash("email", email)[:6] + pseudonym[at:]
+}
+
+// fake returns what generate produces once seeded with the keyed hash of the value. Empty values are kept
+func (p *Pseudonymizer) fake(field, value string, generate func() string) string {
+	if value == "" {
+		return value
+	}
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+	fake.Seed(int64(p.seed(field, value)))
+	return generate()
+}
+
+func (p *Pseudonymizer) seed(field, value string) uint64 {
+	sum, _ := hex.DecodeString(p.hash(field, value))
+	return binary.BigEndian.Uint64(sum)
+}
+
+func (p *Pseudonymizer) hash(field, value string) string {
+	mac := hmac.New(sha256.New, p.key)
+	_, _ = mac.Write([]byte(field + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,45 @@
+package customeru_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/util/customeru"
+)
+
+func TestPseudonymizerIsDeterministic(t *testing.T) {
+	pseudonymizer := customeru.NewPseudonymizer([]byte("secret"))
+	customer := models.Customer{
+		ID:        42,
+		FirstName: "Jane",
+		LastName:  "Doe",
+		BirthDate: time.Date(1980, time.February, 14, 0, 0, 0, 0, time.UTC),
+		Gender:    models.Female,
+		Email:     "jane.doe@example.com",
+		Address:   "1 Main Street",
+	}
+
+	pseudonym := pseudonymizer.Customer(customer)
+	require.Equal(t, customer.ID, pseudonym.ID)
+	require.Equal(t, customer.Gender, pseudonym.Gender)
+	require.Equal(t, customer.BirthDate.Year(), pseudonym.BirthDate.Year())
+	require.Equal(t, customer.BirthDate.Month(), pseudonym.BirthDate.Month())
+	require.NotEqual(t, customer.FirstName, pseudonym.FirstName)
+	require.NotEqual(t, customer.Email, pseudonym.Email)
+	require.NotEqual(t, customer.Address, pseudonym.Address)
+
+	duplicate := customer
+	duplicate.ID = 43
+	duplicate.Email = " Jane.Doe@Example.com"
+	again := customeru.NewPseudonymizer([]byte("secret")).Customer(duplicate)
+	again.ID = pseudonym.ID
+	require.Equal(t, pseudonym, again, "duplicates stay duplicates")
+
+	require.NotEqual(t, pseudonym.Email, customeru.NewPseudonymizer([]byte("other")).Customer(customer).Email)
+	other := customer
+	other.Email = "john.doe@example.com"
+	require.NotEqual(t, pseudonym.Email, pseudonymizer.Customer(other).Email)
+}
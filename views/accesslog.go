@@ -0,0 +1,129 @@
+package views
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/models"
+)
+
+const accessLogPageSize = 100
+
+type accessLogData struct {
+	data
+	Filter   models.AccessFilter
+	Query    url.Values
+	Accesses []models.Access
+	Purposes []models.AccessPurpose
+	// ExportLink exports all accesses that match the filter
+	ExportLink string
+	Previous   string
+	Next       string
+}
+
+// accessFilter parses the filter of the access log from the query: customer, user, purpose,
+// and since and until dates, both inclusive
+func accessFilter(query url.Values) (models.AccessFilter, error) {
+	filter := models.AccessFilter{
+		Actor:   query.Get("user"),
+		Purpose: models.AccessPurpose(query.Get("purpose")),
+	}
+	if customer := query.Get("customer"); customer != "" {
+		id, err := strconv.Atoi(customer)
+		if err != nil || id <= 0 {
+			return filter, apperr.New(apperr.Validation, "invalid customer ID %q", customer)
+		}
+		filter.CustomerID = id
+	}
+	if since := query.Get("since"); since != "" {
+		date, err := time.Parse(jsDateLayout, since)
+		if err != nil {
+			return filter, apperr.New(apperr.Validation, "invalid date %q", since)
+		}
+		filter.Since = date
+	}
+	if until := query.Get("until"); until != "" {
+		date, err := time.Parse(jsDateLayout, until)
+		if err != nil {
+			return filter, apperr.New(apperr.Validation, "invalid date %q", until)
+		}
+		filter.Until = date.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func (v *views) accessLogPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := accessFilter(query)
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	page, err := v.page(query)
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	if page == 0 {
+		page = 1
+	}
+	accesses, err := v.accessLog.ListAccesses(r.Context(), filter, (page-1)*accessLogPageSize, accessLogPageSize+1)
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	viewData := accessLogData{
+		data:       v.newData(r, "Access Log"),
+		Filter:     filter,
+		Query:      query,
+		Purposes:   models.AccessPurposes,
+		ExportLink: "/ui/access/export?" + query.Encode(),
+	}
+	if len(accesses) > accessLogPageSize {
+		accesses = accesses[:accessLogPageSize]
+		viewData.Next = accessLogLink(query, page+1)
+	}
+	if page > 1 {
+		viewData.Previous = accessLogLink(query, page-1)
+	}
+	viewData.Accesses = accesses
+	v.executeTemplate(w, "access_log", viewData)
+}
+
+func accessLogLink(query url.Values, page int) string {
+	values := make(url.Values)
+	for k, v := range query {
+		values[k] = v
+	}
+	values.Set("page", strconv.Itoa(page))
+	return "/ui/access?" + values.Encode()
+}
+
+// exportAccessLog streams all accesses that match the filter as CSV
+func (v *views) exportAccessLog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	filter, err := accessFilter(r.URL.Query())
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="access-log.csv"`)
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"id", "customerId", "user", "purpose", "requestId", "accessedAt"})
+	err = v.accessLog.ExportAccesses(ctx, filter, func(access models.Access) error {
+		return out.Write([]string{strconv.FormatInt(access.ID, 10), strconv.Itoa(access.CustomerID), access.Actor,
+			string(access.Purpose), access.RequestID, access.AccessedAt.Format(time.RFC3339)})
+	})
+	out.Flush()
+	if err != nil {
+		// the response has been already started, so the only way to signal the failure is to cut it short
+		fmt.Println("export access log:", err)
+		panic(http.ErrAbortHandler)
+	}
+}
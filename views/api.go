@@ -0,0 +1,48 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/managers"
+)
+
+type apiError struct {
+	Error  string   `json:"error"`
+	Errors []string `json:"errors,omitempty"`
+	// Fields describe validation errors of particular fields
+	Fields []*managers.FieldError `json:"fields,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		fmt.Println("write json response:", err)
+	}
+}
+
+// writeJSONError responds with the given error as JSON. Errors of a known kind get the status of their kind,
+// the given status is used for the rest
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	result := apiError{Error: err.Error()}
+	status = errorStatus(err, status)
+	if merr, ok := err.(managers.MultipleErrors); ok {
+		result.Error = "validation failed"
+		for _, err := range merr {
+			result.Errors = append(result.Errors, err.Error())
+		}
+		result.Fields, _ = managers.FieldErrors(merr)
+	}
+	writeJSON(w, status, result)
+}
+
+func readJSON(r *http.Request, value interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		return errors.Wrapf(err, "decode request body")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package views
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/havr/customers/models"
+)
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+var changeTypes = map[models.EventType]models.ChangeType{
+	models.CustomerCreated:  models.ChangeCreate,
+	models.CustomerUpdated:  models.ChangeUpdate,
+	models.CustomerDeleted:  models.ChangeDelete,
+	models.CustomerRestored: models.ChangeCreate,
+	models.CustomerErased:   models.ChangeUpdate,
+}
+
+type changeEntry struct {
+	Type          models.ChangeType `json:"type"`
+	CustomerID    int               `json:"customerId"`
+	Tombstone     bool              `json:"tombstone,omitempty"`
+	Customer      *models.Customer  `json:"customer,omitempty"`
+	ChangedFields []string          `json:"changedFields,omitempty"`
+	ChangedAt     time.Time         `json:"changedAt"`
+	Cursor        string            `json:"cursor"`
+}
+
+type changesResponse struct {
+	Changes    []changeEntry `json:"changes"`
+	NextCursor string        `json:"nextCursor"`
+	HasMore    bool          `json:"hasMore"`
+}
+
+// apiListChanges returns customer changes made after the given cursor.
+// The cursor of the response should be passed as `since` to get the following changes
+func (v *views) apiListChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since, err := parseCursor(query.Get("since"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := defaultChangesLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 || limit > maxChangesLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxChangesLimit))
+			return
+		}
+	}
+
+	events, err := v.customerManager.ListChanges(r.Context(), since, limit)
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	response := changesResponse{
+		Changes:    []changeEntry{},
+		NextCursor: formatCursor(since),
+		HasMore:    len(events) == limit,
+	}
+	for _, event := range events {
+		entry := changeEntry{
+			Type:       changeTypes[event.Type],
+			CustomerID: event.CustomerID,
+			ChangedAt:  event.OccurredAt,
+			Cursor:     formatCursor(event.Position()),
+		}
+		if event.Type == models.CustomerErased && event.Customer == nil {
+			// the customer has been deleted for good rather than anonymized
+			entry.Type = models.ChangeDelete
+			entry.Tombstone = true
+		} else if event.Type == models.CustomerDeleted {
+			entry.Tombstone = true
+		} else {
+			entry.Customer = event.Customer
+		}
+		for _, change := range event.Changes {
+			entry.ChangedFields = append(entry.ChangedFields, change.Field)
+		}
+		response.Changes = append(response.Changes, entry)
+		response.NextCursor = entry.Cursor
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// formatCursor encodes an outbox position into an opaque cursor
+func formatCursor(position models.EventPosition) string {
+	if position == (models.EventPosition{}) {
+		return ""
+	}
+	raw := strconv.FormatInt(position.TxID, 10) + ":" + strconv.FormatInt(position.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseCursor decodes a cursor made by formatCursor. An empty cursor points to the beginning of the feed
+func parseCursor(cursor string) (models.EventPosition, error) {
+	if cursor == "" {
+		return models.EventPosition{}, nil
+	}
+	invalid := fmt.Errorf("invalid cursor: %q", cursor)
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return models.EventPosition{}, invalid
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 {
+		return models.EventPosition{}, invalid
+	}
+	var position models.EventPosition
+	if position.TxID, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return models.EventPosition{}, invalid
+	}
+	if position.ID, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return models.EventPosition{}, invalid
+	}
+	return position, nil
+}
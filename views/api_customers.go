@@ -0,0 +1,139 @@
+package views
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+)
+
+const (
+	defaultCustomersLimit = 20
+	maxCustomersLimit     = 100
+)
+
+type customersResponse struct {
+	Customers []models.Customer `json:"customers"`
+	Total     int               `json:"total"`
+}
+
+// apiListCustomers returns a page of customers filtered and ordered the same way as the list page
+func (v *views) apiListCustomers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options := v.viewOptions(query)
+	options.Limit = defaultCustomersLimit
+	var err error
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if options.Limit, err = strconv.Atoi(limitStr); err != nil || options.Limit <= 0 || options.Limit > maxCustomersLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxCustomersLimit))
+			return
+		}
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		if options.Offset, err = strconv.Atoi(offsetStr); err != nil || options.Offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("offset must be a non-negative number"))
+			return
+		}
+	}
+	filter := v.getFilter(query)
+
+	response := customersResponse{Customers: []models.Customer{}}
+	if response.Total, err = v.customerManager.CountCustomers(r.Context(), filter); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	customers, err := v.customerManager.ListCustomers(r.Context(), filter, options)
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	response.Customers = append(response.Customers, customers...)
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (v *views) apiGetCustomer(w http.ResponseWriter, r *http.Request) {
+	customer, err := v.customerManager.GetCustomer(r.Context(), v.id(r))
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, customer)
+}
+
+func (v *views) apiRevealCustomer(w http.ResponseWriter, r *http.Request) {
+	customer, err := v.customerManager.RevealCustomer(r.Context(), v.id(r))
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, customer)
+}
+
+func (v *views) apiCreateCustomer(w http.ResponseWriter, r *http.Request) {
+	var customer models.Customer
+	if err := readJSON(r, &customer); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	created, err := v.customerManager.CreateCustomer(r.Context(), customer)
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// apiUpdateCustomer replaces the customer with the request body.
+// The body should carry the revision the client has read, otherwise the update is rejected with 409
+func (v *views) apiUpdateCustomer(w http.ResponseWriter, r *http.Request) {
+	var customer models.Customer
+	if err := readJSON(r, &customer); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	customer.ID = v.id(r)
+	if err := v.customerManager.UpdateCustomer(r.Context(), customer); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	updated, err := v.customerManager.GetCustomer(r.Context(), customer.ID)
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (v *views) apiDeleteCustomer(w http.ResponseWriter, r *http.Request) {
+	if err := v.customerManager.DeleteCustomer(r.Context(), v.id(r)); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiExportCustomers streams all customers that match the filter as CSV
+func (v *views) apiExportCustomers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := managers.Authorize(ctx, models.ExportCustomers); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="customers.csv"`)
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"id", "firstName", "lastName", "birthDate", "gender", "email", "address"})
+	err := v.customerManager.ExportCustomers(ctx, v.getFilter(r.URL.Query()), func(c models.Customer) error {
+		return out.Write([]string{strconv.Itoa(c.ID), c.FirstName, c.LastName, c.BirthDate.Format(jsDateLayout), string(c.Gender), c.Email, c.Address})
+	})
+	out.Flush()
+	if err != nil {
+		// the response has been already started, so the only way to signal the failure is to cut it short
+		fmt.Println("export customers:", err)
+		panic(http.ErrAbortHandler)
+	}
+}
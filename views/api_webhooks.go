@@ -0,0 +1,89 @@
+package views
+
+import (
+	"net/http"
+
+	"github.com/havr/customers/models"
+)
+
+type webhookRequest struct {
+	URL        string                    `json:"url"`
+	Secret     string                    `json:"secret"`
+	EventTypes []models.EventType        `json:"eventTypes"`
+	Filter     models.CustomerListFilter `json:"filter"`
+}
+
+// createdWebhook is the only response that reveals the secret of a webhook, so that it's shown once to whoever created it
+type createdWebhook struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+func (v *views) apiListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := v.webhookManager.ListWebhooks(r.Context())
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []models.Webhook{}
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+func (v *views) apiCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	webhook, err := v.webhookManager.CreateWebhook(r.Context(), models.Webhook{
+		URL:        req.URL,
+		Secret:     req.Secret,
+		EventTypes: req.EventTypes,
+		Filter:     req.Filter,
+		Active:     true,
+	})
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createdWebhook{Webhook: webhook, Secret: webhook.Secret})
+}
+
+func (v *views) apiGetWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, err := v.webhookManager.GetWebhook(r.Context(), v.id(r))
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+func (v *views) apiDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := v.webhookManager.DeleteWebhook(r.Context(), v.id(r)); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (v *views) apiListDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := v.webhookManager.ListDeliveries(r.Context(), v.id(r), deliveryLogSize)
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func (v *views) apiRedeliver(w http.ResponseWriter, r *http.Request) {
+	if err := v.webhookManager.Redeliver(r.Context(), int64(v.id(r))); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
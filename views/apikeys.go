@@ -0,0 +1,74 @@
+package views
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/models"
+)
+
+type apiKeysData struct {
+	data
+	Keys   []models.APIKey
+	Scopes []models.Permission
+	Form   models.APIKey
+	// Created is the value of a just created key, which is shown only once
+	Created string
+}
+
+func (v *views) listAPIKeysPage(w http.ResponseWriter, r *http.Request) {
+	v.renderAPIKeys(w, r, apiKeysData{Form: models.APIKey{Scopes: []models.Permission{models.ReadCustomers}}}, nil)
+}
+
+func (v *views) renderAPIKeys(w http.ResponseWriter, r *http.Request, viewData apiKeysData, formErr error) {
+	viewData.data = v.newData(r, "API Keys")
+	viewData.Scopes = models.APIKeyScopes
+	if formErr != nil {
+		viewData.Error = v.formatErrorHTML(formErr)
+	}
+	keys, err := v.apiKeyManager.ListAPIKeys(r.Context())
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	viewData.Keys = keys
+	v.executeTemplate(w, "apikeys", viewData)
+}
+
+func (v *views) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		v.renderError(w, r, apperr.Wrap(apperr.Validation, err, "parse form"))
+		return
+	}
+	key := models.APIKey{
+		Name:       r.FormValue("name"),
+		AllowedIPs: strings.Fields(strings.Replace(r.FormValue("allowedIps"), ",", " ", -1)),
+	}
+	for _, scope := range r.PostForm["scope"] {
+		key.Scopes = append(key.Scopes, models.Permission(scope))
+	}
+	if expires := r.FormValue("expiresAt"); expires != "" {
+		expiresAt, err := time.Parse(jsDateLayout, expires)
+		if err != nil {
+			v.renderAPIKeys(w, r, apiKeysData{Form: key}, err)
+			return
+		}
+		key.ExpiresAt = &expiresAt
+	}
+	_, token, err := v.apiKeyManager.CreateAPIKey(r.Context(), key)
+	if err != nil {
+		v.renderAPIKeys(w, r, apiKeysData{Form: key}, err)
+		return
+	}
+	v.renderAPIKeys(w, r, apiKeysData{Form: models.APIKey{Scopes: []models.Permission{models.ReadCustomers}}, Created: token}, nil)
+}
+
+func (v *views) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := v.apiKeyManager.RevokeAPIKey(r.Context(), v.id(r)); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	redirect(w, r, "/ui/apikeys")
+}
@@ -0,0 +1,198 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+)
+
+const sessionCookie = "session"
+
+type loginData struct {
+	data
+	Username string
+	ReturnTo string
+	// SSO tells whether users can log in through an identity provider
+	SSO bool
+}
+
+// requireUser lets the request through only if it belongs to a valid user session.
+// API requests may authenticate with an API key instead.
+// Browsers are redirected to the login page, API clients get 401
+func (v *views) requireUser(next http.Handler) http.Handler {
+	return v.authenticate(next, false)
+}
+
+// requireEnrollingUser is like requireUser, but also lets through users who have to enroll an authenticator
+func (v *views) requireEnrollingUser(next http.Handler) http.Handler {
+	return v.authenticate(next, true)
+}
+
+func (v *views) authenticate(next http.Handler, allowEnrollment bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := apiKeyToken(r); token != "" && isAPIRequest(r) {
+			key, err := v.apiKeyManager.Authenticate(r.Context(), token, v.clientIP(r))
+			if err == managers.ErrInvalidAPIKey {
+				writeJSONError(w, http.StatusUnauthorized, err)
+				return
+			} else if err != nil {
+				v.renderError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(managers.WithAPIKey(r.Context(), key)))
+			return
+		}
+
+		var token string
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			token = cookie.Value
+		}
+		user, err := v.userManager.ResolveSession(r.Context(), token)
+		switch {
+		case err == managers.ErrSessionExpired:
+			v.unauthorized(w, r)
+			return
+		case err == managers.ErrSecondFactorRequired && !isAPIRequest(r):
+			http.Redirect(w, r, "/login/2fa?return_to="+url.QueryEscape(returnPath(r)), http.StatusFound)
+			return
+		case err == managers.ErrTwoFactorEnrollmentRequired && allowEnrollment:
+		case err == managers.ErrTwoFactorEnrollmentRequired && !isAPIRequest(r):
+			http.Redirect(w, r, "/account/2fa", http.StatusFound)
+			return
+		case err == managers.ErrSecondFactorRequired || err == managers.ErrTwoFactorEnrollmentRequired:
+			writeJSONError(w, http.StatusUnauthorized, err)
+			return
+		case err != nil:
+			v.renderError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(managers.WithUser(r.Context(), user)))
+	})
+}
+
+// apiKeyToken returns the API key passed either as a bearer token or in the X-API-Key header
+func apiKeyToken(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	const bearer = "Bearer "
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearer) {
+		return strings.TrimSpace(auth[len(bearer):])
+	}
+	return ""
+}
+
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// requirePermission lets the request through only if the current user has the given permission
+func (v *views) requirePermission(permission models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := managers.Authorize(r.Context(), permission); err != nil {
+				v.renderError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (v *views) unauthorized(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("authentication required"))
+		return
+	}
+	http.Redirect(w, r, "/login?return_to="+url.QueryEscape(returnPath(r)), http.StatusFound)
+}
+
+// returnPath returns the path to return to after logging in
+func returnPath(r *http.Request) string {
+	if r.Method == http.MethodGet {
+		return r.URL.RequestURI()
+	}
+	return ""
+}
+
+func (v *views) loginPage(w http.ResponseWriter, r *http.Request) {
+	viewData := loginData{
+		data:     v.newData(r, "Log In"),
+		ReturnTo: r.FormValue("return_to"),
+		SSO:      v.oidc != nil,
+	}
+	if r.Method == http.MethodPost {
+		viewData.Username = r.FormValue("username")
+		user, err := v.userManager.Authenticate(r.Context(), viewData.Username, r.FormValue("password"))
+		if err == nil {
+			var token string
+			if token, err = v.userManager.StartSession(r.Context(), user); err == nil {
+				setSessionCookie(w, r, token)
+				if user.TwoFactorEnabled() {
+					redirect(w, r, "/login/2fa?return_to="+url.QueryEscape(viewData.ReturnTo))
+					return
+				}
+				redirectBack(w, r, "/ui/customer/list")
+				return
+			}
+		}
+		viewData.Error = v.formatErrorHTML(err)
+	}
+	v.executeTemplate(w, "login", viewData)
+}
+
+// secondFactorPage completes a login with an authenticator or a recovery code
+func (v *views) secondFactorPage(w http.ResponseWriter, r *http.Request) {
+	viewData := loginData{
+		data:     v.newData(r, "Two-Factor Authentication"),
+		ReturnTo: r.FormValue("return_to"),
+	}
+	if r.Method == http.MethodPost {
+		var token string
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			token = cookie.Value
+		}
+		token, err := v.userManager.VerifySecondFactor(r.Context(), token, r.FormValue("code"))
+		if err == managers.ErrSessionExpired {
+			redirect(w, r, "/login?return_to="+url.QueryEscape(viewData.ReturnTo))
+			return
+		} else if err == nil {
+			setSessionCookie(w, r, token)
+			redirectBack(w, r, "/ui/customer/list")
+			return
+		}
+		viewData.Error = v.formatErrorHTML(err)
+	}
+	v.executeTemplate(w, "login_2fa", viewData)
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (v *views) logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := v.userManager.EndSession(r.Context(), cookie.Value); err != nil {
+			v.renderError(w, r, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	redirect(w, r, "/login")
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/havr/customers/managers"
 	"github.com/havr/customers/models"
 )
 
@@ -17,35 +18,62 @@ type customerData struct {
 	data
 	Edit     bool
 	Customer models.Customer
+	// Masked tells that personal data of the customer is masked, since the user may not view it
+	Masked bool
+	// Revealed tells that personal data has been revealed to the user, which has been recorded
+	Revealed bool
 }
 
 func (v *views) createCustomerPage(w http.ResponseWriter, r *http.Request) {
 	viewData := customerData{
-		data: data{
-			Title: "Create a Customer",
-		},
+		data: v.newData(r, "Create a Customer"),
 		Customer: models.Customer{
 			BirthDate: time.Now().Add(-time.Duration(365*30) * 24 * time.Hour),
 			Gender:    models.Female,
 		},
 	}
+	if err := managers.Authorize(r.Context(), models.WriteCustomers); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
 	if r.Method == http.MethodPost {
 		customer, err := v.getCustomer(r)
+		var created models.Customer
 		if err == nil {
-			_, err = v.customerManager.CreateCustomer(r.Context(), customer)
+			created, err = v.customerManager.CreateCustomer(r.Context(), customer)
 		}
 		if err == nil {
+			v.flash(r, models.Flash{Kind: models.FlashSuccess, Message: fmt.Sprintf("Customer #%d created", created.ID)})
 			redirect(w, r, "/ui/customer/list")
 			return
 		}
-		viewData.Error = v.formatErrorHTML(err)
+		v.formError(&viewData.data, err)
 		viewData.Customer = customer
 	}
 	v.executeTemplate(w, "create_edit", viewData)
 }
 
+// formatErrorHTML escapes the error message, which may echo user input, and breaks it into lines
 func (v *views) formatErrorHTML(err error) template.HTML {
-	return template.HTML(strings.Replace(err.Error(), "\n", "<br/>", -1))
+	return template.HTML(strings.Replace(template.HTMLEscapeString(err.Error()), "\n", "<br/>", -1))
+}
+
+// formError shows field errors next to their inputs and the rest of the error above the form
+func (v *views) formError(d *data, err error) {
+	fields, other := managers.FieldErrors(err)
+	d.FieldErrors = make(map[string]string)
+	for _, fieldErr := range fields {
+		if message, ok := d.FieldErrors[fieldErr.Field]; ok {
+			d.FieldErrors[fieldErr.Field] = message + "; " + fieldErr.Error()
+		} else {
+			d.FieldErrors[fieldErr.Field] = fieldErr.Error()
+		}
+	}
+	if len(other) != 0 {
+		d.Error = v.formatErrorHTML(managers.MultipleErrors(other))
+	} else {
+		d.Error = "Please correct the highlighted fields."
+	}
 }
 
 func (v *views) getCustomer(r *http.Request) (models.Customer, error) {
@@ -66,7 +94,7 @@ func (v *views) getCustomer(r *http.Request) (models.Customer, error) {
 	if birthDateStr != "" {
 		birthDate, err := time.Parse(jsDateLayout, birthDateStr)
 		if err != nil {
-			return models.Customer{}, errors.Wrapf(err, "parse birth date")
+			return customer, managers.MultipleErrors{&managers.FieldError{Field: "birthDate", Code: managers.CodeInvalidFormat}}
 		}
 		customer.BirthDate = birthDate
 	}
@@ -74,7 +102,7 @@ func (v *views) getCustomer(r *http.Request) (models.Customer, error) {
 	customer.Email = r.FormValue("email")
 	gender := r.FormValue("gender")
 	if !models.IsValidGender(gender) {
-		return models.Customer{}, fmt.Errorf("unknown gender: %v", gender)
+		return customer, managers.MultipleErrors{managers.InvalidGender(gender)}
 	}
 	customer.Gender = models.Gender(gender)
 	return customer, nil
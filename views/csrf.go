@@ -0,0 +1,84 @@
+package views
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/havr/customers/apperr"
+)
+
+const (
+	csrfCookie = "csrf_token"
+	// csrfField is the form field forms pass the token in
+	csrfField = "csrf_token"
+	// csrfHeader is the header scripts and API clients that authenticate with the session cookie pass the token in
+	csrfHeader = "X-CSRF-Token"
+	// inboundPath receives inbound customer updates, which are authenticated by their signature rather than by cookies
+	inboundPath = "/api/v1/inbound/customers"
+)
+
+type csrfKey struct{}
+
+// protectCSRF implements double-submit cookie protection against cross-site request forgery.
+// Every browser gets a random token in a cookie, and state-changing requests must echo it in a form field or a header,
+// which other sites can't do since they can't read the cookie. Requests authenticated with an API key carry no cookies
+// and are let through
+func (v *views) protectCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+			token = cookie.Value
+		} else {
+			raw := make([]byte, 32)
+			if _, err := rand.Read(raw); err != nil {
+				v.renderError(w, r, err)
+				return
+			}
+			token = base64.RawURLEncoding.EncodeToString(raw)
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		if !safeMethod(r.Method) && !csrfExempt(r) {
+			sent := r.Header.Get(csrfHeader)
+			if sent == "" {
+				sent = r.PostFormValue(csrfField)
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				fmt.Println("csrf: rejected", r.Method, r.URL.Path, "from", v.clientIP(r), "referer", r.Header.Get("Referer"))
+				v.csrfRejected(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+	})
+}
+
+func (v *views) csrfRejected(w http.ResponseWriter, r *http.Request) {
+	v.renderError(w, r, apperr.New(apperr.PermissionDenied, "invalid or missing CSRF token, please reload the page and try again"))
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// csrfExempt tells whether the request can't carry a token: inbound deliveries, requests with an API key
+// and violation reports browsers post on their own
+func csrfExempt(r *http.Request) bool {
+	return r.URL.Path == inboundPath || r.URL.Path == cspReportPath || (isAPIRequest(r) && apiKeyToken(r) != "")
+}
+
+// csrfToken returns the token forms of the page should carry
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
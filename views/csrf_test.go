@@ -0,0 +1,67 @@
+package views_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/views"
+)
+
+const resourceDir = "../resources/web"
+
+func TestCSRFProtection(t *testing.T) {
+	handler := views.NewHandler(views.Services{}, resourceDir)
+
+	// a browser gets its token with the first page it loads
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/static/index.css", nil))
+	var token *http.Cookie
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == "csrf_token" {
+			token = cookie
+		}
+	}
+	require.NotNil(t, token)
+	require.True(t, token.HttpOnly)
+
+	logout := func(cookie *http.Cookie, form url.Values, header string) int {
+		req := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	require.Equal(t, http.StatusForbidden, logout(nil, nil, ""), "a request without a token is rejected")
+	require.Equal(t, http.StatusForbidden, logout(token, nil, ""))
+	require.Equal(t, http.StatusForbidden, logout(token, url.Values{"csrf_token": {"forged"}}, ""))
+	require.Equal(t, http.StatusForbidden, logout(nil, url.Values{"csrf_token": {token.Value}}, ""), "a token without the cookie is rejected")
+	require.Equal(t, http.StatusFound, logout(token, url.Values{"csrf_token": {token.Value}}, ""))
+	require.Equal(t, http.StatusFound, logout(token, nil, token.Value))
+}
+
+func TestFormsCarryCSRFToken(t *testing.T) {
+	postForm := regexp.MustCompile(`(?is)<form[^>]*method="post"[^>]*>(.*?)</form>`)
+	files, err := filepath.Glob(filepath.Join(resourceDir, "templates/*.tmpl"))
+	require.NoError(t, err)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		for _, form := range postForm.FindAllStringSubmatch(string(content), -1) {
+			require.Contains(t, form[1], `{{template "csrf" $}}`, "a form in %s has no CSRF token", file)
+		}
+	}
+}
@@ -1,15 +1,35 @@
 package views
 
 import (
+	"fmt"
 	"net/http"
+
+	"github.com/havr/customers/models"
 )
 
 func (v *views) deleteCustomer(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	err := v.customerManager.DeleteCustomer(ctx, v.id(r))
+	id := v.id(r)
+	err := v.customerManager.DeleteCustomer(ctx, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		v.renderError(w, r, err)
+		return
+	}
+	v.flash(r, models.Flash{
+		Kind:        models.FlashSuccess,
+		Message:     fmt.Sprintf("Customer #%d deleted", id),
+		Action:      fmt.Sprintf("/ui/customer/restore/%d", id),
+		ActionLabel: "Undo",
+	})
+	redirectBack(w, r, "/ui/customer/list")
+}
+
+func (v *views) restoreCustomer(w http.ResponseWriter, r *http.Request) {
+	id := v.id(r)
+	if err := v.customerManager.RestoreCustomer(r.Context(), id); err != nil {
+		v.renderError(w, r, err)
 		return
 	}
-	redirect(w, r, "")
+	v.flash(r, models.Flash{Kind: models.FlashSuccess, Message: fmt.Sprintf("Customer #%d restored", id)})
+	redirectBack(w, r, "/ui/customer/list")
 }
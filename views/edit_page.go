@@ -4,22 +4,27 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/havr/customers/stores"
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
 )
 
 func (v *views) editCustomerPage(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	ctx := managers.WithAccessPurpose(r.Context(), models.AccessEdit)
 	viewData := customerData{
-		data: data{
-			Title: "Edit a Customer",
-		},
-		Edit: true,
+		data:   v.newData(r, "Edit a Customer"),
+		Edit:   true,
+		Masked: managers.Authorize(ctx, models.ViewPII) != nil,
+	}
+	if err := managers.Authorize(ctx, models.WriteCustomers); err != nil {
+		v.renderError(w, r, err)
+		return
 	}
 	if r.Method == http.MethodPost {
 		customer, err := v.getCustomer(r)
 		if err == nil {
 			err = v.customerManager.UpdateCustomer(r.Context(), customer)
-			if err == stores.ErrChanged {
+			if apperr.Is(err, apperr.Conflict) {
 				err = fmt.Errorf("somebody has already updated the customer")
 				if newcustomer, geterr := v.customerManager.GetCustomer(ctx, customer.ID); geterr != nil {
 					err = geterr
@@ -28,17 +33,21 @@ func (v *views) editCustomerPage(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		if err == nil {
+		if apperr.Is(err, apperr.NotFound) {
+			v.renderError(w, r, err)
+			return
+		} else if err == nil {
+			v.flash(r, models.Flash{Kind: models.FlashSuccess, Message: fmt.Sprintf("Customer #%d updated", customer.ID)})
 			redirect(w, r, "/ui/customer/list")
 			return
 		}
 
-		viewData.Error = v.formatErrorHTML(err)
+		v.formError(&viewData.data, err)
 		viewData.Customer = customer
 	} else {
 		customer, err := v.customerManager.GetCustomer(ctx, v.id(r))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			v.renderError(w, r, err)
 			return
 		}
 		viewData.Customer = customer
@@ -0,0 +1,82 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+)
+
+// errorStatuses are the statuses errors of each kind are responded with
+var errorStatuses = map[apperr.Kind]int{
+	apperr.NotFound:         http.StatusNotFound,
+	apperr.Conflict:         http.StatusConflict,
+	apperr.Validation:       http.StatusUnprocessableEntity,
+	apperr.PermissionDenied: http.StatusForbidden,
+	apperr.Unavailable:      http.StatusServiceUnavailable,
+	apperr.RateLimited:      http.StatusTooManyRequests,
+}
+
+// errInternal replaces details of internal errors in production, which may reveal queries, paths and the like
+var errInternal = fmt.Errorf("internal server error")
+
+type errorData struct {
+	data
+	Status int
+	// Message is empty if the details of the error are hidden
+	Message string
+	// Permission is the missing permission if the error is a permission error
+	Permission models.Permission
+	RequestID  string
+}
+
+// errorStatus returns the status to respond with the given error, or the fallback one if the error is of no known kind
+func errorStatus(err error, fallback int) int {
+	if status, ok := errorStatuses[apperr.KindOf(err)]; ok {
+		return status
+	}
+	return fallback
+}
+
+// renderError responds with the given error, the status follows from its kind, errors of no kind are internal errors.
+// API requests get a JSON body, browsers get the "error_<status>" page, or the generic "error" one if there is none.
+// Internal errors are logged with the request ID, and their details are hidden from users in production
+func (v *views) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err, http.StatusInternalServerError)
+	requestID := managers.RequestIDFromContext(r.Context())
+	if status >= http.StatusInternalServerError {
+		fmt.Printf("%s %s (request %s): %v\n", r.Method, r.URL.Path, requestID, err)
+		if v.production {
+			err = errInternal
+		}
+	}
+	if isAPIRequest(r) {
+		writeJSONError(w, status, err)
+		return
+	}
+	viewData := errorData{
+		data:      v.newData(r, http.StatusText(status)),
+		Status:    status,
+		RequestID: requestID,
+	}
+	if err != errInternal {
+		viewData.Message = err.Error()
+	}
+	if permErr, ok := err.(managers.PermissionError); ok {
+		viewData.Permission = permErr.Permission
+	}
+	name := fmt.Sprintf("error_%d", status)
+	if v.template.Lookup(name) == nil {
+		name = "error"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	v.executeTemplate(w, name, viewData)
+}
+
+// notFound renders the 404 page for routes that don't exist
+func (v *views) notFound(w http.ResponseWriter, r *http.Request) {
+	v.renderError(w, r, apperr.New(apperr.NotFound, "page %s not found", r.URL.Path))
+}
@@ -0,0 +1,43 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+func TestErrorStatus(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		status int
+	}{
+		{errors.Wrapf(stores.ErrNotFound, "get customer 1"), http.StatusNotFound},
+		{stores.ErrChanged, http.StatusConflict},
+		{managers.MultipleErrors{managers.ErrInvalidEmail}, http.StatusUnprocessableEntity},
+		{managers.PermissionError{Permission: models.ReadCustomers}, http.StatusForbidden},
+		{managers.ErrNoChangeFeed, http.StatusServiceUnavailable},
+		{fmt.Errorf("connection refused"), http.StatusInternalServerError},
+	} {
+		require.Equal(t, test.status, errorStatus(test.err, http.StatusInternalServerError), test.err.Error())
+	}
+	require.Equal(t, http.StatusBadRequest, errorStatus(fmt.Errorf("decode request body"), http.StatusBadRequest))
+}
+
+func TestRenderAPIError(t *testing.T) {
+	v := &views{}
+	w := httptest.NewRecorder()
+	v.renderError(w, httptest.NewRequest(http.MethodGet, "/api/v1/customers/1", nil), errors.Wrapf(stores.ErrNotFound, "get customer 1"))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	var body apiError
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	require.Equal(t, "get customer 1: not found", body.Error)
+}
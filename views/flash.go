@@ -0,0 +1,37 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+)
+
+// flash saves a message to be shown on the page the browser is redirected to.
+// Messages are kept in the user session, so requests without one don't get them
+func (v *views) flash(r *http.Request, flash models.Flash) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return
+	}
+	if err := v.userManager.AddFlash(r.Context(), cookie.Value, flash); err != nil {
+		fmt.Println("add flash:", err)
+	}
+}
+
+// takeFlashes returns the messages saved for the current page of a signed in user
+func (v *views) takeFlashes(r *http.Request) []models.Flash {
+	if _, ok := managers.UserFromContext(r.Context()); !ok {
+		return nil
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	flashes, err := v.userManager.TakeFlashes(r.Context(), cookie.Value)
+	if err != nil {
+		fmt.Println("take flashes:", err)
+	}
+	return flashes
+}
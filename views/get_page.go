@@ -2,20 +2,40 @@ package views
 
 import (
 	"net/http"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
 )
 
 func (v *views) viewCustomerPage(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	data := customerData{
-		data: data{
-			Title: "View a customer",
-		},
+		data:   v.newData(r, "View a customer"),
+		Masked: managers.Authorize(ctx, models.ViewPII) != nil,
 	}
 	var err error
 	data.Customer, err = v.customerManager.GetCustomer(ctx, v.id(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		v.renderError(w, r, err)
+		return
+	}
+	v.executeTemplate(w, "view", data)
+}
+
+// revealCustomer shows the customer with personal data unmasked. It's a form post rather than a link,
+// since every reveal is recorded
+func (v *views) revealCustomer(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data := customerData{
+		data:     v.newData(r, "View a customer"),
+		Revealed: true,
+	}
+	var err error
+	data.Customer, err = v.customerManager.RevealCustomer(ctx, v.id(r))
+	if err != nil {
+		v.renderError(w, r, err)
 		return
 	}
+	w.Header().Set("Cache-Control", "no-store")
 	v.executeTemplate(w, "view", data)
 }
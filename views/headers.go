@@ -0,0 +1,151 @@
+package views
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cspReportPath collects reports of Content Security Policy violations from browsers
+	cspReportPath = "/csp-report"
+	// cspNoncePlaceholder is replaced by the nonce of the response in the policy
+	cspNoncePlaceholder = "{nonce}"
+	maxCSPReport        = 64 << 10
+)
+
+// DefaultCSP lets pages load scripts, styles and images of the application only. Scripts must carry the nonce of the page
+var DefaultCSP = strings.Join([]string{
+	"default-src 'self'",
+	"script-src 'self' 'nonce-" + cspNoncePlaceholder + "'",
+	"style-src 'self'",
+	"img-src 'self' data:",
+	"connect-src 'self'",
+	"object-src 'none'",
+	"base-uri 'self'",
+	"form-action 'self'",
+	"frame-ancestors 'none'",
+	"report-uri " + cspReportPath,
+}, "; ")
+
+// SecurityPolicy configures security headers of responses
+type SecurityPolicy struct {
+	// CSP is the Content Security Policy. {nonce} is replaced by a random nonce of each response,
+	// which pages put on their script tags
+	CSP string
+	// CSPReportOnly makes browsers report violations of the policy to /csp-report rather than enforce it
+	CSPReportOnly bool
+	// HSTSMaxAge is how long browsers should only connect over TLS. It's only sent over TLS, and not at all if it's 0
+	HSTSMaxAge        time.Duration
+	ReferrerPolicy    string
+	PermissionsPolicy string
+}
+
+// DefaultSecurityPolicy is used if none is given
+var DefaultSecurityPolicy = SecurityPolicy{
+	CSP:               DefaultCSP,
+	HSTSMaxAge:        180 * 24 * time.Hour,
+	ReferrerPolicy:    "same-origin",
+	PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+}
+
+type cspNonceKey struct{}
+
+// secureHeaders sets security headers of every response and gives pages a nonce for their scripts
+func (v *views) secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := v.security
+		raw := make([]byte, 16)
+		if _, err := rand.Read(raw); err != nil {
+			v.renderError(w, r, err)
+			return
+		}
+		nonce := base64.StdEncoding.EncodeToString(raw)
+
+		header := w.Header()
+		if policy.CSP != "" {
+			name := "Content-Security-Policy"
+			if policy.CSPReportOnly {
+				name = "Content-Security-Policy-Report-Only"
+			}
+			header.Set(name, strings.Replace(policy.CSP, cspNoncePlaceholder, nonce, -1))
+		}
+		// browsers ignore frame-ancestors of report-only policies, and older ones don't know it at all
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("X-Content-Type-Options", "nosniff")
+		if policy.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", policy.ReferrerPolicy)
+		}
+		if policy.PermissionsPolicy != "" {
+			header.Set("Permissions-Policy", policy.PermissionsPolicy)
+		}
+		if r.TLS != nil && policy.HSTSMaxAge > 0 {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(policy.HSTSMaxAge.Seconds()))+"; includeSubDomains")
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce)))
+	})
+}
+
+// cspNonce returns the nonce script tags of the page should carry
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// cspViolation is a violation as reported with report-uri
+type cspViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	BlockedURI         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+}
+
+// cspReport logs violations of the Content Security Policy browsers report.
+// Both report-uri reports and Reporting API ones are accepted
+func (v *views) cspReport(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReport))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	var legacy struct {
+		Report *cspViolation `json:"csp-report"`
+	}
+	var reports []struct {
+		Type string          `json:"type"`
+		Body json.RawMessage `json:"body"`
+	}
+	switch {
+	case json.Unmarshal(body, &legacy) == nil && legacy.Report != nil:
+		logCSPViolation(v.clientIP(r), *legacy.Report)
+	case json.Unmarshal(body, &reports) == nil:
+		for _, report := range reports {
+			if report.Type == "csp-violation" {
+				fmt.Println("csp violation from", v.clientIP(r), ":", string(report.Body))
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func logCSPViolation(client net.IP, violation cspViolation) {
+	directive := violation.EffectiveDirective
+	if directive == "" {
+		directive = violation.ViolatedDirective
+	}
+	fmt.Printf("csp violation from %s: %s blocked %q on %s (%s:%d)\n", client, directive, violation.BlockedURI,
+		violation.DocumentURI, violation.SourceFile, violation.LineNumber)
+}
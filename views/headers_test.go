@@ -0,0 +1,74 @@
+package views_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/views"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	handler := views.NewHandler(views.Services{}, resourceDir)
+	nonce := regexp.MustCompile(`'nonce-([^']+)'`)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/login", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	csp := resp.Header().Get("Content-Security-Policy")
+	require.Contains(t, csp, "frame-ancestors 'none'")
+	require.Contains(t, csp, "report-uri /csp-report")
+	first := nonce.FindStringSubmatch(csp)
+	require.Len(t, first, 2)
+	require.Equal(t, "nosniff", resp.Header().Get("X-Content-Type-Options"))
+	require.Equal(t, "same-origin", resp.Header().Get("Referrer-Policy"))
+	require.NotEmpty(t, resp.Header().Get("Permissions-Policy"))
+	require.Empty(t, resp.Header().Get("Strict-Transport-Security"), "HSTS is only sent over TLS")
+
+	// every response gets a nonce of its own, and error pages are covered too
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	second := nonce.FindStringSubmatch(resp.Header().Get("Content-Security-Policy"))
+	require.Len(t, second, 2)
+	require.NotEqual(t, first[1], second[1])
+
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	req.TLS = &tls.ConnectionState{}
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, "max-age=15552000; includeSubDomains", resp.Header().Get("Strict-Transport-Security"))
+}
+
+func TestCSPReportOnly(t *testing.T) {
+	handler := views.NewHandler(views.Services{Security: views.SecurityPolicy{
+		CSP:           "default-src 'self'; script-src 'nonce-{nonce}'; report-uri /csp-report",
+		CSPReportOnly: true,
+	}}, resourceDir)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/login", nil))
+	require.Empty(t, resp.Header().Get("Content-Security-Policy"))
+	require.Regexp(t, `^default-src 'self'; script-src 'nonce-[^{]+'; report-uri /csp-report$`, resp.Header().Get("Content-Security-Policy-Report-Only"))
+	require.Equal(t, "DENY", resp.Header().Get("X-Frame-Options"))
+
+	// browsers post reports without a CSRF token
+	for _, body := range []string{
+		`{"csp-report": {"document-uri": "http://localhost/ui/customer/list", "violated-directive": "script-src", "blocked-uri": "inline"}}`,
+		`[{"type": "csp-violation", "body": {"documentURL": "http://localhost/login", "effectiveDirective": "img-src"}}]`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/csp-report")
+		resp = httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusNoContent, resp.Code)
+	}
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader("nonsense")))
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+}
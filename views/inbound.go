@@ -0,0 +1,106 @@
+package views
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/webhooks"
+)
+
+const (
+	inboundLogSize    = 100
+	maxInboundPayload = 1 << 20
+)
+
+type inboundListData struct {
+	data
+	Status     models.InboundStatus
+	Deliveries []models.InboundDelivery
+}
+
+type inboundData struct {
+	data
+	Delivery models.InboundDelivery
+}
+
+type inboundResponse struct {
+	ID         int64                `json:"id"`
+	Status     models.InboundStatus `json:"status"`
+	CustomerID int                  `json:"customerId,omitempty"`
+	Error      string               `json:"error,omitempty"`
+}
+
+func (v *views) receiveInbound(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxInboundPayload))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	signature := r.Header.Get(webhooks.SignatureHeader)
+	timestamp := r.Header.Get(webhooks.TimestampHeader)
+	delivery, err := v.inbound.Receive(managers.AsSystem(r.Context()), signature, timestamp, body)
+	status := http.StatusOK
+	switch {
+	case err == webhooks.ErrInvalidSignature || err == webhooks.ErrStaleTimestamp:
+		v.rejectInbound(w, r, http.StatusUnauthorized, err)
+		return
+	case err == webhooks.ErrReplayed:
+		v.rejectInbound(w, r, http.StatusConflict, err)
+		return
+	case err != nil && delivery.ID == 0:
+		v.renderError(w, r, err)
+		return
+	case err != nil:
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, inboundResponse{
+		ID:         delivery.ID,
+		Status:     delivery.Status,
+		CustomerID: delivery.CustomerID,
+		Error:      delivery.Error,
+	})
+}
+
+// rejectInbound responds to a rejected delivery, which isn't stored, so it's only logged
+func (v *views) rejectInbound(w http.ResponseWriter, r *http.Request, status int, err error) {
+	fmt.Printf("inbound: rejected delivery from %v: %v\n", v.clientIP(r), err)
+	writeJSONError(w, status, err)
+}
+
+func (v *views) listInboundPage(w http.ResponseWriter, r *http.Request) {
+	viewData := inboundListData{
+		data:   v.newData(r, "Inbound Deliveries"),
+		Status: models.InboundStatus(r.URL.Query().Get("status")),
+	}
+	var err error
+	if viewData.Deliveries, err = v.inbound.ListDeliveries(r.Context(), viewData.Status, inboundLogSize); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	v.executeTemplate(w, "inbound", viewData)
+}
+
+func (v *views) viewInboundPage(w http.ResponseWriter, r *http.Request) {
+	viewData := inboundData{
+		data: v.newData(r, "Inbound Delivery"),
+	}
+	var err error
+	if viewData.Delivery, err = v.inbound.GetDelivery(r.Context(), int64(v.id(r))); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	v.executeTemplate(w, "inbound_delivery", viewData)
+}
+
+func (v *views) replayInbound(w http.ResponseWriter, r *http.Request) {
+	id := v.id(r)
+	// the outcome of the replay is recorded and shown on the delivery page
+	if _, err := v.inbound.Replay(r.Context(), int64(id)); err != nil {
+		fmt.Println("replay inbound delivery", id, ":", err)
+	}
+	redirect(w, r, "/ui/inbound/"+strconv.Itoa(id))
+}
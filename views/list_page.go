@@ -1,13 +1,13 @@
 package views
 
 import (
-	"fmt"
 	"html/template"
 	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 
+	"github.com/havr/customers/apperr"
 	"github.com/havr/customers/models"
 	"github.com/havr/customers/stores"
 )
@@ -18,6 +18,8 @@ type listData struct {
 	Filter    stores.CustomerListFilter
 	Customers []models.Customer
 	Pages     []page
+	// Unsortable are fields customers can't be ordered by, since they are encrypted
+	Unsortable map[string]bool
 }
 
 type page struct {
@@ -35,26 +37,24 @@ const (
 func (v *views) listCustomersPage(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	data := listData{
-		data: data{
-			Title: "List",
-		},
+		data: v.newData(r, "List"),
 	}
 	query := r.URL.Query()
 	page, err := v.page(query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		v.renderError(w, r, err)
 		return
 	}
 	viewOptions := v.viewOptions(query)
 	filter := v.getFilter(query)
 	total, err := v.customerManager.CountCustomers(ctx, filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		v.renderError(w, r, err)
 		return
 	}
 	totalPages := int(math.Ceil(float64(total) / float64(pageSize)))
 	if (totalPages > 0 && page > totalPages) || page < 0 {
-		http.Error(w, "invalid page value: "+strconv.Itoa(page), http.StatusInternalServerError)
+		v.renderError(w, r, apperr.New(apperr.NotFound, "page %d not found", page))
 		return
 	}
 
@@ -69,6 +69,7 @@ func (v *views) listCustomersPage(w http.ResponseWriter, r *http.Request) {
 	}
 	data.CustomerViewOptions = viewOptions
 	data.Filter = filter
+	data.Unsortable = v.encryptedFields
 	data.Pages = v.makePagination(r.URL.Query(), page, totalPages)
 	v.executeTemplate(w, "list", data)
 }
@@ -130,6 +131,7 @@ func (v *views) makePagination(query url.Values, current int, totalPages int) []
 func (v *views) getFilter(query url.Values) (filter stores.CustomerListFilter) {
 	filter.FirstName = query.Get("firstName")
 	filter.LastName = query.Get("lastName")
+	filter.Email = query.Get("email")
 	return
 }
 
@@ -139,10 +141,10 @@ func (v *views) page(query url.Values) (int, error) {
 	if pageStr != "" {
 		var err error
 		if page, err = strconv.Atoi(pageStr); err != nil {
-			return 0, fmt.Errorf("invalid page format")
+			return 0, apperr.New(apperr.Validation, "invalid page format")
 		}
 		if page < 0 {
-			return 0, fmt.Errorf("invalid page value")
+			return 0, apperr.New(apperr.Validation, "invalid page value")
 		}
 	}
 	return page, nil
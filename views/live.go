@@ -0,0 +1,46 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const heartbeatInterval = 30 * time.Second
+
+// streamChanges streams customer changes to a browser as server-sent events
+func (v *views) streamChanges(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		v.renderError(w, r, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	changes, cancel := v.changes.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case change := <-changes:
+			payload, err := json.Marshal(change)
+			if err != nil {
+				fmt.Println("encode customer change:", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", payload)
+		}
+		flusher.Flush()
+	}
+}
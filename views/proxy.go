@@ -0,0 +1,74 @@
+package views
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are reverse proxies whose X-Forwarded-For header tells the address of the client
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR networks
+func ParseTrustedProxies(spec string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, proxy := range strings.Split(spec, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			proxies = append(proxies, network)
+			continue
+		}
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP address or a CIDR network", proxy)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return proxies, nil
+}
+
+// trusts tells whether the given address belongs to a trusted proxy
+func (p TrustedProxies) trusts(ip net.IP) bool {
+	for _, network := range p {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client the request has come from. Requests from trusted proxies are attributed
+// to the last address of X-Forwarded-For that isn't a trusted proxy, since earlier ones may have been made up by the client
+func (v *views) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if !v.trustedProxies.trusts(ip) {
+		return ip
+	}
+	var forwarded []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !v.trustedProxies.trusts(hop) {
+			break
+		}
+	}
+	return ip
+}
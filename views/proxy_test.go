@@ -0,0 +1,34 @@
+package views
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10")
+	require.NoError(t, err)
+	_, err = ParseTrustedProxies("10.0.0.0/8,proxy")
+	require.Error(t, err)
+
+	v := testViews(t)
+	v.trustedProxies = proxies
+	clientIP := func(remoteAddr string, forwarded ...string) string {
+		r := httptest.NewRequest("GET", "/login", nil)
+		r.RemoteAddr = remoteAddr
+		for _, header := range forwarded {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+		return v.clientIP(r).String()
+	}
+
+	require.Equal(t, "203.0.113.7", clientIP("203.0.113.7:1234", "198.51.100.1"), "untrusted clients can't pick their address")
+	require.Equal(t, "198.51.100.1", clientIP("10.1.2.3:1234", "198.51.100.1"))
+	require.Equal(t, "198.51.100.1", clientIP("192.168.1.10:1234", "6.6.6.6, 198.51.100.1", "10.0.0.5"),
+		"addresses before the first untrusted one may have been made up")
+	require.Equal(t, "10.0.0.5", clientIP("10.1.2.3:1234", "10.0.0.5"))
+	require.Equal(t, "10.1.2.3", clientIP("10.1.2.3:1234"))
+	require.Equal(t, "192.168.1.11", clientIP("192.168.1.11:1234", "198.51.100.1"))
+}
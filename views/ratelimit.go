@@ -0,0 +1,70 @@
+package views
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/ratelimit"
+)
+
+// rateLimit rejects requests of clients that have run out of the budget of the group with 429 and Retry-After.
+// Clients are told apart by their API key or user, so it should go after authentication, and by IP address otherwise
+func (v *views) rateLimit(group ratelimit.Group) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return v.limitRequests(next, func(*http.Request) ratelimit.Group { return group }, v.rateLimitClient)
+	}
+}
+
+// limitClients limits all requests of an IP address. It goes ahead of authentication,
+// so that clients guessing API keys or session tokens are limited before they are known
+func (v *views) limitClients(next http.Handler) http.Handler {
+	return v.limitRequests(next, func(*http.Request) ratelimit.Group { return ratelimit.Client }, func(r *http.Request) string {
+		return "ip:" + v.clientIP(r).String()
+	})
+}
+
+// rateLimitByMethod is like rateLimit, but puts requests that only read into the read group and the rest into the write one
+func (v *views) rateLimitByMethod(next http.Handler) http.Handler {
+	return v.limitRequests(next, func(r *http.Request) ratelimit.Group {
+		if safeMethod(r.Method) {
+			return ratelimit.Read
+		}
+		return ratelimit.Write
+	}, v.rateLimitClient)
+}
+
+func (v *views) limitRequests(next http.Handler, groupOf func(*http.Request) ratelimit.Group, clientOf func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v.rateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		group := groupOf(r)
+		wait, err := v.rateLimiter.Allow(r.Context(), group, clientOf(r))
+		if err != nil {
+			// the limiter is a safeguard, so its failures don't take the application down
+			fmt.Println("rate limit:", err)
+		} else if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			v.renderError(w, r, apperr.New(apperr.RateLimited, "too many %s requests, please retry in %v", group, wait.Round(time.Second)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitClient returns the key of the client the request has come from
+func (v *views) rateLimitClient(r *http.Request) string {
+	if key, ok := managers.APIKeyFromContext(r.Context()); ok {
+		return "apikey:" + strconv.Itoa(key.ID)
+	}
+	if user, ok := managers.UserFromContext(r.Context()); ok {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+	return "ip:" + v.clientIP(r).String()
+}
@@ -0,0 +1,69 @@
+package views
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	v := testViews(t)
+	v.rateLimiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Limits{
+		ratelimit.Read:  {Requests: 1, Period: time.Minute},
+		ratelimit.Write: {Requests: 2, Period: time.Minute},
+	})
+	handler := v.rateLimitByMethod(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method string, user *models.User) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/v1/customers", nil)
+		if user != nil {
+			r = r.WithContext(managers.WithUser(r.Context(), *user))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, serve(http.MethodGet, nil).Code)
+	w := serve(http.MethodGet, nil)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// writes have a budget of their own, and so do signed in users
+	require.Equal(t, http.StatusNoContent, serve(http.MethodPost, nil).Code)
+	require.Equal(t, http.StatusNoContent, serve(http.MethodGet, &models.User{ID: 1}).Code)
+	require.Equal(t, http.StatusTooManyRequests, serve(http.MethodGet, &models.User{ID: 1}).Code)
+	require.Equal(t, http.StatusNoContent, serve(http.MethodGet, &models.User{ID: 2}).Code)
+}
+
+func TestLimitClients(t *testing.T) {
+	v := testViews(t)
+	v.rateLimiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Limits{
+		ratelimit.Client: {Requests: 2, Period: time.Minute},
+	})
+	// the handler stands for authentication that rejects guessed credentials
+	handler := v.limitClients(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	serve := func(remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/customers", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-API-Key", "guessed")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusUnauthorized, serve("203.0.113.7:1234"))
+	require.Equal(t, http.StatusUnauthorized, serve("203.0.113.7:1235"))
+	require.Equal(t, http.StatusTooManyRequests, serve("203.0.113.7:1236"), "failed authentication counts against the address")
+	require.Equal(t, http.StatusUnauthorized, serve("198.51.100.1:1234"))
+}
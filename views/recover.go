@@ -0,0 +1,81 @@
+package views
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/havr/customers/managers"
+)
+
+// requestIDHeader carries the ID of a request, both in the request if a proxy has assigned one and in the response
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID restricts IDs that come from outside, so that they are safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID tags the request with an ID and echoes it in the response, so that users may report it
+// and it may be found in the logs. The ID of a proxy is reused if it has assigned a sane one
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			raw := make([]byte, 8)
+			_, _ = rand.Read(raw)
+			id = hex.EncodeToString(raw)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(managers.WithRequestID(r.Context(), id)))
+	})
+}
+
+// recoverPanics turns a panic of a handler into a 500 response and logs it with the stack and the request ID.
+// http.ErrAbortHandler is re-panicked, since handlers use it to cut short responses that have been already started.
+// Such responses can't turn into an error page, so they are aborted the same way
+func (v *views) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &startedWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			fmt.Printf("panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, managers.RequestIDFromContext(r.Context()), recovered, debug.Stack())
+			if sw.started {
+				panic(http.ErrAbortHandler)
+			}
+			v.renderError(w, r, fmt.Errorf("panic: %v", recovered))
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// startedWriter tells whether a response has been started
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedWriter) WriteHeader(status int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *startedWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets change streams through
+func (w *startedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,72 @@
+package views
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testViews(t *testing.T) *views {
+	tmpl, err := template.New("main").Funcs(funcMap).ParseGlob("../resources/web/templates/*.tmpl")
+	require.NoError(t, err)
+	return &views{template: tmpl}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	v := testViews(t)
+	handler := withRequestID(v.recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("pq: relation \"customers\" does not exist")
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ui/customer/list", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	requestID := w.Header().Get(requestIDHeader)
+	require.NotEmpty(t, requestID)
+	require.Contains(t, w.Body.String(), requestID)
+	require.Contains(t, w.Body.String(), "relation")
+
+	v.production = true
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/ui/customer/list", nil)
+	r.Header.Set(requestIDHeader, "proxy-42")
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Equal(t, "proxy-42", w.Header().Get(requestIDHeader))
+	require.Contains(t, w.Body.String(), "proxy-42")
+	require.NotContains(t, w.Body.String(), "relation")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/customers", nil))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.JSONEq(t, `{"error": "internal server error"}`, w.Body.String())
+}
+
+func TestRecoverPanicsAbort(t *testing.T) {
+	v := testViews(t)
+	aborting := v.recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		aborting.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/customers/export", nil))
+	})
+
+	started := v.recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("id,firstName\n"))
+		panic("broken")
+	}))
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		started.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/customers/export", nil))
+	})
+}
+
+func TestNotFoundPage(t *testing.T) {
+	v := testViews(t)
+	w := httptest.NewRecorder()
+	v.notFound(w, httptest.NewRequest(http.MethodGet, "/ui/nowhere", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), "Not Found")
+}
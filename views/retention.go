@@ -0,0 +1,55 @@
+package views
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/havr/customers/models"
+)
+
+type retentionData struct {
+	data
+	Policy    models.RetentionPolicy
+	Interval  time.Duration
+	BatchSize int
+	DryRun    bool
+	NextRun   time.Time
+	LastRun   *models.RetentionRun
+}
+
+// PurgeDeletedAfter formats the period of the purge rule
+func (d retentionData) PurgeDeletedAfter() string {
+	return models.FormatRetentionPeriod(d.Policy.PurgeDeletedAfter)
+}
+
+// AnonymizeInactiveAfter formats the period of the anonymization rule
+func (d retentionData) AnonymizeInactiveAfter() string {
+	return models.FormatRetentionPeriod(d.Policy.AnonymizeInactiveAfter)
+}
+
+// retentionPage shows the retention policy, when it's applied next and what the last run has done
+func (v *views) retentionPage(w http.ResponseWriter, r *http.Request) {
+	viewData := retentionData{
+		data:      v.newData(r, "Data Retention"),
+		Policy:    v.retention.Policy,
+		Interval:  v.retention.Interval,
+		BatchSize: v.retention.BatchSize,
+		DryRun:    v.retention.DryRun,
+		NextRun:   v.retention.NextRun(),
+	}
+	if run, ok := v.retention.LastRun(); ok {
+		viewData.LastRun = &run
+	}
+	v.executeTemplate(w, "retention", viewData)
+}
+
+// applyRetention applies the retention policy right away, which is a dry run unless asked otherwise
+func (v *views) applyRetention(w http.ResponseWriter, r *http.Request) {
+	run, err := v.retention.Apply(r.Context(), r.FormValue("dryRun") != "false")
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	v.flash(r, models.Flash{Kind: models.FlashSuccess, Message: "Retention policy applied: " + run.String()})
+	redirect(w, r, "/ui/retention")
+}
@@ -1,19 +1,153 @@
 package views
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
 	"github.com/havr/customers/util/customeru"
 )
 
+const spawnCount = 10
+
+// maxGenerateCount is the largest number of customers the form generates at once, since they are created within
+// a request; the seed command takes up to customeru.MaxGeneratedCustomers
+const maxGenerateCount = 100
+
+type generateData struct {
+	data
+	Form             generateForm
+	MaxCount         int
+	Locales          []string
+	AgeDistributions []string
+}
+
+// generateForm holds generator options as they have been entered, so that the form keeps them when they are invalid
+type generateForm struct {
+	Seed, Count, Locale, MinAge, MaxAge, AgeDistribution, FemaleRatio, DuplicateRatio, InvalidRatio string
+}
+
+// generatePage shows the form of the data generator
+func (v *views) generatePage(w http.ResponseWriter, r *http.Request) {
+	defaults := customeru.DefaultGeneratorOptions(0, spawnCount)
+	v.renderGenerate(w, r, generateForm{
+		Count:           strconv.Itoa(defaults.Count),
+		Locale:          defaults.Locale,
+		MinAge:          strconv.Itoa(defaults.MinAge),
+		MaxAge:          strconv.Itoa(defaults.MaxAge),
+		AgeDistribution: defaults.AgeDistribution,
+		FemaleRatio:     strconv.FormatFloat(defaults.FemaleRatio, 'f', -1, 64),
+		DuplicateRatio:  "0",
+		InvalidRatio:    "0",
+	}, nil)
+}
+
+func (v *views) renderGenerate(w http.ResponseWriter, r *http.Request, form generateForm, formErr error) {
+	viewData := generateData{
+		data:             v.newData(r, "Generate Customers"),
+		Form:             form,
+		MaxCount:         maxGenerateCount,
+		Locales:          customeru.Locales(),
+		AgeDistributions: customeru.AgeDistributions,
+	}
+	if formErr != nil {
+		v.formError(&viewData.data, formErr)
+	}
+	v.executeTemplate(w, "generate", viewData)
+}
+
+// handleDataGeneration generates customers with the options of the form. Options that aren't given take default values,
+// so that a bare request spawns a few valid customers, and the seed is random unless it's given
 func (v *views) handleDataGeneration(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	for i := 0; i < 10; i++ {
-		customer := customeru.RandomCustomer()
-		if _, err := v.customerManager.CreateCustomer(ctx, customer); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := r.ParseForm(); err != nil {
+		v.renderError(w, r, apperr.Wrap(apperr.Validation, err, "parse form"))
+		return
+	}
+	form := generateForm{
+		Seed:            r.PostFormValue("seed"),
+		Count:           r.PostFormValue("count"),
+		Locale:          r.PostFormValue("locale"),
+		MinAge:          r.PostFormValue("minAge"),
+		MaxAge:          r.PostFormValue("maxAge"),
+		AgeDistribution: r.PostFormValue("ageDistribution"),
+		FemaleRatio:     r.PostFormValue("femaleRatio"),
+		DuplicateRatio:  r.PostFormValue("duplicateRatio"),
+		InvalidRatio:    r.PostFormValue("invalidRatio"),
+	}
+	options, err := form.options(time.Now())
+	if err == nil {
+		var customers []models.GeneratedCustomer
+		if customers, err = customeru.Generate(options); err == nil {
+			err = v.customerManager.SeedCustomers(r.Context(), customers)
+		}
+	}
+	if apperr.Is(err, apperr.Validation) && r.PostFormValue("return_to") == "" {
+		v.renderGenerate(w, r, form, err)
+		return
+	}
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	v.flash(r, models.Flash{Kind: models.FlashSuccess, Message: fmt.Sprintf("%d customers generated with seed %d", options.Count, options.Seed)})
+	redirectBack(w, r, "/ui/customer/list")
+}
+
+// options parses the form into generator options, taking default values for empty fields
+func (f generateForm) options(now time.Time) (customeru.GeneratorOptions, error) {
+	options := customeru.DefaultGeneratorOptions(now.UnixNano(), spawnCount)
+	options.Now = now
+	var errs managers.MultipleErrors
+	parseInt := func(field, value string, to *int) {
+		if value == "" {
+			return
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, &managers.FieldError{Field: field, Code: managers.CodeInvalidFormat, Params: map[string]interface{}{"value": value}})
+			return
+		}
+		*to = parsed
+	}
+	parseRatio := func(field, value string, to *float64) {
+		if value == "" {
 			return
 		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			errs = append(errs, &managers.FieldError{Field: field, Code: managers.CodeInvalidFormat, Params: map[string]interface{}{"value": value}})
+			return
+		}
+		*to = parsed
+	}
+	if f.Seed != "" {
+		seed, err := strconv.ParseInt(f.Seed, 10, 64)
+		if err != nil {
+			errs = append(errs, &managers.FieldError{Field: "seed", Code: managers.CodeInvalidFormat, Params: map[string]interface{}{"value": f.Seed}})
+		}
+		options.Seed = seed
+	}
+	parseInt("count", f.Count, &options.Count)
+	parseInt("minAge", f.MinAge, &options.MinAge)
+	parseInt("maxAge", f.MaxAge, &options.MaxAge)
+	parseRatio("femaleRatio", f.FemaleRatio, &options.FemaleRatio)
+	parseRatio("duplicateRatio", f.DuplicateRatio, &options.DuplicateRatio)
+	parseRatio("invalidRatio", f.InvalidRatio, &options.InvalidRatio)
+	if f.Locale != "" {
+		options.Locale = f.Locale
+	}
+	if f.AgeDistribution != "" {
+		options.AgeDistribution = f.AgeDistribution
+	}
+	if options.Count <= 0 || options.Count > maxGenerateCount {
+		errs = append(errs, &managers.FieldError{Field: "count", Code: managers.CodeOutOfRange, Params: map[string]interface{}{"value": options.Count, "min": 1, "max": maxGenerateCount}})
+	}
+	if len(errs) != 0 {
+		return options, errs
 	}
-	redirect(w, r, "")
+	return options, options.Validate()
 }
@@ -0,0 +1,117 @@
+package views
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/havr/customers/oidc"
+)
+
+const (
+	oidcCookie     = "oidc_auth"
+	oidcCookiePath = "/login/oidc"
+	// oidcCookieTTL is the time a user has to log in at the identity provider
+	oidcCookieTTL = 10 * 60
+)
+
+// oidcState is kept in a cookie between the redirect to the identity provider and the callback
+type oidcState struct {
+	oidc.AuthRequest
+	ReturnTo string `json:"returnTo"`
+}
+
+// startSSO redirects the user to the identity provider to log in
+func (v *views) startSSO(w http.ResponseWriter, r *http.Request) {
+	request, err := oidc.NewAuthRequest()
+	if err != nil {
+		v.ssoFailed(w, r, err)
+		return
+	}
+	authURL, err := v.oidc.AuthCodeURL(r.Context(), request)
+	if err != nil {
+		v.ssoFailed(w, r, err)
+		return
+	}
+	value, err := json.Marshal(oidcState{AuthRequest: request, ReturnTo: localPath(r.FormValue("return_to"), "")})
+	if err != nil {
+		v.ssoFailed(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(value),
+		Path:     oidcCookiePath,
+		MaxAge:   oidcCookieTTL,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// finishSSO handles the redirect back from the identity provider: it verifies the ID token,
+// provisions the user with the role its groups map to and starts a session
+func (v *views) finishSSO(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	var state oidcState
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		v.ssoFailed(w, r, fmt.Errorf("login request has expired, please try again"))
+		return
+	}
+	if raw, err := base64.RawURLEncoding.DecodeString(cookie.Value); err != nil || json.Unmarshal(raw, &state) != nil {
+		v.ssoFailed(w, r, fmt.Errorf("malformed login request, please try again"))
+		return
+	}
+	if providerErr := r.FormValue("error"); providerErr != "" {
+		v.ssoFailed(w, r, fmt.Errorf("identity provider refused to log in: %s %s", providerErr, r.FormValue("error_description")))
+		return
+	}
+
+	claims, err := v.oidc.Exchange(r.Context(), state.AuthRequest, r.FormValue("state"), r.FormValue("code"))
+	if err != nil {
+		v.ssoFailed(w, r, err)
+		return
+	}
+	role, ok := v.oidcRoles.RoleFor(claims.Groups)
+	if !ok {
+		v.ssoFailed(w, r, fmt.Errorf("none of the groups of %s grants access to the application", claims.Username()))
+		return
+	}
+	user, err := v.userManager.ProvisionExternalUser(r.Context(), claims.Issuer+"|"+claims.Subject, claims.Username(), role)
+	if err != nil {
+		v.ssoFailed(w, r, err)
+		return
+	}
+	token, err := v.userManager.StartSession(r.Context(), user)
+	if err != nil {
+		v.ssoFailed(w, r, err)
+		return
+	}
+	setSessionCookie(w, r, token)
+	if user.TwoFactorEnabled() {
+		redirect(w, r, "/login/2fa?return_to="+url.QueryEscape(state.ReturnTo))
+		return
+	}
+	redirect(w, r, localPath(state.ReturnTo, "/ui/customer/list"))
+}
+
+// ssoFailed shows the login page with the reason the login through the identity provider has failed
+func (v *views) ssoFailed(w http.ResponseWriter, r *http.Request, err error) {
+	fmt.Println("sso login:", err)
+	viewData := loginData{
+		data: v.newData(r, "Log In"),
+		SSO:  true,
+	}
+	viewData.Error = v.formatErrorHTML(err)
+	w.WriteHeader(http.StatusUnauthorized)
+	v.executeTemplate(w, "login", viewData)
+}
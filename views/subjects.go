@@ -0,0 +1,127 @@
+package views
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+)
+
+type subjectsData struct {
+	data
+	CustomerID string
+	Note       string
+	Mode       models.ErasureMode
+	Modes      []models.ErasureMode
+}
+
+// subjectsPage offers to export or erase all data of a customer on the customer's request
+func (v *views) subjectsPage(w http.ResponseWriter, r *http.Request) {
+	v.renderSubjects(w, r, nil)
+}
+
+func (v *views) renderSubjects(w http.ResponseWriter, r *http.Request, err error) {
+	viewData := subjectsData{
+		data:       v.newData(r, "Data Requests"),
+		CustomerID: r.FormValue("customer"),
+		Note:       r.FormValue("note"),
+		Mode:       models.ErasureMode(r.FormValue("mode")),
+		Modes:      models.ErasureModes,
+	}
+	if viewData.Mode == "" {
+		viewData.Mode = models.ErasureAnonymize
+	}
+	if err != nil {
+		v.formError(&viewData.data, err)
+	}
+	v.executeTemplate(w, "subjects", viewData)
+}
+
+// subjectCustomer parses the ID of the customer a request is about
+func subjectCustomer(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.FormValue("customer"))
+	if err != nil || id <= 0 {
+		return 0, managers.MultipleErrors{&managers.FieldError{Field: "customer", Code: managers.CodeInvalidFormat}}
+	}
+	return id, nil
+}
+
+// exportSubjectData downloads everything stored about a customer as a bundle, see WriteSubjectBundle
+func (v *views) exportSubjectData(w http.ResponseWriter, r *http.Request) {
+	id, err := subjectCustomer(r)
+	var data models.SubjectData
+	if err == nil {
+		data, err = v.customerManager.ExportSubjectData(r.Context(), id, r.FormValue("note"))
+	}
+	if apperr.Is(err, apperr.Validation) {
+		v.renderSubjects(w, r, err)
+		return
+	} else if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="customer-%d-data.zip"`, id))
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeSubjectBundle(w, v.template, data); err != nil {
+		// the response has been already started, so the only way to signal the failure is to cut it short
+		fmt.Println("export data of customer", id, ":", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// eraseSubject erases personal data of a customer
+func (v *views) eraseSubject(w http.ResponseWriter, r *http.Request) {
+	id, err := subjectCustomer(r)
+	if err == nil {
+		err = v.customerManager.EraseCustomer(r.Context(), id, models.ErasureMode(r.FormValue("mode")), r.FormValue("note"))
+	}
+	if apperr.Is(err, apperr.Validation) {
+		v.renderSubjects(w, r, err)
+		return
+	} else if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	v.flash(r, models.Flash{Kind: models.FlashSuccess, Message: fmt.Sprintf("Personal data of customer #%d erased", id)})
+	redirect(w, r, "/ui/subjects")
+}
+
+// WriteSubjectBundle writes data of a customer as a zip archive of data.json, for machines, and data.html, for people.
+// Templates are read from the resource dir, as NewHandler does
+func WriteSubjectBundle(w io.Writer, resourceDir string, data models.SubjectData) error {
+	tmpl, err := parseTemplates(resourceDir)
+	if err != nil {
+		return err
+	}
+	return writeSubjectBundle(w, tmpl, data)
+}
+
+func writeSubjectBundle(w io.Writer, tmpl *template.Template, data models.SubjectData) error {
+	archive := zip.NewWriter(w)
+	file, err := archive.Create("data.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return errors.Wrapf(err, "write data.json")
+	}
+	if file, err = archive.Create("data.html"); err != nil {
+		return err
+	}
+	if err := tmpl.ExecuteTemplate(file, "subject_data", data); err != nil {
+		return errors.Wrapf(err, "write data.html")
+	}
+	return archive.Close()
+}
@@ -0,0 +1,55 @@
+package views_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/views"
+)
+
+func TestWriteSubjectBundle(t *testing.T) {
+	deletedAt := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	data := models.SubjectData{
+		CustomerID:  7,
+		GeneratedAt: time.Date(2024, 5, 3, 10, 0, 0, 0, time.UTC),
+		GeneratedBy: "admin",
+		Note:        "Request by email of 2 May, identity checked by phone",
+		Customer:    &models.Customer{ID: 7, FirstName: "Jane", LastName: "<Doe>", Email: "jane@example.com"},
+		DeletedAt:   &deletedAt,
+		Events: []models.Event{{Type: models.CustomerUpdated, CustomerID: 7,
+			Changes: []models.FieldChange{{Field: "email", Old: "old@example.com", New: "jane@example.com"}}}},
+		Audit: []models.AuditEntry{{Action: models.CustomerUpdated, CustomerID: 7, Actor: "support", Fields: []string{"email"}}},
+	}
+	var out bytes.Buffer
+	require.NoError(t, views.WriteSubjectBundle(&out, resourceDir, data))
+
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		files[file.Name] = string(content)
+	}
+	require.Len(t, files, 2)
+
+	var decoded models.SubjectData
+	require.NoError(t, json.Unmarshal([]byte(files["data.json"]), &decoded))
+	require.Equal(t, data.Customer, decoded.Customer)
+	require.Equal(t, data.Note, decoded.Note)
+
+	html := files["data.html"]
+	require.Contains(t, html, "jane@example.com")
+	require.Contains(t, html, "old@example.com")
+	require.Contains(t, html, "&lt;Doe&gt;", "values must be escaped")
+	require.Contains(t, html, "02 May 24 10:00:00")
+}
@@ -0,0 +1,94 @@
+package views
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/havr/customers/managers"
+)
+
+type twoFactorData struct {
+	data
+	Enabled       bool
+	Required      bool
+	Secret        string
+	URI           template.URL
+	RecoveryCodes []string
+	CodesLeft     int
+}
+
+type settingsData struct {
+	data
+	RequireTwoFactor bool
+	Saved            bool
+}
+
+// twoFactorPage shows the two-factor status of the current user and lets the user enroll an authenticator.
+// The secret being enrolled is kept in the session, so that a client can't choose it
+func (v *views) twoFactorPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := managers.UserFromContext(ctx)
+	var token string
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		token = cookie.Value
+	}
+	viewData := twoFactorData{
+		data:    v.newData(r, "Two-Factor Authentication"),
+		Enabled: user.TwoFactorEnabled(),
+	}
+	var err error
+	if viewData.Required, err = v.userManager.TwoFactorRequiredFor(ctx, user); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && !viewData.Enabled:
+		codes, err := v.userManager.EnrollTOTP(ctx, token, user, r.FormValue("code"))
+		if err != nil {
+			viewData.Error = v.formatErrorHTML(err)
+			break
+		}
+		viewData.Enabled, viewData.RecoveryCodes = true, codes
+	case r.Method == http.MethodPost:
+		if viewData.RecoveryCodes, err = v.userManager.RegenerateRecoveryCodes(ctx, user, r.FormValue("code")); err != nil {
+			viewData.Error = v.formatErrorHTML(err)
+		}
+	}
+	if !viewData.Enabled {
+		secret, uri, err := v.userManager.TOTPEnrollment(ctx, token, user)
+		if err != nil {
+			v.renderError(w, r, err)
+			return
+		}
+		viewData.Secret, viewData.URI = secret, template.URL(uri)
+	}
+	if viewData.Enabled {
+		if viewData.CodesLeft, err = v.userManager.RecoveryCodesLeft(ctx, user); err != nil {
+			v.renderError(w, r, err)
+			return
+		}
+	}
+	v.executeTemplate(w, "account_2fa", viewData)
+}
+
+// settingsPage shows and changes security settings of the application
+func (v *views) settingsPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewData := settingsData{
+		data: v.newData(r, "Settings"),
+	}
+	if r.Method == http.MethodPost {
+		if err := v.userManager.SetTwoFactorRequired(ctx, r.FormValue("requireTwoFactor") == "on"); err != nil {
+			v.renderError(w, r, err)
+			return
+		}
+		viewData.Saved = true
+	}
+	var err error
+	if viewData.RequireTwoFactor, err = v.userManager.TwoFactorRequired(ctx); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	v.executeTemplate(w, "settings", viewData)
+}
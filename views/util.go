@@ -3,14 +3,18 @@ package views
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
 func (v *views) executeTemplate(w http.ResponseWriter, name string, data interface{}) {
 	if err := v.template.ExecuteTemplate(w, name, data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// the page may have been partially written, so it's too late for an error page
+		fmt.Println("execute template", name, ":", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
@@ -27,9 +31,26 @@ func (v *views) id(r *http.Request) int {
 	return intD
 }
 
+// redirect finishes a form post by sending the browser to the given page of the application
 func redirect(w http.ResponseWriter, r *http.Request, where string) {
-	if where == "" {
-		where = r.Header.Get("Referer")
-	}
 	http.Redirect(w, r, where, http.StatusFound)
 }
+
+// redirectBack sends the browser to the page passed in the return_to field of the request
+// if it belongs to this site, or to the fallback otherwise
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	redirect(w, r, localPath(r.FormValue("return_to"), fallback))
+}
+
+// localPath returns the given path if it points to this site, or the fallback otherwise
+func localPath(path, fallback string) string {
+	// browsers treat backslashes as slashes and drop tabs and newlines, so "/\evil.com" leads to another host
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.ContainsAny(path, "\\\t\r\n") {
+		return fallback
+	}
+	parsed, err := url.Parse(path)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" {
+		return fallback
+	}
+	return path
+}
@@ -0,0 +1,29 @@
+package views
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalPath(t *testing.T) {
+	for path, local := range map[string]bool{
+		"/ui/customer/list?firstName=J&offset=20": true,
+		"/":                       true,
+		"":                        false,
+		"https://evil.com/":       false,
+		"//evil.com/":             false,
+		"/\\evil.com":             false,
+		"/\t/evil.com":            false,
+		"javascript:alert(1)":     false,
+		"ui/customer/list":        false,
+		"/ui/customer/list\r\nX:": false,
+	} {
+		result := localPath(path, "/fallback")
+		if local {
+			require.Equal(t, path, result, path)
+		} else {
+			require.Equal(t, "/fallback", result, path)
+		}
+	}
+}
@@ -9,12 +9,18 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/havr/customers/live"
 	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/oidc"
+	"github.com/havr/customers/ratelimit"
+	"github.com/havr/customers/webhooks"
 )
 
 const (
 	jsDateLayout   = "2006-01-02"
 	onlyDateLayout = "02 Jan 06"
+	dateTimeLayout = "02 Jan 06 15:04:05"
 )
 
 var funcMap = template.FuncMap{
@@ -24,43 +30,256 @@ var funcMap = template.FuncMap{
 	"onlyDate": func(date time.Time) string {
 		return date.Format(onlyDateLayout)
 	},
+	"dateTime": func(date time.Time) string {
+		return date.Format(dateTimeLayout)
+	},
+	"hasScope": func(scopes []models.Permission, scope models.Permission) bool {
+		for _, s := range scopes {
+			if s == scope {
+				return true
+			}
+		}
+		return false
+	},
+	"hasEventType": func(eventTypes []models.EventType, eventType models.EventType) bool {
+		for _, t := range eventTypes {
+			if t == eventType {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// Services are the application services the http handler is built upon
+type Services struct {
+	Customers *managers.CustomerManager
+	Users     *managers.UserManager
+	APIKeys   *managers.APIKeyManager
+	Webhooks  *managers.WebhookManager
+	// Inbound receives customer updates from an upstream system. Inbound routes are disabled if it's nil
+	Inbound *webhooks.Receiver
+	// Changes publishes committed customer changes to be streamed to browsers
+	Changes *live.Hub
+	// OIDC lets users log in through an identity provider. SSO routes are disabled if it's nil
+	OIDC *oidc.Client
+	// OIDCRoles maps groups of the identity provider to roles of users logging in through it
+	OIDCRoles oidc.GroupRoles
+	// Production hides details of internal errors from users, they are only logged
+	Production bool
+	// RateLimiter limits how often clients may make requests. Requests aren't limited if it's nil
+	RateLimiter *ratelimit.Limiter
+	// Security configures security headers, DefaultSecurityPolicy is used if it's empty
+	Security SecurityPolicy
+	// AccessLog records reads of customers and shows them to auditors. The access log page is disabled if it's nil
+	AccessLog *managers.AccessLog
+	// Retention applies the retention policy. The retention page is disabled if it's nil
+	Retention *managers.Retention
+	// EncryptedFields are customer fields encrypted at rest, which customers can't be ordered by
+	EncryptedFields []string
+	// TrustedProxies are reverse proxies requests come through. X-Forwarded-For is ignored unless it's sent by one of them
+	TrustedProxies TrustedProxies
 }
 
 //NewHandler builds a complete http handler for the application
-func NewHandler(customerManager *managers.CustomerManager, resourceDir string) http.Handler {
+func NewHandler(services Services, resourceDir string) http.Handler {
 	staticDir := filepath.Join(resourceDir, "static")
-	templateDir := filepath.Join(resourceDir, "templates/*.tmpl")
-	tmpl, err := template.New("main").Funcs(funcMap).ParseGlob(templateDir)
+	tmpl, err := parseTemplates(resourceDir)
 	if err != nil {
-		panic(fmt.Sprintf("process template dir %q: %v", templateDir, err))
+		panic(err)
 	}
 
+	if services.Security == (SecurityPolicy{}) {
+		services.Security = DefaultSecurityPolicy
+	}
 	views := &views{
 		template:        tmpl,
-		customerManager: customerManager,
+		customerManager: services.Customers,
+		userManager:     services.Users,
+		apiKeyManager:   services.APIKeys,
+		webhookManager:  services.Webhooks,
+		inbound:         services.Inbound,
+		changes:         services.Changes,
+		oidc:            services.OIDC,
+		oidcRoles:       services.OIDCRoles,
+		production:      services.Production,
+		rateLimiter:     services.RateLimiter,
+		security:        services.Security,
+		encryptedFields: make(map[string]bool),
+		accessLog:       services.AccessLog,
+		retention:       services.Retention,
+		trustedProxies:  services.TrustedProxies,
+	}
+	for _, field := range services.EncryptedFields {
+		views.encryptedFields[field] = true
 	}
 
 	router := mux.NewRouter()
-	router.Path("/generate").Methods("POST").HandlerFunc(views.handleDataGeneration)
+	router.NotFoundHandler = http.HandlerFunc(views.notFound)
+	router.Use(views.protectCSRF)
+	limitAuth := views.rateLimit(ratelimit.Auth)
+	router.Path("/login").Methods("GET", "POST").Handler(limitAuth(http.HandlerFunc(views.loginPage)))
+	router.Path("/login/2fa").Methods("GET", "POST").Handler(limitAuth(http.HandlerFunc(views.secondFactorPage)))
+	if services.OIDC != nil {
+		router.Path("/login/oidc").Methods("GET").Handler(limitAuth(http.HandlerFunc(views.startSSO)))
+		router.Path("/login/oidc/callback").Methods("GET").Handler(limitAuth(http.HandlerFunc(views.finishSSO)))
+	}
+	router.Path(cspReportPath).Methods("POST").Handler(views.rateLimit(ratelimit.Write)(http.HandlerFunc(views.cspReport)))
+	router.Path("/logout").Methods("POST").HandlerFunc(views.logout)
+	router.Path("/account/2fa").Methods("GET", "POST").Handler(views.limitClients(views.requireEnrollingUser(limitAuth(http.HandlerFunc(views.twoFactorPage)))))
+	router.Path("/ui/settings").Methods("GET", "POST").Handler(views.limitClients(views.requireUser(views.rateLimitByMethod(
+		views.requirePermission(models.ManageSettings)(http.HandlerFunc(views.settingsPage))))))
+	router.Path("/generate").Methods("GET").Handler(views.limitClients(views.requireUser(views.rateLimitByMethod(
+		views.requirePermission(models.GenerateCustomers)(http.HandlerFunc(views.generatePage))))))
+	router.Path("/generate").Methods("POST").Handler(views.limitClients(views.requireUser(views.rateLimit(ratelimit.Generate)(
+		views.requirePermission(models.GenerateCustomers)(http.HandlerFunc(views.handleDataGeneration))))))
 
 	ui := router.PathPrefix("/ui/customer").Subrouter()
+	ui.Use(views.limitClients, views.requireUser, views.rateLimitByMethod)
 	ui.Path("/list").Methods("GET").HandlerFunc(views.listCustomersPage)
 	ui.Path("/create").Methods("GET", "POST").HandlerFunc(views.createCustomerPage)
 	ui.Path("/view/{id}").Methods("GET").HandlerFunc(views.viewCustomerPage)
 	ui.Path("/edit/{id}").Methods("GET", "POST").HandlerFunc(views.editCustomerPage)
 	ui.Path("/delete/{id}").Methods("POST").HandlerFunc(views.deleteCustomer)
+	ui.Path("/restore/{id}").Methods("POST").HandlerFunc(views.restoreCustomer)
+	ui.Path("/reveal/{id}").Methods("POST").HandlerFunc(views.revealCustomer)
+	ui.Path("/changes").Methods("GET").HandlerFunc(views.streamChanges)
+
+	if services.AccessLog != nil {
+		access := router.PathPrefix("/ui/access").Subrouter()
+		access.Use(views.limitClients, views.requireUser, views.rateLimitByMethod, views.requirePermission(models.ReadAccessLog))
+		access.Path("").Methods("GET").HandlerFunc(views.accessLogPage)
+		access.Path("/export").Methods("GET").HandlerFunc(views.exportAccessLog)
+	}
+
+	if services.Retention != nil {
+		retention := router.PathPrefix("/ui/retention").Subrouter()
+		retention.Use(views.limitClients, views.requireUser, views.rateLimitByMethod, views.requirePermission(models.ManageSettings))
+		retention.Path("").Methods("GET").HandlerFunc(views.retentionPage)
+		retention.Path("/run").Methods("POST").HandlerFunc(views.applyRetention)
+	}
+
+	subjects := router.PathPrefix("/ui/subjects").Subrouter()
+	subjects.Use(views.limitClients, views.requireUser, views.rateLimitByMethod, views.requirePermission(models.HandleSubjectRequests))
+	subjects.Path("").Methods("GET").HandlerFunc(views.subjectsPage)
+	subjects.Path("/export").Methods("POST").HandlerFunc(views.exportSubjectData)
+	subjects.Path("/erase").Methods("POST").HandlerFunc(views.eraseSubject)
+
+	apiKeys := router.PathPrefix("/ui/apikeys").Subrouter()
+	apiKeys.Use(views.limitClients, views.requireUser, views.rateLimitByMethod, views.requirePermission(models.ManageAPIKeys))
+	apiKeys.Path("").Methods("GET").HandlerFunc(views.listAPIKeysPage)
+	apiKeys.Path("/create").Methods("POST").HandlerFunc(views.createAPIKey)
+	apiKeys.Path("/revoke/{id}").Methods("POST").HandlerFunc(views.revokeAPIKey)
+
+	webhooks := router.PathPrefix("/ui/webhooks").Subrouter()
+	webhooks.Use(views.limitClients, views.requireUser, views.rateLimitByMethod, views.requirePermission(models.ManageIntegrations))
+	webhooks.Path("").Methods("GET").HandlerFunc(views.listWebhooksPage)
+	webhooks.Path("/create").Methods("POST").HandlerFunc(views.createWebhook)
+	webhooks.Path("/delete/{id}").Methods("POST").HandlerFunc(views.deleteWebhook)
+	webhooks.Path("/{id}/deliveries").Methods("GET").HandlerFunc(views.listDeliveriesPage)
+	webhooks.Path("/delivery/{id}").Methods("GET").HandlerFunc(views.viewDeliveryPage)
+	webhooks.Path("/delivery/{id}/redeliver").Methods("POST").HandlerFunc(views.redeliver)
+
+	if services.Inbound != nil {
+		// inbound deliveries are authenticated by their signature rather than by a user session
+		router.Path(inboundPath).Methods("POST").Handler(views.rateLimitByMethod(http.HandlerFunc(views.receiveInbound)))
+		inbound := router.PathPrefix("/ui/inbound").Subrouter()
+		inbound.Use(views.limitClients, views.requireUser, views.rateLimitByMethod, views.requirePermission(models.ManageIntegrations))
+		inbound.Path("").Methods("GET").HandlerFunc(views.listInboundPage)
+		inbound.Path("/{id}").Methods("GET").HandlerFunc(views.viewInboundPage)
+		inbound.Path("/{id}/replay").Methods("POST").HandlerFunc(views.replayInbound)
+	}
+
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(views.limitClients, views.requireUser, views.rateLimitByMethod)
+	api.Path("/customers").Methods("GET").HandlerFunc(views.apiListCustomers)
+	api.Path("/customers").Methods("POST").HandlerFunc(views.apiCreateCustomer)
+	api.Path("/customers/changes").Methods("GET").HandlerFunc(views.apiListChanges)
+	api.Path("/customers/export").Methods("GET").HandlerFunc(views.apiExportCustomers)
+	api.Path("/customers/{id:[0-9]+}").Methods("GET").HandlerFunc(views.apiGetCustomer)
+	api.Path("/customers/{id:[0-9]+}").Methods("PUT").HandlerFunc(views.apiUpdateCustomer)
+	api.Path("/customers/{id:[0-9]+}").Methods("DELETE").HandlerFunc(views.apiDeleteCustomer)
+	api.Path("/customers/{id:[0-9]+}/reveal").Methods("POST").HandlerFunc(views.apiRevealCustomer)
+
+	apiWebhooks := api.PathPrefix("/webhooks").Subrouter()
+	apiWebhooks.Use(views.requirePermission(models.ManageIntegrations))
+	apiWebhooks.Path("").Methods("GET").HandlerFunc(views.apiListWebhooks)
+	apiWebhooks.Path("").Methods("POST").HandlerFunc(views.apiCreateWebhook)
+	apiWebhooks.Path("/{id}").Methods("GET").HandlerFunc(views.apiGetWebhook)
+	apiWebhooks.Path("/{id}").Methods("DELETE").HandlerFunc(views.apiDeleteWebhook)
+	apiWebhooks.Path("/{id}/deliveries").Methods("GET").HandlerFunc(views.apiListDeliveries)
+	apiWebhooks.Path("/deliveries/{id}/redeliver").Methods("POST").HandlerFunc(views.apiRedeliver)
 
 	router.Path("/").Methods("GET").Handler(http.RedirectHandler("/ui/customer/list", http.StatusMovedPermanently))
 	router.PathPrefix("/static").Handler(http.StripPrefix("/static", http.FileServer(http.Dir(staticDir))))
-	return router
+	return withRequestID(views.secureHeaders(views.recoverPanics(router)))
+}
+
+// parseTemplates parses the page templates of the resource dir
+func parseTemplates(resourceDir string) (*template.Template, error) {
+	templateDir := filepath.Join(resourceDir, "templates/*.tmpl")
+	tmpl, err := template.New("main").Funcs(funcMap).ParseGlob(templateDir)
+	if err != nil {
+		return nil, fmt.Errorf("process template dir %q: %v", templateDir, err)
+	}
+	return tmpl, nil
 }
 
 type views struct {
 	template        *template.Template
 	customerManager *managers.CustomerManager
+	userManager     *managers.UserManager
+	apiKeyManager   *managers.APIKeyManager
+	webhookManager  *managers.WebhookManager
+	inbound         *webhooks.Receiver
+	changes         *live.Hub
+	oidc            *oidc.Client
+	oidcRoles       oidc.GroupRoles
+	production      bool
+	rateLimiter     *ratelimit.Limiter
+	security        SecurityPolicy
+	encryptedFields map[string]bool
+	accessLog       *managers.AccessLog
+	retention       *managers.Retention
+	trustedProxies  TrustedProxies
 }
 
 type data struct {
 	Title string
 	Error template.HTML
+	User  *models.User
+	// CSRFToken must be passed with every form that changes anything, see the "csrf" template
+	CSRFToken string
+	// RequestPath is the path of the page, which forms pass as return_to to come back to it
+	RequestPath string
+	Flashes     []models.Flash
+	// FieldErrors are messages of invalid form fields by their names
+	FieldErrors map[string]string
+	// CSPNonce must be put on script tags, otherwise the Content Security Policy blocks them
+	CSPNonce string
+}
+
+// newData returns common page data for the given request
+func (v *views) newData(r *http.Request, title string) data {
+	result := data{
+		Title:       title,
+		CSRFToken:   csrfToken(r),
+		CSPNonce:    cspNonce(r),
+		RequestPath: r.URL.RequestURI(),
+		Flashes:     v.takeFlashes(r),
+	}
+	if user, ok := managers.UserFromContext(r.Context()); ok {
+		result.User = &user
+	}
+	return result
+}
+
+// FieldError returns the error message of the given form field, if any
+func (d data) FieldError(field string) string {
+	return d.FieldErrors[field]
+}
+
+// Can tells whether the current user has the given permission, which lets templates hide unavailable actions
+func (d data) Can(permission models.Permission) bool {
+	return d.User != nil && d.User.Can(permission)
 }
@@ -0,0 +1,122 @@
+package views
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/managers"
+	"github.com/havr/customers/models"
+)
+
+const deliveryLogSize = 100
+
+type webhooksData struct {
+	data
+	Webhooks   []models.Webhook
+	EventTypes []models.EventType
+	Form       models.Webhook
+}
+
+type deliveriesData struct {
+	data
+	Webhook    models.Webhook
+	Deliveries []models.WebhookDelivery
+}
+
+type deliveryData struct {
+	data
+	Delivery models.WebhookDelivery
+}
+
+func (v *views) listWebhooksPage(w http.ResponseWriter, r *http.Request) {
+	v.renderWebhooks(w, r, models.Webhook{EventTypes: managers.KnownEventTypes}, nil)
+}
+
+func (v *views) renderWebhooks(w http.ResponseWriter, r *http.Request, form models.Webhook, formErr error) {
+	viewData := webhooksData{
+		data:       v.newData(r, "Webhooks"),
+		EventTypes: managers.KnownEventTypes,
+		Form:       form,
+	}
+	if formErr != nil {
+		viewData.Error = v.formatErrorHTML(formErr)
+	}
+	webhooks, err := v.webhookManager.ListWebhooks(r.Context())
+	if err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	viewData.Webhooks = webhooks
+	v.executeTemplate(w, "webhooks", viewData)
+}
+
+func (v *views) createWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		v.renderError(w, r, apperr.Wrap(apperr.Validation, err, "parse form"))
+		return
+	}
+	webhook := models.Webhook{
+		URL:    r.FormValue("url"),
+		Secret: r.FormValue("secret"),
+		Filter: models.CustomerListFilter{
+			FirstName: r.FormValue("firstName"),
+			LastName:  r.FormValue("lastName"),
+		},
+		Active: true,
+	}
+	for _, eventType := range r.PostForm["eventType"] {
+		webhook.EventTypes = append(webhook.EventTypes, models.EventType(eventType))
+	}
+	if _, err := v.webhookManager.CreateWebhook(r.Context(), webhook); err != nil {
+		v.renderWebhooks(w, r, webhook, err)
+		return
+	}
+	redirect(w, r, "/ui/webhooks")
+}
+
+func (v *views) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := v.webhookManager.DeleteWebhook(r.Context(), v.id(r)); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	redirect(w, r, "/ui/webhooks")
+}
+
+func (v *views) listDeliveriesPage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	viewData := deliveriesData{
+		data: v.newData(r, "Webhook Deliveries"),
+	}
+	var err error
+	if viewData.Webhook, err = v.webhookManager.GetWebhook(ctx, v.id(r)); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	if viewData.Deliveries, err = v.webhookManager.ListDeliveries(ctx, viewData.Webhook.ID, deliveryLogSize); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	v.executeTemplate(w, "webhook_deliveries", viewData)
+}
+
+func (v *views) viewDeliveryPage(w http.ResponseWriter, r *http.Request) {
+	viewData := deliveryData{
+		data: v.newData(r, "Webhook Delivery"),
+	}
+	var err error
+	if viewData.Delivery, err = v.webhookManager.GetDelivery(r.Context(), int64(v.id(r))); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	v.executeTemplate(w, "webhook_delivery", viewData)
+}
+
+func (v *views) redeliver(w http.ResponseWriter, r *http.Request) {
+	id := v.id(r)
+	if err := v.webhookManager.Redeliver(r.Context(), int64(id)); err != nil {
+		v.renderError(w, r, err)
+		return
+	}
+	redirect(w, r, "/ui/webhooks/delivery/"+strconv.Itoa(id))
+}
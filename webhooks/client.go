@@ -0,0 +1,77 @@
+package webhooks
+
+import (
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// reservedNetworks are special purpose networks that net.IP methods don't tell apart from public ones
+var reservedNetworks = parseNetworks(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, broadcast included
+	"64:ff9b::/96",    // NAT64, which reaches IPv4 addresses of any kind
+	"2001:db8::/32",   // documentation
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// PublicIP tells whether webhooks may connect to the given address. Loopback, private, link-local addresses,
+// cloud metadata endpoints among them, and other special purpose addresses are internal and refused
+func PublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClient creates an http client for deliveries that connects to public addresses only.
+// Addresses are checked as connections are made, after names have been resolved, so that a name which
+// resolves to an internal address, at once or later on, and redirects to internal addresses are refused too
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   refuseInternal,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would connect on behalf of the client, out of reach of the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func refuseInternal(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !PublicIP(net.ParseIP(host)) {
+		return errors.Errorf("refused to connect to %s, which isn't a public address", host)
+	}
+	return nil
+}
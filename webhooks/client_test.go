@@ -0,0 +1,46 @@
+package webhooks_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/webhooks"
+)
+
+func TestPublicIP(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:2800::1":    true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fd00:ec2::254":   false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::ffff:10.0.0.1": false,
+		"64:ff9b::a00:1":  false,
+	} {
+		require.Equal(t, public, webhooks.PublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	var requested bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	_, err := webhooks.NewClient(time.Second).Get(server.URL)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "isn't a public address")
+	require.False(t, requested)
+}
@@ -0,0 +1,153 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+const (
+	// DefaultMaxAttempts is the default number of failed attempts after which a delivery is considered dead
+	DefaultMaxAttempts = 8
+	// DefaultBackoff is the default delay before the first retry, doubled with every next one
+	DefaultBackoff = 30 * time.Second
+	// DefaultMaxBackoff is the default upper bound of the delay between retries
+	DefaultMaxBackoff = 6 * time.Hour
+
+	dispatchInterval  = time.Second
+	dispatchBatchSize = 50
+	maxResponseBody   = 64 * 1024
+)
+
+// Dispatcher POSTs scheduled deliveries to their webhooks, retrying failed ones with exponential backoff
+type Dispatcher struct {
+	store       stores.WebhookStore
+	client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Now         func() time.Time
+}
+
+// NewDispatcher creates a dispatcher that performs deliveries of the given store with the given client
+func NewDispatcher(store stores.WebhookStore, client *http.Client) *Dispatcher {
+	return &Dispatcher{
+		store:       store,
+		client:      client,
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		Now:         time.Now,
+	}
+}
+
+// Run performs due deliveries until the context is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.DispatchDue(ctx); err != nil && ctx.Err() == nil {
+			fmt.Println("dispatch webhooks:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchDue attempts all deliveries that are due by now
+func (d *Dispatcher) DispatchDue(ctx context.Context) error {
+	for {
+		deliveries, err := d.store.DueDeliveries(ctx, d.Now(), dispatchBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, delivery := range deliveries {
+			if _, err := d.Attempt(ctx, delivery); err != nil {
+				return err
+			}
+		}
+		if len(deliveries) < dispatchBatchSize {
+			return nil
+		}
+	}
+}
+
+// Attempt POSTs the delivery to its webhook once and saves the outcome.
+// The returned error reports a failure to save the outcome rather than a failure of the delivery itself
+func (d *Dispatcher) Attempt(ctx context.Context, delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	webhook, err := d.store.GetWebhook(ctx, delivery.WebhookID)
+	if err != nil {
+		return delivery, err
+	}
+	now := d.Now()
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return delivery, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Event", string(delivery.EventType))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, now, []byte(delivery.Payload)))
+
+	delivery.Attempts++
+	delivery.RequestHeaders = formatHeaders(req.Header)
+	delivery.ResponseStatus, delivery.ResponseHeaders, delivery.ResponseBody, delivery.Error = 0, "", "", ""
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err == nil {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+		delivery.ResponseStatus = resp.StatusCode
+		delivery.ResponseHeaders = formatHeaders(resp.Header)
+		delivery.ResponseBody = string(body)
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			err = fmt.Errorf("unexpected status %v", resp.Status)
+		}
+	}
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliverySucceeded
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = models.DeliveryDead
+		delivery.Error = err.Error()
+	default:
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	}
+	return delivery, d.store.UpdateDelivery(ctx, delivery)
+}
+
+// backoff returns a delay before the next attempt after the given number of failed ones
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.Backoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
+
+func formatHeaders(header http.Header) string {
+	var lines []string
+	for name, values := range header {
+		lines = append(lines, name+": "+strings.Join(values, ", "))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,142 @@
+package webhooks_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/webhooks"
+)
+
+const testSecret = "secret"
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	var received []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		err = webhooks.Verify(testSecret, r.Header.Get(webhooks.SignatureHeader), r.Header.Get(webhooks.TimestampHeader), body, time.Now(), time.Minute)
+		require.NoError(t, err)
+		received = append(received, string(body))
+	}))
+	defer receiver.Close()
+
+	store := newFakeStore(receiver.URL)
+	dispatcher := webhooks.NewDispatcher(store, receiver.Client())
+	require.NoError(t, dispatcher.DispatchDue(context.Background()))
+	require.Equal(t, []string{store.deliveries[1].Payload}, received)
+	require.Equal(t, models.DeliverySucceeded, store.deliveries[1].Status)
+	require.Equal(t, http.StatusOK, store.deliveries[1].ResponseStatus)
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	ctx := context.Background()
+	store := newFakeStore(receiver.URL)
+	dispatcher := webhooks.NewDispatcher(store, receiver.Client())
+	dispatcher.MaxAttempts = 3
+	now := time.Now()
+	dispatcher.Now = func() time.Time { return now }
+
+	require.NoError(t, dispatcher.DispatchDue(ctx))
+	delivery := store.deliveries[1]
+	require.Equal(t, models.DeliveryFailed, delivery.Status)
+	require.Equal(t, now.Add(webhooks.DefaultBackoff), delivery.NextAttemptAt)
+	require.Equal(t, "unavailable\n", delivery.ResponseBody)
+
+	// not due yet
+	require.NoError(t, dispatcher.DispatchDue(ctx))
+	require.Equal(t, 1, store.deliveries[1].Attempts)
+
+	now = delivery.NextAttemptAt
+	require.NoError(t, dispatcher.DispatchDue(ctx))
+	require.Equal(t, now.Add(2*webhooks.DefaultBackoff), store.deliveries[1].NextAttemptAt)
+
+	now = store.deliveries[1].NextAttemptAt
+	require.NoError(t, dispatcher.DispatchDue(ctx))
+	require.Equal(t, models.DeliveryDead, store.deliveries[1].Status)
+	require.Equal(t, 3, store.deliveries[1].Attempts)
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":1}`)
+	signature := webhooks.Sign(testSecret, now, body)
+	timestamp := fmt.Sprint(now.Unix())
+	require.NoError(t, webhooks.Verify(testSecret, signature, timestamp, body, now, time.Minute))
+	require.Equal(t, webhooks.ErrInvalidSignature, webhooks.Verify(testSecret, signature, timestamp, []byte(`{"id":2}`), now, time.Minute))
+	require.Equal(t, webhooks.ErrInvalidSignature, webhooks.Verify("another", signature, timestamp, body, now, time.Minute))
+	require.Equal(t, webhooks.ErrInvalidSignature, webhooks.Verify(testSecret, signature, fmt.Sprint(now.Unix()+1), body, now, time.Minute))
+	require.Equal(t, webhooks.ErrStaleTimestamp, webhooks.Verify(testSecret, signature, timestamp, body, now.Add(time.Hour), time.Minute))
+}
+
+type fakeStore struct {
+	webhook    models.Webhook
+	deliveries map[int64]models.WebhookDelivery
+}
+
+func newFakeStore(url string) *fakeStore {
+	return &fakeStore{
+		webhook: models.Webhook{ID: 1, URL: url, Secret: testSecret, Active: true},
+		deliveries: map[int64]models.WebhookDelivery{
+			1: {ID: 1, WebhookID: 1, EventID: 1, Payload: `{"id":1}`, Status: models.DeliveryPending},
+		},
+	}
+}
+
+func (s *fakeStore) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	return webhook, nil
+}
+
+func (s *fakeStore) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	return []models.Webhook{s.webhook}, nil
+}
+
+func (s *fakeStore) GetWebhook(ctx context.Context, id int) (models.Webhook, error) {
+	return s.webhook, nil
+}
+
+func (s *fakeStore) DeleteWebhook(ctx context.Context, id int) error {
+	return nil
+}
+
+func (s *fakeStore) CreateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	delivery.ID = int64(len(s.deliveries) + 1)
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (s *fakeStore) ListDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) GetDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	return s.deliveries[id], nil
+}
+
+func (s *fakeStore) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var due []models.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		pending := delivery.Status == models.DeliveryPending || delivery.Status == models.DeliveryFailed
+		if pending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+func (s *fakeStore) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
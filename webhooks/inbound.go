@@ -0,0 +1,134 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/apperr"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+// DefaultTolerance is the default allowed difference between the signing time of an inbound delivery and its receiving time
+const DefaultTolerance = 5 * time.Minute
+
+// ErrReplayed occurs when a delivery with the same signature has been already received
+var ErrReplayed = apperr.New(apperr.Conflict, "delivery has been already received")
+
+// CustomerUpdater is the part of the customer manager that inbound deliveries are applied through
+type CustomerUpdater interface {
+	GetCustomer(ctx context.Context, id int) (models.Customer, error)
+	CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error)
+	UpdateCustomer(ctx context.Context, customer models.Customer) error
+}
+
+// Receiver verifies customer updates pushed by an upstream system, applies them and records their outcome
+type Receiver struct {
+	store     stores.InboundStore
+	customers CustomerUpdater
+	secret    string
+	mapping   FieldMapping
+	Tolerance time.Duration
+	Now       func() time.Time
+}
+
+// NewReceiver creates a receiver that verifies deliveries with the given secret and maps them with the given mapping
+func NewReceiver(store stores.InboundStore, customers CustomerUpdater, secret string, mapping FieldMapping) *Receiver {
+	return &Receiver{
+		store:     store,
+		customers: customers,
+		secret:    secret,
+		mapping:   mapping,
+		Tolerance: DefaultTolerance,
+		Now:       time.Now,
+	}
+}
+
+// Receive verifies and applies the given delivery. A verified delivery is recorded whatever its outcome is.
+// It returns ErrInvalidSignature, ErrStaleTimestamp or ErrReplayed if the delivery has been rejected, in which case
+// nothing is stored, so that unverified senders can't fill the database, or the error that prevented the delivery from being applied
+func (r *Receiver) Receive(ctx context.Context, signature, timestamp string, body []byte) (models.InboundDelivery, error) {
+	delivery := models.InboundDelivery{
+		Signature: signature,
+		Timestamp: timestamp,
+		Body:      string(body),
+		Status:    models.InboundFailed,
+	}
+	if err := Verify(r.secret, signature, timestamp, body, r.Now(), r.Tolerance); err != nil {
+		return models.InboundDelivery{}, err
+	}
+	created, err := r.store.CreateInbound(ctx, delivery)
+	if err == stores.ErrDuplicate {
+		return models.InboundDelivery{}, ErrReplayed
+	} else if err != nil {
+		return models.InboundDelivery{}, err
+	}
+	return r.apply(ctx, created)
+}
+
+// Replay applies a verified delivery again, which is useful when it has failed due to a conflict or invalid data
+func (r *Receiver) Replay(ctx context.Context, id int64) (models.InboundDelivery, error) {
+	delivery, err := r.store.GetInbound(ctx, id)
+	if err != nil {
+		return models.InboundDelivery{}, err
+	}
+	return r.apply(ctx, delivery)
+}
+
+func (r *Receiver) apply(ctx context.Context, delivery models.InboundDelivery) (models.InboundDelivery, error) {
+	delivery.Attempts++
+	customerID, applyErr := r.applyBody(ctx, []byte(delivery.Body))
+	delivery.CustomerID = customerID
+	if applyErr != nil {
+		delivery.Status = models.InboundFailed
+		delivery.Error = applyErr.Error()
+	} else {
+		delivery.Status = models.InboundApplied
+		delivery.Error = ""
+	}
+	if err := r.store.UpdateInbound(ctx, delivery); err != nil {
+		return delivery, err
+	}
+	return delivery, applyErr
+}
+
+// applyBody creates or updates the customer the body describes and returns its ID
+func (r *Receiver) applyBody(ctx context.Context, body []byte) (int, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return 0, errors.Wrapf(err, "decode payload")
+	}
+	id, err := r.mapping.CustomerID(payload)
+	if err != nil {
+		return 0, err
+	}
+	if id == 0 {
+		var customer models.Customer
+		if err := r.mapping.Apply(payload, &customer); err != nil {
+			return 0, err
+		}
+		created, err := r.customers.CreateCustomer(ctx, customer)
+		return created.ID, err
+	}
+	customer, err := r.customers.GetCustomer(ctx, id)
+	if err != nil {
+		return id, err
+	}
+	if err := r.mapping.Apply(payload, &customer); err != nil {
+		return id, err
+	}
+	return id, r.customers.UpdateCustomer(ctx, customer)
+}
+
+// ListDeliveries returns the latest deliveries with the given status, or with any status if it's empty
+func (r *Receiver) ListDeliveries(ctx context.Context, status models.InboundStatus, limit int) ([]models.InboundDelivery, error) {
+	return r.store.ListInbound(ctx, status, limit)
+}
+
+// GetDelivery returns a delivery by its ID
+func (r *Receiver) GetDelivery(ctx context.Context, id int64) (models.InboundDelivery, error) {
+	return r.store.GetInbound(ctx, id)
+}
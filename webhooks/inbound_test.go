@@ -0,0 +1,138 @@
+package webhooks_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+	"github.com/havr/customers/webhooks"
+)
+
+var kycMapping = webhooks.FieldMapping{
+	"id":      "customer_ref",
+	"email":   "contact.email",
+	"address": "contact.address",
+}
+
+func receiveSigned(ctx context.Context, receiver *webhooks.Receiver, body string, at time.Time) (models.InboundDelivery, error) {
+	return receiver.Receive(ctx, webhooks.Sign(testSecret, at, []byte(body)), strconv.FormatInt(at.Unix(), 10), []byte(body))
+}
+
+func TestReceiverAppliesMappedUpdate(t *testing.T) {
+	ctx := context.Background()
+	customers := &fakeCustomers{customers: map[int]models.Customer{7: {ID: 7, FirstName: "John", Email: "old@email.com"}}}
+	store := newFakeInboundStore()
+	receiver := webhooks.NewReceiver(store, customers, testSecret, kycMapping)
+
+	delivery, err := receiveSigned(ctx, receiver, `{"customer_ref": "7", "contact": {"email": "new@email.com"}}`, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, models.InboundApplied, delivery.Status)
+	require.Equal(t, 7, delivery.CustomerID)
+	require.Equal(t, models.Customer{ID: 7, FirstName: "John", Email: "new@email.com"}, customers.customers[7])
+	require.Equal(t, delivery, store.deliveries[delivery.ID])
+}
+
+func TestReceiverRejectsInvalidDeliveries(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeInboundStore()
+	receiver := webhooks.NewReceiver(store, &fakeCustomers{customers: map[int]models.Customer{}}, testSecret, kycMapping)
+
+	now := time.Now()
+	signature := webhooks.Sign(testSecret, now, []byte(`{"customer_ref": 1}`))
+	_, err := receiver.Receive(ctx, signature, strconv.FormatInt(now.Unix(), 10), []byte(`{"customer_ref": 2}`))
+	require.Equal(t, webhooks.ErrInvalidSignature, err)
+	require.Empty(t, store.deliveries, "rejected deliveries aren't stored")
+
+	_, err = receiveSigned(ctx, receiver, `{"customer_ref": 1}`, time.Now().Add(-time.Hour))
+	require.Equal(t, webhooks.ErrStaleTimestamp, err)
+
+	fresh := time.Now()
+	_, err = receiveSigned(ctx, receiver, `{"contact": {"email": "e@mail.com"}}`, fresh)
+	require.NoError(t, err)
+	_, err = receiveSigned(ctx, receiver, `{"contact": {"email": "e@mail.com"}}`, fresh)
+	require.Equal(t, webhooks.ErrReplayed, err)
+	require.Len(t, store.deliveries, 1)
+}
+
+func TestReceiverReplaysFailed(t *testing.T) {
+	ctx := context.Background()
+	customers := &fakeCustomers{customers: map[int]models.Customer{}, fail: true}
+	store := newFakeInboundStore()
+	receiver := webhooks.NewReceiver(store, customers, testSecret, kycMapping)
+
+	delivery, err := receiveSigned(ctx, receiver, `{"contact": {"email": "e@mail.com"}}`, time.Now())
+	require.Error(t, err)
+	require.Equal(t, models.InboundFailed, store.deliveries[delivery.ID].Status)
+
+	customers.fail = false
+	delivery, err = receiver.Replay(ctx, delivery.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.InboundApplied, delivery.Status)
+	require.Equal(t, 2, delivery.Attempts)
+	require.Equal(t, "e@mail.com", customers.customers[delivery.CustomerID].Email)
+}
+
+type fakeCustomers struct {
+	customers map[int]models.Customer
+	fail      bool
+}
+
+func (c *fakeCustomers) GetCustomer(ctx context.Context, id int) (models.Customer, error) {
+	customer, ok := c.customers[id]
+	if !ok {
+		return models.Customer{}, stores.ErrNotFound
+	}
+	return customer, nil
+}
+
+func (c *fakeCustomers) CreateCustomer(ctx context.Context, customer models.Customer) (models.Customer, error) {
+	if c.fail {
+		return models.Customer{}, fmt.Errorf("invalid customer")
+	}
+	customer.ID = len(c.customers) + 1
+	c.customers[customer.ID] = customer
+	return customer, nil
+}
+
+func (c *fakeCustomers) UpdateCustomer(ctx context.Context, customer models.Customer) error {
+	c.customers[customer.ID] = customer
+	return nil
+}
+
+type fakeInboundStore struct {
+	deliveries map[int64]models.InboundDelivery
+}
+
+func newFakeInboundStore() *fakeInboundStore {
+	return &fakeInboundStore{deliveries: make(map[int64]models.InboundDelivery)}
+}
+
+func (s *fakeInboundStore) CreateInbound(ctx context.Context, delivery models.InboundDelivery) (models.InboundDelivery, error) {
+	for _, existing := range s.deliveries {
+		if existing.Signature == delivery.Signature {
+			return models.InboundDelivery{}, stores.ErrDuplicate
+		}
+	}
+	delivery.ID = int64(len(s.deliveries) + 1)
+	s.deliveries[delivery.ID] = delivery
+	return delivery, nil
+}
+
+func (s *fakeInboundStore) UpdateInbound(ctx context.Context, delivery models.InboundDelivery) error {
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (s *fakeInboundStore) GetInbound(ctx context.Context, id int64) (models.InboundDelivery, error) {
+	return s.deliveries[id], nil
+}
+
+func (s *fakeInboundStore) ListInbound(ctx context.Context, status models.InboundStatus, limit int) ([]models.InboundDelivery, error) {
+	return nil, nil
+}
@@ -0,0 +1,135 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/havr/customers/models"
+)
+
+// FieldMapping maps customer fields to dot-separated paths of their values in an inbound payload,
+// e.g. {"email": "contact.email"}. Fields missing from the mapping or from a payload stay intact
+type FieldMapping map[string]string
+
+// DefaultFieldMapping expects a payload to have customer fields at its top level
+var DefaultFieldMapping = FieldMapping{
+	"id":        "id",
+	"firstName": "firstName",
+	"lastName":  "lastName",
+	"birthDate": "birthDate",
+	"gender":    "gender",
+	"email":     "email",
+	"address":   "address",
+}
+
+// LoadFieldMapping reads a field mapping from the given JSON file
+func LoadFieldMapping(path string) (FieldMapping, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read field mapping")
+	}
+	var mapping FieldMapping
+	if err := json.Unmarshal(content, &mapping); err != nil {
+		return nil, errors.Wrapf(err, "parse field mapping")
+	}
+	return mapping, mapping.Validate()
+}
+
+// Validate checks that the mapping refers to known customer fields only
+func (m FieldMapping) Validate() error {
+	for field := range m {
+		if _, ok := DefaultFieldMapping[field]; !ok {
+			return fmt.Errorf("unknown customer field in mapping: %q", field)
+		}
+	}
+	return nil
+}
+
+// CustomerID extracts ID of the customer the payload refers to. It returns 0 if the payload has no ID
+func (m FieldMapping) CustomerID(payload map[string]interface{}) (int, error) {
+	value, ok := m.lookup(payload, "id")
+	if !ok || value == nil {
+		return 0, nil
+	}
+	switch id := value.(type) {
+	case float64:
+		return int(id), nil
+	case string:
+		if id == "" {
+			return 0, nil
+		}
+		parsed, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, fmt.Errorf("invalid customer id: %q", id)
+		}
+		return parsed, nil
+	}
+	return 0, fmt.Errorf("invalid customer id: %v", value)
+}
+
+// Apply sets customer fields to the values the payload has for them
+func (m FieldMapping) Apply(payload map[string]interface{}, customer *models.Customer) error {
+	strFields := map[string]*string{
+		"firstName": &customer.FirstName,
+		"lastName":  &customer.LastName,
+		"email":     &customer.Email,
+		"address":   &customer.Address,
+	}
+	for field, dest := range strFields {
+		if value, ok := m.lookup(payload, field); ok {
+			str, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s must be a string", field)
+			}
+			*dest = str
+		}
+	}
+	if value, ok := m.lookup(payload, "gender"); ok {
+		gender, ok := value.(string)
+		if !ok || !models.IsValidGender(gender) {
+			return fmt.Errorf("unknown gender: %v", value)
+		}
+		customer.Gender = models.Gender(gender)
+	}
+	if value, ok := m.lookup(payload, "birthDate"); ok {
+		str, _ := value.(string)
+		birthDate, err := parseDate(str)
+		if err != nil {
+			return fmt.Errorf("invalid birth date: %v", value)
+		}
+		customer.BirthDate = birthDate
+	}
+	return nil
+}
+
+func (m FieldMapping) lookup(payload map[string]interface{}, field string) (interface{}, bool) {
+	path, ok := m[field]
+	if !ok || path == "" {
+		return nil, false
+	}
+	var current interface{} = payload
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func parseDate(str string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", str); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, str)
+	return date.UTC(), err
+}
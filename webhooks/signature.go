@@ -0,0 +1,60 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader is a header that carries the HMAC-SHA256 signature of a webhook request
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader is a header that carries the unix time a webhook request has been signed at
+	TimestampHeader = "X-Webhook-Timestamp"
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	// ErrInvalidSignature occurs when a request signature doesn't match its body
+	ErrInvalidSignature = fmt.Errorf("invalid signature")
+	// ErrStaleTimestamp occurs when a request has been signed too long ago or in the future
+	ErrStaleTimestamp = fmt.Errorf("timestamp is out of the allowed window")
+)
+
+// Sign returns the signature header value of the given body signed at the given time.
+// The signed message is "<unix timestamp>.<body>", so a signature can't be reused with another timestamp
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(secret, timestamp.Unix(), body))
+}
+
+// Verify checks that the signature matches the body and the timestamp, and that the timestamp lies within the tolerance from now
+func Verify(secret, signature, timestamp string, body []byte, now time.Time, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-tolerance)) || signedAt.After(now.Add(tolerance)) {
+		return ErrStaleTimestamp
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal(decoded, mac(secret, unix, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func mac(secret string, unix int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(unix, 10) + "."))
+	h.Write(body)
+	return h.Sum(nil)
+}
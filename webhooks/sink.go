@@ -0,0 +1,59 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/havr/customers/events"
+	"github.com/havr/customers/models"
+	"github.com/havr/customers/stores"
+)
+
+// SinkName is the name the webhook sink tracks its outbox offset with
+const SinkName = "webhooks"
+
+// NewSink creates an event sink that schedules deliveries of events to the webhooks that accept them.
+// Deliveries themselves are performed by a Dispatcher
+func NewSink(store stores.WebhookStore) events.Sink {
+	return &sink{
+		store: store,
+	}
+}
+
+type sink struct {
+	store stores.WebhookStore
+}
+
+func (s *sink) Name() string {
+	return SinkName
+}
+
+func (s *sink) Deliver(ctx context.Context, event models.Event) error {
+	webhooks, err := s.store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Accepts(event) {
+			continue
+		}
+		err := s.store.CreateDelivery(ctx, models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			CustomerID:    event.CustomerID,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}